@@ -52,32 +52,40 @@ var newFlagNameLookup = map[string]string{
 type Config struct {
 	SystemPath string
 
-	Addr             string `toml:"addr" env:"ETCD_ADDR"`
-	BindAddr         string `toml:"bind_addr" env:"ETCD_BIND_ADDR"`
-	CAFile           string `toml:"ca_file" env:"ETCD_CA_FILE"`
-	CertFile         string `toml:"cert_file" env:"ETCD_CERT_FILE"`
-	CPUProfileFile   string
-	CorsOrigins      []string `toml:"cors" env:"ETCD_CORS"`
-	DataDir          string   `toml:"data_dir" env:"ETCD_DATA_DIR"`
-	Discovery        string   `toml:"discovery" env:"ETCD_DISCOVERY"`
-	Force            bool
-	KeyFile          string   `toml:"key_file" env:"ETCD_KEY_FILE"`
-	HTTPReadTimeout  float64  `toml:"http_read_timeout" env:"ETCD_HTTP_READ_TIMEOUT"`
-	HTTPWriteTimeout float64  `toml:"http_write_timeout" env:"ETCD_HTTP_WRITE_TIMEOUT"`
-	Peers            []string `toml:"peers" env:"ETCD_PEERS"`
-	PeersFile        string   `toml:"peers_file" env:"ETCD_PEERS_FILE"`
-	MaxResultBuffer  int      `toml:"max_result_buffer" env:"ETCD_MAX_RESULT_BUFFER"`
-	MaxRetryAttempts int      `toml:"max_retry_attempts" env:"ETCD_MAX_RETRY_ATTEMPTS"`
-	RetryInterval    float64  `toml:"retry_interval" env:"ETCD_RETRY_INTERVAL"`
-	Name             string   `toml:"name" env:"ETCD_NAME"`
-	Snapshot         bool     `toml:"snapshot" env:"ETCD_SNAPSHOT"`
-	SnapshotCount    int      `toml:"snapshot_count" env:"ETCD_SNAPSHOTCOUNT"`
-	ShowHelp         bool
-	ShowVersion      bool
-	Verbose          bool `toml:"verbose" env:"ETCD_VERBOSE"`
-	VeryVerbose      bool `toml:"very_verbose" env:"ETCD_VERY_VERBOSE"`
-	VeryVeryVerbose  bool `toml:"very_very_verbose" env:"ETCD_VERY_VERY_VERBOSE"`
-	Peer             struct {
+	Addr              string `toml:"addr" env:"ETCD_ADDR"`
+	BindAddr          string `toml:"bind_addr" env:"ETCD_BIND_ADDR"`
+	CAFile            string `toml:"ca_file" env:"ETCD_CA_FILE"`
+	CertFile          string `toml:"cert_file" env:"ETCD_CERT_FILE"`
+	ClientAuthFile    string `toml:"client_auth_file" env:"ETCD_CLIENT_AUTH_FILE"`
+	CPUProfileFile    string
+	CorsOrigins       []string `toml:"cors" env:"ETCD_CORS"`
+	DataDir           string   `toml:"data_dir" env:"ETCD_DATA_DIR"`
+	Discovery         string   `toml:"discovery" env:"ETCD_DISCOVERY"`
+	DiscoveryService  bool     `toml:"discovery_service" env:"ETCD_DISCOVERY_SERVICE"`
+	DiscoverySRV      string   `toml:"discovery_srv" env:"ETCD_DISCOVERY_SRV"`
+	DiscoveryTimeout  float64  `toml:"discovery_timeout" env:"ETCD_DISCOVERY_TIMEOUT"`
+	Force             bool
+	KeyFile           string   `toml:"key_file" env:"ETCD_KEY_FILE"`
+	ListenURLs        []string `toml:"listen_urls" env:"ETCD_LISTEN_URLS"`
+	HTTPReadTimeout   float64  `toml:"http_read_timeout" env:"ETCD_HTTP_READ_TIMEOUT"`
+	HTTPWriteTimeout  float64  `toml:"http_write_timeout" env:"ETCD_HTTP_WRITE_TIMEOUT"`
+	Peers             []string `toml:"peers" env:"ETCD_PEERS"`
+	PeersFile         string   `toml:"peers_file" env:"ETCD_PEERS_FILE"`
+	MaxResultBuffer   int      `toml:"max_result_buffer" env:"ETCD_MAX_RESULT_BUFFER"`
+	MaxRetryAttempts  int      `toml:"max_retry_attempts" env:"ETCD_MAX_RETRY_ATTEMPTS"`
+	RetryInterval     float64  `toml:"retry_interval" env:"ETCD_RETRY_INTERVAL"`
+	Name              string   `toml:"name" env:"ETCD_NAME"`
+	Snapshot          bool     `toml:"snapshot" env:"ETCD_SNAPSHOT"`
+	SnapshotCount     int      `toml:"snapshot_count" env:"ETCD_SNAPSHOTCOUNT"`
+	SnapshotLogSize   int      `toml:"snapshot_log_size" env:"ETCD_SNAPSHOT_LOG_SIZE"`
+	SnapshotInterval  float64  `toml:"snapshot_interval" env:"ETCD_SNAPSHOT_INTERVAL"`
+	SnapshotRetention int      `toml:"snapshot_retention" env:"ETCD_SNAPSHOT_RETENTION"`
+	ShowHelp          bool
+	ShowVersion       bool
+	Verbose           bool `toml:"verbose" env:"ETCD_VERBOSE"`
+	VeryVerbose       bool `toml:"very_verbose" env:"ETCD_VERY_VERBOSE"`
+	VeryVeryVerbose   bool `toml:"very_very_verbose" env:"ETCD_VERY_VERY_VERBOSE"`
+	Peer              struct {
 		Addr              string `toml:"addr" env:"ETCD_PEER_ADDR"`
 		BindAddr          string `toml:"bind_addr" env:"ETCD_PEER_BIND_ADDR"`
 		CAFile            string `toml:"ca_file" env:"ETCD_PEER_CA_FILE"`
@@ -85,9 +93,11 @@ type Config struct {
 		KeyFile           string `toml:"key_file" env:"ETCD_PEER_KEY_FILE"`
 		HeartbeatInterval int    `toml:"heartbeat_interval" env:"ETCD_PEER_HEARTBEAT_INTERVAL"`
 		ElectionTimeout   int    `toml:"election_timeout" env:"ETCD_PEER_ELECTION_TIMEOUT"`
+		SnapshotRate      int    `toml:"snapshot_rate" env:"ETCD_PEER_SNAPSHOT_RATE"`
 	}
-	strTrace     string `toml:"trace" env:"ETCD_TRACE"`
-	GraphiteHost string `toml:"graphite_host" env:"ETCD_GRAPHITE_HOST"`
+	strTrace     string           `toml:"trace" env:"ETCD_TRACE"`
+	GraphiteHost string           `toml:"graphite_host" env:"ETCD_GRAPHITE_HOST"`
+	Listeners    []ListenerConfig `toml:"listeners"`
 	Cluster      struct {
 		ActiveSize   int     `toml:"active_size" env:"ETCD_CLUSTER_ACTIVE_SIZE"`
 		RemoveDelay  float64 `toml:"remove_delay" env:"ETCD_CLUSTER_REMOVE_DELAY"`
@@ -95,11 +105,23 @@ type Config struct {
 	}
 }
 
+// ListenerConfig describes an additional client listener with its own
+// TLS settings. Listeners without a certificate of their own use the
+// client certificate files.
+type ListenerConfig struct {
+	URL        string `toml:"url"`
+	CAFile     string `toml:"ca_file"`
+	CertFile   string `toml:"cert_file"`
+	KeyFile    string `toml:"key_file"`
+	SocketMode string `toml:"socket_mode"`
+}
+
 // New returns a Config initialized with default values.
 func New() *Config {
 	c := new(Config)
 	c.SystemPath = DefaultSystemConfigPath
 	c.Addr = "127.0.0.1:4001"
+	c.DiscoveryTimeout = server.DefaultDiscoveryTimeout
 	c.HTTPReadTimeout = server.DefaultReadTimeout
 	c.HTTPWriteTimeout = server.DefaultWriteTimeout
 	c.MaxResultBuffer = 1024
@@ -107,9 +129,11 @@ func New() *Config {
 	c.RetryInterval = 10.0
 	c.Snapshot = true
 	c.SnapshotCount = 10000
+	c.SnapshotRetention = server.DefaultSnapshotRetention
 	c.Peer.Addr = "127.0.0.1:7001"
 	c.Peer.HeartbeatInterval = defaultHeartbeatInterval
 	c.Peer.ElectionTimeout = defaultElectionTimeout
+	c.Peer.SnapshotRate = server.DefaultSnapshotRate
 	rand.Seed(time.Now().UTC().UnixNano())
 	// Make maximum twice as minimum.
 	c.RetryInterval = float64(50+rand.Int()%50) * defaultHeartbeatInterval / 1000
@@ -225,7 +249,7 @@ func (c *Config) loadEnv(target interface{}) error {
 
 // Loads configuration from command line flags.
 func (c *Config) LoadFlags(arguments []string) error {
-	var peers, cors, path string
+	var peers, cors, listenURLs, path string
 
 	f := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
 	f.SetOutput(ioutil.Discard)
@@ -247,13 +271,18 @@ func (c *Config) LoadFlags(arguments []string) error {
 	f.StringVar(&c.Name, "name", c.Name, "")
 	f.StringVar(&c.Addr, "addr", c.Addr, "")
 	f.StringVar(&c.Discovery, "discovery", c.Discovery, "")
+	f.BoolVar(&c.DiscoveryService, "discovery-service", c.DiscoveryService, "")
+	f.StringVar(&c.DiscoverySRV, "discovery-srv", c.DiscoverySRV, "")
+	f.Float64Var(&c.DiscoveryTimeout, "discovery-timeout", c.DiscoveryTimeout, "")
 	f.StringVar(&c.BindAddr, "bind-addr", c.BindAddr, "")
+	f.StringVar(&listenURLs, "listen-urls", "", "")
 	f.StringVar(&c.Peer.Addr, "peer-addr", c.Peer.Addr, "")
 	f.StringVar(&c.Peer.BindAddr, "peer-bind-addr", c.Peer.BindAddr, "")
 
 	f.StringVar(&c.CAFile, "ca-file", c.CAFile, "")
 	f.StringVar(&c.CertFile, "cert-file", c.CertFile, "")
 	f.StringVar(&c.KeyFile, "key-file", c.KeyFile, "")
+	f.StringVar(&c.ClientAuthFile, "client-auth-file", c.ClientAuthFile, "")
 
 	f.StringVar(&c.Peer.CAFile, "peer-ca-file", c.Peer.CAFile, "")
 	f.StringVar(&c.Peer.CertFile, "peer-cert-file", c.Peer.CertFile, "")
@@ -268,11 +297,15 @@ func (c *Config) LoadFlags(arguments []string) error {
 	f.Float64Var(&c.RetryInterval, "retry-interval", c.RetryInterval, "")
 	f.IntVar(&c.Peer.HeartbeatInterval, "peer-heartbeat-interval", c.Peer.HeartbeatInterval, "")
 	f.IntVar(&c.Peer.ElectionTimeout, "peer-election-timeout", c.Peer.ElectionTimeout, "")
+	f.IntVar(&c.Peer.SnapshotRate, "peer-snapshot-rate", c.Peer.SnapshotRate, "")
 
 	f.StringVar(&cors, "cors", "", "")
 
 	f.BoolVar(&c.Snapshot, "snapshot", c.Snapshot, "")
 	f.IntVar(&c.SnapshotCount, "snapshot-count", c.SnapshotCount, "")
+	f.IntVar(&c.SnapshotLogSize, "snapshot-log-size", c.SnapshotLogSize, "")
+	f.Float64Var(&c.SnapshotInterval, "snapshot-interval", c.SnapshotInterval, "")
+	f.IntVar(&c.SnapshotRetention, "snapshot-retention", c.SnapshotRetention, "")
 	f.StringVar(&c.CPUProfileFile, "cpuprofile", "", "")
 
 	f.StringVar(&c.strTrace, "trace", "", "")
@@ -327,6 +360,9 @@ func (c *Config) LoadFlags(arguments []string) error {
 	if cors != "" {
 		c.CorsOrigins = ustrings.TrimSplit(cors, ",")
 	}
+	if listenURLs != "" {
+		c.ListenURLs = ustrings.TrimSplit(listenURLs, ",")
+	}
 
 	return nil
 }
@@ -376,6 +412,12 @@ func (c *Config) Reset() error {
 	if err := os.RemoveAll(filepath.Join(c.DataDir, "snapshot")); err != nil {
 		return err
 	}
+	if err := os.RemoveAll(filepath.Join(c.DataDir, "snapshot.archive")); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(c.DataDir, "snapshot.recv")); err != nil {
+		return err
+	}
 	if err := os.RemoveAll(filepath.Join(c.DataDir, "standby_info")); err != nil {
 		return err
 	}
@@ -401,6 +443,9 @@ func (c *Config) Sanitize() error {
 	if c.Peer.BindAddr, err = sanitizeBindAddr(c.Peer.BindAddr, url); err != nil {
 		return fmt.Errorf("Peer Listen Host: %s", err)
 	}
+	if _, err = c.ClientListeners(); err != nil {
+		return fmt.Errorf("Listen URLs: %s", err)
+	}
 
 	// Only guess the machine name if there is no data dir specified
 	// because the info file should have our name
@@ -424,6 +469,69 @@ func (c *Config) EtcdTLSInfo() *server.TLSInfo {
 	}
 }
 
+// ClientListeners retrieves the additional client listeners from the
+// listen URLs and the listener sections of the configuration file.
+func (c *Config) ClientListeners() ([]*server.ListenerInfo, error) {
+	var listeners []*server.ListenerInfo
+	for _, u := range c.ListenURLs {
+		info, err := newListenerInfo(ListenerConfig{URL: u}, c.EtcdTLSInfo())
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, info)
+	}
+	for _, lc := range c.Listeners {
+		info, err := newListenerInfo(lc, c.EtcdTLSInfo())
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, info)
+	}
+	return listeners, nil
+}
+
+// newListenerInfo validates a listener configuration. The given TLSInfo is
+// used when the listener has no certificate of its own.
+func newListenerInfo(lc ListenerConfig, tlsInfo *server.TLSInfo) (*server.ListenerInfo, error) {
+	u, err := url.Parse(lc.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &server.ListenerInfo{URL: u, SocketMode: server.DefaultSocketMode}
+	switch u.Scheme {
+	case "http":
+	case "https":
+		info.TLSInfo = server.TLSInfo{CAFile: lc.CAFile, CertFile: lc.CertFile, KeyFile: lc.KeyFile}
+		if info.TLSInfo.CertFile == "" && info.TLSInfo.KeyFile == "" {
+			info.TLSInfo = *tlsInfo
+		}
+		if info.TLSInfo.CertFile == "" || info.TLSInfo.KeyFile == "" {
+			return nil, fmt.Errorf("%s: https requires a cert file and a key file", lc.URL)
+		}
+	case "unix":
+		if info.Addr() == "" {
+			return nil, fmt.Errorf("%s: socket path required", lc.URL)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported scheme %q", lc.URL, u.Scheme)
+	}
+	if u.Scheme != "unix" {
+		if _, _, err := net.SplitHostPort(u.Host); err != nil {
+			return nil, fmt.Errorf("%s: %s", lc.URL, err)
+		}
+	}
+
+	if lc.SocketMode != "" {
+		mode, err := strconv.ParseUint(lc.SocketMode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid socket mode %q", lc.URL, lc.SocketMode)
+		}
+		info.SocketMode = os.FileMode(mode)
+	}
+	return info, nil
+}
+
 // PeerRaftInfo retrieves a TLSInfo object for the peer server.
 func (c *Config) PeerTLSInfo() *server.TLSInfo {
 	return &server.TLSInfo{
@@ -7,6 +7,8 @@ import (
 
 	"github.com/coreos/etcd/third_party/github.com/BurntSushi/toml"
 	"github.com/coreos/etcd/third_party/github.com/stretchr/testify/assert"
+
+	"github.com/coreos/etcd/server"
 )
 
 // Ensures that a configuration can be deserialized from TOML.
@@ -19,6 +21,9 @@ func TestConfigTOML(t *testing.T) {
 		cpu_profile_file = "XXX"
 		data_dir = "/tmp/data"
 		discovery = "http://example.com/foobar"
+		discovery_service = true
+		discovery_srv = "example.com"
+		discovery_timeout = 60.0
 		key_file = "/tmp/file.key"
 		bind_addr = "127.0.0.1:4003"
 		peers = ["coreos.com:4001", "coreos.com:4002"]
@@ -54,6 +59,9 @@ func TestConfigTOML(t *testing.T) {
 	assert.Equal(t, c.CorsOrigins, []string{"*"}, "")
 	assert.Equal(t, c.DataDir, "/tmp/data", "")
 	assert.Equal(t, c.Discovery, "http://example.com/foobar", "")
+	assert.Equal(t, c.DiscoveryService, true, "")
+	assert.Equal(t, c.DiscoverySRV, "example.com", "")
+	assert.Equal(t, c.DiscoveryTimeout, 60.0, "")
 	assert.Equal(t, c.HTTPReadTimeout, 2.34, "")
 	assert.Equal(t, c.HTTPWriteTimeout, 1.23, "")
 	assert.Equal(t, c.KeyFile, "/tmp/file.key", "")
@@ -84,6 +92,9 @@ func TestConfigEnv(t *testing.T) {
 	os.Setenv("ETCD_CORS", "localhost:4001,localhost:4002")
 	os.Setenv("ETCD_DATA_DIR", "/tmp/data")
 	os.Setenv("ETCD_DISCOVERY", "http://example.com/foobar")
+	os.Setenv("ETCD_DISCOVERY_SERVICE", "true")
+	os.Setenv("ETCD_DISCOVERY_SRV", "example.com")
+	os.Setenv("ETCD_DISCOVERY_TIMEOUT", "60")
 	os.Setenv("ETCD_HTTP_READ_TIMEOUT", "2.34")
 	os.Setenv("ETCD_HTTP_WRITE_TIMEOUT", "1.23")
 	os.Setenv("ETCD_KEY_FILE", "/tmp/file.key")
@@ -113,6 +124,9 @@ func TestConfigEnv(t *testing.T) {
 	assert.Equal(t, c.CorsOrigins, []string{"localhost:4001", "localhost:4002"}, "")
 	assert.Equal(t, c.DataDir, "/tmp/data", "")
 	assert.Equal(t, c.Discovery, "http://example.com/foobar", "")
+	assert.Equal(t, c.DiscoveryService, true, "")
+	assert.Equal(t, c.DiscoverySRV, "example.com", "")
+	assert.Equal(t, c.DiscoveryTimeout, 60.0, "")
 	assert.Equal(t, c.HTTPReadTimeout, 2.34, "")
 	assert.Equal(t, c.HTTPWriteTimeout, 1.23, "")
 	assert.Equal(t, c.KeyFile, "/tmp/file.key", "")
@@ -136,6 +150,9 @@ func TestConfigEnv(t *testing.T) {
 
 	// Clear this as it will mess up other tests
 	os.Setenv("ETCD_DISCOVERY", "")
+	os.Setenv("ETCD_DISCOVERY_SERVICE", "")
+	os.Setenv("ETCD_DISCOVERY_SRV", "")
+	os.Setenv("ETCD_DISCOVERY_TIMEOUT", "")
 }
 
 // Ensures that the "help" flag can be parsed.
@@ -203,6 +220,82 @@ func TestConfigCAFileFlag(t *testing.T) {
 	assert.Equal(t, c.CAFile, "/tmp/file.ca", "")
 }
 
+// Ensures that the client auth file can be parsed from the environment.
+func TestConfigClientAuthFileEnv(t *testing.T) {
+	withEnv("ETCD_CLIENT_AUTH_FILE", "/tmp/file.auth", func(c *Config) {
+		assert.Nil(t, c.LoadEnv(), "")
+		assert.Equal(t, c.ClientAuthFile, "/tmp/file.auth", "")
+	})
+}
+
+// Ensures that the client auth file can be parsed from the command line.
+func TestConfigClientAuthFileFlag(t *testing.T) {
+	c := New()
+	assert.Nil(t, c.LoadFlags([]string{"-client-auth-file", "/tmp/file.auth"}), "")
+	assert.Equal(t, c.ClientAuthFile, "/tmp/file.auth", "")
+}
+
+// Ensures that the listen URLs can be parsed from the environment.
+func TestConfigListenURLsEnv(t *testing.T) {
+	withEnv("ETCD_LISTEN_URLS", "unix:///tmp/etcd.sock,http://127.0.0.1:4005", func(c *Config) {
+		assert.Nil(t, c.LoadEnv(), "")
+		assert.Equal(t, c.ListenURLs, []string{"unix:///tmp/etcd.sock", "http://127.0.0.1:4005"}, "")
+	})
+}
+
+// Ensures that the listen URLs can be parsed from the command line.
+func TestConfigListenURLsFlag(t *testing.T) {
+	c := New()
+	assert.Nil(t, c.LoadFlags([]string{"-listen-urls", "unix:///tmp/etcd.sock, http://127.0.0.1:4005"}), "")
+	assert.Equal(t, c.ListenURLs, []string{"unix:///tmp/etcd.sock", "http://127.0.0.1:4005"}, "")
+}
+
+// Ensures that listeners with their own TLS settings can be deserialized from TOML.
+func TestConfigListenersTOML(t *testing.T) {
+	content := `
+		cert_file = "/tmp/file.cert"
+		key_file = "/tmp/file.key"
+		listen_urls = ["unix:///tmp/etcd.sock"]
+
+		[[listeners]]
+		url = "unix:///tmp/agent.sock"
+		socket_mode = "0600"
+
+		[[listeners]]
+		url = "https://127.0.0.1:4005"
+		cert_file = "/tmp/other.cert"
+		key_file = "/tmp/other.key"
+
+		[[listeners]]
+		url = "https://127.0.0.1:4006"
+	`
+	c := New()
+	_, err := toml.Decode(content, &c)
+	assert.Nil(t, err, "")
+
+	listeners, err := c.ClientListeners()
+	assert.Nil(t, err, "")
+	if assert.Equal(t, len(listeners), 4, "") {
+		assert.Equal(t, listeners[0].Addr(), "/tmp/etcd.sock", "")
+		assert.Equal(t, listeners[0].SocketMode, server.DefaultSocketMode, "")
+		assert.Equal(t, listeners[1].Addr(), "/tmp/agent.sock", "")
+		assert.Equal(t, listeners[1].SocketMode, os.FileMode(0600), "")
+		assert.Equal(t, listeners[2].Addr(), "127.0.0.1:4005", "")
+		assert.Equal(t, listeners[2].TLSInfo.CertFile, "/tmp/other.cert", "")
+		assert.Equal(t, listeners[3].TLSInfo.CertFile, "/tmp/file.cert", "")
+	}
+}
+
+// Ensures that invalid listen URLs are rejected.
+func TestConfigInvalidListenURLs(t *testing.T) {
+	for _, u := range []string{"ftp://127.0.0.1:4005", "unix://", "http://127.0.0.1", "https://127.0.0.1:4005"} {
+		c := New()
+		c.ListenURLs = []string{u}
+		_, err := c.ClientListeners()
+		assert.Error(t, err, u)
+	}
+}
+
 // Ensures that the CA file can be parsed from the environment.
 func TestConfigCertFileEnv(t *testing.T) {
 	withEnv("ETCD_CERT_FILE", "/tmp/file.cert", func(c *Config) {
@@ -403,6 +496,35 @@ func TestConfigDataDirGuess(t *testing.T) {
 	assert.Equal(t, c.DataDir, name+".etcd", "")
 }
 
+// Ensures that DiscoveryService can be parsed from the environment.
+func TestConfigDiscoveryServiceEnv(t *testing.T) {
+	withEnv("ETCD_DISCOVERY_SERVICE", "1", func(c *Config) {
+		assert.Nil(t, c.LoadEnv(), "")
+		assert.Equal(t, c.DiscoveryService, true, "")
+	})
+}
+
+// Ensures that the DiscoveryService flag can be parsed.
+func TestConfigDiscoveryServiceFlag(t *testing.T) {
+	c := New()
+	assert.Nil(t, c.LoadFlags([]string{"-discovery-service"}), "")
+	assert.Equal(t, c.DiscoveryService, true, "")
+}
+
+// Ensures that the DiscoverySRV flag can be parsed.
+func TestConfigDiscoverySRVFlag(t *testing.T) {
+	c := New()
+	assert.Nil(t, c.LoadFlags([]string{"-discovery-srv", "example.com"}), "")
+	assert.Equal(t, c.DiscoverySRV, "example.com", "")
+}
+
+// Ensures that the DiscoveryTimeout flag can be parsed.
+func TestConfigDiscoveryTimeoutFlag(t *testing.T) {
+	c := New()
+	assert.Nil(t, c.LoadFlags([]string{"-discovery-timeout", "60"}), "")
+	assert.Equal(t, c.DiscoveryTimeout, 60.0, "")
+}
+
 // Ensures that Snapshot can be parsed from the environment.
 func TestConfigSnapshotEnv(t *testing.T) {
 	withEnv("ETCD_SNAPSHOT", "1", func(c *Config) {
@@ -418,6 +540,52 @@ func TestConfigSnapshotFlag(t *testing.T) {
 	assert.Equal(t, c.Snapshot, true, "")
 }
 
+// Ensures that the snapshot log size can be parsed from the environment.
+func TestConfigSnapshotLogSizeEnv(t *testing.T) {
+	withEnv("ETCD_SNAPSHOT_LOG_SIZE", "64", func(c *Config) {
+		assert.Nil(t, c.LoadEnv(), "")
+		assert.Equal(t, c.SnapshotLogSize, 64, "")
+	})
+}
+
+// Ensures that the snapshot log size flag can be parsed.
+func TestConfigSnapshotLogSizeFlag(t *testing.T) {
+	c := New()
+	assert.Nil(t, c.LoadFlags([]string{"-snapshot-log-size", "64"}), "")
+	assert.Equal(t, c.SnapshotLogSize, 64, "")
+}
+
+// Ensures that the snapshot interval can be parsed from the environment.
+func TestConfigSnapshotIntervalEnv(t *testing.T) {
+	withEnv("ETCD_SNAPSHOT_INTERVAL", "3600", func(c *Config) {
+		assert.Nil(t, c.LoadEnv(), "")
+		assert.Equal(t, c.SnapshotInterval, 3600.0, "")
+	})
+}
+
+// Ensures that the snapshot interval flag can be parsed.
+func TestConfigSnapshotIntervalFlag(t *testing.T) {
+	c := New()
+	assert.Nil(t, c.LoadFlags([]string{"-snapshot-interval", "3600"}), "")
+	assert.Equal(t, c.SnapshotInterval, 3600.0, "")
+}
+
+// Ensures that the snapshot retention can be parsed from the environment.
+func TestConfigSnapshotRetentionEnv(t *testing.T) {
+	withEnv("ETCD_SNAPSHOT_RETENTION", "5", func(c *Config) {
+		assert.Nil(t, c.LoadEnv(), "")
+		assert.Equal(t, c.SnapshotRetention, 5, "")
+	})
+}
+
+// Ensures that the snapshot retention flag can be parsed.
+func TestConfigSnapshotRetentionFlag(t *testing.T) {
+	c := New()
+	assert.Equal(t, c.SnapshotRetention, 1, "")
+	assert.Nil(t, c.LoadFlags([]string{"-snapshot-retention", "5"}), "")
+	assert.Equal(t, c.SnapshotRetention, 5, "")
+}
+
 // Ensures that Verbose can be parsed from the environment.
 func TestConfigVerboseEnv(t *testing.T) {
 	withEnv("ETCD_VERBOSE", "true", func(c *Config) {
@@ -531,6 +699,21 @@ func TestConfigPeerBindAddrFlag(t *testing.T) {
 	assert.Equal(t, c.Peer.BindAddr, "127.0.0.1:4003", "")
 }
 
+// Ensures that the peer snapshot rate can be parsed from the environment.
+func TestConfigPeerSnapshotRateEnv(t *testing.T) {
+	withEnv("ETCD_PEER_SNAPSHOT_RATE", "512", func(c *Config) {
+		assert.Nil(t, c.LoadEnv(), "")
+		assert.Equal(t, c.Peer.SnapshotRate, 512, "")
+	})
+}
+
+// Ensures that the peer snapshot rate flag can be parsed.
+func TestConfigPeerSnapshotRateFlag(t *testing.T) {
+	c := New()
+	assert.Nil(t, c.LoadFlags([]string{"-peer-snapshot-rate", "0"}), "")
+	assert.Equal(t, c.Peer.SnapshotRate, 0, "")
+}
+
 // Ensures that the cluster active size can be parsed from the environment.
 func TestConfigClusterActiveSizeEnv(t *testing.T) {
 	withEnv("ETCD_CLUSTER_ACTIVE_SIZE", "5", func(c *Config) {
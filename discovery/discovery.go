@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net/url"
 	"path"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,10 +17,20 @@ import (
 
 const (
 	stateKey     = "_state"
+	sizeKey      = "_size"
 	startedState = "started"
 	defaultTTL   = 604800 // One week TTL
+
+	// waitInterval is how often the registered members are checked while
+	// waiting for the expected cluster size.
+	waitInterval = 1 * time.Second
 )
 
+// ErrClusterFull is returned along with the peers of a cluster that
+// already has as many members as its discovery URL expects. The machine
+// can only follow such a cluster as a standby.
+var ErrClusterFull = errors.New("Discovery found that the cluster is full")
+
 type Discoverer struct {
 	client       *etcd.Client
 	name         string
@@ -33,7 +45,12 @@ func init() {
 	defaultDiscoverer = &Discoverer{}
 }
 
-func (d *Discoverer) Do(discoveryURL string, name string, peer string, closeChan <-chan bool, startRoutine func(func())) (peers []string, err error) {
+// Do registers the machine with a discovery URL and returns the peers to
+// join, or no peers if the machine starts the cluster.
+// If the discovery URL has an expected cluster size in its "_size" key, a
+// new cluster is only started once that many members have registered, which
+// Do waits for up to the timeout.
+func (d *Discoverer) Do(discoveryURL string, name string, peer string, timeout time.Duration, closeChan <-chan bool, startRoutine func(func())) (peers []string, err error) {
 	d.name = name
 	d.peer = peer
 	d.discoveryURL = discoveryURL
@@ -46,6 +63,9 @@ func (d *Discoverer) Do(discoveryURL string, name string, peer string, closeChan
 
 	// prefix is prepended to all keys for this discovery
 	d.prefix = strings.TrimPrefix(u.Path, "/v2/keys/")
+	// keys outside of the keys API are joined to the host, which
+	// adds its own slash
+	d.prefix = strings.TrimPrefix(d.prefix, "/")
 
 	// keep the old path in case we need to set the KeyPrefix below
 	oldPath := u.Path
@@ -63,9 +83,34 @@ func (d *Discoverer) Do(discoveryURL string, name string, peer string, closeChan
 	// this cluster
 	err = d.heartbeat()
 	if err != nil {
+		// A discovery service rejects members beyond the cluster size
+		if clientErr, ok := err.(*etcd.EtcdError); ok && clientErr.ErrorCode == etcdErr.EcodeNoMorePeer {
+			return d.overflow()
+		}
 		return
 	}
 
+	// Wait for the other members of a new cluster of known size, so that
+	// it doesn't start with fewer members than it needs for a quorum
+	size, err := d.size()
+	if err != nil {
+		return
+	}
+	if size > 0 {
+		var started bool
+		if started, err = d.started(); err != nil {
+			return
+		}
+		if !started {
+			if err = d.waitForMembers(size, timeout, closeChan); err == ErrClusterFull {
+				d.client.Delete(path.Join(d.prefix, d.name), false)
+				return d.overflow()
+			} else if err != nil {
+				return
+			}
+		}
+	}
+
 	// Start the very slow heartbeat to the cluster now in anticipation
 	// that everything is going to go alright now
 	startRoutine(func() { d.startHeartbeat(closeChan) })
@@ -120,6 +165,86 @@ func (d *Discoverer) findPeers() (peers []string, err error) {
 	return
 }
 
+// overflow returns the peers of a full cluster along with ErrClusterFull.
+func (d *Discoverer) overflow() ([]string, error) {
+	log.Infof("Discovery found that all the members of the cluster are registered.")
+	peers, err := d.findPeers()
+	if err != nil {
+		return nil, err
+	}
+	return peers, ErrClusterFull
+}
+
+// size returns the expected cluster size, or 0 if there is none.
+func (d *Discoverer) size() (int, error) {
+	resp, err := d.client.Get(path.Join(d.prefix, sizeKey), false, false)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	size, err := strconv.Atoi(resp.Node.Value)
+	if err != nil {
+		return 0, fmt.Errorf("Discovery found an invalid cluster size %q.", resp.Node.Value)
+	}
+	return size, nil
+}
+
+// started checks whether a member has already started the cluster.
+func (d *Discoverer) started() (bool, error) {
+	_, err := d.client.Get(path.Join(d.prefix, stateKey), false, false)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// waitForMembers waits until as many members as the cluster size have
+// registered. The members are counted in the order they registered, and
+// ErrClusterFull is returned if this machine isn't among the first ones.
+func (d *Discoverer) waitForMembers(size int, timeout time.Duration, closeChan <-chan bool) error {
+	var timeoutChan <-chan time.Time
+	if timeout > 0 {
+		timeoutChan = time.After(timeout)
+	}
+
+	registered := -1
+	for {
+		resp, err := d.client.Get(d.prefix, false, false)
+		if err != nil {
+			return err
+		}
+		members := byCreatedIndex(resp.Node.Nodes)
+		sort.Sort(members)
+		for i, n := range members {
+			if i >= size && path.Base(n.Key) == d.name {
+				return ErrClusterFull
+			}
+		}
+		if len(members) >= size {
+			log.Infof("Discovery found all %d members of the cluster.", size)
+			return nil
+		}
+
+		if len(members) != registered {
+			registered = len(members)
+			log.Infof("Discovery is waiting for %d more members (%d of %d registered).", size-registered, registered, size)
+		}
+
+		select {
+		case <-time.After(waitInterval):
+		case <-timeoutChan:
+			return fmt.Errorf("Discovery timed out waiting for %d members, %d registered.", size, registered)
+		case <-closeChan:
+			return errors.New("Discovery was stopped while waiting for members.")
+		}
+	}
+}
+
 func (d *Discoverer) startHeartbeat(closeChan <-chan bool) {
 	// In case of errors we should attempt to heartbeat fairly frequently
 	heartbeatInterval := defaultTTL / 8
@@ -143,6 +268,18 @@ func (d *Discoverer) heartbeat() error {
 	return err
 }
 
-func Do(discoveryURL string, name string, peer string, closeChan <-chan bool, startRoutine func(func())) ([]string, error) {
-	return defaultDiscoverer.Do(discoveryURL, name, peer, closeChan, startRoutine)
+func Do(discoveryURL string, name string, peer string, timeout time.Duration, closeChan <-chan bool, startRoutine func(func())) ([]string, error) {
+	return defaultDiscoverer.Do(discoveryURL, name, peer, timeout, closeChan, startRoutine)
+}
+
+func isKeyNotFound(err error) bool {
+	clientErr, ok := err.(*etcd.EtcdError)
+	return ok && clientErr.ErrorCode == etcdErr.EcodeKeyNotFound
 }
+
+// byCreatedIndex sorts nodes in the order they were created.
+type byCreatedIndex etcd.Nodes
+
+func (s byCreatedIndex) Len() int           { return len(s) }
+func (s byCreatedIndex) Less(i, j int) bool { return s[i].CreatedIndex < s[j].CreatedIndex }
+func (s byCreatedIndex) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
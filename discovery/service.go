@@ -0,0 +1,286 @@
+package discovery
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	etcdErr "github.com/coreos/etcd/error"
+	uhttp "github.com/coreos/etcd/pkg/http"
+	"github.com/coreos/etcd/store"
+	"github.com/coreos/etcd/third_party/github.com/goraft/raft"
+	"github.com/coreos/etcd/third_party/github.com/gorilla/mux"
+)
+
+const (
+	// servicePrefix is where the discovery service keeps its tokens.
+	servicePrefix = "/_etcd/discovery"
+
+	// DefaultClusterSize is the size of the cluster of a token requested
+	// without one.
+	DefaultClusterSize = 3
+)
+
+// A token of the discovery service is a directory that holds the members
+// registered with it, the "_state" key written by the first member and the
+// "_size" key with the expected cluster size. The directory expires when no
+// member has registered with it for the TTL of a member, which cleans up
+// the tokens of clusters that never started or are gone.
+
+// Server is the etcd server the discovery service runs in.
+// The service applies its changes as store commands through Do, so its
+// handlers only run on the leader.
+type Server interface {
+	State() string
+	Leader() string
+	URL() string
+	ClientURL(string) (string, bool)
+	Store() store.Store
+	Do(raft.Command) (interface{}, error)
+}
+
+// serviceHandler manages the discovery service HTTP requests.
+type serviceHandler struct {
+	*mux.Router
+	server Server
+
+	// mutex serializes registrations so that a token never gets more
+	// members than its size.
+	mutex sync.Mutex
+}
+
+// NewServiceHandler creates an HTTP handler for the discovery service that
+// can be registered on a router. Members find each other through it with
+// the same requests they send to any discovery URL.
+func NewServiceHandler(s Server) http.Handler {
+	h := &serviceHandler{
+		Router: mux.NewRouter(),
+		server: s,
+	}
+	h.StrictSlash(false)
+	h.handleFunc("/new", h.newTokenHandler).Methods("GET", "POST")
+	h.handleFunc("/{token}", h.getHandler).Methods("GET")
+	h.handleFunc("/{token}/{key:.*}", h.getHandler).Methods("GET")
+	h.handleFunc("/{token}/{key:.*}", h.registerHandler).Methods("PUT")
+	h.handleFunc("/{token}/{key:.*}", h.unregisterHandler).Methods("DELETE")
+	return h
+}
+
+// ServiceKey returns the key of the store that a request to the discovery
+// service at the given path reads or writes, and whether it writes it.
+// Creating a token is a write whatever the method.
+func ServiceKey(method, p string) (string, bool) {
+	if p == "/new" {
+		return servicePrefix, true
+	}
+	return path.Join(servicePrefix, p), method != "GET" && method != "HEAD"
+}
+
+func (h *serviceHandler) handleFunc(path string, f func(http.ResponseWriter, *http.Request) error) *mux.Route {
+	return h.Router.HandleFunc(path, func(w http.ResponseWriter, req *http.Request) {
+		// Tokens are only managed by the leader.
+		if h.server.State() != raft.Leader {
+			leader := h.server.Leader()
+			if leader == "" {
+				w.Header().Set("Content-Type", "application/json")
+				etcdErr.NewError(etcdErr.EcodeLeaderElect, "", h.server.Store().Index()).Write(w)
+				return
+			}
+			url, _ := h.server.ClientURL(leader)
+			uhttp.Redirect(url, w, req)
+			return
+		}
+
+		if err := f(w, req); err != nil {
+			switch err := err.(type) {
+			case *etcdErr.Error:
+				w.Header().Set("Content-Type", "application/json")
+				err.Write(w)
+			default:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+		}
+	})
+}
+
+// newTokenHandler creates a new token.
+// The "size" parameter specifies the expected size of the cluster.
+//
+// The response is the discovery URL of the token.
+func (h *serviceHandler) newTokenHandler(w http.ResponseWriter, req *http.Request) error {
+	size := DefaultClusterSize
+	if v := req.FormValue("size"); v != "" {
+		var err error
+		if size, err = strconv.Atoi(v); err != nil || size <= 0 {
+			return etcdErr.NewError(etcdErr.EcodeInvalidField, "New: size", h.server.Store().Index())
+		}
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(b)
+	dir := path.Join(servicePrefix, token)
+
+	c := h.server.Store().CommandFactory().CreateCreateCommand(dir, true, "", tokenExpireTime(), false)
+	if _, err := h.server.Do(c); err != nil {
+		return err
+	}
+	c = h.server.Store().CommandFactory().CreateSetCommand(path.Join(dir, sizeKey), false, strconv.Itoa(size), store.Permanent)
+	if _, err := h.server.Do(c); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "%s/discovery/%s", h.server.URL(), token)
+	return nil
+}
+
+// getHandler retrieves the members registered with a token, or one of its
+// keys.
+func (h *serviceHandler) getHandler(w http.ResponseWriter, req *http.Request) error {
+	vars := mux.Vars(req)
+	key := path.Join(servicePrefix, vars["token"], vars["key"])
+
+	recursive := req.FormValue("recursive") == "true"
+	sorted := req.FormValue("sorted") == "true"
+	e, err := h.server.Store().Get(key, recursive, sorted)
+	if err != nil {
+		return err
+	}
+	return h.writeEvent(w, e)
+}
+
+// registerHandler sets a key of a token.
+// The "value" and "ttl" parameters specify the value and TTL of the key,
+// and "prevExist=false" only creates the key if it doesn't exist.
+// A new member is rejected once as many members as the size of the token
+// are registered.
+func (h *serviceHandler) registerHandler(w http.ResponseWriter, req *http.Request) error {
+	vars := mux.Vars(req)
+	name := vars["key"]
+	dir := path.Join(servicePrefix, vars["token"])
+	index := h.server.Store().Index()
+
+	if name == "" || strings.Contains(name, "/") {
+		return etcdErr.NewError(etcdErr.EcodeInvalidField, "Register: name", index)
+	}
+	if strings.HasPrefix(name, "_") && name != stateKey {
+		return etcdErr.NewError(etcdErr.EcodeKeyIsPreserved, path.Join(dir, name), index)
+	}
+
+	expireTime := store.Permanent
+	if v := req.FormValue("ttl"); v != "" {
+		ttl, err := strconv.Atoi(v)
+		if err != nil || ttl < 0 {
+			return etcdErr.NewError(etcdErr.EcodeTTLNaN, "Register", index)
+		}
+		if ttl > 0 {
+			expireTime = time.Now().Add(time.Duration(ttl) * time.Second)
+		}
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	size, err := h.size(dir)
+	if err != nil {
+		return err
+	}
+	if name != stateKey {
+		e, err := h.server.Store().Get(dir, false, false)
+		if err != nil {
+			return err
+		}
+		registered := false
+		for _, node := range e.Node.Nodes {
+			if path.Base(node.Key) == name {
+				registered = true
+				break
+			}
+		}
+		if !registered && len(e.Node.Nodes) >= size {
+			return etcdErr.NewError(etcdErr.EcodeNoMorePeer, fmt.Sprintf("Register: cluster size is %d", size), index)
+		}
+	}
+
+	key := path.Join(dir, name)
+	value := req.FormValue("value")
+	var c raft.Command
+	if req.FormValue("prevExist") == "false" {
+		c = h.server.Store().CommandFactory().CreateCreateCommand(key, false, value, expireTime, false)
+	} else {
+		c = h.server.Store().CommandFactory().CreateSetCommand(key, false, value, expireTime)
+	}
+	result, err := h.server.Do(c)
+	if err != nil {
+		return err
+	}
+
+	// Every registration keeps the token alive.
+	c = h.server.Store().CommandFactory().CreateUpdateCommand(dir, "", tokenExpireTime())
+	if _, err := h.server.Do(c); err != nil {
+		return err
+	}
+
+	return h.writeEvent(w, result.(*store.Event))
+}
+
+// unregisterHandler removes a member from a token.
+func (h *serviceHandler) unregisterHandler(w http.ResponseWriter, req *http.Request) error {
+	vars := mux.Vars(req)
+	name := vars["key"]
+	if strings.HasPrefix(name, "_") || strings.Contains(name, "/") {
+		return etcdErr.NewError(etcdErr.EcodeKeyIsPreserved, name, h.server.Store().Index())
+	}
+
+	c := h.server.Store().CommandFactory().CreateDeleteCommand(path.Join(servicePrefix, vars["token"], name), false, false)
+	result, err := h.server.Do(c)
+	if err != nil {
+		return err
+	}
+	return h.writeEvent(w, result.(*store.Event))
+}
+
+// size retrieves the expected cluster size of a token.
+func (h *serviceHandler) size(dir string) (int, error) {
+	e, err := h.server.Store().Get(path.Join(dir, sizeKey), false, false)
+	if err != nil {
+		return 0, err
+	}
+	size, err := strconv.Atoi(*e.Node.Value)
+	if err != nil {
+		return 0, etcdErr.NewError(etcdErr.EcodeInvalidField, "size of "+dir, h.server.Store().Index())
+	}
+	return size, nil
+}
+
+// writeEvent writes an event the way the keys API does.
+func (h *serviceHandler) writeEvent(w http.ResponseWriter, e *store.Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("X-Etcd-Index", fmt.Sprint(h.server.Store().Index()))
+	if e.IsCreated() {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.Write(b)
+	return nil
+}
+
+// tokenExpireTime returns the time a token expires if no member registers
+// with it anymore.
+func tokenExpireTime() time.Time {
+	return time.Now().Add(defaultTTL * time.Second)
+}
@@ -0,0 +1,33 @@
+package discovery
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// lookupSRV resolves SRV records. Tests replace it with a stub resolver.
+var lookupSRV = net.LookupSRV
+
+// SRVPeers resolves the peer addresses a domain publishes in its
+// "_etcd-server._tcp" SRV records, or in its "_etcd-server-ssl._tcp" ones
+// for peers that use TLS. The peers are returned as host:port in the order
+// of the records.
+func SRVPeers(domain string, secure bool) ([]string, error) {
+	service := "etcd-server"
+	if secure {
+		service = "etcd-server-ssl"
+	}
+
+	_, addrs, err := lookupSRV(service, "tcp", domain)
+	if err != nil {
+		return nil, err
+	}
+
+	peers := make([]string, 0, len(addrs))
+	for _, srv := range addrs {
+		host := strings.TrimSuffix(srv.Target, ".")
+		peers = append(peers, net.JoinHostPort(host, strconv.Itoa(int(srv.Port))))
+	}
+	return peers, nil
+}
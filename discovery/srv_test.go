@@ -0,0 +1,53 @@
+package discovery
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/coreos/etcd/third_party/github.com/stretchr/testify/assert"
+)
+
+// withSRV replaces the SRV resolver with a stub for the duration of f.
+func withSRV(records map[string][]*net.SRV, f func()) {
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		addrs, ok := records["_"+service+"._"+proto+"."+name]
+		if !ok {
+			return "", nil, errors.New("no such host")
+		}
+		return "", addrs, nil
+	}
+	defer func() { lookupSRV = net.LookupSRV }()
+	f()
+}
+
+// Ensures that the SRV records of a domain are resolved into peers.
+func TestSRVPeers(t *testing.T) {
+	records := map[string][]*net.SRV{
+		"_etcd-server._tcp.example.com": {
+			{Target: "etcd1.example.com.", Port: 7001},
+			{Target: "10.0.0.2", Port: 7002},
+		},
+		"_etcd-server-ssl._tcp.example.com": {
+			{Target: "etcd1.example.com.", Port: 7443},
+		},
+	}
+	withSRV(records, func() {
+		peers, err := SRVPeers("example.com", false)
+		assert.Nil(t, err, "")
+		assert.Equal(t, peers, []string{"etcd1.example.com:7001", "10.0.0.2:7002"}, "")
+
+		peers, err = SRVPeers("example.com", true)
+		assert.Nil(t, err, "")
+		assert.Equal(t, peers, []string{"etcd1.example.com:7443"}, "")
+	})
+}
+
+// Ensures that a failed lookup is reported.
+func TestSRVPeersNotFound(t *testing.T) {
+	withSRV(nil, func() {
+		peers, err := SRVPeers("example.com", false)
+		assert.NotNil(t, err, "")
+		assert.Equal(t, len(peers), 0, "")
+	})
+}
@@ -34,6 +34,11 @@ var errors = map[int]string{
 	EcodeKeyIsPreserved:   "The prefix of given key is a keyword in etcd",
 	EcodeDirNotEmpty:      "Directory not empty",
 	EcodeExistingPeerAddr: "Peer address has existed",
+	EcodeMachineNotFound:  "Machine not found",
+	EcodeMachineExist:     "Machine already exists",
+	EcodeAccessDenied:     "Access denied",
+	EcodeClusterMismatch:  "Cluster ID mismatch",
+	EcodeLimitMismatch:    "Limit differs from the one of the semaphore",
 
 	// Post form related errors
 	EcodeValueRequired:        "Value is Required in POST form",
@@ -46,6 +51,8 @@ var errors = map[int]string{
 	EcodeIndexOrValueRequired: "Index or value is required",
 	EcodeIndexValueMutex:      "Index and value cannot both be specified",
 	EcodeInvalidField:         "Invalid field",
+	EcodeLimitNaN:             "The given limit in POST form is not a number",
+	EcodeCountNaN:             "The given count in POST form is not a number",
 
 	// raft related errors
 	EcodeRaftInternal: "Raft Internal Error",
@@ -73,6 +80,11 @@ const (
 	EcodeRootROnly        = 107
 	EcodeDirNotEmpty      = 108
 	EcodeExistingPeerAddr = 109
+	EcodeMachineNotFound  = 110
+	EcodeMachineExist     = 111
+	EcodeAccessDenied     = 112
+	EcodeClusterMismatch  = 113
+	EcodeLimitMismatch    = 114
 
 	EcodeValueRequired        = 200
 	EcodePrevValueRequired    = 201
@@ -84,6 +96,8 @@ const (
 	EcodeIndexOrValueRequired = 207
 	EcodeIndexValueMutex      = 208
 	EcodeInvalidField         = 209
+	EcodeLimitNaN             = 210
+	EcodeCountNaN             = 211
 
 	EcodeRaftInternal = 300
 	EcodeLeaderElect  = 301
@@ -132,12 +146,14 @@ func (e Error) Write(w http.ResponseWriter) {
 	// 3xx is raft internal error
 	status := http.StatusBadRequest
 	switch e.ErrorCode {
-	case EcodeKeyNotFound:
+	case EcodeKeyNotFound, EcodeMachineNotFound:
 		status = http.StatusNotFound
-	case EcodeNotFile, EcodeDirNotEmpty:
+	case EcodeNotFile, EcodeDirNotEmpty, EcodeAccessDenied, EcodeClusterMismatch:
 		status = http.StatusForbidden
-	case EcodeTestFailed, EcodeNodeExist:
+	case EcodeTestFailed, EcodeNodeExist, EcodeMachineExist:
 		status = http.StatusPreconditionFailed
+	case EcodeLimitMismatch:
+		status = http.StatusConflict
 	default:
 		if e.ErrorCode/100 == 3 {
 			status = http.StatusInternalServerError
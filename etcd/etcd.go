@@ -17,6 +17,9 @@ limitations under the License.
 package etcd
 
 import (
+	"crypto/tls"
+	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -31,6 +34,7 @@ import (
 	httpclient "github.com/coreos/etcd/third_party/github.com/mreiferson/go-httpclient"
 
 	"github.com/coreos/etcd/config"
+	"github.com/coreos/etcd/discovery"
 	ehttp "github.com/coreos/etcd/http"
 	"github.com/coreos/etcd/log"
 	"github.com/coreos/etcd/metrics"
@@ -167,45 +171,57 @@ func (e *Etcd) Run() {
 	dialTimeout := (3 * heartbeatInterval) + electionTimeout
 	responseHeaderTimeout := (3 * heartbeatInterval) + electionTimeout
 
-	clientTransporter := &httpclient.Transport{
-		ResponseHeaderTimeout: responseHeaderTimeout + extraTimeout,
-		// This is a workaround for Transport.CancelRequest doesn't work on
-		// HTTPS connections blocked. The patch for it is in progress,
-		// and would be available in Go1.3
-		// More: https://codereview.appspot.com/69280043/
-		ConnectTimeout: dialTimeout + extraTimeout,
-		RequestTimeout: responseHeaderTimeout + dialTimeout + 2*extraTimeout,
-	}
-	if e.Config.PeerTLSInfo().Scheme() == "https" {
-		clientTLSConfig, err := e.Config.PeerTLSInfo().ClientConfig()
-		if err != nil {
-			log.Fatal("client TLS error: ", err)
+	newClientTransporter := func() *httpclient.Transport {
+		return &httpclient.Transport{
+			ResponseHeaderTimeout: responseHeaderTimeout + extraTimeout,
+			// This is a workaround for Transport.CancelRequest doesn't work on
+			// HTTPS connections blocked. The patch for it is in progress,
+			// and would be available in Go1.3
+			// More: https://codereview.appspot.com/69280043/
+			ConnectTimeout: dialTimeout + extraTimeout,
+			RequestTimeout: responseHeaderTimeout + dialTimeout + 2*extraTimeout,
 		}
-		clientTransporter.TLSClientConfig = clientTLSConfig
-		clientTransporter.DisableCompression = true
+	}
+	// The TLS files of the peer listener are reloaded when they change,
+	// and connections to peers present the reloaded certificate too.
+	var clientTransporter http.RoundTripper = newClientTransporter()
+	peerTLSReloader := server.TLSServerReloader("peer", e.Config.PeerTLSInfo())
+	if peerTLSReloader != nil {
+		defer peerTLSReloader.Stop()
+		clientTransporter = peerTLSReloader.NewTransport(func(cfg *tls.Config) *httpclient.Transport {
+			tr := newClientTransporter()
+			tr.TLSClientConfig = cfg
+			tr.DisableCompression = true
+			return tr
+		})
 	}
 	client := server.NewClient(clientTransporter)
 
 	// Create peer server
 	psConfig := server.PeerServerConfig{
-		Name:          e.Config.Name,
-		Scheme:        e.Config.PeerTLSInfo().Scheme(),
-		URL:           e.Config.Peer.Addr,
-		SnapshotCount: e.Config.SnapshotCount,
-		RetryTimes:    e.Config.MaxRetryAttempts,
-		RetryInterval: e.Config.RetryInterval,
+		Name:             e.Config.Name,
+		Scheme:           e.Config.PeerTLSInfo().Scheme(),
+		URL:              e.Config.Peer.Addr,
+		SnapshotCount:    e.Config.SnapshotCount,
+		RetryTimes:       e.Config.MaxRetryAttempts,
+		RetryInterval:    e.Config.RetryInterval,
+		DiscoveryTimeout: e.Config.DiscoveryTimeout,
+
+		SnapshotLogSize:   int64(e.Config.SnapshotLogSize) * 1024 * 1024,
+		SnapshotInterval:  time.Duration(e.Config.SnapshotInterval * float64(time.Second)),
+		SnapshotRetention: e.Config.SnapshotRetention,
 	}
 	e.PeerServer = server.NewPeerServer(psConfig, client, e.Registry, e.Store, &mb, followersStats, serverStats)
 
 	// Create raft transporter and server
 	raftTransporter := server.NewTransporter(followersStats, serverStats, e.Registry, heartbeatInterval, dialTimeout, responseHeaderTimeout)
-	if e.Config.PeerTLSInfo().Scheme() == "https" {
-		raftClientTLSConfig, err := e.Config.PeerTLSInfo().ClientConfig()
-		if err != nil {
-			log.Fatal("raft client TLS error: ", err)
-		}
-		raftTransporter.SetTLSConfig(*raftClientTLSConfig)
+	if peerTLSReloader != nil {
+		raftTransporter.SetTLSConfig(*peerTLSReloader.ClientConfig())
+		peerTLSReloader.OnReload(func() {
+			raftTransporter.SetTLSConfig(*peerTLSReloader.ClientConfig())
+		})
 	}
+	raftTransporter.SetSnapshotRate(int64(e.Config.Peer.SnapshotRate) * 1024)
 	raftServer, err := raft.NewServer(e.Config.Name, e.Config.DataDir, raftTransporter, e.Store, e.PeerServer, "")
 	if err != nil {
 		log.Fatal(err)
@@ -221,6 +237,21 @@ func (e *Etcd) Run() {
 		e.Server.EnableTracing()
 	}
 
+	if e.Config.DiscoveryService {
+		e.Server.EnableDiscoveryService()
+	}
+
+	if e.Config.ClientAuthFile != "" {
+		if e.Config.CAFile == "" {
+			log.Fatal("client auth file requires a client CA file to verify client certificates")
+		}
+		clientAuth, err := server.LoadClientAuth(e.Config.ClientAuthFile)
+		if err != nil {
+			log.Fatal("client auth error: ", err)
+		}
+		e.Server.SetClientAuth(clientAuth)
+	}
+
 	e.PeerServer.SetServer(e.Server)
 
 	// Create standby server
@@ -236,11 +267,25 @@ func (e *Etcd) Run() {
 
 	// Generating config could be slow.
 	// Put it here to make listen happen immediately after peer-server starting.
-	peerTLSConfig := server.TLSServerConfig(e.Config.PeerTLSInfo())
-	etcdTLSConfig := server.TLSServerConfig(e.Config.EtcdTLSInfo())
+	// The TLS files of both listeners are reloaded when they change.
+	etcdTLSReloader := server.TLSServerReloader("client", e.Config.EtcdTLSInfo())
+	if etcdTLSReloader != nil {
+		defer etcdTLSReloader.Stop()
+	}
+	e.PeerServer.SetTLSReloaders(peerTLSReloader, etcdTLSReloader)
 
 	if !e.StandbyServer.IsRunning() {
-		startPeerServer, possiblePeers, err := e.PeerServer.FindCluster(e.Config.Discovery, e.Config.Peers)
+		peers := e.Config.Peers
+		if e.Config.DiscoverySRV != "" {
+			srvPeers, err := discovery.SRVPeers(e.Config.DiscoverySRV, e.Config.PeerTLSInfo().Scheme() == "https")
+			if err != nil {
+				log.Warnf("%s failed to resolve the peers of %s: %v", e.Config.Name, e.Config.DiscoverySRV, err)
+			} else {
+				log.Infof("%s found peers %v in the SRV records of %s", e.Config.Name, srvPeers, e.Config.DiscoverySRV)
+				peers = append(peers, srvPeers...)
+			}
+		}
+		startPeerServer, possiblePeers, err := e.PeerServer.FindCluster(e.Config.Discovery, peers)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -259,7 +304,27 @@ func (e *Etcd) Run() {
 	standbyServerHTTPHandler := &ehttp.CORSHandler{e.StandbyServer.ClientHTTPHandler(), corsInfo}
 
 	log.Infof("etcd server [name %s, listen on %s, advertised url %s]", e.Server.Name, e.Config.BindAddr, e.Server.URL())
-	listener := server.NewListener(e.Config.EtcdTLSInfo().Scheme(), e.Config.BindAddr, etcdTLSConfig)
+	activated := newActivatedListeners()
+	listener := activated.listen(e.Config.EtcdTLSInfo().Scheme(), e.Config.BindAddr, etcdTLSReloader)
+	listeners := []net.Listener{listener}
+
+	clientListeners, err := e.Config.ClientListeners()
+	if err != nil {
+		log.Fatal("Failed to configure listeners: ", err)
+	}
+	for _, info := range clientListeners {
+		var r *server.TLSReloader
+		if info.Scheme() == "https" {
+			r = server.TLSServerReloader(fmt.Sprintf("client %s", info.URL), &info.TLSInfo)
+			defer r.Stop()
+		}
+		l, err := activated.listenURL(info, r)
+		if err != nil {
+			log.Fatal("Failed to create listener: ", err)
+		}
+		log.Infof("etcd server [name %s, listen on %s]", e.Server.Name, info.URL)
+		listeners = append(listeners, l)
+	}
 
 	e.server = &http.Server{Handler: &ModeHandler{e, serverHTTPHandler, standbyServerHTTPHandler},
 		ReadTimeout:  time.Duration(e.Config.HTTPReadTimeout) * time.Second,
@@ -267,7 +332,8 @@ func (e *Etcd) Run() {
 	}
 
 	log.Infof("peer server [name %s, listen on %s, advertised url %s]", e.PeerServer.Config.Name, e.Config.Peer.BindAddr, e.PeerServer.Config.URL)
-	peerListener := server.NewListener(e.Config.PeerTLSInfo().Scheme(), e.Config.Peer.BindAddr, peerTLSConfig)
+	peerListener := activated.listen(e.Config.PeerTLSInfo().Scheme(), e.Config.Peer.BindAddr, peerTLSReloader)
+	activated.close()
 
 	e.peerServer = &http.Server{Handler: &ModeHandler{e, peerServerHTTPHandler, http.NotFoundHandler()},
 		ReadTimeout:  time.Duration(server.DefaultReadTimeout) * time.Second,
@@ -275,16 +341,18 @@ func (e *Etcd) Run() {
 	}
 
 	wg := sync.WaitGroup{}
-	wg.Add(2)
-	go func() {
-		<-e.readyNotify
-		defer wg.Done()
-		if err := e.server.Serve(listener); err != nil {
-			if !isListenerClosing(err) {
-				log.Fatal(err)
+	wg.Add(len(listeners) + 1)
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			<-e.readyNotify
+			defer wg.Done()
+			if err := e.server.Serve(l); err != nil {
+				if !isListenerClosing(err) {
+					log.Fatal(err)
+				}
 			}
-		}
-	}()
+		}(l)
+	}
 	go func() {
 		<-e.readyNotify
 		defer wg.Done()
@@ -295,9 +363,13 @@ func (e *Etcd) Run() {
 		}
 	}()
 
+	go e.notifyReady()
 	e.runServer()
+	e.notifyStopping()
 
-	listener.Close()
+	for _, l := range listeners {
+		l.Close()
+	}
 	peerListener.Close()
 	wg.Wait()
 	log.Infof("etcd instance is stopped [name %s]", e.Config.Name)
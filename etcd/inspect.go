@@ -0,0 +1,212 @@
+/*
+Copyright 2014 CoreOS Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package etcd
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/coreos/etcd/third_party/github.com/goraft/raft"
+
+	"github.com/coreos/etcd/store"
+)
+
+// Inspect runs "etcd inspect": it prints what is in a data directory
+// without starting a member on it. -snapshots lists the snapshots, with
+// their index, term and size, and is the default. -log dumps the entries of
+// the raft log as JSON lines, with their commands decoded. -keys=<prefix>
+// prints the keys under the prefix as JSON lines, from the latest snapshot
+// unless -snapshot=<path> is given; hidden keys are listed only when the
+// prefix names them.
+//
+// The commands are decoded with the types registered by the server and
+// store/v2 packages.
+func Inspect(args []string, w io.Writer) error {
+	f := flag.NewFlagSet("etcd inspect", flag.ContinueOnError)
+	f.SetOutput(ioutil.Discard)
+	dataDir := f.String("data-dir", "", "")
+	snapshots := f.Bool("snapshots", false, "")
+	dumpLog := f.Bool("log", false, "")
+	prefix := f.String("keys", "", "")
+	snapshotPath := f.String("snapshot", "", "")
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	if *dataDir == "" {
+		return errors.New("inspect: -data-dir is required")
+	}
+	if !*dumpLog && *prefix == "" {
+		*snapshots = true
+	}
+
+	if *snapshots {
+		if err := inspectSnapshots(*dataDir, w); err != nil {
+			return fmt.Errorf("inspect: %v", err)
+		}
+	}
+	if *dumpLog {
+		if err := inspectLog(*dataDir, w); err != nil {
+			return fmt.Errorf("inspect: %v", err)
+		}
+	}
+	if *prefix != "" {
+		if err := inspectKeys(*dataDir, *snapshotPath, *prefix, w); err != nil {
+			return fmt.Errorf("inspect: %v", err)
+		}
+	}
+	return nil
+}
+
+// snapshotInfo is a snapshot file of a data directory.
+type snapshotInfo struct {
+	path  string
+	term  uint64
+	index uint64
+}
+
+type snapshotInfos []snapshotInfo
+
+func (a snapshotInfos) Len() int           { return len(a) }
+func (a snapshotInfos) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a snapshotInfos) Less(i, j int) bool { return a[i].index < a[j].index }
+
+// findSnapshots lists the snapshot files of a data directory, the one raft
+// loads on restart as well as the archived ones, oldest first.
+func findSnapshots(dataDir string) (snapshotInfos, error) {
+	paths, err := filepath.Glob(filepath.Join(dataDir, "snapshot*", "*.ss"))
+	if err != nil {
+		return nil, err
+	}
+
+	var infos snapshotInfos
+	for _, p := range paths {
+		var info snapshotInfo
+		if _, err := fmt.Sscanf(filepath.Base(p), "%d_%d.ss", &info.term, &info.index); err != nil {
+			continue
+		}
+		info.path = p
+		infos = append(infos, info)
+	}
+	sort.Sort(infos)
+	return infos, nil
+}
+
+func inspectSnapshots(dataDir string, w io.Writer) error {
+	infos, err := findSnapshots(dataDir)
+	if err != nil {
+		return err
+	}
+	if len(infos) == 0 {
+		fmt.Fprintln(w, "no snapshots")
+		return nil
+	}
+
+	for _, info := range infos {
+		name, _ := filepath.Rel(dataDir, info.path)
+		var size int64
+		if fi, err := os.Stat(info.path); err == nil {
+			size = fi.Size()
+		}
+		fmt.Fprintf(w, "%s: index %d, term %d, %d bytes", name, info.index, info.term, size)
+		if _, err := raft.ReadSnapshot(info.path); err != nil {
+			fmt.Fprintf(w, ", unreadable: %v", err)
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// logEntry is the JSON line of an entry of the raft log.
+type logEntry struct {
+	Index   uint64          `json:"index"`
+	Term    uint64          `json:"term"`
+	Command string          `json:"command"`
+	Args    json.RawMessage `json:"args,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+func inspectLog(dataDir string, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	tail, err := raft.ReadLog(filepath.Join(dataDir, "log"), func(e *raft.LogEntry) error {
+		entry := logEntry{Index: e.Index(), Term: e.Term(), Command: e.CommandName()}
+		command, err := raft.DecodeCommand(e.CommandName(), e.Command())
+		if err == nil {
+			entry.Args, err = json.Marshal(command)
+		}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		return enc.Encode(&entry)
+	})
+	if err != nil {
+		return err
+	}
+	if tail != nil {
+		return fmt.Errorf("torn tail of the log: %v; run etcd repair to drop it", tail)
+	}
+	return nil
+}
+
+func inspectKeys(dataDir, snapshotPath, prefix string, w io.Writer) error {
+	if snapshotPath == "" {
+		infos, err := findSnapshots(dataDir)
+		if err != nil {
+			return err
+		}
+		if len(infos) == 0 {
+			return errors.New("no snapshot to read the keys from")
+		}
+		snapshotPath = infos[len(infos)-1].path
+	}
+
+	ss, err := raft.ReadSnapshot(snapshotPath)
+	if err != nil {
+		return err
+	}
+	s := store.New()
+	if err := s.Recovery(ss.State); err != nil {
+		return err
+	}
+
+	e, err := s.Get(prefix, true, true)
+	if err != nil {
+		return err
+	}
+	return printNodes(json.NewEncoder(w), e.Node)
+}
+
+// printNodes writes a node and the nodes under it, one per line.
+func printNodes(enc *json.Encoder, n *store.NodeExtern) error {
+	node := *n
+	node.Nodes = nil
+	if err := enc.Encode(&node); err != nil {
+		return err
+	}
+	for _, child := range n.Nodes {
+		if err := printNodes(enc, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
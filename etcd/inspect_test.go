@@ -0,0 +1,107 @@
+/*
+Copyright 2014 CoreOS Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package etcd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/etcd/third_party/github.com/goraft/raft"
+
+	"github.com/coreos/etcd/config"
+	"github.com/coreos/etcd/store"
+)
+
+// Ensure that inspect lists the snapshots, decodes the log and prints the
+// keys of a data directory.
+func TestInspect(t *testing.T) {
+	path, _ := ioutil.TempDir("", "etcd-")
+	defer os.RemoveAll(path)
+
+	config := config.New()
+	config.Name = "ETCDTEST"
+	config.DataDir = path
+	config.Addr = "localhost:0"
+	config.Peer.Addr = "localhost:0"
+
+	etcd := New(config)
+	go etcd.Run()
+	<-etcd.ReadyNotify()
+	etcd.Stop()
+
+	var out bytes.Buffer
+	if err := Inspect([]string{"-data-dir", path, "-log"}, &out); err != nil {
+		t.Fatalf("Unable to inspect the log: %v", err)
+	}
+	var entry struct {
+		Index   uint64
+		Command string
+		Args    map[string]interface{}
+	}
+	line := strings.SplitN(out.String(), "\n", 2)[0]
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		t.Fatalf("Unable to decode %q: %v", line, err)
+	}
+	if entry.Index != 1 || entry.Command != "etcd:join" || entry.Args["name"] != "ETCDTEST" {
+		t.Fatalf("Unexpected first entry: %s", line)
+	}
+
+	s := store.New()
+	s.Set("/foo/bar", false, "baz", store.Permanent)
+	s.Set("/other", false, "x", store.Permanent)
+	writeSnapshot(t, filepath.Join(path, "snapshot", "2_12.ss"), 12, 2, s)
+	writeSnapshot(t, filepath.Join(path, "snapshot.archive", "1_5.ss"), 5, 1, store.New())
+
+	out.Reset()
+	if err := Inspect([]string{"-data-dir", path}, &out); err != nil {
+		t.Fatalf("Unable to list the snapshots: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "snapshot.archive/1_5.ss: index 5, term 1,") || !strings.HasPrefix(lines[1], "snapshot/2_12.ss: index 12, term 2,") {
+		t.Fatalf("Unexpected snapshots: %s", out.String())
+	}
+
+	out.Reset()
+	if err := Inspect([]string{"-data-dir", path, "-keys", "/foo"}, &out); err != nil {
+		t.Fatalf("Unable to print the keys: %v", err)
+	}
+	expected := `{"key":"/foo","dir":true,"modifiedIndex":1,"createdIndex":1}
+{"key":"/foo/bar","value":"baz","modifiedIndex":1,"createdIndex":1}
+`
+	if out.String() != expected {
+		t.Fatalf("Unexpected keys: %s", out.String())
+	}
+}
+
+func writeSnapshot(t *testing.T, path string, index, term uint64, s store.Store) {
+	state, err := s.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(&raft.Snapshot{LastIndex: index, LastTerm: term, State: state, Path: path})
+	os.MkdirAll(filepath.Dir(path), 0700)
+	if err := ioutil.WriteFile(path, []byte(fmt.Sprintf("%08x\n%s", crc32.ChecksumIEEE(b), b)), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2014 CoreOS Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package etcd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/coreos/etcd/third_party/github.com/goraft/raft"
+)
+
+// Repair runs "etcd repair": it drops the torn tail of the raft log in a
+// data directory, which a power loss may leave, and reports the indexes
+// that were dropped. It refuses to change a log that is damaged before its
+// tail, as that would drop entries that were acknowledged. etcd must not
+// be running on the data directory.
+func Repair(args []string, w io.Writer) error {
+	f := flag.NewFlagSet("etcd repair", flag.ContinueOnError)
+	f.SetOutput(ioutil.Discard)
+	dataDir := f.String("data-dir", "", "")
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	if *dataDir == "" {
+		return errors.New("repair: -data-dir is required")
+	}
+
+	path := filepath.Join(*dataDir, "log")
+	tail, err := raft.RepairLog(path)
+	if err != nil {
+		if _, ok := err.(*raft.CorruptLogError); ok {
+			return fmt.Errorf("repair: %v\nThe log is damaged before its tail and was left unchanged. Restore the member from a backup, or remove it from the cluster and add it back with an empty data directory.", err)
+		}
+		return fmt.Errorf("repair: %v", err)
+	}
+
+	if tail == nil {
+		fmt.Fprintf(w, "%s: no damage found\n", path)
+		return nil
+	}
+
+	fmt.Fprintf(w, "%s: dropped torn tail of %d bytes at offset %d: %v\n", tail.Path, tail.Size, tail.Offset, tail.Err)
+	fmt.Fprintf(w, "dropped indexes: %d and later", tail.LastIndex+1)
+	if len(tail.Indexes) > 0 {
+		fmt.Fprintf(w, " (partially written: %v)", tail.Indexes)
+	}
+	fmt.Fprintln(w)
+	return nil
+}
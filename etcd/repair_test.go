@@ -0,0 +1,84 @@
+/*
+Copyright 2014 CoreOS Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package etcd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/etcd/config"
+)
+
+// Ensure that repair drops the torn tail of the log of a stopped member.
+func TestRepair(t *testing.T) {
+	path, _ := ioutil.TempDir("", "etcd-")
+	defer os.RemoveAll(path)
+
+	config := config.New()
+	config.Name = "ETCDTEST"
+	config.DataDir = path
+	config.Addr = "localhost:0"
+	config.Peer.Addr = "localhost:0"
+
+	etcd := New(config)
+	go etcd.Run()
+	<-etcd.ReadyNotify()
+	etcd.Stop()
+
+	// Damage the end of the last segment of the log.
+	segments, _ := filepath.Glob(filepath.Join(path, "log", "*.log"))
+	if len(segments) == 0 {
+		t.Fatal("No log segments found")
+	}
+	logPath := segments[len(segments)-1]
+	fi, err := os.Stat(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, _ := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString("      2a 00")
+	f.Close()
+
+	var out bytes.Buffer
+	if err := Repair([]string{"-data-dir", path}, &out); err != nil {
+		t.Fatalf("Unable to repair: %v", err)
+	}
+	if !strings.Contains(out.String(), "dropped torn tail of 11 bytes") {
+		t.Fatalf("Unexpected output: %s", out.String())
+	}
+	if fi2, _ := os.Stat(logPath); fi2.Size() != fi.Size() {
+		t.Fatalf("Expected the log to be truncated to %d bytes, got %d", fi.Size(), fi2.Size())
+	}
+
+	out.Reset()
+	if err := Repair([]string{"-data-dir", path}, &out); err != nil {
+		t.Fatalf("Unable to repair: %v", err)
+	}
+	if !strings.Contains(out.String(), "no damage found") {
+		t.Fatalf("Unexpected output: %s", out.String())
+	}
+}
+
+func TestRepairWithoutDataDir(t *testing.T) {
+	if err := Repair(nil, ioutil.Discard); err == nil {
+		t.Fatal("Repair without a data directory should fail")
+	}
+}
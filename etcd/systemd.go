@@ -0,0 +1,119 @@
+package etcd
+
+import (
+	"net"
+	"time"
+
+	"github.com/coreos/etcd/log"
+	"github.com/coreos/etcd/pkg/systemd"
+	"github.com/coreos/etcd/server"
+)
+
+// activatedListeners holds the listening sockets passed by systemd socket
+// activation that have not been claimed by a listener yet.
+type activatedListeners []net.Listener
+
+func newActivatedListeners() activatedListeners {
+	listeners, err := systemd.Listeners()
+	if err != nil {
+		log.Fatal("Failed to use socket activated listeners: ", err)
+	}
+	if len(listeners) > 0 {
+		log.Infof("received %d socket activated listeners", len(listeners))
+	}
+	return activatedListeners(listeners)
+}
+
+// listen returns the activated listener bound to addr, wrapped in TLS for
+// the "https" scheme, or creates a new listener if there is none.
+func (a *activatedListeners) listen(scheme, addr string, r *server.TLSReloader) net.Listener {
+	if l := a.take("tcp", addr); l != nil {
+		log.Infof("using socket activated listener on %s", l.Addr())
+		return server.WrapListener(scheme, l, r)
+	}
+	return server.NewListener(scheme, addr, r)
+}
+
+// listenURL is like listen for the additional client listeners.
+func (a *activatedListeners) listenURL(info *server.ListenerInfo, r *server.TLSReloader) (net.Listener, error) {
+	network := "tcp"
+	if info.Scheme() == "unix" {
+		network = "unix"
+	}
+	if l := a.take(network, info.Addr()); l != nil {
+		log.Infof("using socket activated listener on %s", info.URL)
+		return server.WrapListener(info.Scheme(), l, r), nil
+	}
+	return server.NewURLListener(info, r)
+}
+
+// take removes and returns the activated listener bound to addr.
+func (a *activatedListeners) take(network, addr string) net.Listener {
+	for i, l := range *a {
+		if listenerBoundTo(l, network, addr) {
+			*a = append((*a)[:i], (*a)[i+1:]...)
+			return l
+		}
+	}
+	return nil
+}
+
+// close closes the activated listeners that match no configured address.
+func (a activatedListeners) close() {
+	for _, l := range a {
+		log.Warnf("socket activated listener on %s matches no configured address", l.Addr())
+		l.Close()
+	}
+}
+
+func listenerBoundTo(l net.Listener, network, addr string) bool {
+	if network == "unix" {
+		return l.Addr().Network() == "unix" && l.Addr().String() == addr
+	}
+
+	la, ok := l.Addr().(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	if addr == "" {
+		addr = ":http"
+	}
+	ta, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil || ta.Port != la.Port {
+		return false
+	}
+	// An empty host binds all addresses, as does an unspecified IP.
+	if ta.IP == nil || ta.IP.IsUnspecified() {
+		return la.IP == nil || la.IP.IsUnspecified()
+	}
+	return ta.IP.Equal(la.IP)
+}
+
+// notifyReady tells systemd that the member is ready once it has started
+// and, in peer mode, knows the leader of the cluster.
+func (e *Etcd) notifyReady() {
+	select {
+	case <-e.readyNotify:
+	case <-e.closeChan:
+		return
+	}
+
+	for e.Mode() == PeerMode && e.PeerServer.RaftServer().Leader() == "" {
+		select {
+		case <-time.After(50 * time.Millisecond):
+		case <-e.closeChan:
+			return
+		}
+	}
+
+	if err := systemd.Notify("READY=1"); err != nil {
+		log.Warnf("failed notifying systemd of readiness: %v", err)
+	}
+}
+
+// notifyStopping tells systemd that the member is shutting down.
+func (e *Etcd) notifyStopping() {
+	if err := systemd.Notify("STOPPING=1"); err != nil {
+		log.Warnf("failed notifying systemd of shutdown: %v", err)
+	}
+}
@@ -60,6 +60,13 @@ func (h *CORSHandler) addHeader(w http.ResponseWriter, origin string) {
 // ServeHTTP adds the correct CORS headers based on the origin and returns immediately
 // with a 200 OK if the method is OPTIONS.
 func (h *CORSHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// Upgraded connections are taken over by the handler, so there is
+	// nothing left to write or flush.
+	if req.Header.Get("Upgrade") != "" {
+		h.Handler.ServeHTTP(w, req)
+		return
+	}
+
 	// It is important to flush before leaving the goroutine.
 	// Or it may miss the latest info written.
 	defer w.(http.Flusher).Flush()
@@ -18,7 +18,10 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/coreos/etcd/config"
 	"github.com/coreos/etcd/etcd"
@@ -26,6 +29,23 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		var run func([]string, io.Writer) error
+		switch os.Args[1] {
+		case "repair":
+			run = etcd.Repair
+		case "inspect":
+			run = etcd.Inspect
+		}
+		if run != nil {
+			if err := run(os.Args[2:], os.Stdout); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+	}
+
 	var config = config.New()
 	if err := config.Load(os.Args[1:]); err != nil {
 		fmt.Println(server.Usage() + "\n")
@@ -40,5 +60,16 @@ func main() {
 	}
 
 	var etcd = etcd.New(config)
+
+	// Shut down cleanly on SIGTERM so that the service manager is told
+	// that etcd is stopping. A second signal kills the process.
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGTERM)
+	go func() {
+		<-c
+		signal.Stop(c)
+		etcd.Stop()
+	}()
+
 	etcd.Run()
 }
@@ -1,7 +1,6 @@
 package v2
 
 import (
-	"errors"
 	"fmt"
 	"net/http"
 	"path"
@@ -9,7 +8,7 @@ import (
 	"time"
 
 	etcdErr "github.com/coreos/etcd/error"
-	"github.com/coreos/etcd/third_party/github.com/coreos/go-etcd/etcd"
+	"github.com/coreos/etcd/store"
 	"github.com/coreos/etcd/third_party/github.com/gorilla/mux"
 )
 
@@ -17,58 +16,136 @@ import (
 // The "key" parameter specifies the resource to lock.
 // The "value" parameter specifies a value to associate with the lock.
 // The "ttl" parameter specifies how long the lock will persist for.
+// The "session" parameter ties the lock to a session instead of a TTL.
 // The "timeout" parameter specifies how long the request should wait for the lock.
+// The "limit" parameter specifies how many holders a semaphore allows.
+// The "mode" parameter specifies whether a read/write lock request is a
+// "read" or a "write" one; it defaults to "write".
+//
+// The response is the index of the lock, which is also its fencing token:
+// every grant of a lock returns a greater index than the previous ones.
 func (h *handler) acquireHandler(w http.ResponseWriter, req *http.Request) error {
-	h.client.SyncCluster()
-
 	// Setup connection watcher.
 	closeNotifier, _ := w.(http.CloseNotifier)
 	closeChan := closeNotifier.CloseNotify()
 
-	// Wrap closeChan so we can pass it to subsequent components
-	timeoutChan := make(chan bool)
-	stopChan := make(chan bool)
-	go func() {
-		select {
-		case <-closeChan:
-			// Client closed connection
-			stopChan <- true
-		case <-timeoutChan:
-			// Timeout expired
-			stopChan <- true
-		case <-stopChan:
-		}
-		close(stopChan)
-	}()
-
 	// Parse the lock "key".
 	vars := mux.Vars(req)
-	keypath := path.Join(prefix, vars["key"])
+	keypath := path.Join(h.prefix, vars["key"])
 	value := req.FormValue("value")
 
-	// Parse "timeout" parameter.
-	var timeout int
-	var err error
+	// Parse the parameters of the lock variant.
+	mode := writeMode
+	switch h.variant {
+	case semaphore:
+		limit, err := strconv.Atoi(req.FormValue("limit"))
+		if err != nil || limit <= 0 {
+			return etcdErr.NewError(etcdErr.EcodeLimitNaN, "Acquire", 0)
+		}
+		if err := h.checkLimit(keypath, limit); err != nil {
+			return err
+		}
+
+	case readWrite:
+		if m := req.FormValue("mode"); m != "" {
+			mode = m
+		}
+		if mode != readMode && mode != writeMode {
+			return etcdErr.NewError(etcdErr.EcodeInvalidField, "Acquire: mode", 0)
+		}
+	}
+
+	timeout, l, err := parseLease(req, "Acquire")
+	if err != nil {
+		return err
+	}
+
+	// Default the value to "-" if it is blank.
+	nodeValue := value
+	if len(nodeValue) == 0 {
+		nodeValue = "-"
+	}
+
+	index, err := h.acquire(keypath, value, h.nodeValue(nodeValue, mode), l, timeout, closeChan)
+	if err != nil {
+		return err
+	}
+
+	// Write response.
+	w.Write([]byte(strconv.Itoa(index)))
+	return nil
+}
+
+// lease is how long a lock node lives: for a TTL, or as long as a session.
+type lease struct {
+	ttl     int
+	session string
+}
+
+// expireTime returns the expiration time of a node with the lease.
+func (l lease) expireTime(s Server) (time.Time, error) {
+	if l.session != "" {
+		return sessionExpiration(s, l.session)
+	}
+	return expireTime(l.ttl), nil
+}
+
+// parseTimeout parses the "timeout" parameter of a request.
+// A timeout of -1 means waiting forever.
+func parseTimeout(req *http.Request, cause string) (int, error) {
 	if req.FormValue("timeout") == "" {
-		timeout = -1
-	} else if timeout, err = strconv.Atoi(req.FormValue("timeout")); err != nil {
-		return etcdErr.NewError(etcdErr.EcodeTimeoutNaN, "Acquire", 0)
+		return -1, nil
 	}
+	timeout, err := strconv.Atoi(req.FormValue("timeout"))
+	if err != nil {
+		return 0, etcdErr.NewError(etcdErr.EcodeTimeoutNaN, cause, 0)
+	}
+	return timeout, nil
+}
+
+// timeoutAfter returns a channel that fires when the timeout in seconds runs
+// out, or nil if the timeout is infinite.
+func timeoutAfter(timeout int) <-chan time.Time {
+	if timeout < 0 {
+		return nil
+	}
+	return time.After(time.Duration(timeout) * time.Second)
+}
 
-	// Parse TTL.
-	ttl, err := strconv.Atoi(req.FormValue("ttl"))
+// parseLease parses the "timeout" parameter and the "ttl" or "session"
+// parameter of a request.
+func parseLease(req *http.Request, cause string) (int, lease, error) {
+	var l lease
+	timeout, err := parseTimeout(req, cause)
 	if err != nil {
-		return etcdErr.NewError(etcdErr.EcodeTTLNaN, "Acquire", 0)
+		return 0, l, err
 	}
 
+	// The TTL is only needed without a session.
+	if l.session = req.FormValue("session"); l.session == "" {
+		if l.ttl, err = strconv.Atoi(req.FormValue("ttl")); err != nil {
+			return 0, l, etcdErr.NewError(etcdErr.EcodeTTLNaN, cause, 0)
+		}
+	}
+	return timeout, l, nil
+}
+
+// acquire queues a request for the lock at keypath, unless the owner
+// already has one, and waits until the request is granted.
+// A blank owner always queues a new request.
+// It returns the index of the lock node.
+func (h *handler) acquire(keypath string, owner string, value string, l lease, timeout int, closeChan <-chan bool) (int, error) {
 	// Search for the node
-	_, index, pos := h.findExistingNode(keypath, value)
+	index, pos, err := h.findExistingNode(keypath, owner)
+	if err != nil {
+		return 0, err
+	}
 	if index == 0 {
 		// Node doesn't exist; Create it
 		pos = -1 // Invalidate previous position
-		index, err = h.createNode(keypath, value, ttl)
+		index, err = h.createNode(keypath, value, l)
 		if err != nil {
-			return err
+			return 0, err
 		}
 	}
 
@@ -81,161 +158,165 @@ func (h *handler) acquireHandler(w http.ResponseWriter, req *http.Request) error
 			err = h.get(keypath, index)
 		} else {
 			// Keep updating TTL while we wait
-			go h.ttlKeepAlive(keypath, value, ttl, stopChan)
-
-			// Start timeout
-			go h.timeoutExpire(timeout, timeoutChan, stopChan)
+			stopChan := make(chan bool)
+			if l.session == "" {
+				go h.ttlKeepAlive(indexpath, l.ttl, stopChan)
+			}
 
 			// wait for lock
-			err = h.watch(keypath, index, stopChan)
+			err = h.watch(keypath, index, closeChan, timeoutAfter(timeout))
+			close(stopChan)
 		}
 	}
 
-	// Return on error, deleting our lock request on the way
-	if err != nil {
-		if index > 0 {
-			h.client.Delete(indexpath, false)
+	// The lease starts over when the lock is granted.
+	if err == nil {
+		var t time.Time
+		if t, err = l.expireTime(h.server); err == nil {
+			_, err = update(h.server, indexpath, t)
 		}
-		return err
 	}
 
-	// Check for connection disconnect before we write the lock index.
-	select {
-	case <-stopChan:
-		err = errors.New("user interrupted")
-	default:
+	// Return on error, deleting our lock request on the way
+	if err != nil {
+		remove(h.server, indexpath, false)
+		return 0, err
 	}
+	return index, nil
+}
 
-	// Update TTL one last time if lock was acquired. Otherwise delete.
-	if err == nil {
-		h.client.Update(indexpath, value, uint64(ttl))
-	} else {
-		h.client.Delete(indexpath, false)
+// checkLimit fixes the limit of a semaphore on its first acquire request,
+// and rejects the requests that pass a different limit afterwards.
+func (h *handler) checkLimit(keypath string, limit int) error {
+	key, value := path.Join(keypath, limitKey), strconv.Itoa(limit)
+	e, err := h.server.Store().Get(key, false, false)
+	if isKeyNotFound(err) {
+		c := h.server.Store().CommandFactory().CreateCreateCommand(key, false, value, store.Permanent, false)
+		if e, err = do(h.server, c); isNodeExist(err) {
+			// Another request created the semaphore first.
+			e, err = h.server.Store().Get(key, false, false)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if e.Node.Value == nil || *e.Node.Value != value {
+		return etcdErr.NewError(etcdErr.EcodeLimitMismatch, "Acquire", 0)
 	}
-
-	// Write response.
-	w.Write([]byte(strconv.Itoa(index)))
 	return nil
 }
 
-// createNode creates a new lock node and watches it until it is acquired or acquisition fails.
-func (h *handler) createNode(keypath string, value string, ttl int) (int, error) {
-	// Default the value to "-" if it is blank.
-	if len(value) == 0 {
-		value = "-"
+// createNode creates a new lock node for the given lease.
+func (h *handler) createNode(keypath string, value string, l lease) (int, error) {
+	t, err := l.expireTime(h.server)
+	if err != nil {
+		return 0, err
 	}
 
 	// Create an incrementing id for the lock.
-	resp, err := h.client.AddChild(keypath, value, uint64(ttl))
+	e, err := create(h.server, keypath, value, t)
 	if err != nil {
 		return 0, err
 	}
-	indexpath := resp.Node.Key
-	index, err := strconv.Atoi(path.Base(indexpath))
-	return index, err
+
+	// Release the lock when the session ends.
+	if l.session != "" {
+		if err := attachToSession(h.server, l.session, e.Node.Key); err != nil {
+			remove(h.server, e.Node.Key, false)
+			return 0, err
+		}
+	}
+
+	return nodeIndex(e.Node), nil
 }
 
 // findExistingNode search for a node on the lock with the given value.
-func (h *handler) findExistingNode(keypath string, value string) (*etcd.Node, int, int) {
-	if len(value) > 0 {
-		resp, err := h.client.Get(keypath, true, true)
-		if err == nil {
-			nodes := lockNodes{resp.Node.Nodes}
-			if node, pos := nodes.FindByValue(value); node != nil {
-				index, _ := strconv.Atoi(path.Base(node.Key))
-				return node, index, pos
-			}
-		}
+// It returns the index and the position of the node in the lock queue.
+func (h *handler) findExistingNode(keypath string, value string) (int, int, error) {
+	if len(value) == 0 {
+		return 0, 0, nil
+	}
+	nodes, err := h.getLockNodes(keypath)
+	if err != nil {
+		return 0, 0, err
 	}
-	return nil, 0, 0
+	if node, pos := nodes.FindByValue(value); node != nil {
+		return nodeIndex(node), pos, nil
+	}
+	return 0, 0, nil
 }
 
 // ttlKeepAlive continues to update a key's TTL until the stop channel is closed.
-func (h *handler) ttlKeepAlive(k string, value string, ttl int, stopChan chan bool) {
+func (h *handler) ttlKeepAlive(k string, ttl int, stopChan chan bool) {
+	interval := time.Duration(ttl) * time.Second / 2
+	if interval <= 0 {
+		return
+	}
 	for {
 		select {
-		case <-time.After(time.Duration(ttl/2) * time.Second):
-			h.client.Update(k, value, uint64(ttl))
+		case <-time.After(interval):
+			update(h.server, k, expireTime(ttl))
 		case <-stopChan:
 			return
 		}
 	}
 }
 
-// timeoutExpire sets the countdown timer is a positive integer
-// cancels on stopChan, sends true on timeoutChan after timer expires
-func (h *handler) timeoutExpire(timeout int, timeoutChan chan bool, stopChan chan bool) {
-	// Set expiration timer if timeout is 1 or higher
-	if timeout < 1 {
-		timeoutChan = nil
-		return
-	}
-	select {
-	case <-stopChan:
-		return
-	case <-time.After(time.Duration(timeout) * time.Second):
-		timeoutChan <- true
-		return
-	}
-}
-
-func (h *handler) getLockIndex(keypath string, index int) (int, int, error) {
-	// Read all nodes for the lock.
-	resp, err := h.client.Get(keypath, true, true)
-	if err != nil {
-		return 0, 0, fmt.Errorf("lock watch lookup error: %s", err.Error())
-	}
-	nodes := lockNodes{resp.Node.Nodes}
-	prevIndex, modifiedIndex := nodes.PrevIndex(index)
-	return prevIndex, modifiedIndex, nil
-}
-
 // get tries once to get the lock; no waiting
 func (h *handler) get(keypath string, index int) error {
-	prevIndex, _, err := h.getLockIndex(keypath, index)
+	key, _, err := h.blocker(keypath, index)
 	if err != nil {
 		return err
 	}
-	if prevIndex == 0 {
+	if key == "" {
 		// Lock acquired
 		return nil
 	}
 	return fmt.Errorf("failed to acquire lock")
 }
 
-// watch continuously waits for a given lock index to be acquired or until lock fails.
-// Returns a boolean indicating success.
-func (h *handler) watch(keypath string, index int, closeChan <-chan bool) error {
-	// Wrap close chan so we can pass it to Client.Watch().
-	stopWatchChan := make(chan bool)
-	stopWrapChan := make(chan bool)
-	go func() {
-		select {
-		case <-closeChan:
-			stopWatchChan <- true
-		case <-stopWrapChan:
-			stopWatchChan <- true
-		case <-stopWatchChan:
-		}
-	}()
-	defer close(stopWrapChan)
+// blocker returns the key that the lock node with the given index waits
+// for, and whether it has to be watched recursively.
+// It returns "" if the lock node holds the lock.
+func (h *handler) blocker(keypath string, index int) (string, bool, error) {
+	nodes, err := h.getLockNodes(keypath)
+	if err != nil {
+		return "", false, err
+	}
+	node, pos := nodes.FindByIndex(index)
+	if node == nil {
+		return "", false, fmt.Errorf("lock request expired")
+	}
+	blocker := nodes.Blocker(pos)
+	if blocker == nil {
+		return "", false, nil
+	}
+	// The first semaphore request in line is granted when any of the
+	// holders leaves. The others wait for the request before them, which
+	// is updated when it is granted.
+	if h.variant == semaphore && pos == nodes.limit {
+		return keypath, true, nil
+	}
+	return blocker.Key, false, nil
+}
 
+// watch waits until the lock node with the given index holds the lock,
+// or until the request is stopped.
+// It watches the store for changes to the node it waits for.
+func (h *handler) watch(keypath string, index int, closeChan <-chan bool, timeoutChan <-chan time.Time) error {
 	for {
-		prevIndex, modifiedIndex, err := h.getLockIndex(keypath, index)
-		// If there is no previous index then we have the lock.
-		if prevIndex == 0 {
-			return nil
+		since := h.server.Store().Index()
+		key, recursive, err := h.blocker(keypath, index)
+		if err != nil || key == "" {
+			return err
 		}
 
-		// Wait from the last modification of the node.
-		waitIndex := modifiedIndex + 1
-
-		_, err = h.client.Watch(path.Join(keypath, strconv.Itoa(prevIndex)), uint64(waitIndex), false, nil, stopWatchChan)
-		if err == etcd.ErrWatchStoppedByUser {
-			return fmt.Errorf("lock watch closed")
-		} else if err != nil {
-			return fmt.Errorf("lock watch error: %s", err.Error())
+		switch err := h.waitForChange(key, recursive, since, closeChan, timeoutChan); err {
+		case nil:
+		case errTimedOut:
+			return fmt.Errorf("failed to acquire lock: timed out")
+		default:
+			return err
 		}
-		return nil
 	}
 }
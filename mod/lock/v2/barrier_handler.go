@@ -0,0 +1,121 @@
+package v2
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+
+	etcdErr "github.com/coreos/etcd/error"
+	"github.com/coreos/etcd/third_party/github.com/gorilla/mux"
+)
+
+// A barrier blocks its waiters while it is held. The holder keeps a node at
+// the key of the barrier under barrierPrefix, and the waiters watch the node
+// until it is removed.
+
+// NewBarrierHandler creates an HTTP handler for barriers that can be
+// registered on a router.
+func NewBarrierHandler(s Server) http.Handler {
+	h := &handler{
+		Router: mux.NewRouter(),
+		server: s,
+		prefix: barrierPrefix,
+	}
+	h.StrictSlash(false)
+	h.handleFunc("/{key:.*}", h.waitBarrierHandler).Methods("GET")
+	h.handleFunc("/{key:.*}", h.holdBarrierHandler).Methods("PUT")
+	h.handleFunc("/{key:.*}", h.releaseBarrierHandler).Methods("DELETE")
+	return h
+}
+
+// holdBarrierHandler holds a barrier, or renews the hold on it.
+// The "value" parameter specifies a value to associate with the barrier.
+// The "ttl" parameter specifies how long the barrier is held for.
+// The "session" parameter ties the barrier to a session instead of a TTL.
+func (h *handler) holdBarrierHandler(w http.ResponseWriter, req *http.Request) error {
+	vars := mux.Vars(req)
+	key := path.Join(h.prefix, vars["key"])
+	value := req.FormValue("value")
+	if len(value) == 0 {
+		value = "-"
+	}
+
+	_, l, err := parseLease(req, "Hold")
+	if err != nil {
+		return err
+	}
+	t, err := l.expireTime(h.server)
+	if err != nil {
+		return err
+	}
+
+	if _, err := set(h.server, key, value, t); err != nil {
+		return err
+	}
+	if l.session != "" {
+		return attachToSession(h.server, l.session, key)
+	}
+	return nil
+}
+
+// releaseBarrierHandler releases a barrier and lets its waiters through.
+func (h *handler) releaseBarrierHandler(w http.ResponseWriter, req *http.Request) error {
+	vars := mux.Vars(req)
+	_, err := remove(h.server, path.Join(h.prefix, vars["key"]), false)
+	return err
+}
+
+// waitBarrierHandler retrieves the value of a barrier, which is empty if the
+// barrier isn't held.
+// With "wait=true", the request waits until the barrier is released.
+// The "timeout" parameter specifies how long the request should wait.
+func (h *handler) waitBarrierHandler(w http.ResponseWriter, req *http.Request) error {
+	vars := mux.Vars(req)
+	key := path.Join(h.prefix, vars["key"])
+
+	timeout, err := parseTimeout(req, "Wait")
+	if err != nil {
+		return err
+	}
+
+	if req.FormValue("wait") != "true" {
+		e, err := h.server.Store().Get(key, false, false)
+		if err != nil {
+			if isKeyNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		if e.Node.Dir {
+			return etcdErr.NewError(etcdErr.EcodeNotFile, "Wait", 0)
+		}
+		w.Write([]byte(*e.Node.Value))
+		return nil
+	}
+
+	closeNotifier, _ := w.(http.CloseNotifier)
+	closeChan := closeNotifier.CloseNotify()
+	timeoutChan := timeoutAfter(timeout)
+	for {
+		since := h.server.Store().Index()
+		e, err := h.server.Store().Get(key, false, false)
+		if err != nil {
+			if isKeyNotFound(err) {
+				// The barrier is released.
+				return nil
+			}
+			return err
+		}
+		if e.Node.Dir {
+			return etcdErr.NewError(etcdErr.EcodeNotFile, "Wait", 0)
+		}
+
+		switch err := h.waitForChange(key, false, since, closeChan, timeoutChan); err {
+		case nil:
+		case errTimedOut:
+			return fmt.Errorf("failed to wait for barrier: timed out")
+		default:
+			return err
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package v2
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	etcdErr "github.com/coreos/etcd/error"
+	"github.com/coreos/etcd/store"
+	"github.com/coreos/etcd/third_party/github.com/gorilla/mux"
+)
+
+// readyKey is the directory under a double barrier that holds a key for each
+// round whose participants have all entered.
+const readyKey = "ready"
+
+// A double barrier lets its participants enter together once a given count
+// of them has arrived, and leave together once all of them are done. Each
+// participant queues a node under dbarrierPrefix, like a lock request. The
+// participant that completes the count starts a round: it marks the round
+// ready with a key named after the index of its last participant, and the
+// last one of the round to leave removes the mark. Participants arriving
+// meanwhile wait for the next round.
+
+// NewDoubleBarrierHandler creates an HTTP handler for double barriers that
+// can be registered on a router.
+func NewDoubleBarrierHandler(s Server) http.Handler {
+	h := &handler{
+		Router: mux.NewRouter(),
+		server: s,
+		prefix: dbarrierPrefix,
+	}
+	h.StrictSlash(false)
+	h.handleFunc("/{key:.*}", h.participantsHandler).Methods("GET")
+	h.handleFunc("/{key:.*}", h.enterHandler).Methods("POST")
+	h.handleFunc("/{key:.*}", h.renewLockHandler).Methods("PUT")
+	h.handleFunc("/{key:.*}", h.leaveHandler).Methods("DELETE")
+	return h
+}
+
+// enterHandler registers a participant and waits until the given count of
+// participants have entered the barrier.
+// The "count" parameter specifies how many participants the barrier waits for.
+// The "value" parameter specifies a value that identifies the participant.
+// The "ttl", "session" and "timeout" parameters are the same as for a lock.
+//
+// The response is the index of the participant.
+func (h *handler) enterHandler(w http.ResponseWriter, req *http.Request) error {
+	closeNotifier, _ := w.(http.CloseNotifier)
+	closeChan := closeNotifier.CloseNotify()
+
+	vars := mux.Vars(req)
+	keypath := path.Join(h.prefix, vars["key"])
+	value := req.FormValue("value")
+
+	count, err := strconv.Atoi(req.FormValue("count"))
+	if err != nil || count <= 0 {
+		return etcdErr.NewError(etcdErr.EcodeCountNaN, "Enter", 0)
+	}
+	timeout, l, err := parseLease(req, "Enter")
+	if err != nil {
+		return err
+	}
+
+	index, _, err := h.findExistingNode(keypath, value)
+	if err != nil {
+		return err
+	}
+	if index == 0 {
+		nodeValue := value
+		if len(nodeValue) == 0 {
+			nodeValue = "-"
+		}
+		if index, err = h.createNode(keypath, nodeValue, l); err != nil {
+			return err
+		}
+	}
+	indexpath := path.Join(keypath, strconv.Itoa(index))
+
+	// Keep updating TTL while we wait
+	stopChan := make(chan bool)
+	if l.session == "" {
+		go h.ttlKeepAlive(indexpath, l.ttl, stopChan)
+	}
+	err = h.waitToEnter(keypath, index, count, timeout, closeChan)
+	close(stopChan)
+
+	// The lease starts over on entering.
+	if err == nil {
+		var t time.Time
+		if t, err = l.expireTime(h.server); err == nil {
+			_, err = update(h.server, indexpath, t)
+		}
+	}
+
+	// Return on error, deleting our participant on the way
+	if err != nil {
+		remove(h.server, indexpath, false)
+		return err
+	}
+
+	w.Write([]byte(strconv.Itoa(index)))
+	return nil
+}
+
+// waitToEnter waits until the barrier is ready or the participant with the
+// given index completes its count.
+func (h *handler) waitToEnter(keypath string, index int, count int, timeout int, closeChan <-chan bool) error {
+	timeoutChan := timeoutAfter(timeout)
+	for {
+		since := h.server.Store().Index()
+		nodes, err := h.getLockNodes(keypath)
+		if err != nil {
+			return err
+		}
+		if node, _ := nodes.FindByIndex(index); node == nil {
+			return fmt.Errorf("barrier request expired")
+		}
+		if _, entered := nodes.Round(index); entered {
+			return nil
+		}
+		if pending := nodes.Pending(); len(pending) >= count {
+			round := strconv.Itoa(nodeIndex(pending[len(pending)-1]))
+			_, err := set(h.server, path.Join(keypath, readyKey, round), strconv.Itoa(count), store.Permanent)
+			return err
+		}
+
+		switch err := h.waitForChange(keypath, true, since, closeChan, timeoutChan); err {
+		case nil:
+		case errTimedOut:
+			return fmt.Errorf("failed to enter barrier: timed out")
+		default:
+			return err
+		}
+	}
+}
+
+// leaveHandler removes a participant and waits until all the participants
+// of its round have left the barrier.
+// The "index" or "value" parameter identifies the participant.
+// The "timeout" parameter specifies how long the request should wait.
+func (h *handler) leaveHandler(w http.ResponseWriter, req *http.Request) error {
+	closeNotifier, _ := w.(http.CloseNotifier)
+	closeChan := closeNotifier.CloseNotify()
+
+	vars := mux.Vars(req)
+	keypath := path.Join(h.prefix, vars["key"])
+
+	timeout, err := parseTimeout(req, "Leave")
+	if err != nil {
+		return err
+	}
+
+	// Read index and value parameters.
+	index := req.FormValue("index")
+	value := req.FormValue("value")
+	if len(index) == 0 && len(value) == 0 {
+		return etcdErr.NewError(etcdErr.EcodeIndexOrValueRequired, "Leave", 0)
+	} else if len(index) != 0 && len(value) != 0 {
+		return etcdErr.NewError(etcdErr.EcodeIndexValueMutex, "Leave", 0)
+	}
+
+	// Look up index by value if index is missing.
+	if len(index) == 0 {
+		nodes, err := h.getLockNodes(keypath)
+		if err != nil {
+			return err
+		}
+		node, _ := nodes.FindByValue(value)
+		if node == nil {
+			return etcdErr.NewError(etcdErr.EcodeKeyNotFound, "Leave", 0)
+		}
+		index = path.Base(node.Key)
+	}
+
+	nodes, err := h.getLockNodes(keypath)
+	if err != nil {
+		return err
+	}
+	i, _ := strconv.Atoi(index)
+	round, entered := nodes.Round(i)
+
+	if _, err := remove(h.server, path.Join(keypath, index), false); err != nil {
+		return err
+	}
+	// A participant that hasn't entered has no one to wait for.
+	if !entered {
+		return nil
+	}
+
+	timeoutChan := timeoutAfter(timeout)
+	for {
+		since := h.server.Store().Index()
+		nodes, err := h.getLockNodes(keypath)
+		if err != nil {
+			return err
+		}
+		if len(nodes.InRound(round)) == 0 {
+			// Everyone in the round has left.
+			if _, err := remove(h.server, path.Join(keypath, readyKey, strconv.Itoa(round)), false); err != nil && !isKeyNotFound(err) {
+				return err
+			}
+			return nil
+		}
+
+		switch err := h.waitForChange(keypath, true, since, closeChan, timeoutChan); err {
+		case nil:
+		case errTimedOut:
+			return fmt.Errorf("failed to leave barrier: timed out")
+		default:
+			return err
+		}
+	}
+}
+
+// participantsHandler retrieves the participants of a barrier.
+// The "field" parameter specifies to read either the participant "index"
+// or "value". The participants are written one per line.
+func (h *handler) participantsHandler(w http.ResponseWriter, req *http.Request) error {
+	vars := mux.Vars(req)
+	keypath := path.Join(h.prefix, vars["key"])
+	field := req.FormValue("field")
+	if len(field) == 0 {
+		field = "value"
+	}
+
+	nodes, err := h.getLockNodes(keypath)
+	if err != nil {
+		return err
+	}
+
+	var lines []string
+	for _, node := range nodes.NodeExterns {
+		switch field {
+		case "index":
+			lines = append(lines, path.Base(node.Key))
+
+		case "value":
+			lines = append(lines, nodes.Value(node))
+
+		default:
+			return etcdErr.NewError(etcdErr.EcodeInvalidField, "Get", 0)
+		}
+	}
+	w.Write([]byte(strings.Join(lines, "\n")))
+	return nil
+}
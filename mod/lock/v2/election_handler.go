@@ -0,0 +1,240 @@
+package v2
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strconv"
+
+	etcdErr "github.com/coreos/etcd/error"
+	"github.com/coreos/etcd/store"
+	"github.com/coreos/etcd/third_party/github.com/gorilla/mux"
+)
+
+// An election is an exclusive lock whose holder is the leader. Each
+// candidate queues a node under electionPrefix with its name, which
+// identifies it, and the value it proclaims. When the leader resigns or its
+// lease runs out, the next candidate in the queue becomes the leader.
+
+// leader is the state of an election seen by its observers.
+type leader struct {
+	Name  string `json:"name,omitempty"`
+	Value string `json:"value,omitempty"`
+	Index int    `json:"index,omitempty"`
+}
+
+// NewElectionHandler creates an HTTP handler for leader elections that can
+// be registered on a router.
+func NewElectionHandler(s Server) http.Handler {
+	h := &handler{
+		Router:  mux.NewRouter(),
+		server:  s,
+		prefix:  electionPrefix,
+		variant: election,
+	}
+	h.StrictSlash(false)
+	h.handleFunc("/{key:.*}", h.leaderHandler).Methods("GET")
+	h.handleFunc("/{key:.*}", h.campaignHandler).Methods("PUT")
+	h.handleFunc("/{key:.*}", h.proclaimHandler).Methods("POST")
+	h.handleFunc("/{key:.*}", h.resignHandler).Methods("DELETE")
+	return h
+}
+
+// campaignHandler enters a candidate in an election and waits until it is
+// the leader.
+// The "name" parameter identifies the candidate.
+// The "value" parameter specifies the value the candidate proclaims once it
+// leads; it defaults to the name.
+// The "ttl", "session" and "timeout" parameters are the same as for a lock.
+//
+// The response is the index of the candidate, which is also the fencing
+// token of its leadership.
+func (h *handler) campaignHandler(w http.ResponseWriter, req *http.Request) error {
+	closeNotifier, _ := w.(http.CloseNotifier)
+	closeChan := closeNotifier.CloseNotify()
+
+	vars := mux.Vars(req)
+	keypath := path.Join(h.prefix, vars["key"])
+	name := req.FormValue("name")
+	if name == "" {
+		return etcdErr.NewError(etcdErr.EcodeNameRequired, "Campaign", 0)
+	}
+	value := req.FormValue("value")
+	if value == "" {
+		value = name
+	}
+
+	timeout, l, err := parseLease(req, "Campaign")
+	if err != nil {
+		return err
+	}
+
+	index, err := h.acquire(keypath, name, electionValue(name, value), l, timeout, closeChan)
+	if err != nil {
+		return err
+	}
+
+	// A candidate that was already queued may campaign with a new value.
+	if err := h.proclaim(keypath, name, value); err != nil {
+		return err
+	}
+
+	w.Write([]byte(strconv.Itoa(index)))
+	return nil
+}
+
+// proclaimHandler lets the leader of an election update its value.
+// The "name" parameter identifies the leader and the "value" parameter
+// specifies the new value.
+func (h *handler) proclaimHandler(w http.ResponseWriter, req *http.Request) error {
+	vars := mux.Vars(req)
+	keypath := path.Join(h.prefix, vars["key"])
+	name := req.FormValue("name")
+	if name == "" {
+		return etcdErr.NewError(etcdErr.EcodeNameRequired, "Proclaim", 0)
+	}
+	value := req.FormValue("value")
+	if value == "" {
+		return etcdErr.NewError(etcdErr.EcodeValueRequired, "Proclaim", 0)
+	}
+	return h.proclaim(keypath, name, value)
+}
+
+// proclaim sets the value of the leader of an election.
+// It fails if the named candidate isn't the leader.
+func (h *handler) proclaim(keypath string, name string, value string) error {
+	nodes, err := h.getLockNodes(keypath)
+	if err != nil {
+		return err
+	}
+	node, pos := nodes.FindByValue(name)
+	if node == nil {
+		return etcdErr.NewError(etcdErr.EcodeKeyNotFound, "Proclaim", h.server.Store().Index())
+	}
+	if pos != 0 {
+		return etcdErr.NewError(etcdErr.EcodeTestFailed, "Proclaim: "+name+" is not the leader", h.server.Store().Index())
+	}
+	if nodes.Proclaimed(node) == value {
+		return nil
+	}
+
+	// Keep the lease of the leader.
+	t := store.Permanent
+	if node.Expiration != nil {
+		t = *node.Expiration
+	}
+	c := h.server.Store().CommandFactory().CreateUpdateCommand(node.Key, electionValue(name, value), t)
+	_, err = do(h.server, c)
+	return err
+}
+
+// resignHandler removes a candidate from an election. If the candidate is
+// the leader, the next candidate takes over.
+// The "name" parameter identifies the candidate.
+func (h *handler) resignHandler(w http.ResponseWriter, req *http.Request) error {
+	vars := mux.Vars(req)
+	keypath := path.Join(h.prefix, vars["key"])
+	name := req.FormValue("name")
+	if name == "" {
+		return etcdErr.NewError(etcdErr.EcodeNameRequired, "Resign", 0)
+	}
+
+	nodes, err := h.getLockNodes(keypath)
+	if err != nil {
+		return err
+	}
+	node, _ := nodes.FindByValue(name)
+	if node == nil {
+		return etcdErr.NewError(etcdErr.EcodeKeyNotFound, "Resign", h.server.Store().Index())
+	}
+	_, err = remove(h.server, node.Key, false)
+	return err
+}
+
+// leaderHandler retrieves the leader of an election.
+// The "field" parameter specifies to read either the leader "value",
+// "name" or "index".
+// With "wait=true", the request waits for the next change of leader or
+// value. With "stream=true", it writes the current leader and then every
+// change until the connection closes. Both write each leader as a JSON
+// object on its own line, which is empty when there is no leader.
+func (h *handler) leaderHandler(w http.ResponseWriter, req *http.Request) error {
+	vars := mux.Vars(req)
+	keypath := path.Join(h.prefix, vars["key"])
+	wait := req.FormValue("wait") == "true"
+	stream := req.FormValue("stream") == "true"
+
+	since := h.server.Store().Index()
+	l, err := h.leader(keypath)
+	if err != nil {
+		return err
+	}
+
+	if !wait && !stream {
+		switch req.FormValue("field") {
+		case "", "value":
+			w.Write([]byte(l.Value))
+		case "name":
+			w.Write([]byte(l.Name))
+		case "index":
+			if l.Index != 0 {
+				w.Write([]byte(strconv.Itoa(l.Index)))
+			}
+		default:
+			return etcdErr.NewError(etcdErr.EcodeInvalidField, "Get", 0)
+		}
+		return nil
+	}
+
+	closeNotifier, _ := w.(http.CloseNotifier)
+	closeChan := closeNotifier.CloseNotify()
+	encoder := json.NewEncoder(w)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if stream {
+		encoder.Encode(l)
+	}
+	w.(http.Flusher).Flush()
+
+	for {
+		if err := h.waitForChange(keypath, true, since, closeChan, nil); err != nil {
+			// The response has started, so the request simply ends.
+			return nil
+		}
+
+		since = h.server.Store().Index()
+		next, err := h.leader(keypath)
+		if err != nil {
+			return nil
+		}
+		if next == l {
+			continue
+		}
+		l = next
+
+		encoder.Encode(l)
+		w.(http.Flusher).Flush()
+		if !stream {
+			return nil
+		}
+	}
+}
+
+// leader returns the current leader of an election.
+func (h *handler) leader(keypath string) (leader, error) {
+	nodes, err := h.getLockNodes(keypath)
+	if err != nil {
+		return leader{}, err
+	}
+	holders := nodes.Holders()
+	if len(holders) == 0 {
+		return leader{}, nil
+	}
+	node := holders[0]
+	return leader{
+		Name:  nodes.Value(node),
+		Value: nodes.Proclaimed(node),
+		Index: nodeIndex(node),
+	}, nil
+}
@@ -3,6 +3,7 @@ package v2
 import (
 	"net/http"
 	"path"
+	"strings"
 
 	etcdErr "github.com/coreos/etcd/error"
 	"github.com/coreos/etcd/third_party/github.com/gorilla/mux"
@@ -10,36 +11,38 @@ import (
 
 // getIndexHandler retrieves the current lock index.
 // The "field" parameter specifies to read either the lock "index" or lock "value".
+// The index of the current holder is its fencing token.
+// Semaphores and read/write locks can have several holders, which are
+// written one per line.
 func (h *handler) getIndexHandler(w http.ResponseWriter, req *http.Request) error {
-	h.client.SyncCluster()
-
 	vars := mux.Vars(req)
-	keypath := path.Join(prefix, vars["key"])
+	keypath := path.Join(h.prefix, vars["key"])
 	field := req.FormValue("field")
 	if len(field) == 0 {
 		field = "value"
 	}
 
 	// Read all indices.
-	resp, err := h.client.Get(keypath, true, true)
+	nodes, err := h.getLockNodes(keypath)
 	if err != nil {
 		return err
 	}
-	nodes := lockNodes{resp.Node.Nodes}
 
-	// Write out the requested field.
-	if node := nodes.First(); node != nil {
+	// Write out the requested field of each holder.
+	var lines []string
+	for _, node := range nodes.Holders() {
 		switch field {
 		case "index":
-			w.Write([]byte(path.Base(node.Key)))
+			lines = append(lines, path.Base(node.Key))
 
 		case "value":
-			w.Write([]byte(node.Value))
+			lines = append(lines, nodes.Value(node))
 
 		default:
 			return etcdErr.NewError(etcdErr.EcodeInvalidField, "Get", 0)
 		}
 	}
+	w.Write([]byte(strings.Join(lines, "\n")))
 
 	return nil
 }
@@ -1,26 +1,114 @@
 package v2
 
 import (
+	"errors"
 	"net/http"
+	"path"
+	"strings"
+	"time"
 
 	etcdErr "github.com/coreos/etcd/error"
-	"github.com/coreos/etcd/third_party/github.com/coreos/go-etcd/etcd"
+	uhttp "github.com/coreos/etcd/pkg/http"
+	"github.com/coreos/etcd/store"
+	"github.com/coreos/etcd/third_party/github.com/goraft/raft"
 	"github.com/coreos/etcd/third_party/github.com/gorilla/mux"
 )
 
-const prefix = "/_etcd/mod/lock"
+const (
+	prefix          = "/_etcd/mod/lock"
+	semaphorePrefix = "/_etcd/mod/semaphore"
+	rwlockPrefix    = "/_etcd/mod/rwlock"
+	electionPrefix  = "/_etcd/mod/leader"
+	barrierPrefix   = "/_etcd/mod/barrier"
+	dbarrierPrefix  = "/_etcd/mod/doublebarrier"
+	queuePrefix     = "/_etcd/mod/queue"
+)
+
+// modulePrefixes maps the path of each module to the directory of the store
+// it keeps its keys in.
+var modulePrefixes = map[string]string{
+	"lock":          prefix,
+	"semaphore":     semaphorePrefix,
+	"rwlock":        rwlockPrefix,
+	"session":       sessionPrefix,
+	"leader":        electionPrefix,
+	"barrier":       barrierPrefix,
+	"doublebarrier": dbarrierPrefix,
+	"queue":         queuePrefix,
+}
+
+// Key returns the key of the store that a request to a module at the given
+// path, such as "/lock/foo", reads or writes.
+func Key(p string) (string, bool) {
+	name, rest := strings.TrimPrefix(p, "/"), ""
+	if i := strings.Index(name, "/"); i >= 0 {
+		name, rest = name[:i], name[i:]
+	}
+	prefix, ok := modulePrefixes[name]
+	if !ok {
+		return "", false
+	}
+	return path.Join(prefix, rest), true
+}
+
+// lockVariant tells how a lock grants the requests in its queue.
+type lockVariant int
+
+const (
+	// exclusive grants the lock to the first request.
+	exclusive lockVariant = iota
+	// semaphore grants the lock to the first requests up to its limit.
+	semaphore
+	// readWrite grants the lock to the first request if it is a writer,
+	// or to all the readers before the first writer.
+	readWrite
+	// election grants the lock to the first candidate, which is the leader.
+	election
+)
+
+// Server is the etcd server the lock module runs in.
+// The module applies its changes as store commands through Do, so its
+// handlers only run on the leader.
+type Server interface {
+	State() string
+	Leader() string
+	ClientURL(string) (string, bool)
+	Store() store.Store
+	Do(raft.Command) (interface{}, error)
+}
 
 // handler manages the lock HTTP request.
 type handler struct {
 	*mux.Router
-	client *etcd.Client
+	server  Server
+	prefix  string
+	variant lockVariant
 }
 
-// NewHandler creates an HTTP handler that can be registered on a router.
-func NewHandler(addr string) http.Handler {
+// NewHandler creates an HTTP handler for exclusive locks that can be
+// registered on a router.
+func NewHandler(s Server) http.Handler {
+	return newHandler(s, prefix, exclusive)
+}
+
+// NewSemaphoreHandler creates an HTTP handler for counting semaphores that
+// can be registered on a router.
+func NewSemaphoreHandler(s Server) http.Handler {
+	return newHandler(s, semaphorePrefix, semaphore)
+}
+
+// NewRWLockHandler creates an HTTP handler for read/write locks that can be
+// registered on a router.
+func NewRWLockHandler(s Server) http.Handler {
+	return newHandler(s, rwlockPrefix, readWrite)
+}
+
+func newHandler(s Server, prefix string, variant lockVariant) http.Handler {
 	h := &handler{
-		Router: mux.NewRouter(),
-		client: etcd.NewClient([]string{addr}),
+		Router:  mux.NewRouter(),
+		server:  s,
+		prefix:  prefix,
+		variant: variant,
 	}
 	h.StrictSlash(false)
 	h.handleFunc("/{key:.*}", h.getIndexHandler).Methods("GET")
@@ -32,17 +120,110 @@ func NewHandler(addr string) http.Handler {
 
 func (h *handler) handleFunc(path string, f func(http.ResponseWriter, *http.Request) error) *mux.Route {
 	return h.Router.HandleFunc(path, func(w http.ResponseWriter, req *http.Request) {
+		// Locks are only managed by the leader.
+		if h.server.State() != raft.Leader {
+			leader := h.server.Leader()
+			if leader == "" {
+				w.Header().Set("Content-Type", "application/json")
+				etcdErr.NewError(etcdErr.EcodeLeaderElect, "", h.server.Store().Index()).Write(w)
+				return
+			}
+			url, _ := h.server.ClientURL(leader)
+			uhttp.Redirect(url, w, req)
+			return
+		}
+
 		if err := f(w, req); err != nil {
 			switch err := err.(type) {
 			case *etcdErr.Error:
 				w.Header().Set("Content-Type", "application/json")
 				err.Write(w)
-			case etcd.EtcdError:
-				w.Header().Set("Content-Type", "application/json")
-				etcdErr.NewError(err.ErrorCode, err.Cause, err.Index).Write(w)
 			default:
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 			}
 		}
 	})
 }
+
+// do executes a store command and returns the resulting event.
+func do(s Server, c raft.Command) (*store.Event, error) {
+	result, err := s.Do(c)
+	if err != nil {
+		return nil, err
+	}
+	return result.(*store.Event), nil
+}
+
+// create creates a new node with a unique, increasing index under dir.
+func create(s Server, dir string, value string, expireTime time.Time) (*store.Event, error) {
+	c := s.Store().CommandFactory().CreateCreateCommand(dir, false, value, expireTime, true)
+	return do(s, c)
+}
+
+// update changes the expiration time of a node and keeps its value.
+func update(s Server, key string, expireTime time.Time) (*store.Event, error) {
+	e, err := s.Store().Get(key, false, false)
+	if err != nil {
+		return nil, err
+	}
+	var value string
+	if e.Node.Value != nil {
+		value = *e.Node.Value
+	}
+	c := s.Store().CommandFactory().CreateUpdateCommand(key, value, expireTime)
+	return do(s, c)
+}
+
+// set sets the value and expiration time of a node.
+func set(s Server, key string, value string, expireTime time.Time) (*store.Event, error) {
+	c := s.Store().CommandFactory().CreateSetCommand(key, false, value, expireTime)
+	return do(s, c)
+}
+
+// remove deletes a node.
+func remove(s Server, key string, recursive bool) (*store.Event, error) {
+	c := s.Store().CommandFactory().CreateDeleteCommand(key, recursive, recursive)
+	return do(s, c)
+}
+
+var (
+	errInterrupted = errors.New("user interrupted")
+	errTimedOut    = errors.New("timed out")
+)
+
+// waitForChange waits for a change to the key in the store after the given
+// index, or until the request is stopped.
+// Watching from the index of a previous read of the store makes sure that
+// no change after that read is missed.
+func (h *handler) waitForChange(key string, recursive bool, since uint64, closeChan <-chan bool, timeoutChan <-chan time.Time) error {
+	watcher, err := h.server.Store().Watch(key, recursive, false, since+1)
+	if err != nil {
+		return err
+	}
+
+	// A change found in the history is already there.
+	select {
+	case <-watcher.EventChan:
+		return nil
+	default:
+	}
+
+	select {
+	case <-watcher.EventChan:
+		return nil
+	case <-closeChan:
+		watcher.Remove()
+		return errInterrupted
+	case <-timeoutChan:
+		watcher.Remove()
+		return errTimedOut
+	}
+}
+
+// expireTime returns the expiration time for the given TTL in seconds.
+func expireTime(ttl int) time.Time {
+	if ttl <= 0 {
+		return store.Permanent
+	}
+	return time.Now().Add(time.Duration(ttl) * time.Second)
+}
@@ -1,60 +1,233 @@
 package v2
 
 import (
+	"net/url"
 	"path"
 	"sort"
 	"strconv"
 
-	"github.com/coreos/etcd/third_party/github.com/coreos/go-etcd/etcd"
+	"github.com/coreos/etcd/store"
 )
 
-// lockNodes is a wrapper for go-etcd's Nodes to allow for sorting by numeric key.
+const (
+	// limitKey is the key under a semaphore that holds its limit.
+	limitKey = "limit"
+
+	readMode  = "read"
+	writeMode = "write"
+)
+
+// lockNodes is a wrapper for the store's nodes to allow for sorting by
+// numeric key.
 type lockNodes struct {
-	etcd.Nodes
+	store.NodeExterns
+	variant lockVariant
+
+	// limit is the number of holders of a semaphore.
+	limit int
+
+	// ready holds the rounds of a double barrier whose participants have
+	// all entered, each as the index of its last participant, in order.
+	ready []int
+}
+
+// getLockNodes retrieves the requests in the queue of a lock, sorted by index.
+// A lock that doesn't exist has no nodes.
+func (h *handler) getLockNodes(keypath string) (lockNodes, error) {
+	nodes := lockNodes{variant: h.variant, limit: 1}
+	e, err := h.server.Store().Get(keypath, true, false)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return nodes, nil
+		}
+		return nodes, err
+	}
+	for _, node := range e.Node.Nodes {
+		if path.Base(node.Key) == limitKey && node.Value != nil {
+			if limit, err := strconv.Atoi(*node.Value); err == nil && limit > 0 {
+				nodes.limit = limit
+			}
+		} else if path.Base(node.Key) == readyKey && node.Dir {
+			for _, round := range node.Nodes {
+				if index := nodeIndex(round); index > 0 {
+					nodes.ready = append(nodes.ready, index)
+				}
+			}
+		} else if nodeIndex(node) > 0 {
+			nodes.NodeExterns = append(nodes.NodeExterns, node)
+		}
+	}
+	sort.Sort(nodes)
+	sort.Ints(nodes.ready)
+	return nodes, nil
 }
 
 // Less sorts the nodes by key (numerically).
 func (s lockNodes) Less(i, j int) bool {
-	a, _ := strconv.Atoi(path.Base(s.Nodes[i].Key))
-	b, _ := strconv.Atoi(path.Base(s.Nodes[j].Key))
-	return a < b
+	return nodeIndex(s.NodeExterns[i]) < nodeIndex(s.NodeExterns[j])
 }
 
-// Retrieves the first node in the set of lock nodes.
-func (s lockNodes) First() *etcd.Node {
-	sort.Sort(s)
-	if len(s.Nodes) > 0 {
-		return &s.Nodes[0]
+// Holders retrieves the nodes that hold the lock.
+func (s lockNodes) Holders() store.NodeExterns {
+	for i, node := range s.NodeExterns {
+		if s.Blocker(i) != nil {
+			return s.NodeExterns[:i]
+		}
+		if s.variant == readWrite && s.Mode(node) == writeMode {
+			return s.NodeExterns[:i+1]
+		}
 	}
-	return nil
+	return s.NodeExterns
+}
+
+// Blocker retrieves the node that the request at the given position waits
+// for, or nil if the request holds the lock.
+// A semaphore request waits for the request before it, and the first one
+// in line for the last of the holders.
+func (s lockNodes) Blocker(pos int) *store.NodeExtern {
+	switch s.variant {
+	case semaphore:
+		if pos < s.limit {
+			return nil
+		}
+		return s.NodeExterns[pos-1]
+
+	case readWrite:
+		// A reader waits for the last writer before it, so that a writer
+		// in the queue keeps later readers out.
+		if s.Mode(s.NodeExterns[pos]) == readMode {
+			for i := pos - 1; i >= 0; i-- {
+				if s.Mode(s.NodeExterns[i]) == writeMode {
+					return s.NodeExterns[i]
+				}
+			}
+			return nil
+		}
+	}
+
+	if pos == 0 {
+		return nil
+	}
+	return s.NodeExterns[pos-1]
 }
 
 // Retrieves the first node with a given value.
-func (s lockNodes) FindByValue(value string) (*etcd.Node, int) {
-	sort.Sort(s)
+func (s lockNodes) FindByValue(value string) (*store.NodeExtern, int) {
+	for i, node := range s.NodeExterns {
+		if s.Value(node) == value {
+			return node, i
+		}
+	}
+	return nil, 0
+}
 
-	for i, node := range s.Nodes {
-		if node.Value == value {
-			return &node, i
+// Retrieves the node with a given index and its position.
+func (s lockNodes) FindByIndex(index int) (*store.NodeExtern, int) {
+	for i, node := range s.NodeExterns {
+		if nodeIndex(node) == index {
+			return node, i
 		}
 	}
 	return nil, 0
 }
 
-// Find the node with the largest index in the lockNodes that is smaller than the given index. Also return the lastModified index of that node.
-func (s lockNodes) PrevIndex(index int) (int, int) {
-	sort.Sort(s)
+// Round returns the round of a double barrier that the participant with the
+// given index entered in, if it has entered.
+func (s lockNodes) Round(index int) (int, bool) {
+	for _, round := range s.ready {
+		if round >= index {
+			return round, true
+		}
+	}
+	return 0, false
+}
+
+// Pending retrieves the participants of a double barrier that wait for the
+// next round.
+func (s lockNodes) Pending() store.NodeExterns {
+	last := 0
+	if len(s.ready) > 0 {
+		last = s.ready[len(s.ready)-1]
+	}
+	for i, node := range s.NodeExterns {
+		if nodeIndex(node) > last {
+			return s.NodeExterns[i:]
+		}
+	}
+	return nil
+}
 
-	// Iterate over each node to find the given index. We keep track of the
-	// previous index on each iteration so we can return it when we match the
-	// index we're looking for.
-	var prevIndex int
-	for _, node := range s.Nodes {
-		idx, _ := strconv.Atoi(path.Base(node.Key))
-		if index == idx {
-			return prevIndex, int(node.ModifiedIndex)
+// InRound retrieves the participants of a double barrier that entered in
+// the given round.
+func (s lockNodes) InRound(round int) store.NodeExterns {
+	first := 0
+	for _, r := range s.ready {
+		if r < round {
+			first = r
 		}
-		prevIndex = idx
 	}
-	return 0, 0
+	var nodes store.NodeExterns
+	for _, node := range s.NodeExterns {
+		if index := nodeIndex(node); index > first && index <= round {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// Value returns the value that identifies the owner of a lock node.
+// The owner of an election node is its candidate name.
+func (s lockNodes) Value(node *store.NodeExtern) string {
+	switch s.variant {
+	case readWrite:
+		return nodeField(node, "value")
+	case election:
+		return nodeField(node, "name")
+	}
+	if node.Value == nil {
+		return ""
+	}
+	return *node.Value
+}
+
+// Proclaimed returns the value a candidate of an election proclaims.
+func (s lockNodes) Proclaimed(node *store.NodeExtern) string {
+	return nodeField(node, "value")
+}
+
+// Mode returns whether a lock node of a read/write lock is a reader or a
+// writer.
+func (s lockNodes) Mode(node *store.NodeExtern) string {
+	if s.variant != readWrite {
+		return writeMode
+	}
+	return nodeField(node, "mode")
+}
+
+// nodeValue encodes the value of a new lock node.
+func (h *handler) nodeValue(value string, mode string) string {
+	if h.variant != readWrite {
+		return value
+	}
+	return url.Values{"mode": {mode}, "value": {value}}.Encode()
+}
+
+// electionValue encodes the value of an election node.
+func electionValue(name string, value string) string {
+	return url.Values{"name": {name}, "value": {value}}.Encode()
+}
+
+// nodeField returns a field of a lock node whose value is encoded as a query.
+func nodeField(node *store.NodeExtern, field string) string {
+	if node.Value == nil {
+		return ""
+	}
+	v, _ := url.ParseQuery(*node.Value)
+	return v.Get(field)
+}
+
+// nodeIndex returns the index in the key of a lock node.
+func nodeIndex(node *store.NodeExtern) int {
+	index, _ := strconv.Atoi(path.Base(node.Key))
+	return index
 }
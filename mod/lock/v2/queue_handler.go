@@ -0,0 +1,252 @@
+package v2
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	etcdErr "github.com/coreos/etcd/error"
+	"github.com/coreos/etcd/store"
+	"github.com/coreos/etcd/third_party/github.com/gorilla/mux"
+)
+
+// DefaultVisibilityTimeout is how long a claimed item stays invisible to
+// other consumers when the claim doesn't specify it.
+const DefaultVisibilityTimeout = 30 * time.Second
+
+// A work queue keeps its items in order under the items directory of the
+// queue. A consumer claims the first visible item by swapping in its new
+// state, compared against the index of the item, so that only one claim of
+// an item succeeds. The claimed item is invisible until its visibility
+// timeout runs out, and the consumer acknowledges it by deleting it,
+// compared against the index of the claim. An item that has been delivered
+// as many times as it may be moves to the dead directory of the queue
+// instead of being claimed again.
+
+// NewQueueHandler creates an HTTP handler for work queues that can be
+// registered on a router.
+func NewQueueHandler(s Server) http.Handler {
+	h := &handler{
+		Router: mux.NewRouter(),
+		server: s,
+		prefix: queuePrefix,
+	}
+	h.StrictSlash(false)
+	h.handleFunc("/{key:.*}", h.listQueueHandler).Methods("GET")
+	h.handleFunc("/{key:.*}", h.pushHandler).Methods("POST")
+	h.handleFunc("/{key:.*}", h.claimHandler).Methods("PUT")
+	h.handleFunc("/{key:.*}", h.ackHandler).Methods("DELETE")
+	return h
+}
+
+// pushHandler adds an item at the end of a queue.
+// The "value" parameter specifies the value of the item.
+// The "max_deliveries" parameter specifies how many times the item is
+// delivered before it moves to the dead-letter directory.
+//
+// The response is the id of the item.
+func (h *handler) pushHandler(w http.ResponseWriter, req *http.Request) error {
+	vars := mux.Vars(req)
+	keypath := path.Join(h.prefix, vars["key"])
+
+	item := &queueItem{Value: req.FormValue("value")}
+	if max := req.FormValue("max_deliveries"); max != "" {
+		var err error
+		if item.MaxDeliveries, err = strconv.Atoi(max); err != nil || item.MaxDeliveries < 0 {
+			return etcdErr.NewError(etcdErr.EcodeCountNaN, "Push", 0)
+		}
+	}
+
+	e, err := create(h.server, path.Join(keypath, itemsKey), item.encode(), store.Permanent)
+	if err != nil {
+		return err
+	}
+	w.Write([]byte(path.Base(e.Node.Key)))
+	return nil
+}
+
+// claimHandler claims the first visible item of a queue, waiting for one if
+// there is none.
+// The "visibility" parameter specifies how many seconds the item stays
+// invisible to other consumers.
+// The "timeout" parameter specifies how long the request should wait for an
+// item.
+//
+// The response is the item as a JSON object, including the claim index
+// needed to acknowledge it.
+func (h *handler) claimHandler(w http.ResponseWriter, req *http.Request) error {
+	closeNotifier, _ := w.(http.CloseNotifier)
+	closeChan := closeNotifier.CloseNotify()
+
+	vars := mux.Vars(req)
+	keypath := path.Join(h.prefix, vars["key"])
+
+	visibility := DefaultVisibilityTimeout
+	if v := req.FormValue("visibility"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			return etcdErr.NewError(etcdErr.EcodeTimeoutNaN, "Claim: visibility", 0)
+		}
+		visibility = time.Duration(seconds) * time.Second
+	}
+	timeout, err := parseTimeout(req, "Claim")
+	if err != nil {
+		return err
+	}
+	var deadline time.Time
+	if timeout >= 0 {
+		deadline = time.Now().Add(time.Duration(timeout) * time.Second)
+	}
+
+	for {
+		since := h.server.Store().Index()
+		item, next, err := h.claimNext(keypath, visibility)
+		if err != nil {
+			return err
+		}
+		if item != nil {
+			w.Header().Set("Content-Type", "application/json")
+			return json.NewEncoder(w).Encode(item)
+		}
+
+		// Wait for a new item, or for a claimed one to become visible.
+		now := time.Now()
+		if !deadline.IsZero() && !now.Before(deadline) {
+			return fmt.Errorf("failed to claim item: timed out")
+		}
+		wake := deadline
+		if !next.IsZero() && (wake.IsZero() || next.Before(wake)) {
+			wake = next
+		}
+		var wakeChan <-chan time.Time
+		if !wake.IsZero() {
+			wakeChan = time.After(wake.Sub(now))
+		}
+		if err := h.waitForChange(path.Join(keypath, itemsKey), true, since, closeChan, wakeChan); err != nil && err != errTimedOut {
+			return err
+		}
+	}
+}
+
+// claimNext claims the first visible item of a queue.
+// It also returns when the next invisible item becomes visible again.
+func (h *handler) claimNext(keypath string, visibility time.Duration) (*queueItem, time.Time, error) {
+	items, err := h.getQueueItems(path.Join(keypath, itemsKey))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var next time.Time
+	now := time.Now()
+	for _, item := range items {
+		if !item.visible(now) {
+			if next.IsZero() || item.invisibleUntil.Before(next) {
+				next = item.invisibleUntil
+			}
+			continue
+		}
+
+		if item.exhausted() {
+			if err := h.deadLetter(keypath, item); err != nil {
+				return nil, next, err
+			}
+			continue
+		}
+
+		claimed, err := h.claim(keypath, item, now.Add(visibility))
+		if err != nil {
+			return nil, next, err
+		}
+		if claimed != nil {
+			return claimed, next, nil
+		}
+	}
+	return nil, next, nil
+}
+
+// claim delivers an item once more. It returns nil if another consumer
+// changed the item first.
+func (h *handler) claim(keypath string, item *queueItem, invisibleUntil time.Time) (*queueItem, error) {
+	claimed := *item
+	claimed.Deliveries++
+	claimed.invisibleUntil = invisibleUntil
+
+	key := path.Join(keypath, itemsKey, strconv.Itoa(item.ID))
+	c := h.server.Store().CommandFactory().CreateCompareAndSwapCommand(key, claimed.encode(), "", item.modifiedIndex, store.Permanent)
+	e, err := do(h.server, c)
+	if err != nil {
+		if isCompareFailed(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	claimed.Claim = e.Node.ModifiedIndex
+	return &claimed, nil
+}
+
+// deadLetter moves an item to the dead directory of a queue.
+// Writing the item to the same id in the dead directory makes the move safe
+// to repeat if another consumer races with it.
+func (h *handler) deadLetter(keypath string, item *queueItem) error {
+	id := strconv.Itoa(item.ID)
+	if _, err := set(h.server, path.Join(keypath, deadKey, id), item.encode(), store.Permanent); err != nil {
+		return err
+	}
+	c := h.server.Store().CommandFactory().CreateCompareAndDeleteCommand(path.Join(keypath, itemsKey, id), "", item.modifiedIndex)
+	if _, err := do(h.server, c); err != nil && !isCompareFailed(err) {
+		return err
+	}
+	return nil
+}
+
+// ackHandler acknowledges a claimed item, which removes it from the queue.
+// The "id" parameter identifies the item and the "claim" parameter is the
+// claim index returned with it. An item whose visibility timeout ran out
+// and that has been claimed again can't be acknowledged anymore.
+func (h *handler) ackHandler(w http.ResponseWriter, req *http.Request) error {
+	vars := mux.Vars(req)
+	keypath := path.Join(h.prefix, vars["key"])
+
+	id, err := strconv.Atoi(req.FormValue("id"))
+	if err != nil {
+		return etcdErr.NewError(etcdErr.EcodeIndexNaN, "Ack: id", 0)
+	}
+	claim, err := strconv.ParseUint(req.FormValue("claim"), 10, 64)
+	if err != nil {
+		return etcdErr.NewError(etcdErr.EcodeIndexNaN, "Ack: claim", 0)
+	}
+
+	c := h.server.Store().CommandFactory().CreateCompareAndDeleteCommand(path.Join(keypath, itemsKey, strconv.Itoa(id)), "", claim)
+	_, err = do(h.server, c)
+	return err
+}
+
+// listQueueHandler retrieves the items of a queue as a JSON array.
+// With "dead=true", it retrieves the items of the dead-letter directory.
+func (h *handler) listQueueHandler(w http.ResponseWriter, req *http.Request) error {
+	vars := mux.Vars(req)
+	dir := itemsKey
+	if req.FormValue("dead") == "true" {
+		dir = deadKey
+	}
+
+	items, err := h.getQueueItems(path.Join(h.prefix, vars["key"], dir))
+	if err != nil {
+		return err
+	}
+	if items == nil {
+		items = queueItems{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(items)
+}
+
+// isCompareFailed checks whether a compare command failed because the node
+// changed or is gone.
+func isCompareFailed(err error) bool {
+	e, ok := err.(*etcdErr.Error)
+	return ok && (e.ErrorCode == etcdErr.EcodeTestFailed || e.ErrorCode == etcdErr.EcodeKeyNotFound)
+}
@@ -0,0 +1,103 @@
+package v2
+
+import (
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/coreos/etcd/store"
+)
+
+const (
+	itemsKey = "items"
+	deadKey  = "dead"
+)
+
+// queueItem is an item of a work queue.
+// Its state is encoded as a query in the value of its node.
+type queueItem struct {
+	ID         int    `json:"id"`
+	Value      string `json:"value"`
+	Deliveries int    `json:"deliveries"`
+
+	// Claim is the index of the claim that delivered the item, which the
+	// consumer needs to acknowledge it.
+	Claim uint64 `json:"claim,omitempty"`
+
+	// MaxDeliveries is how many times the item is delivered before it
+	// moves to the dead-letter directory. Zero means no limit.
+	MaxDeliveries int `json:"maxDeliveries,omitempty"`
+
+	// invisibleUntil is the end of the visibility timeout of the claim.
+	invisibleUntil time.Time
+	modifiedIndex  uint64
+}
+
+// newQueueItem decodes an item from its node.
+func newQueueItem(node *store.NodeExtern) *queueItem {
+	item := &queueItem{modifiedIndex: node.ModifiedIndex}
+	item.ID, _ = strconv.Atoi(path.Base(node.Key))
+	if node.Value == nil {
+		return item
+	}
+	v, _ := url.ParseQuery(*node.Value)
+	item.Value = v.Get("value")
+	item.Deliveries, _ = strconv.Atoi(v.Get("deliveries"))
+	item.MaxDeliveries, _ = strconv.Atoi(v.Get("max"))
+	if nanos, err := strconv.ParseInt(v.Get("invisible"), 10, 64); err == nil && nanos > 0 {
+		item.invisibleUntil = time.Unix(0, nanos)
+	}
+	return item
+}
+
+// encode encodes the state of the item as the value of its node.
+func (item *queueItem) encode() string {
+	v := url.Values{}
+	v.Set("value", item.Value)
+	v.Set("deliveries", strconv.Itoa(item.Deliveries))
+	if item.MaxDeliveries > 0 {
+		v.Set("max", strconv.Itoa(item.MaxDeliveries))
+	}
+	if !item.invisibleUntil.IsZero() {
+		v.Set("invisible", strconv.FormatInt(item.invisibleUntil.UnixNano(), 10))
+	}
+	return v.Encode()
+}
+
+// visible checks whether the item can be claimed at the given time.
+func (item *queueItem) visible(now time.Time) bool {
+	return !now.Before(item.invisibleUntil)
+}
+
+// exhausted checks whether the item has been delivered as many times as
+// it may be.
+func (item *queueItem) exhausted() bool {
+	return item.MaxDeliveries > 0 && item.Deliveries >= item.MaxDeliveries
+}
+
+// queueItems is a list of items sorted by id.
+type queueItems []*queueItem
+
+func (s queueItems) Len() int           { return len(s) }
+func (s queueItems) Less(i, j int) bool { return s[i].ID < s[j].ID }
+func (s queueItems) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// getQueueItems retrieves the items in a directory of a queue, in order.
+// A directory that doesn't exist has no items.
+func (h *handler) getQueueItems(dir string) (queueItems, error) {
+	e, err := h.server.Store().Get(dir, true, false)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	items := make(queueItems, 0, len(e.Node.Nodes))
+	for _, node := range e.Node.Nodes {
+		items = append(items, newQueueItem(node))
+	}
+	sort.Sort(items)
+	return items, nil
+}
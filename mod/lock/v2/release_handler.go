@@ -10,10 +10,8 @@ import (
 
 // releaseLockHandler deletes the lock.
 func (h *handler) releaseLockHandler(w http.ResponseWriter, req *http.Request) error {
-	h.client.SyncCluster()
-
 	vars := mux.Vars(req)
-	keypath := path.Join(prefix, vars["key"])
+	keypath := path.Join(h.prefix, vars["key"])
 
 	// Read index and value parameters.
 	index := req.FormValue("index")
@@ -26,11 +24,10 @@ func (h *handler) releaseLockHandler(w http.ResponseWriter, req *http.Request) e
 
 	// Look up index by value if index is missing.
 	if len(index) == 0 {
-		resp, err := h.client.Get(keypath, true, true)
+		nodes, err := h.getLockNodes(keypath)
 		if err != nil {
 			return err
 		}
-		nodes := lockNodes{resp.Node.Nodes}
 		node, _ := nodes.FindByValue(value)
 		if node == nil {
 			return etcdErr.NewError(etcdErr.EcodeKeyNotFound, "Release", 0)
@@ -39,7 +36,7 @@ func (h *handler) releaseLockHandler(w http.ResponseWriter, req *http.Request) e
 	}
 
 	// Delete the lock.
-	if _, err := h.client.Delete(path.Join(keypath, index), false); err != nil {
+	if _, err := remove(h.server, path.Join(keypath, index), false); err != nil {
 		return err
 	}
 
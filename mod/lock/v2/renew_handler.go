@@ -11,12 +11,12 @@ import (
 
 // renewLockHandler attempts to update the TTL on an existing lock.
 // Returns a 200 OK if successful. Returns non-200 on error.
+// Locks acquired within a session are renewed through the session, along
+// with the other nodes of the session.
 func (h *handler) renewLockHandler(w http.ResponseWriter, req *http.Request) error {
-	h.client.SyncCluster()
-
 	// Read the lock path.
 	vars := mux.Vars(req)
-	keypath := path.Join(prefix, vars["key"])
+	keypath := path.Join(h.prefix, vars["key"])
 
 	// Parse new TTL parameter.
 	ttl, err := strconv.Atoi(req.FormValue("ttl"))
@@ -33,28 +33,31 @@ func (h *handler) renewLockHandler(w http.ResponseWriter, req *http.Request) err
 
 	if len(index) == 0 {
 		// If index is not specified then look it up by value.
-		resp, err := h.client.Get(keypath, true, true)
+		nodes, err := h.getLockNodes(keypath)
 		if err != nil {
 			return err
 		}
-		nodes := lockNodes{resp.Node.Nodes}
 		node, _ := nodes.FindByValue(value)
 		if node == nil {
 			return etcdErr.NewError(etcdErr.EcodeKeyNotFound, "Renew", 0)
 		}
 		index = path.Base(node.Key)
+	}
 
-	} else if len(value) == 0 {
-		// If value is not specified then default it to the previous value.
-		resp, err := h.client.Get(path.Join(keypath, index), true, false)
-		if err != nil {
-			return err
-		}
-		value = resp.Node.Value
+	// A lock acquired within a session expires with it, so renewing the
+	// lock alone would give it a TTL of its own until the next renewal of
+	// the session.
+	indexpath := path.Join(keypath, index)
+	session, err := nodeSession(h.server, indexpath)
+	if err != nil {
+		return err
+	}
+	if session != "" {
+		return renewSession(h.server, session, ttl)
 	}
 
 	// Renew the lock, if it exists.
-	if _, err = h.client.Update(path.Join(keypath, index), value, uint64(ttl)); err != nil {
+	if _, err = update(h.server, indexpath, expireTime(ttl)); err != nil {
 		return err
 	}
 
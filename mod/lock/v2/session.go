@@ -0,0 +1,137 @@
+package v2
+
+import (
+	"path"
+	"time"
+
+	etcdErr "github.com/coreos/etcd/error"
+	"github.com/coreos/etcd/store"
+)
+
+const sessionPrefix = "/_etcd/mod/session"
+
+// A session is a directory with a TTL under sessionPrefix. Each node that
+// lives as long as the session is recorded as a child of the directory and
+// shares its expiration time, so that the nodes expire together with the
+// session unless it is renewed.
+
+// createSession creates a new session and returns its id.
+func createSession(s Server, ttl int) (string, error) {
+	c := s.Store().CommandFactory().CreateCreateCommand(sessionPrefix, true, "", expireTime(ttl), true)
+	e, err := do(s, c)
+	if err != nil {
+		return "", err
+	}
+	return path.Base(e.Node.Key), nil
+}
+
+// sessionExpiration returns the expiration time of an open session.
+func sessionExpiration(s Server, id string) (time.Time, error) {
+	e, err := s.Store().Get(path.Join(sessionPrefix, id), false, false)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !e.Node.Dir {
+		return time.Time{}, etcdErr.NewError(etcdErr.EcodeNotDir, e.Node.Key, s.Store().Index())
+	}
+	if e.Node.Expiration == nil {
+		return store.Permanent, nil
+	}
+	return *e.Node.Expiration, nil
+}
+
+// attachToSession makes the node at key live as long as the session.
+// The node must have been created with the expiration time of the session.
+// A node that is already attached, such as a renewed one, isn't recorded
+// again.
+func attachToSession(s Server, id string, key string) error {
+	records, err := sessionNodes(s, id)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if record.Value != nil && *record.Value == key {
+			return nil
+		}
+	}
+	_, err = create(s, path.Join(sessionPrefix, id), key, store.Permanent)
+	return err
+}
+
+// sessionNodes returns the records of the nodes attached to a session.
+func sessionNodes(s Server, id string) (store.NodeExterns, error) {
+	e, err := s.Store().Get(path.Join(sessionPrefix, id), true, false)
+	if err != nil {
+		return nil, err
+	}
+	return e.Node.Nodes, nil
+}
+
+// nodeSession returns the id of the session the node at key is attached
+// to, or "" if the node isn't attached to any.
+func nodeSession(s Server, key string) (string, error) {
+	e, err := s.Store().Get(sessionPrefix, true, false)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	for _, session := range e.Node.Nodes {
+		for _, record := range session.Nodes {
+			if record.Value != nil && *record.Value == key {
+				return path.Base(session.Key), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// renewSession sets the TTL of a session and of the nodes attached to it.
+func renewSession(s Server, id string, ttl int) error {
+	records, err := sessionNodes(s, id)
+	if err != nil {
+		return err
+	}
+
+	t := expireTime(ttl)
+	if _, err := update(s, path.Join(sessionPrefix, id), t); err != nil {
+		return err
+	}
+	for _, record := range records {
+		if _, err := update(s, *record.Value, t); err != nil {
+			if !isKeyNotFound(err) {
+				return err
+			}
+			// The node has been removed; forget about it.
+			remove(s, record.Key, false)
+		}
+	}
+	return nil
+}
+
+// endSession removes a session and the nodes attached to it.
+func endSession(s Server, id string) error {
+	records, err := sessionNodes(s, id)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if _, err := remove(s, *record.Value, false); err != nil && !isKeyNotFound(err) {
+			return err
+		}
+	}
+	_, err = remove(s, path.Join(sessionPrefix, id), true)
+	return err
+}
+
+func isKeyNotFound(err error) bool {
+	e, ok := err.(*etcdErr.Error)
+	return ok && e.ErrorCode == etcdErr.EcodeKeyNotFound
+}
+
+func isNodeExist(err error) bool {
+	e, ok := err.(*etcdErr.Error)
+	return ok && e.ErrorCode == etcdErr.EcodeNodeExist
+}
@@ -0,0 +1,58 @@
+package v2
+
+import (
+	"net/http"
+	"strconv"
+
+	etcdErr "github.com/coreos/etcd/error"
+	"github.com/coreos/etcd/third_party/github.com/gorilla/mux"
+)
+
+// NewSessionHandler creates an HTTP handler for sessions that can be
+// registered on a router.
+// A session is kept alive by its holder renewing it before its TTL runs
+// out. Locks acquired within a session are released when it ends, either
+// because it is closed or because it expired.
+func NewSessionHandler(s Server) http.Handler {
+	h := &handler{
+		Router: mux.NewRouter(),
+		server: s,
+	}
+	h.StrictSlash(false)
+	h.handleFunc("/session", h.createSessionHandler).Methods("POST")
+	h.handleFunc("/session/{id}", h.renewSessionHandler).Methods("PUT")
+	h.handleFunc("/session/{id}", h.closeSessionHandler).Methods("DELETE")
+	return h
+}
+
+// createSessionHandler opens a session and returns its id.
+// The "ttl" parameter specifies how long the session lasts unless renewed.
+func (h *handler) createSessionHandler(w http.ResponseWriter, req *http.Request) error {
+	ttl, err := strconv.Atoi(req.FormValue("ttl"))
+	if err != nil || ttl <= 0 {
+		return etcdErr.NewError(etcdErr.EcodeTTLNaN, "Create Session", 0)
+	}
+
+	id, err := createSession(h.server, ttl)
+	if err != nil {
+		return err
+	}
+
+	w.Write([]byte(id))
+	return nil
+}
+
+// renewSessionHandler resets the TTL of a session and its locks.
+func (h *handler) renewSessionHandler(w http.ResponseWriter, req *http.Request) error {
+	ttl, err := strconv.Atoi(req.FormValue("ttl"))
+	if err != nil || ttl <= 0 {
+		return etcdErr.NewError(etcdErr.EcodeTTLNaN, "Renew Session", 0)
+	}
+
+	return renewSession(h.server, mux.Vars(req)["id"], ttl)
+}
+
+// closeSessionHandler ends a session and releases its locks.
+func (h *handler) closeSessionHandler(w http.ResponseWriter, req *http.Request) error {
+	return endSession(h.server, mux.Vars(req)["id"])
+}
@@ -0,0 +1,275 @@
+package lock
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/server"
+	"github.com/coreos/etcd/tests"
+	"github.com/coreos/etcd/third_party/github.com/stretchr/testify/assert"
+)
+
+// Ensure that waiters are blocked until a barrier is released.
+func TestModBarrierRelease(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		_, status, err := testHoldBarrier(s, "foo", 10)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+
+		// A waiter times out while the barrier is held.
+		_, status, err = testWaitBarrier(s, "foo", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 500)
+
+		c := make(chan bool)
+		go func() {
+			_, status, err := testWaitBarrier(s, "foo", 10)
+			assert.NoError(t, err)
+			assert.Equal(t, status, 200)
+			c <- true
+		}()
+		time.Sleep(500 * time.Millisecond)
+
+		_, status, err = testReleaseBarrier(s, "foo")
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+
+		select {
+		case <-c:
+		case <-time.After(1 * time.Second):
+			t.Fatal("waiter not released")
+		}
+	})
+}
+
+// Ensure that a barrier held with a TTL is released when the TTL runs out.
+func TestModBarrierExpire(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		testHoldBarrier(s, "foo", 1)
+
+		_, status, err := testWaitBarrier(s, "foo", 5)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+	})
+}
+
+// Ensure that renewing a barrier held within a session doesn't record it in
+// the session again.
+func TestModBarrierSessionRenew(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		session, _, err := testCreateSession(s, 10)
+		assert.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			_, status, err := testHoldBarrierWithSession(s, "foo", session)
+			assert.NoError(t, err)
+			assert.Equal(t, status, 200)
+		}
+
+		resp, err := tests.Get(fmt.Sprintf("%s/v2/keys/_etcd/mod/session/%s", s.URL(), session))
+		assert.NoError(t, err)
+		body := string(tests.ReadBody(resp))
+		assert.Equal(t, strings.Count(body, `"value":"/_etcd/mod/barrier/foo"`), 1, body)
+
+		// The barrier is still released with the session.
+		_, status, err := testCloseSession(s, session)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+		_, status, err = testWaitBarrier(s, "foo", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+	})
+}
+
+// Ensure that waiting on a directory is an error.
+func TestModBarrierWaitOnDirectory(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		testHoldBarrier(s, "foo/bar", 10)
+
+		_, status, err := testGetBarrier(s, "foo")
+		assert.NoError(t, err)
+		assert.Equal(t, status, 403)
+
+		_, status, err = testWaitBarrier(s, "foo", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 403)
+	})
+}
+
+// Ensure that the participants of a double barrier enter and leave together.
+func TestModDoubleBarrier(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		entered := make(chan string, 3)
+		for _, name := range []string{"a", "b"} {
+			go func(name string) {
+				_, status, err := testEnterDoubleBarrier(s, "foo", name, 3)
+				assert.NoError(t, err)
+				assert.Equal(t, status, 200)
+				entered <- name
+			}(name)
+		}
+		time.Sleep(500 * time.Millisecond)
+		assert.Equal(t, len(entered), 0)
+
+		body, _, err := testGetParticipants(s, "foo")
+		assert.NoError(t, err)
+		assert.Equal(t, len(strings.Split(body, "\n")), 2)
+
+		// The third participant lets everyone in.
+		_, status, err := testEnterDoubleBarrier(s, "foo", "c", 3)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+		for i := 0; i < 2; i++ {
+			select {
+			case <-entered:
+			case <-time.After(1 * time.Second):
+				t.Fatal("participant did not enter")
+			}
+		}
+
+		left := make(chan string, 3)
+		for _, name := range []string{"a", "b"} {
+			go func(name string) {
+				_, status, err := testLeaveDoubleBarrier(s, "foo", name)
+				assert.NoError(t, err)
+				assert.Equal(t, status, 200)
+				left <- name
+			}(name)
+		}
+		time.Sleep(500 * time.Millisecond)
+		assert.Equal(t, len(left), 0)
+
+		// The last participant lets everyone out.
+		_, status, err = testLeaveDoubleBarrier(s, "foo", "c")
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+		for i := 0; i < 2; i++ {
+			select {
+			case <-left:
+			case <-time.After(1 * time.Second):
+				t.Fatal("participant did not leave")
+			}
+		}
+
+		// The barrier is reset, so a new participant has to wait again.
+		_, status, err = testEnterDoubleBarrierWithTimeout(s, "foo", "a", 2, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 500)
+	})
+}
+
+// Ensure that participants arriving while a round is leaving wait for the
+// next round.
+func TestModDoubleBarrierRounds(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		for _, name := range []string{"a", "b"} {
+			go testEnterDoubleBarrier(s, "foo", name, 2)
+		}
+		time.Sleep(500 * time.Millisecond)
+
+		left := make(chan bool)
+		go func() {
+			_, status, err := testLeaveDoubleBarrier(s, "foo", "a")
+			assert.NoError(t, err)
+			assert.Equal(t, status, 200)
+			left <- true
+		}()
+		time.Sleep(500 * time.Millisecond)
+
+		// The first round isn't over, so the new participant waits.
+		_, status, err := testEnterDoubleBarrierWithTimeout(s, "foo", "c", 2, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 500)
+
+		_, status, err = testLeaveDoubleBarrier(s, "foo", "b")
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+		select {
+		case <-left:
+		case <-time.After(1 * time.Second):
+			t.Fatal("participant did not leave")
+		}
+
+		// The next round starts afresh.
+		entered := make(chan bool)
+		go func() {
+			_, status, err := testEnterDoubleBarrier(s, "foo", "d", 2)
+			assert.NoError(t, err)
+			assert.Equal(t, status, 200)
+			entered <- true
+		}()
+		_, status, err = testEnterDoubleBarrier(s, "foo", "e", 2)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+		select {
+		case <-entered:
+		case <-time.After(1 * time.Second):
+			t.Fatal("participant did not enter")
+		}
+	})
+}
+
+// Ensure that a double barrier requires a valid count.
+func TestModDoubleBarrierInvalidCount(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		_, status, err := testEnterDoubleBarrier(s, "foo", "a", 0)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 400)
+	})
+}
+
+func testHoldBarrier(s *server.Server, key string, ttl int) (string, int, error) {
+	resp, err := tests.PutForm(fmt.Sprintf("%s/mod/v2/barrier/%s?ttl=%d", s.URL(), key, ttl), nil)
+	ret := tests.ReadBody(resp)
+	return string(ret), resp.StatusCode, err
+}
+
+func testHoldBarrierWithSession(s *server.Server, key string, session string) (string, int, error) {
+	resp, err := tests.PutForm(fmt.Sprintf("%s/mod/v2/barrier/%s?session=%s", s.URL(), key, session), nil)
+	ret := tests.ReadBody(resp)
+	return string(ret), resp.StatusCode, err
+}
+
+func testGetBarrier(s *server.Server, key string) (string, int, error) {
+	resp, err := tests.Get(fmt.Sprintf("%s/mod/v2/barrier/%s", s.URL(), key))
+	ret := tests.ReadBody(resp)
+	return string(ret), resp.StatusCode, err
+}
+
+func testWaitBarrier(s *server.Server, key string, timeout int) (string, int, error) {
+	resp, err := tests.Get(fmt.Sprintf("%s/mod/v2/barrier/%s?wait=true&timeout=%d", s.URL(), key, timeout))
+	ret := tests.ReadBody(resp)
+	return string(ret), resp.StatusCode, err
+}
+
+func testReleaseBarrier(s *server.Server, key string) (string, int, error) {
+	resp, err := tests.DeleteForm(fmt.Sprintf("%s/mod/v2/barrier/%s", s.URL(), key), nil)
+	ret := tests.ReadBody(resp)
+	return string(ret), resp.StatusCode, err
+}
+
+func testEnterDoubleBarrier(s *server.Server, key string, value string, count int) (string, int, error) {
+	resp, err := tests.PostForm(fmt.Sprintf("%s/mod/v2/doublebarrier/%s?value=%s&count=%d&ttl=10", s.URL(), key, value, count), nil)
+	ret := tests.ReadBody(resp)
+	return string(ret), resp.StatusCode, err
+}
+
+func testEnterDoubleBarrierWithTimeout(s *server.Server, key string, value string, count int, timeout int) (string, int, error) {
+	resp, err := tests.PostForm(fmt.Sprintf("%s/mod/v2/doublebarrier/%s?value=%s&count=%d&ttl=10&timeout=%d", s.URL(), key, value, count, timeout), nil)
+	ret := tests.ReadBody(resp)
+	return string(ret), resp.StatusCode, err
+}
+
+func testLeaveDoubleBarrier(s *server.Server, key string, value string) (string, int, error) {
+	resp, err := tests.DeleteForm(fmt.Sprintf("%s/mod/v2/doublebarrier/%s?value=%s", s.URL(), key, value), nil)
+	ret := tests.ReadBody(resp)
+	return string(ret), resp.StatusCode, err
+}
+
+func testGetParticipants(s *server.Server, key string) (string, int, error) {
+	resp, err := tests.Get(fmt.Sprintf("%s/mod/v2/doublebarrier/%s", s.URL(), key))
+	ret := tests.ReadBody(resp)
+	return string(ret), resp.StatusCode, err
+}
@@ -0,0 +1,200 @@
+package lock
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/server"
+	"github.com/coreos/etcd/tests"
+	"github.com/coreos/etcd/third_party/github.com/stretchr/testify/assert"
+)
+
+type testLeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Index int    `json:"index"`
+}
+
+// Ensure that the next candidate leads as soon as the leader resigns.
+func TestModElectionResign(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		_, status, err := testCampaign(s, "foo", "a", "", 10)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+
+		c := make(chan bool)
+		go func() {
+			_, status, err := testCampaign(s, "foo", "b", "", 10)
+			assert.NoError(t, err)
+			assert.Equal(t, status, 200)
+			c <- true
+		}()
+		time.Sleep(1 * time.Second)
+
+		body, status, err := testGetLeader(s, "foo", "name")
+		assert.NoError(t, err)
+		assert.Equal(t, body, "a")
+
+		_, status, err = testResign(s, "foo", "a")
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+
+		select {
+		case <-c:
+		case <-time.After(1 * time.Second):
+			t.Fatal("candidate b did not take over")
+		}
+
+		body, status, err = testGetLeader(s, "foo", "name")
+		assert.NoError(t, err)
+		assert.Equal(t, body, "b")
+	})
+}
+
+// Ensure that only the leader can proclaim a value.
+func TestModElectionProclaim(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		_, status, err := testCampaign(s, "foo", "a", "v1", 10)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+
+		body, _, err := testGetLeader(s, "foo", "")
+		assert.NoError(t, err)
+		assert.Equal(t, body, "v1")
+
+		_, status, err = testProclaim(s, "foo", "a", "v2")
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+
+		body, _, err = testGetLeader(s, "foo", "")
+		assert.NoError(t, err)
+		assert.Equal(t, body, "v2")
+
+		_, status, err = testProclaim(s, "foo", "b", "v3")
+		assert.NoError(t, err)
+		assert.Equal(t, status, 404)
+
+		// A candidate that doesn't lead can't proclaim.
+		go testCampaign(s, "foo", "b", "", 10)
+		time.Sleep(500 * time.Millisecond)
+		_, status, err = testProclaim(s, "foo", "b", "v3")
+		assert.NoError(t, err)
+		assert.Equal(t, status, 412)
+	})
+}
+
+// Ensure that observers are told about changes of the leader.
+func TestModElectionObserve(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		resp, err := tests.Get(fmt.Sprintf("%s/mod/v2/leader/foo?stream=true", s.URL()))
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		leaders := make(chan testLeader, 10)
+		go func() {
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				var l testLeader
+				json.Unmarshal(scanner.Bytes(), &l)
+				leaders <- l
+			}
+		}()
+
+		next := func() testLeader {
+			select {
+			case l := <-leaders:
+				return l
+			case <-time.After(2 * time.Second):
+				t.Fatal("no leader change observed")
+			}
+			return testLeader{}
+		}
+
+		// No leader yet.
+		assert.Equal(t, next(), testLeader{})
+
+		testCampaign(s, "foo", "a", "v1", 10)
+		l := next()
+		assert.Equal(t, l.Name, "a")
+		assert.Equal(t, l.Value, "v1")
+
+		testProclaim(s, "foo", "a", "v2")
+		l = next()
+		assert.Equal(t, l.Name, "a")
+		assert.Equal(t, l.Value, "v2")
+
+		testResign(s, "foo", "a")
+		assert.Equal(t, next(), testLeader{})
+	})
+}
+
+// Ensure that a waiting observer returns on the next change of the leader.
+func TestModElectionWait(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		testCampaign(s, "foo", "a", "v1", 10)
+
+		c := make(chan string)
+		go func() {
+			resp, _ := tests.Get(fmt.Sprintf("%s/mod/v2/leader/foo?wait=true", s.URL()))
+			c <- string(tests.ReadBody(resp))
+		}()
+		time.Sleep(500 * time.Millisecond)
+
+		testProclaim(s, "foo", "a", "v2")
+		select {
+		case body := <-c:
+			var l testLeader
+			assert.NoError(t, json.Unmarshal([]byte(body), &l))
+			assert.Equal(t, l.Value, "v2")
+		case <-time.After(2 * time.Second):
+			t.Fatal("waiting observer not notified")
+		}
+	})
+}
+
+// Ensure that the next candidate leads when the session of the leader expires.
+func TestModElectionSessionExpire(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		session, _, err := testCreateSession(s, 1)
+		assert.NoError(t, err)
+
+		resp, err := tests.PutForm(fmt.Sprintf("%s/mod/v2/leader/foo?name=a&session=%s", s.URL(), session), nil)
+		assert.NoError(t, err)
+		tests.ReadBody(resp)
+		assert.Equal(t, resp.StatusCode, 200)
+
+		_, status, err := testCampaign(s, "foo", "b", "", 10)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+
+		body, _, err := testGetLeader(s, "foo", "name")
+		assert.NoError(t, err)
+		assert.Equal(t, body, "b")
+	})
+}
+
+func testCampaign(s *server.Server, key string, name string, value string, ttl int) (string, int, error) {
+	resp, err := tests.PutForm(fmt.Sprintf("%s/mod/v2/leader/%s?name=%s&value=%s&ttl=%d", s.URL(), key, name, value, ttl), nil)
+	ret := tests.ReadBody(resp)
+	return string(ret), resp.StatusCode, err
+}
+
+func testProclaim(s *server.Server, key string, name string, value string) (string, int, error) {
+	resp, err := tests.PostForm(fmt.Sprintf("%s/mod/v2/leader/%s?name=%s&value=%s", s.URL(), key, name, value), nil)
+	ret := tests.ReadBody(resp)
+	return string(ret), resp.StatusCode, err
+}
+
+func testResign(s *server.Server, key string, name string) (string, int, error) {
+	resp, err := tests.DeleteForm(fmt.Sprintf("%s/mod/v2/leader/%s?name=%s", s.URL(), key, name), nil)
+	ret := tests.ReadBody(resp)
+	return string(ret), resp.StatusCode, err
+}
+
+func testGetLeader(s *server.Server, key string, field string) (string, int, error) {
+	resp, err := tests.Get(fmt.Sprintf("%s/mod/v2/leader/%s?field=%s", s.URL(), key, field))
+	ret := tests.ReadBody(resp)
+	return string(ret), resp.StatusCode, err
+}
@@ -2,6 +2,7 @@ package lock
 
 import (
 	"fmt"
+	"strconv"
 	"testing"
 	"time"
 
@@ -17,16 +18,16 @@ func TestModLockAcquireAndRelease(t *testing.T) {
 		body, status, err := testAcquireLock(s, "foo", "", 10)
 		assert.NoError(t, err)
 		assert.Equal(t, status, 200)
-		assert.Equal(t, body, "2")
+		assert.Equal(t, body, "3")
 
 		// Check that we have the lock.
 		body, status, err = testGetLockIndex(s, "foo")
 		assert.NoError(t, err)
 		assert.Equal(t, status, 200)
-		assert.Equal(t, body, "2")
+		assert.Equal(t, body, "3")
 
 		// Release lock.
-		body, status, err = testReleaseLock(s, "foo", "2", "")
+		body, status, err = testReleaseLock(s, "foo", "3", "")
 		assert.NoError(t, err)
 		assert.Equal(t, status, 200)
 		assert.Equal(t, body, "")
@@ -49,7 +50,7 @@ func TestModLockBlockUntilAcquire(t *testing.T) {
 			body, status, err := testAcquireLock(s, "foo", "", 10)
 			assert.NoError(t, err)
 			assert.Equal(t, status, 200)
-			assert.Equal(t, body, "2")
+			assert.Equal(t, body, "3")
 			c <- true
 		}()
 		<-c
@@ -61,7 +62,7 @@ func TestModLockBlockUntilAcquire(t *testing.T) {
 			body, status, err := testAcquireLock(s, "foo", "", 10)
 			assert.NoError(t, err)
 			assert.Equal(t, status, 200)
-			assert.Equal(t, body, "4")
+			assert.Equal(t, body, "5")
 			waiting = false
 		}()
 		<-c
@@ -72,13 +73,13 @@ func TestModLockBlockUntilAcquire(t *testing.T) {
 		body, status, err := testGetLockIndex(s, "foo")
 		assert.NoError(t, err)
 		assert.Equal(t, status, 200)
-		assert.Equal(t, body, "2")
+		assert.Equal(t, body, "3")
 
 		// Check that we are still waiting for lock #2.
 		assert.Equal(t, waiting, true)
 
 		// Release lock #1.
-		_, status, err = testReleaseLock(s, "foo", "2", "")
+		_, status, err = testReleaseLock(s, "foo", "3", "")
 		assert.NoError(t, err)
 		assert.Equal(t, status, 200)
 
@@ -86,10 +87,10 @@ func TestModLockBlockUntilAcquire(t *testing.T) {
 		body, status, err = testGetLockIndex(s, "foo")
 		assert.NoError(t, err)
 		assert.Equal(t, status, 200)
-		assert.Equal(t, body, "4")
+		assert.Equal(t, body, "5")
 
 		// Release lock #2.
-		_, status, err = testReleaseLock(s, "foo", "4", "")
+		_, status, err = testReleaseLock(s, "foo", "5", "")
 		assert.NoError(t, err)
 		assert.Equal(t, status, 200)
 
@@ -111,7 +112,7 @@ func TestModLockExpireAndRelease(t *testing.T) {
 			body, status, err := testAcquireLock(s, "foo", "", 2)
 			assert.NoError(t, err)
 			assert.Equal(t, status, 200)
-			assert.Equal(t, body, "2")
+			assert.Equal(t, body, "3")
 			c <- true
 		}()
 		<-c
@@ -122,7 +123,7 @@ func TestModLockExpireAndRelease(t *testing.T) {
 			body, status, err := testAcquireLock(s, "foo", "", 10)
 			assert.NoError(t, err)
 			assert.Equal(t, status, 200)
-			assert.Equal(t, body, "4")
+			assert.Equal(t, body, "5")
 		}()
 		<-c
 
@@ -132,7 +133,7 @@ func TestModLockExpireAndRelease(t *testing.T) {
 		body, status, err := testGetLockIndex(s, "foo")
 		assert.NoError(t, err)
 		assert.Equal(t, status, 200)
-		assert.Equal(t, body, "2")
+		assert.Equal(t, body, "3")
 
 		// Wait for lock #1 TTL.
 		time.Sleep(2 * time.Second)
@@ -141,7 +142,7 @@ func TestModLockExpireAndRelease(t *testing.T) {
 		body, status, err = testGetLockIndex(s, "foo")
 		assert.NoError(t, err)
 		assert.Equal(t, status, 200)
-		assert.Equal(t, body, "4")
+		assert.Equal(t, body, "5")
 	})
 }
 
@@ -152,7 +153,7 @@ func TestModLockRenew(t *testing.T) {
 		body, status, err := testAcquireLock(s, "foo", "", 3)
 		assert.NoError(t, err)
 		assert.Equal(t, status, 200)
-		assert.Equal(t, body, "2")
+		assert.Equal(t, body, "3")
 
 		time.Sleep(2 * time.Second)
 
@@ -160,10 +161,10 @@ func TestModLockRenew(t *testing.T) {
 		body, status, err = testGetLockIndex(s, "foo")
 		assert.NoError(t, err)
 		assert.Equal(t, status, 200)
-		assert.Equal(t, body, "2")
+		assert.Equal(t, body, "3")
 
 		// Renew lock.
-		body, status, err = testRenewLock(s, "foo", "2", "", 3)
+		body, status, err = testRenewLock(s, "foo", "3", "", 3)
 		assert.NoError(t, err)
 		assert.Equal(t, status, 200)
 		assert.Equal(t, body, "")
@@ -174,7 +175,7 @@ func TestModLockRenew(t *testing.T) {
 		body, status, err = testGetLockIndex(s, "foo")
 		assert.NoError(t, err)
 		assert.Equal(t, status, 200)
-		assert.Equal(t, body, "2")
+		assert.Equal(t, body, "3")
 
 		time.Sleep(2 * time.Second)
 
@@ -193,7 +194,7 @@ func TestModLockAcquireAndReleaseByValue(t *testing.T) {
 		body, status, err := testAcquireLock(s, "foo", "XXX", 10)
 		assert.NoError(t, err)
 		assert.Equal(t, status, 200)
-		assert.Equal(t, body, "2")
+		assert.Equal(t, body, "3")
 
 		// Check that we have the lock.
 		body, status, err = testGetLockValue(s, "foo")
@@ -225,7 +226,7 @@ func TestModLockAcquireTimeout(t *testing.T) {
 			body, status, err := testAcquireLock(s, "foo", "first", 10)
 			assert.NoError(t, err)
 			assert.Equal(t, status, 200)
-			assert.Equal(t, body, "2")
+			assert.Equal(t, body, "3")
 			c <- true
 		}()
 		<-c
@@ -247,13 +248,13 @@ func TestModLockAcquireTimeout(t *testing.T) {
 		body, status, err := testGetLockIndex(s, "foo")
 		assert.NoError(t, err)
 		assert.Equal(t, status, 200)
-		assert.Equal(t, body, "2")
+		assert.Equal(t, body, "3")
 
 		// Check that we are not still waiting for lock #2.
 		assert.Equal(t, waiting, false)
 
 		// Release lock #1.
-		_, status, err = testReleaseLock(s, "foo", "2", "")
+		_, status, err = testReleaseLock(s, "foo", "3", "")
 		assert.NoError(t, err)
 		assert.Equal(t, status, 200)
 
@@ -274,7 +275,7 @@ func TestModLockAcquireNoWait(t *testing.T) {
 			body, status, err := testAcquireLockWithTimeout(s, "foo", "first", 10, 0)
 			assert.NoError(t, err)
 			assert.Equal(t, status, 200)
-			assert.Equal(t, body, "2")
+			assert.Equal(t, body, "3")
 			c <- true
 		}()
 		<-c
@@ -285,10 +286,10 @@ func TestModLockAcquireNoWait(t *testing.T) {
 		body, status, err := testGetLockIndex(s, "foo")
 		assert.NoError(t, err)
 		assert.Equal(t, status, 200)
-		assert.Equal(t, body, "2")
+		assert.Equal(t, body, "3")
 
 		// Release lock #1.
-		_, status, err = testReleaseLock(s, "foo", "2", "")
+		_, status, err = testReleaseLock(s, "foo", "3", "")
 		assert.NoError(t, err)
 		assert.Equal(t, status, 200)
 
@@ -309,7 +310,7 @@ func TestModLockAcquireNoWaitWhileLocked(t *testing.T) {
 			body, status, err := testAcquireLock(s, "foo", "first", 10)
 			assert.NoError(t, err)
 			assert.Equal(t, status, 200)
-			assert.Equal(t, body, "2")
+			assert.Equal(t, body, "3")
 			c <- true
 		}()
 		<-c
@@ -331,13 +332,13 @@ func TestModLockAcquireNoWaitWhileLocked(t *testing.T) {
 		body, status, err := testGetLockIndex(s, "foo")
 		assert.NoError(t, err)
 		assert.Equal(t, status, 200)
-		assert.Equal(t, body, "2")
+		assert.Equal(t, body, "3")
 
 		// Check that we are not still waiting for lock #2.
 		assert.Equal(t, waiting, false)
 
 		// Release lock #1.
-		_, status, err = testReleaseLock(s, "foo", "2", "")
+		_, status, err = testReleaseLock(s, "foo", "3", "")
 		assert.NoError(t, err)
 		assert.Equal(t, status, 200)
 
@@ -348,6 +349,134 @@ func TestModLockAcquireNoWaitWhileLocked(t *testing.T) {
 	})
 }
 
+// Ensure that each grant of a lock returns a greater fencing token.
+func TestModLockFencingToken(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		var last int
+		for i := 0; i < 3; i++ {
+			body, status, err := testAcquireLock(s, "foo", "", 10)
+			assert.NoError(t, err)
+			assert.Equal(t, status, 200)
+
+			token, err := strconv.Atoi(body)
+			assert.NoError(t, err)
+			assert.True(t, token > last, fmt.Sprintf("token %d after %d", token, last))
+			last = token
+
+			_, status, err = testReleaseLock(s, "foo", body, "")
+			assert.NoError(t, err)
+			assert.Equal(t, status, 200)
+		}
+	})
+}
+
+// Ensure that the locks of a session are released when it is closed.
+func TestModLockSessionClose(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		session, status, err := testCreateSession(s, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+
+		// Acquire lock #1 within the session.
+		body, status, err := testAcquireLockWithSession(s, "foo", session)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+		first := body
+
+		// Acquire lock #2 without session.
+		c := make(chan string)
+		go func() {
+			body, status, err := testAcquireLock(s, "foo", "", 10)
+			assert.NoError(t, err)
+			assert.Equal(t, status, 200)
+			c <- body
+		}()
+
+		time.Sleep(500 * time.Millisecond)
+		body, _, _ = testGetLockIndex(s, "foo")
+		assert.Equal(t, body, first)
+
+		// Close the session.
+		_, status, err = testCloseSession(s, session)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+
+		// Check that lock #2 is granted.
+		second := <-c
+		body, _, _ = testGetLockIndex(s, "foo")
+		assert.Equal(t, body, second)
+	})
+}
+
+// Ensure that the locks of a session are released when it expires and
+// kept while it is renewed.
+func TestModLockSessionExpire(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		session, status, err := testCreateSession(s, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+
+		body, status, err := testAcquireLockWithSession(s, "foo", session)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+		index := body
+
+		// Renew the session past its first TTL.
+		time.Sleep(1 * time.Second)
+		_, status, err = testRenewSession(s, session, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+		time.Sleep(1500 * time.Millisecond)
+
+		body, _, _ = testGetLockIndex(s, "foo")
+		assert.Equal(t, body, index)
+
+		// Let the session expire.
+		time.Sleep(2 * time.Second)
+
+		body, status, err = testGetLockIndex(s, "foo")
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+		assert.Equal(t, body, "")
+
+		_, status, err = testRenewSession(s, session, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 404)
+	})
+}
+
+// Ensure that renewing a lock acquired within a session renews the session.
+func TestModLockRenewSessionLock(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		session, status, err := testCreateSession(s, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+
+		index, status, err := testAcquireLockWithSession(s, "foo", session)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+
+		_, status, err = testRenewLock(s, "foo", index, "", 5)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+
+		// The session outlives its first TTL along with the lock.
+		time.Sleep(3 * time.Second)
+		body, _, _ := testGetLockIndex(s, "foo")
+		assert.Equal(t, body, index)
+		_, status, err = testRenewSession(s, session, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+
+		// The lock still goes away with the session.
+		_, status, err = testCloseSession(s, session)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+		body, _, _ = testGetLockIndex(s, "foo")
+		assert.Equal(t, body, "")
+	})
+}
+
 func testAcquireLock(s *server.Server, key string, value string, ttl int) (string, int, error) {
 	resp, err := tests.PostForm(fmt.Sprintf("%s/mod/v2/lock/%s?value=%s&ttl=%d", s.URL(), key, value, ttl), nil)
 	ret := tests.ReadBody(resp)
@@ -383,3 +512,27 @@ func testRenewLock(s *server.Server, key string, index string, value string, ttl
 	ret := tests.ReadBody(resp)
 	return string(ret), resp.StatusCode, err
 }
+
+func testAcquireLockWithSession(s *server.Server, key string, session string) (string, int, error) {
+	resp, err := tests.PostForm(fmt.Sprintf("%s/mod/v2/lock/%s?session=%s", s.URL(), key, session), nil)
+	ret := tests.ReadBody(resp)
+	return string(ret), resp.StatusCode, err
+}
+
+func testCreateSession(s *server.Server, ttl int) (string, int, error) {
+	resp, err := tests.PostForm(fmt.Sprintf("%s/mod/v2/session?ttl=%d", s.URL(), ttl), nil)
+	ret := tests.ReadBody(resp)
+	return string(ret), resp.StatusCode, err
+}
+
+func testRenewSession(s *server.Server, session string, ttl int) (string, int, error) {
+	resp, err := tests.PutForm(fmt.Sprintf("%s/mod/v2/session/%s?ttl=%d", s.URL(), session, ttl), nil)
+	ret := tests.ReadBody(resp)
+	return string(ret), resp.StatusCode, err
+}
+
+func testCloseSession(s *server.Server, session string) (string, int, error) {
+	resp, err := tests.DeleteForm(fmt.Sprintf("%s/mod/v2/session/%s", s.URL(), session), nil)
+	ret := tests.ReadBody(resp)
+	return string(ret), resp.StatusCode, err
+}
@@ -0,0 +1,202 @@
+package lock
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/server"
+	"github.com/coreos/etcd/tests"
+	"github.com/coreos/etcd/third_party/github.com/stretchr/testify/assert"
+)
+
+// Ensure that a semaphore is held by up to its limit of holders.
+func TestModSemaphoreLimit(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		_, status, err := testAcquireSemaphore(s, "foo", "a", 2, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+
+		_, status, err = testAcquireSemaphore(s, "foo", "b", 2, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+
+		// The semaphore is full.
+		_, status, err = testAcquireSemaphore(s, "foo", "c", 2, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 500)
+
+		body, status, err := testGetVariantValue(s, "semaphore", "foo")
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+		assert.Equal(t, body, "a\nb")
+
+		// Wait for a holder to leave.
+		c := make(chan bool)
+		go func() {
+			_, status, err := testAcquireSemaphore(s, "foo", "c", 2, 10)
+			assert.NoError(t, err)
+			assert.Equal(t, status, 200)
+			c <- true
+		}()
+		time.Sleep(1 * time.Second)
+
+		_, status, err = testReleaseVariant(s, "semaphore", "foo", "a")
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+
+		select {
+		case <-c:
+		case <-time.After(5 * time.Second):
+			t.Fatal("semaphore not acquired after release")
+		}
+
+		body, status, err = testGetVariantValue(s, "semaphore", "foo")
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+		assert.Equal(t, body, "b\nc")
+	})
+}
+
+// Ensure that waiters in line for a semaphore are granted it in turn.
+func TestModSemaphoreWaitersInLine(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		_, status, err := testAcquireSemaphore(s, "foo", "a", 1, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+
+		acquired := make(chan string, 2)
+		for _, name := range []string{"b", "c"} {
+			go func(name string) {
+				_, status, err := testAcquireSemaphore(s, "foo", name, 1, 10)
+				assert.NoError(t, err)
+				assert.Equal(t, status, 200)
+				acquired <- name
+			}(name)
+			time.Sleep(500 * time.Millisecond)
+		}
+
+		for _, name := range []string{"a", "b"} {
+			_, status, err := testReleaseVariant(s, "semaphore", "foo", name)
+			assert.NoError(t, err)
+			assert.Equal(t, status, 200)
+
+			select {
+			case <-acquired:
+			case <-time.After(5 * time.Second):
+				t.Fatal("semaphore not acquired after release")
+			}
+		}
+	})
+}
+
+// Ensure that the limit of a semaphore is fixed by its first request.
+func TestModSemaphoreLimitMismatch(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		_, status, err := testAcquireSemaphore(s, "foo", "a", 2, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+
+		_, status, err = testAcquireSemaphore(s, "foo", "b", 3, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 409)
+
+		body, _, err := testGetVariantValue(s, "semaphore", "foo")
+		assert.NoError(t, err)
+		assert.Equal(t, body, "a")
+	})
+}
+
+// Ensure that a semaphore requires a valid limit.
+func TestModSemaphoreInvalidLimit(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		_, status, err := testAcquireSemaphore(s, "foo", "a", 0, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 400)
+	})
+}
+
+// Ensure that a read/write lock is shared by readers and that a waiting
+// writer keeps later readers out.
+func TestModRWLockWriterNotStarved(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		_, status, err := testAcquireRWLock(s, "foo", "r1", "read", 0)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+
+		_, status, err = testAcquireRWLock(s, "foo", "r2", "read", 0)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+
+		// A writer has to wait for the readers.
+		_, status, err = testAcquireRWLock(s, "foo", "w", "write", 0)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 500)
+
+		c := make(chan bool)
+		go func() {
+			_, status, err := testAcquireRWLock(s, "foo", "w", "write", 10)
+			assert.NoError(t, err)
+			assert.Equal(t, status, 200)
+			c <- true
+		}()
+		time.Sleep(1 * time.Second)
+
+		// A reader after the waiting writer has to wait too.
+		_, status, err = testAcquireRWLock(s, "foo", "r3", "read", 0)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 500)
+
+		body, status, err := testGetVariantValue(s, "rwlock", "foo")
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+		assert.Equal(t, body, "r1\nr2")
+
+		testReleaseVariant(s, "rwlock", "foo", "r1")
+		testReleaseVariant(s, "rwlock", "foo", "r2")
+
+		select {
+		case <-c:
+		case <-time.After(5 * time.Second):
+			t.Fatal("write lock not acquired after readers released")
+		}
+
+		body, status, err = testGetVariantValue(s, "rwlock", "foo")
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+		assert.Equal(t, body, "w")
+	})
+}
+
+// Ensure that a read/write lock rejects an unknown mode.
+func TestModRWLockInvalidMode(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		_, status, err := testAcquireRWLock(s, "foo", "a", "append", 0)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 400)
+	})
+}
+
+func testAcquireSemaphore(s *server.Server, key string, value string, limit int, timeout int) (string, int, error) {
+	resp, err := tests.PostForm(fmt.Sprintf("%s/mod/v2/semaphore/%s?value=%s&limit=%d&ttl=10&timeout=%d", s.URL(), key, value, limit, timeout), nil)
+	ret := tests.ReadBody(resp)
+	return string(ret), resp.StatusCode, err
+}
+
+func testAcquireRWLock(s *server.Server, key string, value string, mode string, timeout int) (string, int, error) {
+	resp, err := tests.PostForm(fmt.Sprintf("%s/mod/v2/rwlock/%s?value=%s&mode=%s&ttl=10&timeout=%d", s.URL(), key, value, mode, timeout), nil)
+	ret := tests.ReadBody(resp)
+	return string(ret), resp.StatusCode, err
+}
+
+func testGetVariantValue(s *server.Server, variant string, key string) (string, int, error) {
+	resp, err := tests.Get(fmt.Sprintf("%s/mod/v2/%s/%s", s.URL(), variant, key))
+	ret := tests.ReadBody(resp)
+	return string(ret), resp.StatusCode, err
+}
+
+func testReleaseVariant(s *server.Server, variant string, key string, value string) (string, int, error) {
+	resp, err := tests.DeleteForm(fmt.Sprintf("%s/mod/v2/%s/%s?value=%s", s.URL(), variant, key, value), nil)
+	ret := tests.ReadBody(resp)
+	return string(ret), resp.StatusCode, err
+}
@@ -0,0 +1,147 @@
+package lock
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/server"
+	"github.com/coreos/etcd/tests"
+	"github.com/coreos/etcd/third_party/github.com/stretchr/testify/assert"
+)
+
+type testQueueItem struct {
+	ID         int    `json:"id"`
+	Value      string `json:"value"`
+	Deliveries int    `json:"deliveries"`
+	Claim      uint64 `json:"claim"`
+}
+
+// Ensure that items are claimed in order and removed when acknowledged.
+func TestModQueueClaimAndAck(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		testPush(s, "jobs", "a", 0)
+		testPush(s, "jobs", "b", 0)
+
+		a, status := testClaim(t, s, "jobs", 10, 0)
+		assert.Equal(t, status, 200)
+		assert.Equal(t, a.Value, "a")
+		assert.Equal(t, a.Deliveries, 1)
+
+		b, status := testClaim(t, s, "jobs", 10, 0)
+		assert.Equal(t, status, 200)
+		assert.Equal(t, b.Value, "b")
+
+		// Both items are invisible.
+		_, status = testClaim(t, s, "jobs", 10, 0)
+		assert.Equal(t, status, 500)
+
+		_, status, err := testAck(s, "jobs", a.ID, a.Claim)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+
+		// An item can only be acknowledged once.
+		_, status, err = testAck(s, "jobs", a.ID, a.Claim)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 404)
+	})
+}
+
+// Ensure that an item that isn't acknowledged in time is delivered again,
+// and that the first claim can't acknowledge it anymore.
+func TestModQueueVisibilityTimeout(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		testPush(s, "jobs", "a", 0)
+
+		first, _ := testClaim(t, s, "jobs", 1, 0)
+		assert.Equal(t, first.Deliveries, 1)
+
+		// The claim waits for the item to become visible again.
+		second, status := testClaim(t, s, "jobs", 10, 5)
+		assert.Equal(t, status, 200)
+		assert.Equal(t, second.ID, first.ID)
+		assert.Equal(t, second.Deliveries, 2)
+
+		_, status, err := testAck(s, "jobs", first.ID, first.Claim)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 412)
+
+		_, status, err = testAck(s, "jobs", second.ID, second.Claim)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+	})
+}
+
+// Ensure that a waiting claim gets an item as soon as it is pushed.
+func TestModQueueClaimWait(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		c := make(chan testQueueItem)
+		go func() {
+			item, _ := testClaim(t, s, "jobs", 10, 5)
+			c <- item
+		}()
+		time.Sleep(500 * time.Millisecond)
+
+		testPush(s, "jobs", "a", 0)
+		select {
+		case item := <-c:
+			assert.Equal(t, item.Value, "a")
+		case <-time.After(2 * time.Second):
+			t.Fatal("waiting claim not served")
+		}
+	})
+}
+
+// Ensure that an item moves to the dead-letter directory after its last delivery.
+func TestModQueueDeadLetter(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		testPush(s, "jobs", "a", 1)
+
+		item, status := testClaim(t, s, "jobs", 1, 0)
+		assert.Equal(t, status, 200)
+		assert.Equal(t, item.Deliveries, 1)
+
+		time.Sleep(1500 * time.Millisecond)
+		_, status = testClaim(t, s, "jobs", 1, 0)
+		assert.Equal(t, status, 500)
+
+		assert.Equal(t, len(testListQueue(t, s, "jobs", false)), 0)
+		dead := testListQueue(t, s, "jobs", true)
+		if assert.Equal(t, len(dead), 1) {
+			assert.Equal(t, dead[0].Value, "a")
+			assert.Equal(t, dead[0].Deliveries, 1)
+		}
+	})
+}
+
+func testPush(s *server.Server, key string, value string, maxDeliveries int) (string, int, error) {
+	resp, err := tests.PostForm(fmt.Sprintf("%s/mod/v2/queue/%s?value=%s&max_deliveries=%d", s.URL(), key, value, maxDeliveries), nil)
+	ret := tests.ReadBody(resp)
+	return string(ret), resp.StatusCode, err
+}
+
+func testClaim(t *testing.T, s *server.Server, key string, visibility int, timeout int) (testQueueItem, int) {
+	var item testQueueItem
+	resp, err := tests.PutForm(fmt.Sprintf("%s/mod/v2/queue/%s?visibility=%d&timeout=%d", s.URL(), key, visibility, timeout), nil)
+	assert.NoError(t, err)
+	ret := tests.ReadBody(resp)
+	if resp.StatusCode == 200 {
+		assert.NoError(t, json.Unmarshal(ret, &item))
+	}
+	return item, resp.StatusCode
+}
+
+func testAck(s *server.Server, key string, id int, claim uint64) (string, int, error) {
+	resp, err := tests.DeleteForm(fmt.Sprintf("%s/mod/v2/queue/%s?id=%d&claim=%d", s.URL(), key, id, claim), nil)
+	ret := tests.ReadBody(resp)
+	return string(ret), resp.StatusCode, err
+}
+
+func testListQueue(t *testing.T, s *server.Server, key string, dead bool) []testQueueItem {
+	var items []testQueueItem
+	resp, err := tests.Get(fmt.Sprintf("%s/mod/v2/queue/%s?dead=%v", s.URL(), key, dead))
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(tests.ReadBody(resp), &items))
+	return items
+}
@@ -4,9 +4,9 @@ package mod
 import (
 	"net/http"
 	"path"
+	"strings"
 
 	"github.com/coreos/etcd/mod/dashboard"
-	leader2 "github.com/coreos/etcd/mod/leader/v2"
 	lock2 "github.com/coreos/etcd/mod/lock/v2"
 	"github.com/coreos/etcd/third_party/github.com/gorilla/mux"
 )
@@ -18,14 +18,29 @@ func addSlash(w http.ResponseWriter, req *http.Request) {
 	return
 }
 
-func HttpHandler(addr string) http.Handler {
+// Key returns the key of the store that a request to a module at the given
+// path, relative to /mod, reads or writes. The dashboard has no key.
+func Key(p string) (string, bool) {
+	if !strings.HasPrefix(p, "/v2/") {
+		return "", false
+	}
+	return lock2.Key(strings.TrimPrefix(p, "/v2"))
+}
+
+func HttpHandler(s lock2.Server) http.Handler {
 	r := mux.NewRouter()
 	r.HandleFunc("/dashboard", addSlash)
 
 	r.PathPrefix("/dashboard/static/").Handler(http.StripPrefix("/dashboard/static/", dashboard.HttpHandler()))
 	r.HandleFunc("/dashboard{path:.*}", dashboard.IndexPage)
 
-	r.PathPrefix("/v2/lock").Handler(http.StripPrefix("/v2/lock", lock2.NewHandler(addr)))
-	r.PathPrefix("/v2/leader").Handler(http.StripPrefix("/v2/leader", leader2.NewHandler(addr)))
+	r.PathPrefix("/v2/lock").Handler(http.StripPrefix("/v2/lock", lock2.NewHandler(s)))
+	r.PathPrefix("/v2/semaphore").Handler(http.StripPrefix("/v2/semaphore", lock2.NewSemaphoreHandler(s)))
+	r.PathPrefix("/v2/rwlock").Handler(http.StripPrefix("/v2/rwlock", lock2.NewRWLockHandler(s)))
+	r.PathPrefix("/v2/session").Handler(http.StripPrefix("/v2", lock2.NewSessionHandler(s)))
+	r.PathPrefix("/v2/barrier").Handler(http.StripPrefix("/v2/barrier", lock2.NewBarrierHandler(s)))
+	r.PathPrefix("/v2/doublebarrier").Handler(http.StripPrefix("/v2/doublebarrier", lock2.NewDoubleBarrierHandler(s)))
+	r.PathPrefix("/v2/queue").Handler(http.StripPrefix("/v2/queue", lock2.NewQueueHandler(s)))
+	r.PathPrefix("/v2/leader").Handler(http.StripPrefix("/v2/leader", lock2.NewElectionHandler(s)))
 	return r
 }
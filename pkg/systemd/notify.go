@@ -0,0 +1,29 @@
+package systemd
+
+import (
+	"net"
+	"os"
+)
+
+// Notify sends a state string such as "READY=1" or "STOPPING=1" to the
+// service manager through NOTIFY_SOCKET.
+// It does nothing if the process was not started with a notify socket.
+func Notify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil
+	}
+	// A leading '@' stands for the abstract socket namespace.
+	if name[0] == '@' {
+		name = "\x00" + name[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
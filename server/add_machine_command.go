@@ -0,0 +1,52 @@
+package server
+
+import (
+	"encoding/json"
+
+	etcdErr "github.com/coreos/etcd/error"
+	"github.com/coreos/etcd/log"
+	"github.com/coreos/etcd/store"
+	"github.com/coreos/etcd/third_party/github.com/goraft/raft"
+)
+
+func init() {
+	raft.RegisterCommand(&AddMachineCommand{})
+}
+
+// AddMachineCommand pre-registers a machine in the cluster before it starts.
+// The machine is added to the registry and to raft as a peer, and it takes
+// its place by joining later with the same name and peer URL.
+// The command returns the JSON-encoded machine message.
+type AddMachineCommand struct {
+	Name      string `json:"name"`
+	PeerURL   string `json:"peerURL"`
+	ClientURL string `json:"clientURL"`
+}
+
+// CommandName returns the name of the command in the log.
+func (c *AddMachineCommand) CommandName() string {
+	return "etcd:addMachine"
+}
+
+// Apply adds the machine to the registry and to raft.
+func (c *AddMachineCommand) Apply(context raft.Context) (interface{}, error) {
+	ps, _ := context.Server().Context().(*PeerServer)
+
+	if ps.registry.Exists(c.Name) {
+		log.Debugf("Reject add request for existing machine %s", c.Name)
+		return nil, etcdErr.NewError(etcdErr.EcodeMachineExist, c.Name, context.CommitIndex())
+	}
+
+	join := &JoinCommand{
+		MinVersion: store.MinVersion(),
+		MaxVersion: store.MaxVersion(),
+		Name:       c.Name,
+		RaftURL:    c.PeerURL,
+		EtcdURL:    c.ClientURL,
+	}
+	if _, err := applyJoin(join, context); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(ps.getMachineMessage(c.Name, ps.raftServer.Leader()))
+}
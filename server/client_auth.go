@@ -0,0 +1,142 @@
+package server
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/coreos/etcd/third_party/github.com/BurntSushi/toml"
+)
+
+// ClientAuth maps the identities of verified client certificates to roles,
+// and each role to the key prefixes it may read and write.
+//
+// It is loaded from a TOML file like:
+//
+//	[roles.billing]
+//	identities = ["billing.svc.example.com"]
+//	read = ["/shared"]
+//	write = ["/billing"]
+//
+// Write access to a prefix implies read access to it.
+type ClientAuth struct {
+	Roles map[string]ClientRole `toml:"roles"`
+
+	identities map[string]string
+}
+
+// ClientRole holds the identities mapped to a role and the key prefixes
+// the role may access.
+type ClientRole struct {
+	Identities []string `toml:"identities"`
+	Read       []string `toml:"read"`
+	Write      []string `toml:"write"`
+}
+
+// LoadClientAuth loads the client authorization rules from a TOML file.
+func LoadClientAuth(path string) (*ClientAuth, error) {
+	a := &ClientAuth{}
+	if _, err := toml.DecodeFile(path, a); err != nil {
+		return nil, err
+	}
+
+	a.identities = make(map[string]string)
+	for name, role := range a.Roles {
+		for _, identity := range role.Identities {
+			if other, ok := a.identities[identity]; ok {
+				return nil, fmt.Errorf("identity %q is mapped to both roles %q and %q", identity, other, name)
+			}
+			a.identities[identity] = name
+		}
+	}
+	return a, nil
+}
+
+// Role returns the name and rules of the role mapped to the first
+// known identity in the list.
+func (a *ClientAuth) Role(identities []string) (string, *ClientRole) {
+	for _, identity := range identities {
+		if name, ok := a.identities[identity]; ok {
+			role := a.Roles[name]
+			return name, &role
+		}
+	}
+	return "", nil
+}
+
+// CanRead checks whether the role may read the given key.
+func (r *ClientRole) CanRead(key string) bool {
+	return matchKeyPrefix(r.Read, key) || matchKeyPrefix(r.Write, key)
+}
+
+// CanWrite checks whether the role may modify the given key.
+func (r *ClientRole) CanWrite(key string) bool {
+	return matchKeyPrefix(r.Write, key)
+}
+
+// matchKeyPrefix checks whether the key is equal to or under one of the prefixes.
+func matchKeyPrefix(prefixes []string, key string) bool {
+	key = path.Clean("/" + key)
+	for _, prefix := range prefixes {
+		prefix = path.Clean("/" + prefix)
+		if prefix == "/" || key == prefix || strings.HasPrefix(key, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIdentities returns the identities of the verified client certificate
+// of the request: its subject common name followed by its DNS, email, IP
+// and URI subject alternative names.
+// It returns nil if the request didn't present a verified certificate.
+func ClientIdentities(req *http.Request) []string {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	cert := req.TLS.VerifiedChains[0][0]
+	identities := make([]string, 0)
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+	identities = append(identities, cert.DNSNames...)
+	identities = append(identities, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		identities = append(identities, ip.String())
+	}
+	identities = append(identities, uriNames(cert)...)
+	return identities
+}
+
+var oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// uriNames returns the URI subject alternative names of a certificate,
+// which the x509 package of the supported Go releases doesn't parse.
+func uriNames(cert *x509.Certificate) []string {
+	var names []string
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+		var seq asn1.RawValue
+		if rest, err := asn1.Unmarshal(ext.Value, &seq); err != nil || len(rest) != 0 || !seq.IsCompound {
+			return nil
+		}
+		for rest := seq.Bytes; len(rest) > 0; {
+			var name asn1.RawValue
+			var err error
+			if rest, err = asn1.Unmarshal(rest, &name); err != nil {
+				return nil
+			}
+			// A uniformResourceIdentifier is the context-specific tag 6.
+			if name.Class == 2 && name.Tag == 6 {
+				names = append(names, string(name.Bytes))
+			}
+		}
+	}
+	return names
+}
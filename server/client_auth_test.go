@@ -0,0 +1,130 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/coreos/etcd/store"
+	"github.com/coreos/etcd/third_party/github.com/stretchr/testify/assert"
+)
+
+// Ensures that client auth rules can be loaded from TOML.
+func TestLoadClientAuth(t *testing.T) {
+	f, _ := ioutil.TempFile("", "client-auth")
+	defer os.Remove(f.Name())
+	f.WriteString(`
+[roles.billing]
+identities = ["billing.svc"]
+read = ["/shared"]
+write = ["/billing"]
+
+[roles.admin]
+identities = ["admin.svc"]
+write = ["/"]
+`)
+	f.Close()
+
+	a, err := LoadClientAuth(f.Name())
+	assert.NoError(t, err)
+
+	name, role := a.Role([]string{"unknown", "billing.svc"})
+	assert.Equal(t, name, "billing")
+	assert.True(t, role.CanRead("/shared/foo"))
+	assert.False(t, role.CanWrite("/shared/foo"))
+	assert.True(t, role.CanRead("/billing"))
+	assert.True(t, role.CanWrite("/billing/invoices/1"))
+	assert.False(t, role.CanWrite("/billingfoo"))
+	assert.False(t, role.CanRead("/other"))
+
+	name, role = a.Role([]string{"admin.svc"})
+	assert.Equal(t, name, "admin")
+	assert.True(t, role.CanWrite("/billing"))
+
+	name, role = a.Role([]string{"unknown"})
+	assert.Equal(t, name, "")
+	assert.Nil(t, role)
+}
+
+// Ensures that an identity mapped to two roles is rejected.
+func TestLoadClientAuthDuplicateIdentity(t *testing.T) {
+	f, _ := ioutil.TempFile("", "client-auth")
+	defer os.Remove(f.Name())
+	f.WriteString(`
+[roles.billing]
+identities = ["billing.svc"]
+
+[roles.admin]
+identities = ["billing.svc"]
+`)
+	f.Close()
+
+	_, err := LoadClientAuth(f.Name())
+	assert.Error(t, err)
+}
+
+// Ensures that the identities come from the verified client certificate.
+func TestClientIdentities(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://127.0.0.1:4001/v2/keys/foo", nil)
+	assert.Nil(t, ClientIdentities(req))
+
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "billing.svc"},
+		DNSNames:       []string{"billing.example.com"},
+		EmailAddresses: []string{"billing@example.com"},
+	}
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	assert.Nil(t, ClientIdentities(req))
+
+	req.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	assert.Equal(t, ClientIdentities(req), []string{"billing.svc", "billing.example.com", "billing@example.com"})
+
+	// URI names are read from the subject alternative name extension.
+	san, _ := asn1.Marshal([]asn1.RawValue{
+		{Class: 2, Tag: 2, Bytes: []byte("billing.example.com")},
+		{Class: 2, Tag: 6, Bytes: []byte("spiffe://example.com/billing")},
+	})
+	cert.Extensions = []pkix.Extension{{Id: oidSubjectAltName, Value: san}}
+	assert.Equal(t, ClientIdentities(req), []string{"billing.svc", "billing.example.com", "billing@example.com", "spiffe://example.com/billing"})
+}
+
+// Ensures that the mod and discovery handlers check the access to the keys
+// they write.
+func TestModAndDiscoveryAuthorization(t *testing.T) {
+	f, _ := ioutil.TempFile("", "client-auth")
+	defer os.Remove(f.Name())
+	f.WriteString(`
+[roles.billing]
+identities = ["billing.svc"]
+read = ["/"]
+write = ["/billing"]
+`)
+	f.Close()
+	a, err := LoadClientAuth(f.Name())
+	assert.NoError(t, err)
+
+	s := New("node", "http://127.0.0.1:4001", nil, nil, store.New(), nil)
+	s.SetClientAuth(a)
+	s.EnableDiscoveryService()
+	h := s.HTTPHandler()
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "billing.svc"}}
+	for _, url := range []string{
+		"/mod/v2/lock/billing?ttl=10",
+		"/mod/v2/semaphore/billing?ttl=10&limit=2",
+		"/mod/v2/queue/billing",
+		"/discovery/new",
+	} {
+		req, _ := http.NewRequest("POST", "https://127.0.0.1:4001"+url, nil)
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		assert.Equal(t, w.Code, http.StatusForbidden, url)
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -22,6 +23,15 @@ const (
 
 	// MinSyncInterval is the minimum sync interval allowed.
 	MinSyncInterval = float64((1 * time.Second) / time.Second)
+
+	// MinHeartbeatInterval is the minimum heartbeat interval allowed, in milliseconds.
+	MinHeartbeatInterval = 1
+
+	// MinElectionTimeout is the minimum election timeout allowed, in milliseconds.
+	MinElectionTimeout = 1
+
+	// MinSnapshotCount is the minimum snapshot count allowed.
+	MinSnapshotCount = 1
 )
 
 // ClusterConfig represents cluster-wide configuration settings.
@@ -37,6 +47,24 @@ type ClusterConfig struct {
 	// SyncInterval is the amount of time, in seconds, between
 	// cluster sync when it runs in standby mode.
 	SyncInterval float64 `json:"syncInterval"`
+
+	// HeartbeatInterval is the time, in milliseconds, between heartbeats
+	// sent by the leader. Zero leaves the value of each peer untouched.
+	HeartbeatInterval int `json:"heartbeatInterval,omitempty"`
+
+	// ElectionTimeout is the time, in milliseconds, a follower waits
+	// without hearing from the leader before it starts an election.
+	// Zero leaves the value of each peer untouched.
+	ElectionTimeout int `json:"electionTimeout,omitempty"`
+
+	// SnapshotCount is the number of committed transactions between
+	// snapshots. Zero leaves the value of each peer untouched.
+	SnapshotCount int `json:"snapshotCount,omitempty"`
+
+	// ClusterID identifies the cluster to its peers, which reject the
+	// requests of peers from other clusters. It is generated when the
+	// cluster starts and can't be changed.
+	ClusterID string `json:"clusterID,omitempty"`
 }
 
 // NewClusterConfig returns a cluster configuration with default settings.
@@ -47,3 +75,12 @@ func NewClusterConfig() *ClusterConfig {
 		SyncInterval: DefaultSyncInterval,
 	}
 }
+
+// validate checks that the raft timing of the configuration can work: a
+// follower must hear several heartbeats before its election timeout ends.
+func (c *ClusterConfig) validate() error {
+	if c.HeartbeatInterval != 0 && c.ElectionTimeout != 0 && c.ElectionTimeout <= c.HeartbeatInterval {
+		return fmt.Errorf("election timeout (%dms) must be greater than the heartbeat interval (%dms)", c.ElectionTimeout, c.HeartbeatInterval)
+	}
+	return nil
+}
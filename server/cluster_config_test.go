@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/store"
+	"github.com/coreos/etcd/third_party/github.com/goraft/raft"
+	"github.com/coreos/etcd/third_party/github.com/stretchr/testify/assert"
+)
+
+// Ensures that the cluster ID is kept when the cluster config is set, so
+// that it isn't read from the store for each raft message.
+func TestClusterIDCached(t *testing.T) {
+	s := store.New()
+	ps := NewPeerServer(PeerServerConfig{Name: "node1"}, nil, NewRegistry(s), s, nil, nil, nil)
+	assert.Equal(t, ps.ClusterID(), "")
+
+	assert.NoError(t, ps.SetClusterConfig(&ClusterConfig{ClusterID: "abc"}))
+	reads := storeReads(s)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, ps.ClusterID(), "abc")
+	}
+	assert.Equal(t, storeReads(s), reads)
+
+	// A config that can't be applied leaves the ID as it was.
+	assert.Error(t, ps.SetClusterConfig(&ClusterConfig{ClusterID: "abc", HeartbeatInterval: 10, ElectionTimeout: 5}))
+	assert.Equal(t, ps.ClusterID(), "abc")
+}
+
+// Ensures that a cluster config doesn't replace or clear the ID of the
+// cluster once it has one.
+func TestClusterIDKept(t *testing.T) {
+	s := store.New()
+	ps := NewPeerServer(PeerServerConfig{Name: "node1"}, nil, NewRegistry(s), s, nil, nil, nil)
+
+	assert.NoError(t, ps.SetClusterConfig(&ClusterConfig{ClusterID: "abc"}))
+	assert.NoError(t, ps.SetClusterConfig(&ClusterConfig{ClusterID: "def", ActiveSize: 5}))
+	assert.Equal(t, ps.ClusterID(), "abc")
+	assert.Equal(t, ps.ClusterConfig().ClusterID, "abc")
+	assert.Equal(t, ps.ClusterConfig().ActiveSize, 5)
+
+	assert.NoError(t, ps.SetClusterConfig(&ClusterConfig{ActiveSize: 7}))
+	assert.Equal(t, ps.ClusterID(), "abc")
+	assert.Equal(t, ps.ClusterConfig().ClusterID, "abc")
+	assert.Equal(t, ps.ClusterConfig().ActiveSize, 7)
+}
+
+// Ensures that a heartbeat interval waiting for the followers is dropped
+// once a later config sets the raft timing.
+func TestRaftTimingPendingHeartbeatDropped(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "etcd-raft-timing")
+	defer os.RemoveAll(dir)
+
+	s := store.New()
+	ps := NewPeerServer(PeerServerConfig{Name: "node1"}, nil, NewRegistry(s), s, nil, nil, nil)
+	tr := NewTransporter(NewRaftFollowersStats("node1"), NewRaftServerStats("node1"), nil, time.Second, time.Second, time.Second)
+	raftServer, err := raft.NewServer("node1", dir, tr, s, ps, "")
+	assert.NoError(t, err)
+	raftServer.SetHeartbeatInterval(50 * time.Millisecond)
+	raftServer.SetElectionTimeout(100 * time.Millisecond)
+	ps.SetRaftServer(raftServer, false)
+
+	// The longer heartbeat interval waits for the old election timeout.
+	assert.NoError(t, ps.SetClusterConfig(&ClusterConfig{HeartbeatInterval: 100, ElectionTimeout: 300}))
+	assert.Equal(t, raftServer.HeartbeatInterval(), 50*time.Millisecond)
+	assert.Equal(t, raftServer.ElectionTimeout(), 300*time.Millisecond)
+
+	assert.NoError(t, ps.SetClusterConfig(&ClusterConfig{HeartbeatInterval: 50, ElectionTimeout: 300}))
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, raftServer.HeartbeatInterval(), 50*time.Millisecond)
+
+	assert.NoError(t, ps.SetClusterConfig(&ClusterConfig{HeartbeatInterval: 100, ElectionTimeout: 300}))
+	time.Sleep(400 * time.Millisecond)
+	assert.Equal(t, raftServer.HeartbeatInterval(), 100*time.Millisecond)
+}
+
+func storeReads(s store.Store) uint64 {
+	var stats struct {
+		GetSuccess uint64 `json:"getsSuccess"`
+		GetFail    uint64 `json:"getsFail"`
+	}
+	json.Unmarshal(s.JsonStats(), &stats)
+	return stats.GetSuccess + stats.GetFail
+}
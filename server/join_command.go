@@ -20,6 +20,10 @@ type JoinCommand struct {
 	Name       string `json:"name"`
 	RaftURL    string `json:"raftURL"`
 	EtcdURL    string `json:"etcdURL"`
+
+	// ClusterID is the ID of the cluster the machine belonged to before,
+	// if any.
+	ClusterID string `json:"clusterID,omitempty"`
 }
 
 // The name of the join command in the log
@@ -48,6 +52,12 @@ func applyJoin(c *JoinCommand, context raft.Context) (uint64, error) {
 	ps, _ := context.Server().Context().(*PeerServer)
 	commitIndex := context.CommitIndex()
 
+	// Check that a machine that belonged to a cluster before joins the same one.
+	if clusterID := ps.ClusterID(); c.ClusterID != "" && clusterID != "" && c.ClusterID != clusterID {
+		log.Warnf("%v tries to join cluster %v with the data of cluster %v", c.Name, clusterID, c.ClusterID)
+		return 0, etcdErr.NewError(etcdErr.EcodeClusterMismatch, c.ClusterID, commitIndex)
+	}
+
 	// Make sure we're not getting a cached value from the registry.
 	ps.registry.Invalidate(c.Name)
 
@@ -94,8 +104,10 @@ func applyJoin(c *JoinCommand, context raft.Context) (uint64, error) {
 
 	// Add peer stats
 	if c.Name != ps.RaftServer().Name() {
+		ps.followersStats.Lock()
 		ps.followersStats.Followers[c.Name] = &raftFollowerStats{}
 		ps.followersStats.Followers[c.Name].Latency.Minimum = 1 << 63
+		ps.followersStats.Unlock()
 	}
 
 	if c.Name == context.Server().Name() {
@@ -2,7 +2,12 @@ package server
 
 import (
 	"crypto/tls"
+	"fmt"
+	"io/ioutil"
 	"net"
+	"net/url"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/coreos/etcd/log"
@@ -11,8 +16,32 @@ import (
 const (
 	DefaultReadTimeout  = float64((5 * time.Minute) / time.Second)
 	DefaultWriteTimeout = float64((5 * time.Minute) / time.Second)
+
+	// DefaultSocketMode is the file mode of unix socket listeners.
+	DefaultSocketMode os.FileMode = 0660
 )
 
+// ListenerInfo describes an additional client listener.
+type ListenerInfo struct {
+	URL        *url.URL
+	TLSInfo    TLSInfo
+	SocketMode os.FileMode
+}
+
+// Scheme returns the scheme of the listen URL.
+func (info *ListenerInfo) Scheme() string {
+	return info.URL.Scheme
+}
+
+// Addr returns the address to listen on: host:port for TCP listeners and
+// the socket path for unix listeners.
+func (info *ListenerInfo) Addr() string {
+	if info.URL.Scheme == "unix" {
+		return info.URL.Host + info.URL.Path
+	}
+	return info.URL.Host
+}
+
 // TLSServerConfig generates tls configuration based on TLSInfo
 // If any error happens, this function will call log.Fatal
 func TLSServerConfig(info *TLSInfo) *tls.Config {
@@ -28,11 +57,11 @@ func TLSServerConfig(info *TLSInfo) *tls.Config {
 }
 
 // NewListener creates a net.Listener
-// If the given scheme is "https", it will use TLS config to set listener.
+// If the given scheme is "https", it will use the TLS reloader to set listener.
 // If any error happens, this function will call log.Fatal
-func NewListener(scheme, addr string, cfg *tls.Config) net.Listener {
+func NewListener(scheme, addr string, r *TLSReloader) net.Listener {
 	if scheme == "https" {
-		l, err := newTLSListener(addr, cfg)
+		l, err := newTLSListener(addr, r)
 		if err != nil {
 			log.Fatal("Failed to create TLS listener: ", err)
 		}
@@ -46,6 +75,16 @@ func NewListener(scheme, addr string, cfg *tls.Config) net.Listener {
 	return l
 }
 
+// WrapListener returns a listener for an already listening socket, such as
+// one passed by systemd. If the given scheme is "https", it will use the
+// TLS reloader to wrap the listener.
+func WrapListener(scheme string, l net.Listener, r *TLSReloader) net.Listener {
+	if scheme == "https" {
+		return r.NewListener(l)
+	}
+	return l
+}
+
 func newListener(addr string) (net.Listener, error) {
 	if addr == "" {
 		addr = ":http"
@@ -57,7 +96,7 @@ func newListener(addr string) (net.Listener, error) {
 	return l, nil
 }
 
-func newTLSListener(addr string, cfg *tls.Config) (net.Listener, error) {
+func newTLSListener(addr string, r *TLSReloader) (net.Listener, error) {
 	if addr == "" {
 		addr = ":https"
 	}
@@ -67,5 +106,74 @@ func newTLSListener(addr string, cfg *tls.Config) (net.Listener, error) {
 		return nil, err
 	}
 
-	return tls.NewListener(conn, cfg), nil
+	return r.NewListener(conn), nil
+}
+
+// NewURLListener creates a net.Listener for the given ListenerInfo.
+// The "https" scheme uses the TLS reloader, "http" listens on plain TCP and
+// "unix" listens on a unix domain socket whose file mode is set to the
+// configured socket mode, so that access is controlled by file permissions.
+func NewURLListener(info *ListenerInfo, r *TLSReloader) (net.Listener, error) {
+	switch info.Scheme() {
+	case "http":
+		return newListener(info.Addr())
+	case "https":
+		return newTLSListener(info.Addr(), r)
+	case "unix":
+		return newUnixListener(info.Addr(), info.SocketMode)
+	}
+	return nil, fmt.Errorf("unsupported listen scheme %q", info.Scheme())
+}
+
+func newUnixListener(path string, mode os.FileMode) (net.Listener, error) {
+	// Remove the socket left behind by a previous run, but refuse to
+	// remove anything that is not a socket.
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// Create the socket in a private directory and move it in place once
+	// its mode is set, so that it is never reachable with other permissions.
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".etcd")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "s")
+	l, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode == 0 {
+		mode = DefaultSocketMode
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return &unixListener{Listener: l, path: path}, nil
+}
+
+// unixListener removes its socket file on close, since the socket was
+// moved away from the path it was created at.
+type unixListener struct {
+	net.Listener
+	path string
+}
+
+func (l *unixListener) Close() error {
+	err := l.Listener.Close()
+	os.Remove(l.path)
+	return err
 }
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreos/etcd/third_party/github.com/stretchr/testify/assert"
+)
+
+// Ensures that a unix listener creates its socket with the configured mode
+// and serves requests on it.
+func TestNewURLListenerUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcd-listener")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "etcd.sock")
+	u, _ := url.Parse("unix://" + path)
+	l, err := NewURLListener(&ListenerInfo{URL: u, SocketMode: 0600}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(path)
+	if assert.NoError(t, err) {
+		assert.True(t, fi.Mode()&os.ModeSocket != 0)
+		assert.Equal(t, fi.Mode().Perm(), os.FileMode(0600))
+	}
+
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	client := &http.Client{Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return net.Dial("unix", path)
+		},
+	}}
+	resp, err := client.Get("http://etcd/")
+	if assert.NoError(t, err) {
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, string(b), "ok")
+	}
+
+	// Only the socket is left in the directory, and closing removes it.
+	files, _ := ioutil.ReadDir(dir)
+	assert.Equal(t, len(files), 1)
+	l.Close()
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
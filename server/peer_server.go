@@ -1,14 +1,19 @@
 package server
 
 import (
+	crand "crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"math/rand"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/coreos/etcd/third_party/github.com/goraft/raft"
@@ -18,7 +23,6 @@ import (
 	etcdErr "github.com/coreos/etcd/error"
 	"github.com/coreos/etcd/log"
 	"github.com/coreos/etcd/metrics"
-	"github.com/coreos/etcd/pkg/btrfs"
 	"github.com/coreos/etcd/store"
 )
 
@@ -42,6 +46,18 @@ const (
 
 	// The location of cluster config in key space.
 	ClusterConfigKey = "/_etcd/config"
+
+	// ClusterIDHeader is the header that carries the cluster ID of the
+	// sender on peer requests.
+	ClusterIDHeader = "X-Etcd-Cluster-ID"
+
+	// ClusterMismatchLogInterval is the minimum time between log
+	// notifications about rejected requests from another cluster.
+	ClusterMismatchLogInterval = 5 * time.Second
+
+	// DefaultDiscoveryTimeout is how long in seconds discovery waits for
+	// the expected members of a new cluster by default.
+	DefaultDiscoveryTimeout = float64((5 * time.Minute) / time.Second)
 )
 
 type PeerServerConfig struct {
@@ -51,6 +67,22 @@ type PeerServerConfig struct {
 	SnapshotCount int
 	RetryTimes    int
 	RetryInterval float64
+
+	// SnapshotLogSize is the size in bytes of the entries of the raft log
+	// since the last snapshot above which a snapshot is taken. Zero
+	// disables the trigger.
+	SnapshotLogSize int64
+
+	// SnapshotInterval is the time after which a snapshot is taken if
+	// anything was committed since the last one. Zero disables the trigger.
+	SnapshotInterval time.Duration
+
+	// SnapshotRetention is the number of snapshot files kept.
+	SnapshotRetention int
+
+	// DiscoveryTimeout is how long in seconds discovery waits for the
+	// expected members of a new cluster. Zero means no timeout.
+	DiscoveryTimeout float64
 }
 
 type PeerServer struct {
@@ -76,6 +108,27 @@ type PeerServer struct {
 
 	logBackoffs map[string]*logBackoff
 
+	// raftTimingTimer is the pending change of the heartbeat interval,
+	// stopped once a later raft timing is applied.
+	raftTimingTimer *time.Timer
+	raftTimingMutex sync.Mutex
+
+	// clusterID is the ID of the cluster, kept from the cluster config
+	// whenever it is set or recovered, as every raft message carries it.
+	clusterID      string
+	clusterIDMutex sync.RWMutex
+
+	// clusterMismatchLogged is when a request from another cluster was
+	// last logged.
+	clusterMismatchLogged time.Time
+	clusterMismatchMutex  sync.Mutex
+
+	// snapshotReceiver keeps the chunks of the snapshot sent by the leader.
+	snapshotReceiver snapshotReceiver
+
+	peerTLSReloader   *TLSReloader
+	clientTLSReloader *TLSReloader
+
 	metrics *metrics.Bucket
 	sync.Mutex
 }
@@ -86,17 +139,20 @@ type logBackoff struct {
 	count   int
 }
 
-// TODO: find a good policy to do snapshot
 type snapshotConf struct {
 	// Etcd will check if snapshot is need every checkingInterval
 	checkingInterval time.Duration
 
-	// The index when the last snapshot happened
+	// The index and time when the last snapshot happened
 	lastIndex uint64
+	lastTime  time.Time
 
 	// If the incremental number of index since the last snapshot
 	// exceeds the snapshot Threshold, etcd will do a snapshot
 	snapshotThr uint64
+
+	// mutex serializes the snapshots and guards lastIndex and lastTime.
+	mutex sync.Mutex
 }
 
 func NewPeerServer(psConfig PeerServerConfig, client *Client, registry *Registry, store store.Store, mb *metrics.Bucket, followersStats *raftFollowersStats, serverStats *raftServerStats) *PeerServer {
@@ -122,6 +178,7 @@ func (s *PeerServer) SetRaftServer(raftServer raft.Server, snapshot bool) {
 		checkingInterval: time.Second * 3,
 		// this is not accurate, we will update raft to provide an api
 		lastIndex:   raftServer.CommitIndex(),
+		lastTime:    time.Now(),
 		snapshotThr: uint64(s.Config.SnapshotCount),
 	}
 
@@ -151,14 +208,12 @@ func (s *PeerServer) SetRaftServer(raftServer raft.Server, snapshot bool) {
 		}
 	}
 
-	s.raftServer.Init()
-
-	// Set NOCOW for data directory in btrfs
-	if btrfs.IsBtrfs(s.raftServer.LogPath()) {
-		if err := btrfs.SetNOCOWFile(s.raftServer.LogPath()); err != nil {
-			log.Warnf("Failed setting NOCOW: %v", err)
-		}
+	// The raft server can't run if its state can't be read, as when its
+	// log is damaged before the tail.
+	if err := s.raftServer.Init(); err != nil {
+		log.Fatal(err)
 	}
+	s.loadClusterID()
 }
 
 func (s *PeerServer) SetRegistry(registry *Registry) {
@@ -235,6 +290,12 @@ func (s *PeerServer) FindCluster(discoverURL string, peers []string) (toStart bo
 	// Attempt cluster discovery
 	if discoverURL != "" {
 		discoverPeers, discoverErr := s.handleDiscovery(discoverURL)
+		// The cluster already has all its members, so follow it
+		if discoverErr == discovery.ErrClusterFull {
+			log.Infof("%s works as standby for the full cluster %v", name, discoverPeers)
+			possiblePeers = discoverPeers
+			return
+		}
 		// It is not registered in discover url
 		if discoverErr != nil {
 			log.Warnf("%s failed to connect discovery service[%v]: %v", name, discoverURL, discoverErr)
@@ -288,10 +349,17 @@ func (s *PeerServer) Start(snapshot bool, clusterConfig *ClusterConfig) error {
 		s.isNewCluster = false
 	}
 
+	// The cluster configuration may have been recovered from a snapshot
+	// without replaying the command that set it.
+	c := s.ClusterConfig()
+	s.setClusterID(c.ClusterID)
+	s.applyRaftConfig(c)
+
 	s.startRoutine(s.monitorSync)
 	s.startRoutine(s.monitorTimeoutThreshold)
 	s.startRoutine(s.monitorActiveSize)
 	s.startRoutine(s.monitorPeerActivity)
+	s.startRoutine(s.monitorClusterID)
 
 	// open the snapshot
 	if snapshot {
@@ -311,6 +379,7 @@ func (s *PeerServer) Stop() {
 	s.started = false
 
 	close(s.closeChan)
+	s.stopRaftTimingTimer()
 	// TODO(yichengq): it should also call async stop for raft server,
 	// but this functionality has not been implemented.
 	s.raftServer.Stop()
@@ -332,6 +401,7 @@ func (s *PeerServer) asyncRemove() {
 	s.started = false
 
 	close(s.closeChan)
+	s.stopRaftTimingTimer()
 	// TODO(yichengq): it should also call async stop for raft server,
 	// but this functionality has not been implemented.
 	go func() {
@@ -361,17 +431,54 @@ func (s *PeerServer) HTTPHandler() http.Handler {
 	router.HandleFunc("/vote", s.VoteHttpHandler)
 	router.HandleFunc("/log", s.GetLogHttpHandler)
 	router.HandleFunc("/log/append", s.AppendEntriesHttpHandler)
+	router.HandleFunc("/log/stream", s.AppendEntriesStreamHttpHandler)
 	router.HandleFunc("/snapshot", s.SnapshotHttpHandler)
 	router.HandleFunc("/snapshotRecovery", s.SnapshotRecoveryHttpHandler)
+	router.HandleFunc("/snapshotRecovery/{id}", s.GetSnapshotChunksHttpHandler).Methods("GET", "HEAD")
+	router.HandleFunc("/snapshotRecovery/{id}", s.SnapshotChunkHttpHandler).Methods("PUT")
+	router.HandleFunc("/snapshotRecovery/{id}", s.SnapshotChunksRecoveryHttpHandler).Methods("POST")
 	router.HandleFunc("/etcdURL", s.EtcdURLHttpHandler)
 
 	router.HandleFunc("/v2/admin/config", s.getClusterConfigHttpHandler).Methods("GET")
 	router.HandleFunc("/v2/admin/config", s.setClusterConfigHttpHandler).Methods("PUT")
+	router.HandleFunc("/v2/admin/snapshot", s.snapshotHttpHandler).Methods("POST")
 	router.HandleFunc("/v2/admin/machines", s.getMachinesHttpHandler).Methods("GET")
 	router.HandleFunc("/v2/admin/machines/{name}", s.getMachineHttpHandler).Methods("GET")
-	router.HandleFunc("/v2/admin/machines/{name}", s.RemoveHttpHandler).Methods("DELETE")
+	router.HandleFunc("/v2/admin/machines/{name}", s.addMachineHttpHandler).Methods("POST")
+	router.HandleFunc("/v2/admin/machines/{name}", s.updateMachineHttpHandler).Methods("PUT")
+	router.HandleFunc("/v2/admin/machines/{name}", s.removeMachineHttpHandler).Methods("DELETE")
 
-	return router
+	return s.checkClusterID(router)
+}
+
+// checkClusterID wraps a handler to reject requests from the peers of
+// another cluster. Requests without a cluster ID, or received before this
+// machine knows its cluster ID, are let through.
+func (s *PeerServer) checkClusterID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(ClusterIDHeader)
+		if clusterID := s.ClusterID(); id != "" && clusterID != "" && id != clusterID {
+			s.logClusterMismatch(req, id, clusterID)
+			cause := fmt.Sprintf("request from cluster %s to cluster %s", id, clusterID)
+			w.Header().Set("Content-Type", "application/json")
+			etcdErr.NewError(etcdErr.EcodeClusterMismatch, cause, s.store.Index()).Write(w)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// logClusterMismatch logs about a request from another cluster only if we
+// haven't warned within a reasonable interval, since a misconfigured peer
+// keeps sending them with every heartbeat.
+func (s *PeerServer) logClusterMismatch(req *http.Request, id string, clusterID string) {
+	s.clusterMismatchMutex.Lock()
+	defer s.clusterMismatchMutex.Unlock()
+	if time.Since(s.clusterMismatchLogged) < ClusterMismatchLogInterval {
+		return
+	}
+	s.clusterMismatchLogged = time.Now()
+	log.Warnf("%s: rejected %s %s from %s: it belongs to cluster %s instead of %s; check the -peers and -discovery settings of both clusters", s.Config.Name, req.Method, req.URL.Path, req.RemoteAddr, id, clusterID)
 }
 
 func (s *PeerServer) SetJoinIndex(joinIndex uint64) {
@@ -396,10 +503,39 @@ func (s *PeerServer) ClusterConfig() *ClusterConfig {
 	return &c
 }
 
+// ClusterID retrieves the ID of the cluster, or an empty string if the
+// cluster was started by a version of etcd without cluster IDs and its
+// leader hasn't assigned one yet.
+func (s *PeerServer) ClusterID() string {
+	s.clusterIDMutex.RLock()
+	defer s.clusterIDMutex.RUnlock()
+	return s.clusterID
+}
+
+func (s *PeerServer) setClusterID(id string) {
+	s.clusterIDMutex.Lock()
+	defer s.clusterIDMutex.Unlock()
+	s.clusterID = id
+}
+
+// loadClusterID reads the ID of the cluster from the cluster config, after
+// the store was recovered from a snapshot.
+func (s *PeerServer) loadClusterID() {
+	s.setClusterID(s.ClusterConfig().ClusterID)
+}
+
 // SetClusterConfig updates the current cluster configuration.
 // Adjusting the active size will cause cluster to add or remove machines
-// to match the new size.
-func (s *PeerServer) SetClusterConfig(c *ClusterConfig) {
+// to match the new size. A configuration whose raft timing can't work is
+// rejected. Once the cluster has an ID, the configuration keeps it.
+func (s *PeerServer) SetClusterConfig(c *ClusterConfig) error {
+	if clusterID := s.ClusterConfig().ClusterID; clusterID != "" && c.ClusterID != clusterID {
+		if c.ClusterID != "" {
+			log.Warnf("%s: ignored cluster ID %s, the cluster keeps ID %s", s.Config.Name, c.ClusterID, clusterID)
+		}
+		c.ClusterID = clusterID
+	}
+
 	// Set minimums.
 	if c.ActiveSize < MinActiveSize {
 		c.ActiveSize = MinActiveSize
@@ -410,10 +546,104 @@ func (s *PeerServer) SetClusterConfig(c *ClusterConfig) {
 	if c.SyncInterval < MinSyncInterval {
 		c.SyncInterval = MinSyncInterval
 	}
+	if c.HeartbeatInterval != 0 && c.HeartbeatInterval < MinHeartbeatInterval {
+		c.HeartbeatInterval = MinHeartbeatInterval
+	}
+	if c.ElectionTimeout != 0 && c.ElectionTimeout < MinElectionTimeout {
+		c.ElectionTimeout = MinElectionTimeout
+	}
+	if c.SnapshotCount != 0 && c.SnapshotCount < MinSnapshotCount {
+		c.SnapshotCount = MinSnapshotCount
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
 
 	log.Debugf("set cluster config as %v", c)
 	b, _ := json.Marshal(c)
 	s.store.Set(ClusterConfigKey, false, string(b), store.Permanent)
+
+	s.setClusterID(c.ClusterID)
+	s.applyRaftConfig(c)
+	return nil
+}
+
+// applyRaftConfig applies the raft timing and snapshot settings of the
+// cluster configuration to the local raft server and transporter.
+// Settings left as zero keep the values given on the command line.
+func (s *PeerServer) applyRaftConfig(c *ClusterConfig) {
+	if s.raftServer == nil {
+		return
+	}
+
+	heartbeatInterval := s.raftServer.HeartbeatInterval()
+	electionTimeout := s.raftServer.ElectionTimeout()
+	if c.HeartbeatInterval != 0 {
+		heartbeatInterval = time.Duration(c.HeartbeatInterval) * time.Millisecond
+	}
+	if c.ElectionTimeout != 0 {
+		electionTimeout = time.Duration(c.ElectionTimeout) * time.Millisecond
+	}
+	if electionTimeout <= heartbeatInterval {
+		// Only one of them is set by the cluster configuration, and it
+		// doesn't fit the other one given on the command line.
+		log.Warnf("%s: ignoring raft timing of heartbeat interval %v, election timeout %v", s.Config.Name, heartbeatInterval, electionTimeout)
+		heartbeatInterval = s.raftServer.HeartbeatInterval()
+		electionTimeout = s.raftServer.ElectionTimeout()
+	}
+
+	// A heartbeat interval still waiting from an earlier config would
+	// override this one.
+	s.stopRaftTimingTimer()
+
+	if heartbeatInterval != s.raftServer.HeartbeatInterval() || electionTimeout != s.raftServer.ElectionTimeout() {
+		log.Infof("%s: raft timing set to heartbeat interval %v, election timeout %v", s.Config.Name, heartbeatInterval, electionTimeout)
+		oldElectionTimeout := s.raftServer.ElectionTimeout()
+		s.raftServer.SetElectionTimeout(electionTimeout)
+
+		if heartbeatInterval > s.raftServer.HeartbeatInterval() {
+			// The followers apply the new election timeout when they
+			// hear that the config was committed, on the next heartbeats.
+			// Heartbeating less often before that would make them start
+			// an election, so the longer interval waits for them.
+			s.raftTimingMutex.Lock()
+			var timer *time.Timer
+			timer = time.AfterFunc(oldElectionTimeout, func() {
+				s.raftTimingMutex.Lock()
+				defer s.raftTimingMutex.Unlock()
+				// The timer may have fired while being stopped.
+				if s.raftTimingTimer == timer {
+					s.raftTimingTimer = nil
+					s.raftServer.SetHeartbeatInterval(heartbeatInterval)
+				}
+			})
+			s.raftTimingTimer = timer
+			s.raftTimingMutex.Unlock()
+		} else {
+			s.raftServer.SetHeartbeatInterval(heartbeatInterval)
+		}
+
+		// Keep the transporter timeouts in line with the raft timing.
+		if t, ok := s.raftServer.Transporter().(*transporter); ok {
+			dialTimeout := (3 * heartbeatInterval) + electionTimeout
+			t.SetTimeouts(heartbeatInterval, dialTimeout, dialTimeout)
+		}
+	}
+
+	if c.SnapshotCount != 0 && s.snapConf != nil {
+		atomic.StoreUint64(&s.snapConf.snapshotThr, uint64(c.SnapshotCount))
+	}
+}
+
+// stopRaftTimingTimer drops the pending change of the heartbeat interval,
+// if any.
+func (s *PeerServer) stopRaftTimingTimer() {
+	s.raftTimingMutex.Lock()
+	defer s.raftTimingMutex.Unlock()
+	if s.raftTimingTimer != nil {
+		s.raftTimingTimer.Stop()
+		s.raftTimingTimer = nil
+	}
 }
 
 // Retrieves the underlying Raft server.
@@ -421,6 +651,14 @@ func (s *PeerServer) RaftServer() raft.Server {
 	return s.raftServer
 }
 
+// SetTLSReloaders associates the TLS configurations of the peer and client
+// listeners with the peer server, to report their certificate expiry.
+// Either may be nil if the listener doesn't use TLS.
+func (s *PeerServer) SetTLSReloaders(peer, client *TLSReloader) {
+	s.peerTLSReloader = peer
+	s.clientTLSReloader = client
+}
+
 // Associates the client server with the peer server.
 func (s *PeerServer) SetServer(server *Server) {
 	s.server = server
@@ -438,8 +676,21 @@ func (s *PeerServer) InitNewCluster(clusterConfig *ClusterConfig) {
 	log.Debugf("%s start as a leader", s.Config.Name)
 	s.joinIndex = 1
 
+	clusterConfig.ClusterID = newClusterID()
 	s.doCommand(&SetClusterConfigCommand{Config: clusterConfig})
 	log.Debugf("%s sets cluster config as %v", s.Config.Name, clusterConfig)
+	log.Infof("%s starts cluster %s", s.Config.Name, clusterConfig.ClusterID)
+}
+
+// newClusterID generates a random cluster ID.
+func newClusterID() string {
+	b := make([]byte, 8)
+	if _, err := crand.Read(b); err != nil {
+		// Fall back to the weaker generator, the ID only has to be
+		// different from the IDs of the other clusters.
+		return fmt.Sprintf("%016x", rand.Int63())
+	}
+	return hex.EncodeToString(b)
 }
 
 func (s *PeerServer) doCommand(cmd raft.Command) {
@@ -512,12 +763,14 @@ func (s *PeerServer) checkPeerAddressNonconflict() bool {
 
 // Helper function to do discovery and return results in expected format
 func (s *PeerServer) handleDiscovery(discoverURL string) (peers []string, err error) {
-	peers, err = discovery.Do(discoverURL, s.Config.Name, s.Config.URL, s.closeChan, s.startRoutine)
+	timeout := time.Duration(s.Config.DiscoveryTimeout * float64(time.Second))
+	peers, err = discovery.Do(discoverURL, s.Config.Name, s.Config.URL, timeout, s.closeChan, s.startRoutine)
 
 	// Warn about errors coming from discovery, this isn't fatal
 	// since the user might have provided a peer list elsewhere,
 	// or there is some log in data dir.
-	if err != nil {
+	// The peers of a full cluster are still returned.
+	if err != nil && err != discovery.ErrClusterFull {
 		log.Warnf("Discovery encountered an error: %v", err)
 		return
 	}
@@ -630,9 +883,11 @@ func (s *PeerServer) joinByPeer(server raft.Server, peer string, scheme string)
 			Name:       server.Name(),
 			RaftURL:    s.Config.URL,
 			EtcdURL:    s.server.URL(),
+			ClusterID:  s.ClusterID(),
 		})
 	if err != nil {
-		return err.ErrorCode == etcdErr.EcodeNoMorePeer, fmt.Errorf("fail on join request: %v", err)
+		rejected := err.ErrorCode == etcdErr.EcodeNoMorePeer || err.ErrorCode == etcdErr.EcodeClusterMismatch
+		return rejected, fmt.Errorf("fail on join request: %v", err)
 	}
 
 	s.joinIndex = joinIndex
@@ -656,6 +911,18 @@ func (s *PeerServer) Stats() []byte {
 
 	s.serverStats.RecvingPkgRate, s.serverStats.RecvingBandwidthRate = queue.Rate()
 
+	if s.peerTLSReloader != nil {
+		expiry := s.peerTLSReloader.Expiry()
+		s.serverStats.PeerCertExpiry = &expiry
+	}
+	if s.clientTLSReloader != nil {
+		expiry := s.clientTLSReloader.Expiry()
+		s.serverStats.ClientCertExpiry = &expiry
+	}
+
+	logStats := s.RaftServer().LogStats()
+	s.serverStats.Log = &logStats
+
 	b, _ := json.Marshal(s.serverStats)
 
 	return b
@@ -663,6 +930,8 @@ func (s *PeerServer) Stats() []byte {
 
 func (s *PeerServer) PeerStats() []byte {
 	if s.raftServer.State() == raft.Leader {
+		s.followersStats.Lock()
+		defer s.followersStats.Unlock()
 		b, _ := json.Marshal(s.followersStats)
 		return b
 	}
@@ -779,16 +1048,72 @@ func (s *PeerServer) monitorSnapshot() {
 		case <-timer.C:
 		}
 
-		currentIndex := s.RaftServer().CommitIndex()
-		count := currentIndex - s.snapConf.lastIndex
-		if uint64(count) > s.snapConf.snapshotThr {
-			err := s.raftServer.TakeSnapshot()
-			s.logSnapshot(err, currentIndex, count)
-			s.snapConf.lastIndex = currentIndex
+		if s.snapshotNeeded() {
+			s.TakeSnapshot()
 		}
 	}
 }
 
+// snapshotNeeded checks whether the log has grown enough since the last
+// snapshot, in entries or in bytes, or whether the snapshot interval has
+// elapsed with entries to compact.
+func (s *PeerServer) snapshotNeeded() bool {
+	s.snapConf.mutex.Lock()
+	count := s.RaftServer().CommitIndex() - s.snapConf.lastIndex
+	lastTime := s.snapConf.lastTime
+	s.snapConf.mutex.Unlock()
+
+	if count > atomic.LoadUint64(&s.snapConf.snapshotThr) {
+		return true
+	}
+	if count == 0 {
+		return false
+	}
+	if s.Config.SnapshotInterval > 0 && time.Since(lastTime) >= s.Config.SnapshotInterval {
+		return true
+	}
+	if s.Config.SnapshotLogSize > 0 && s.RaftServer().LogStats().Size > s.Config.SnapshotLogSize {
+		return true
+	}
+	return false
+}
+
+// TakeSnapshot takes a snapshot of the state and compacts the raft log.
+// The time it took and the size of the snapshot are reported in the
+// server stats.
+func (s *PeerServer) TakeSnapshot() error {
+	s.snapConf.mutex.Lock()
+	defer s.snapConf.mutex.Unlock()
+
+	currentIndex := s.RaftServer().CommitIndex()
+	count := currentIndex - s.snapConf.lastIndex
+
+	start := time.Now()
+	err := s.raftServer.TakeSnapshot()
+	s.logSnapshot(err, currentIndex, count)
+	s.snapConf.lastIndex = currentIndex
+	s.snapConf.lastTime = time.Now()
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(s.raftServer.Path(), "snapshot")
+	name, index, err := latestSnapshotFile(dir)
+	if err != nil || name == "" {
+		return err
+	}
+	fi, err := os.Stat(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	s.serverStats.SnapshotTaken(index, time.Since(start), fi.Size())
+
+	if err := retainSnapshot(s.raftServer.Path(), name, s.Config.SnapshotRetention); err != nil {
+		log.Warnf("%s: cannot keep snapshot %s: %v", s.Config.Name, name, err)
+	}
+	return nil
+}
+
 func (s *PeerServer) monitorSync() {
 	ticker := time.NewTicker(time.Millisecond * 500)
 	defer ticker.Stop()
@@ -862,6 +1187,35 @@ func (s *PeerServer) monitorActiveSize() {
 	}
 }
 
+// monitorClusterID has the leader assign an ID to a cluster started by a
+// version of etcd without cluster IDs.
+func (s *PeerServer) monitorClusterID() {
+	for {
+		select {
+		case <-s.closeChan:
+			return
+		case <-time.After(ActiveMonitorTimeout):
+		}
+
+		if s.ClusterID() != "" {
+			return
+		}
+		// Ignore while this peer is not a leader.
+		if s.raftServer.State() != raft.Leader {
+			continue
+		}
+
+		c := s.ClusterConfig()
+		c.ClusterID = newClusterID()
+		if _, err := s.raftServer.Do(&SetClusterConfigCommand{Config: c}); err != nil {
+			log.Warnf("%s: failed assigning ID %s to the cluster: %v", s.Config.Name, c.ClusterID, err)
+			continue
+		}
+		log.Infof("%s: assigned ID %s to the cluster, which was started without one", s.Config.Name, c.ClusterID)
+		return
+	}
+}
+
 // monitorPeerActivity has the leader periodically for dead nodes and demotes them.
 func (s *PeerServer) monitorPeerActivity() {
 	for {
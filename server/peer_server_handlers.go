@@ -1,8 +1,14 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"path/filepath"
 	"strconv"
 	"time"
 
@@ -86,6 +92,82 @@ func (ps *PeerServer) AppendEntriesHttpHandler(w http.ResponseWriter, req *http.
 	(*ps.metrics).Timer("timer.appendentries.handle").UpdateSince(start)
 }
 
+// Serves a stream of append entries requests from the leader. The
+// connection is taken over from the HTTP server, and the requests are
+// handled in the order they arrive.
+func (ps *PeerServer) AppendEntriesStreamHttpHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" || !isStreamUpgrade(req) {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		log.Warnf("[recv] %s/log/stream: %v", ps.Config.URL, err)
+		return
+	}
+	defer conn.Close()
+
+	log.Debugf("[recv] POST %s/log/stream from %s", ps.Config.URL, req.RemoteAddr)
+
+	// Drop the deadlines of the HTTP server, the stream stays open as
+	// long as the leader uses it.
+	conn.SetDeadline(time.Time{})
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", streamProtocol)
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(StreamIdleTimeout))
+		b, err := readStreamFrame(rw)
+		if err != nil {
+			log.Debugf("[recv] %s/log/stream closed: %v", ps.Config.URL, err)
+			return
+		}
+
+		start := time.Now()
+		aereq := &raft.AppendEntriesRequest{}
+		if _, err := aereq.Decode(bytes.NewReader(b)); err != nil {
+			log.Warnf("[recv] BADREQUEST %s/log/stream [%v]", ps.Config.URL, err)
+			return
+		}
+
+		log.Debugf("[recv] STREAM %s/log/stream [%d]", ps.Config.URL, len(aereq.Entries))
+
+		ps.serverStats.RecvAppendReq(aereq.LeaderName, len(b))
+
+		resp := ps.raftServer.AppendEntries(aereq)
+		if resp == nil {
+			log.Warn("[ae] Error: nil response")
+			return
+		}
+
+		var rb bytes.Buffer
+		if _, err := resp.Encode(&rb); err != nil {
+			log.Warn("[ae] Error: ", err)
+			return
+		}
+		// Only flush when no more requests are waiting, so that the
+		// responses to pipelined requests share packets.
+		if err := writeStreamFrame(rw, rb.Bytes()); err != nil {
+			return
+		}
+		if rw.Reader.Buffered() == 0 {
+			if err := rw.Flush(); err != nil {
+				return
+			}
+		}
+
+		(*ps.metrics).Timer("timer.appendentries.handle").UpdateSince(start)
+	}
+}
+
 // Response to recover from snapshot request
 func (ps *PeerServer) SnapshotHttpHandler(w http.ResponseWriter, req *http.Request) {
 	ssreq := &raft.SnapshotRequest{}
@@ -126,6 +208,7 @@ func (ps *PeerServer) SnapshotRecoveryHttpHandler(w http.ResponseWriter, req *ht
 	log.Debugf("[recv] POST %s/snapshotRecovery", ps.Config.URL)
 
 	resp := ps.raftServer.SnapshotRecoveryRequest(ssrreq)
+	ps.loadClusterID()
 
 	if resp == nil {
 		log.Warn("[ssr] Error: nil response")
@@ -140,6 +223,108 @@ func (ps *PeerServer) SnapshotRecoveryHttpHandler(w http.ResponseWriter, req *ht
 	}
 }
 
+// Response to the leader asking how much of a snapshot was received
+func (ps *PeerServer) GetSnapshotChunksHttpHandler(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+	if _, _, ok := parseSnapshotTransferID(id); !ok {
+		http.Error(w, "bad snapshot id", http.StatusBadRequest)
+		return
+	}
+
+	offset, err := ps.snapshotReceiver.offset(ps.snapshotRecvDir(), id)
+	if err != nil {
+		log.Warnf("[ssr] Error: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(SnapshotOffsetHeader, strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+// Response to a chunk of snapshot
+func (ps *PeerServer) SnapshotChunkHttpHandler(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+	offset, err := strconv.ParseInt(req.FormValue("offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad offset", http.StatusBadRequest)
+		return
+	}
+	checksum, err := strconv.ParseUint(req.Header.Get(SnapshotChecksumHeader), 16, 32)
+	if err != nil {
+		http.Error(w, "bad checksum", http.StatusBadRequest)
+		return
+	}
+	chunk, err := ioutil.ReadAll(io.LimitReader(req.Body, maxSnapshotChunk))
+	if err != nil {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	log.Debugf("[recv] PUT %s/snapshotRecovery/%s at %d (%d bytes)", ps.Config.URL, id, offset, len(chunk))
+
+	received, err := ps.snapshotReceiver.write(ps.snapshotRecvDir(), id, offset, chunk, uint32(checksum))
+	switch err {
+	case nil:
+		w.Header().Set(SnapshotOffsetHeader, strconv.FormatInt(received, 10))
+		w.WriteHeader(http.StatusOK)
+	case errSnapshotOffset:
+		w.Header().Set(SnapshotOffsetHeader, strconv.FormatInt(received, 10))
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errSnapshotChecksum:
+		log.Warnf("[recv] BADREQUEST %s/snapshotRecovery/%s at %d [%v]", ps.Config.URL, id, offset, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Warnf("[ssr] Error: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
+
+// Response to recover from the snapshot whose chunks were all received
+func (ps *PeerServer) SnapshotChunksRecoveryHttpHandler(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+	dir := ps.snapshotRecvDir()
+
+	f, err := ps.snapshotReceiver.open(dir, id)
+	if err != nil {
+		log.Warnf("[recv] BADREQUEST %s/snapshotRecovery/%s [%v]", ps.Config.URL, id, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ssrreq := &raft.SnapshotRecoveryRequest{}
+	n, err := ssrreq.Decode(f)
+	f.Close()
+	if err != nil {
+		ps.snapshotReceiver.remove(dir)
+		log.Warnf("[recv] BADREQUEST %s/snapshotRecovery/%s [%v]", ps.Config.URL, id, err)
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	log.Infof("Recovering from snapshot %d received from %s (%d bytes)", ssrreq.LastIndex, ssrreq.LeaderName, n)
+
+	resp := ps.raftServer.SnapshotRecoveryRequest(ssrreq)
+	ps.loadClusterID()
+	ps.snapshotReceiver.remove(dir)
+
+	if resp == nil {
+		log.Warn("[ssr] Error: nil response")
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := resp.Encode(w); err != nil {
+		log.Warnf("[ssr] Error: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+}
+
+// snapshotRecvDir returns where the chunks of a snapshot are kept.
+func (ps *PeerServer) snapshotRecvDir() string {
+	return filepath.Join(ps.raftServer.Path(), snapshotRecvDir)
+}
+
 // Get the port that listening for etcd connecting of the server
 func (ps *PeerServer) EtcdURLHttpHandler(w http.ResponseWriter, req *http.Request) {
 	log.Debugf("[recv] Get %s/etcdURL/ ", ps.Config.URL)
@@ -212,6 +397,19 @@ func (ps *PeerServer) setClusterConfigHttpHandler(w http.ResponseWriter, req *ht
 	if syncInterval, ok := m["syncInterval"].(float64); ok {
 		config.SyncInterval = syncInterval
 	}
+	if heartbeatInterval, ok := m["heartbeatInterval"].(float64); ok {
+		config.HeartbeatInterval = int(heartbeatInterval)
+	}
+	if electionTimeout, ok := m["electionTimeout"].(float64); ok {
+		config.ElectionTimeout = int(electionTimeout)
+	}
+	if snapshotCount, ok := m["snapshotCount"].(float64); ok {
+		config.SnapshotCount = int(snapshotCount)
+	}
+	if err := config.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Issue command to update.
 	c := &SetClusterConfigCommand{Config: config}
@@ -222,6 +420,18 @@ func (ps *PeerServer) setClusterConfigHttpHandler(w http.ResponseWriter, req *ht
 	json.NewEncoder(w).Encode(ps.ClusterConfig())
 }
 
+// Takes a snapshot of this machine immediately.
+func (ps *PeerServer) snapshotHttpHandler(w http.ResponseWriter, req *http.Request) {
+	log.Debugf("[recv] Take Snapshot Request")
+	if err := ps.TakeSnapshot(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ps.serverStats.LastSnapshot())
+}
+
 // Retrieves a list of peers and standbys.
 func (ps *PeerServer) getMachinesHttpHandler(w http.ResponseWriter, req *http.Request) {
 	machines := make([]*machineMessage, 0)
@@ -240,10 +450,114 @@ func (ps *PeerServer) getMachinesHttpHandler(w http.ResponseWriter, req *http.Re
 func (ps *PeerServer) getMachineHttpHandler(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	m := ps.getMachineMessage(vars["name"], ps.raftServer.Leader())
+	if m == nil {
+		ps.writeAdminError(w, etcdErr.NewError(etcdErr.EcodeMachineNotFound, vars["name"], ps.store.Index()))
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(m)
 }
 
+// Pre-registers a peer so that it can join later with the given URLs.
+func (ps *PeerServer) addMachineHttpHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	m := &machineMessage{}
+	if err := uhttp.DecodeJsonRequest(req, m); err != nil {
+		ps.writeAdminError(w, etcdErr.NewError(etcdErr.EcodeInvalidField, err.Error(), ps.store.Index()))
+		return
+	}
+	if err := ps.checkMachineURLs(m, true); err != nil {
+		ps.writeAdminError(w, err)
+		return
+	}
+
+	c := &AddMachineCommand{
+		Name:      vars["name"],
+		PeerURL:   m.PeerURL,
+		ClientURL: m.ClientURL,
+	}
+	log.Debugf("[recv] Add Machine Request [%s]", c.Name)
+	w.Header().Set("Content-Type", "application/json")
+	if err := ps.server.Dispatch(c, w, req); err != nil {
+		ps.writeAdminError(w, err)
+	}
+}
+
+// Updates the peer and client URLs of an existing peer.
+func (ps *PeerServer) updateMachineHttpHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	m := &machineMessage{}
+	if err := uhttp.DecodeJsonRequest(req, m); err != nil {
+		ps.writeAdminError(w, etcdErr.NewError(etcdErr.EcodeInvalidField, err.Error(), ps.store.Index()))
+		return
+	}
+	if err := ps.checkMachineURLs(m, false); err != nil {
+		ps.writeAdminError(w, err)
+		return
+	}
+
+	c := &UpdateMachineCommand{
+		Name:      vars["name"],
+		PeerURL:   m.PeerURL,
+		ClientURL: m.ClientURL,
+	}
+	log.Debugf("[recv] Update Machine Request [%s]", c.Name)
+	w.Header().Set("Content-Type", "application/json")
+	if err := ps.server.Dispatch(c, w, req); err != nil {
+		ps.writeAdminError(w, err)
+	}
+}
+
+// Removes a peer from the cluster.
+func (ps *PeerServer) removeMachineHttpHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	if !ps.registry.Exists(vars["name"]) {
+		ps.writeAdminError(w, etcdErr.NewError(etcdErr.EcodeMachineNotFound, vars["name"], ps.store.Index()))
+		return
+	}
+
+	c := &RemoveCommand{Name: vars["name"]}
+	log.Debugf("[recv] Remove Machine Request [%s]", c.Name)
+	if err := ps.server.Dispatch(c, w, req); err != nil {
+		ps.writeAdminError(w, err)
+	}
+}
+
+// checkMachineURLs validates the URLs given for a machine.
+// Both URLs are required if required is set.
+func (ps *PeerServer) checkMachineURLs(m *machineMessage, required bool) error {
+	for _, f := range []struct{ name, value string }{
+		{"peerURL", m.PeerURL},
+		{"clientURL", m.ClientURL},
+	} {
+		if f.value == "" {
+			if required {
+				return etcdErr.NewError(etcdErr.EcodeInvalidField, f.name+" is required", ps.store.Index())
+			}
+			continue
+		}
+		u, err := url.Parse(f.value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return etcdErr.NewError(etcdErr.EcodeInvalidField, fmt.Sprintf("%s %q is not a valid URL", f.name, f.value), ps.store.Index())
+		}
+	}
+	if !required && m.PeerURL == "" && m.ClientURL == "" {
+		return etcdErr.NewError(etcdErr.EcodeInvalidField, "peerURL or clientURL is required", ps.store.Index())
+	}
+	return nil
+}
+
+// writeAdminError writes the error as an etcd JSON error.
+func (ps *PeerServer) writeAdminError(w http.ResponseWriter, err error) {
+	e, ok := err.(*etcdErr.Error)
+	if !ok {
+		e = etcdErr.NewError(etcdErr.EcodeRaftInternal, err.Error(), ps.store.Index())
+	}
+	log.Debug("Return error: ", e.Error())
+	w.Header().Set("Content-Type", "application/json")
+	e.Write(w)
+}
+
 func (ps *PeerServer) getMachineMessage(name string, leader string) *machineMessage {
 	if !ps.registry.Exists(name) {
 		return nil
@@ -256,6 +570,7 @@ func (ps *PeerServer) getMachineMessage(name string, leader string) *machineMess
 		State:     raft.Follower,
 		ClientURL: clientURL,
 		PeerURL:   peerURL,
+		ClusterID: ps.ClusterID(),
 	}
 	if name == leader {
 		msg.State = raft.Leader
@@ -315,4 +630,5 @@ type machineMessage struct {
 	State     string `json:"state"`
 	ClientURL string `json:"clientURL"`
 	PeerURL   string `json:"peerURL"`
+	ClusterID string `json:"clusterID,omitempty"`
 }
@@ -0,0 +1,364 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/log"
+	"github.com/coreos/etcd/third_party/github.com/goraft/raft"
+)
+
+const (
+	// StreamEntriesPerRequest is the maximum number of log entries sent in
+	// one append entries request over a peer stream. Larger batches are
+	// split into pipelined requests.
+	StreamEntriesPerRequest = 64
+
+	// StreamMaxInflight is the maximum number of append entries requests
+	// sent over a peer stream without a response.
+	StreamMaxInflight = 8
+
+	// StreamRetryInterval is how long the transporter uses the per-request
+	// endpoints for a peer that doesn't support streams before it tries
+	// again, in case the peer was upgraded.
+	StreamRetryInterval = 1 * time.Minute
+
+	// StreamIdleTimeout is how long a peer keeps a stream open without
+	// receiving anything on it.
+	StreamIdleTimeout = 1 * time.Minute
+
+	// maxStreamFrame is the maximum size of a message on a peer stream.
+	maxStreamFrame = 64 * 1024 * 1024
+
+	// streamProtocol is the protocol a peer connection is upgraded to.
+	streamProtocol = "etcd-raft-stream"
+)
+
+// errStreamUnsupported is returned when a peer runs a version of etcd
+// without streams.
+var errStreamUnsupported = errors.New("peer doesn't support streams")
+
+// A peer stream is a long-lived connection to a follower that carries
+// append entries requests and their responses, each preceded by its
+// length. The follower handles the requests in order, so the leader can
+// send several of them before it waits for the first response.
+type peerStream struct {
+	url  string
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+
+	// mutex serializes the rounds of requests sent over the stream.
+	mutex sync.Mutex
+}
+
+// dialPeerStream opens a stream to the peer at the given URL.
+func dialPeerStream(server raft.Server, urlStr string, tlsConf *tls.Config, dialTimeout time.Duration) (*peerStream, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("tcp", u.Host, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "https" {
+		// Copy the fields set for peers, as the config is shared.
+		conf := &tls.Config{}
+		if tlsConf != nil {
+			conf.Certificates = tlsConf.Certificates
+			conf.RootCAs = tlsConf.RootCAs
+			conf.ServerName = tlsConf.ServerName
+			conf.InsecureSkipVerify = tlsConf.InsecureSkipVerify
+		}
+		if conf.ServerName == "" {
+			conf.ServerName, _, _ = net.SplitHostPort(u.Host)
+		}
+		conn = tls.Client(conn, conf)
+	}
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+
+	req, err := newPeerRequest(server, "POST", urlStr+"/log/stream", nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", streamProtocol)
+
+	s := &peerStream{
+		url:  urlStr,
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}
+	if err := req.Write(s.w); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := s.w.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := http.ReadResponse(s.r, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body.Close()
+		conn.Close()
+		// Older versions don't know the endpoint.
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+			return nil, errStreamUnsupported
+		}
+		return nil, fmt.Errorf("stream to %s: %s", urlStr, resp.Status)
+	}
+	return s, nil
+}
+
+// appendEntries sends the requests over the stream, with at most
+// StreamMaxInflight of them waiting for a response. It stops sending once a
+// request fails, and returns the responses received in order.
+func (s *peerStream) appendEntries(reqs []*raft.AppendEntriesRequest, timeout time.Duration) ([]*raft.AppendEntriesResponse, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	resps := make([]*raft.AppendEntriesResponse, 0, len(reqs))
+	sent := 0
+	failed := false
+	for len(resps) < sent || (sent < len(reqs) && !failed) {
+		s.conn.SetDeadline(time.Now().Add(timeout))
+
+		// Keep sending while the window allows it.
+		if sent < len(reqs) && !failed && sent-len(resps) < StreamMaxInflight {
+			var b bytes.Buffer
+			if _, err := reqs[sent].Encode(&b); err != nil {
+				return resps, err
+			}
+			if err := writeStreamFrame(s.w, b.Bytes()); err != nil {
+				return resps, err
+			}
+			sent++
+			// Flush when the window is full or there is nothing left
+			// to send, so that small requests share packets.
+			if sent == len(reqs) || sent-len(resps) == StreamMaxInflight {
+				if err := s.w.Flush(); err != nil {
+					return resps, err
+				}
+			}
+			continue
+		}
+
+		b, err := readStreamFrame(s.r)
+		if err != nil {
+			return resps, err
+		}
+		resp := &raft.AppendEntriesResponse{}
+		if _, err := resp.Decode(bytes.NewReader(b)); err != nil {
+			return resps, err
+		}
+		resps = append(resps, resp)
+		if !resp.Success() {
+			failed = true
+		}
+	}
+	return resps, nil
+}
+
+func (s *peerStream) close() {
+	s.conn.Close()
+}
+
+// sendAppendEntriesStream sends an append entries request to a peer over
+// its stream. Requests with many entries are split into pipelined requests.
+// It returns the response to report to raft and the number of entries the
+// peer appended. When only the first requests succeed, the response is that
+// of the last one appended, whose index tells raft how far the peer got.
+// The last return is false if the peer doesn't support streams.
+//
+// Raft waits for the response before its next heartbeat sends more, so
+// only the requests of one round are in flight at once: a follower far
+// behind catches up by at most one round, of MaxLogEntriesPerRequest
+// entries, per heartbeat interval.
+func (t *transporter) sendAppendEntriesStream(server raft.Server, peer *raft.Peer, u string, req *raft.AppendEntriesRequest) (*raft.AppendEntriesResponse, int, bool) {
+	reqs := splitAppendEntriesRequest(req, StreamEntriesPerRequest)
+
+	var resps []*raft.AppendEntriesResponse
+	for {
+		s, opened, err := t.peerStream(server, peer.Name, u)
+		if err == errStreamUnsupported {
+			return nil, 0, false
+		} else if err != nil {
+			log.Debugf("Cannot open stream to %s: %v", u, err)
+			return nil, 0, true
+		}
+
+		resps, err = s.appendEntries(reqs, t.requestTimeout())
+		if err == nil {
+			break
+		}
+		log.Debugf("Stream to %s failed: %v", u, err)
+		t.closePeerStream(peer.Name, s)
+		// A stream that was idle may have been closed by a restarted
+		// peer, so nothing is lost by trying a new one.
+		if opened || len(resps) > 0 {
+			break
+		}
+	}
+	resp, acked := streamResponse(reqs, resps)
+	return resp, acked, true
+}
+
+// streamResponse picks the response to report to raft for the split
+// requests, out of the responses received in order, and counts the entries
+// acknowledged by them. A failure is reported when it is the first response,
+// or when it comes from a peer with a later term, for the leader to step
+// down. Otherwise the last success is reported.
+func streamResponse(reqs []*raft.AppendEntriesRequest, resps []*raft.AppendEntriesResponse) (*raft.AppendEntriesResponse, int) {
+	var resp *raft.AppendEntriesResponse
+	acked := 0
+	for i, r := range resps {
+		if !r.Success() {
+			if resp == nil || r.Term() > reqs[i].Term {
+				resp = r
+			}
+			break
+		}
+		resp = r
+		acked += len(reqs[i].Entries)
+	}
+	return resp, acked
+}
+
+// peerStream returns the open stream to a peer, or opens one. The second
+// return tells whether the stream was just opened.
+func (t *transporter) peerStream(server raft.Server, name string, u string) (*peerStream, bool, error) {
+	t.streamMutex.Lock()
+	if s, ok := t.streams[name]; ok {
+		if s.url == u {
+			t.streamMutex.Unlock()
+			return s, false, nil
+		}
+		// The peer has moved.
+		s.close()
+		delete(t.streams, name)
+	}
+	retry, ok := t.noStreams[name]
+	t.streamMutex.Unlock()
+	if ok && time.Now().Before(retry) {
+		return nil, false, errStreamUnsupported
+	}
+
+	t.mutex.RLock()
+	tlsConf, dialTimeout := t.transport.TLSClientConfig, t.transport.ConnectTimeout
+	t.mutex.RUnlock()
+
+	// Raft only talks to a peer from one goroutine, so nobody else
+	// opens a stream to it meanwhile.
+	s, err := dialPeerStream(server, u, tlsConf, dialTimeout)
+
+	t.streamMutex.Lock()
+	defer t.streamMutex.Unlock()
+	if err == errStreamUnsupported {
+		log.Infof("%s doesn't support streams, using per-request messages for %v", name, StreamRetryInterval)
+		t.noStreams[name] = time.Now().Add(StreamRetryInterval)
+		return nil, false, err
+	} else if err != nil {
+		return nil, false, err
+	}
+	delete(t.noStreams, name)
+	t.streams[name] = s
+	log.Debugf("Opened stream to %s", u)
+	return s, true, nil
+}
+
+// closePeerStream closes the stream to a peer if it is still the open one.
+func (t *transporter) closePeerStream(name string, s *peerStream) {
+	t.streamMutex.Lock()
+	defer t.streamMutex.Unlock()
+	s.close()
+	if t.streams[name] == s {
+		delete(t.streams, name)
+	}
+}
+
+// requestTimeout returns the time to wait for the response to a raft message.
+func (t *transporter) requestTimeout() time.Duration {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.transport.RequestTimeout
+}
+
+// splitAppendEntriesRequest splits an append entries request into requests
+// of at most n entries, each following the entries of the previous one.
+func splitAppendEntriesRequest(req *raft.AppendEntriesRequest, n int) []*raft.AppendEntriesRequest {
+	if len(req.Entries) <= n {
+		return []*raft.AppendEntriesRequest{req}
+	}
+
+	reqs := make([]*raft.AppendEntriesRequest, 0, (len(req.Entries)+n-1)/n)
+	prevLogIndex, prevLogTerm := req.PrevLogIndex, req.PrevLogTerm
+	for i := 0; i < len(req.Entries); i += n {
+		end := i + n
+		if end > len(req.Entries) {
+			end = len(req.Entries)
+		}
+		reqs = append(reqs, &raft.AppendEntriesRequest{
+			Term:         req.Term,
+			PrevLogIndex: prevLogIndex,
+			PrevLogTerm:  prevLogTerm,
+			CommitIndex:  req.CommitIndex,
+			LeaderName:   req.LeaderName,
+			Entries:      req.Entries[i:end],
+		})
+		last := req.Entries[end-1]
+		prevLogIndex, prevLogTerm = last.GetIndex(), last.GetTerm()
+	}
+	return reqs
+}
+
+// writeStreamFrame writes a message preceded by its length.
+func writeStreamFrame(w io.Writer, b []byte) error {
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], uint32(len(b)))
+	if _, err := w.Write(l[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// readStreamFrame reads a message preceded by its length.
+func readStreamFrame(r io.Reader) ([]byte, error) {
+	var l [4]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(l[:])
+	if n > maxStreamFrame {
+		return nil, fmt.Errorf("stream message of %d bytes is too large", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// isStreamUpgrade checks whether a request asks for a peer stream.
+func isStreamUpgrade(req *http.Request) bool {
+	return strings.ToLower(req.Header.Get("Upgrade")) == streamProtocol
+}
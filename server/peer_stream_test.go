@@ -0,0 +1,215 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/third_party/code.google.com/p/gogoprotobuf/proto"
+	"github.com/coreos/etcd/third_party/github.com/goraft/raft"
+	"github.com/coreos/etcd/third_party/github.com/goraft/raft/protobuf"
+	"github.com/coreos/etcd/third_party/github.com/stretchr/testify/assert"
+)
+
+func newEntries(first, n int, term uint64) []*protobuf.LogEntry {
+	entries := make([]*protobuf.LogEntry, n)
+	for i := range entries {
+		entries[i] = &protobuf.LogEntry{
+			Index:       proto.Uint64(uint64(first + i)),
+			Term:        proto.Uint64(term),
+			CommandName: proto.String("etcd:nop"),
+		}
+	}
+	return entries
+}
+
+// waitCounter records the largest number of requests a follower found
+// waiting at once.
+type waitCounter struct {
+	mutex sync.Mutex
+	max   int
+}
+
+func (c *waitCounter) record(waiting int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if waiting > c.max {
+		c.max = waiting
+	}
+}
+
+func (c *waitCounter) get() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.max
+}
+
+// streamFollower serves a peer stream like a follower that appends every
+// request whose previous entry it has. It records the largest number of
+// requests it found waiting at once.
+func streamFollower(t *testing.T, maxWaiting *waitCounter) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !isStreamUpgrade(req) {
+			http.NotFound(w, req)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: %s\r\n\r\n", streamProtocol)
+		rw.Flush()
+
+		var index uint64
+		for {
+			b, err := readStreamFrame(rw)
+			if err != nil {
+				return
+			}
+			// Give the leader the time to fill its window.
+			time.Sleep(time.Millisecond)
+			maxWaiting.record(1 + rw.Reader.Buffered()/len(b))
+
+			aereq := &raft.AppendEntriesRequest{}
+			aereq.Decode(bytes.NewReader(b))
+			success := aereq.PrevLogIndex == index
+			if success && len(aereq.Entries) > 0 {
+				index = aereq.Entries[len(aereq.Entries)-1].GetIndex()
+			}
+			pb := &protobuf.AppendEntriesResponse{
+				Term:        proto.Uint64(aereq.Term),
+				Index:       proto.Uint64(index),
+				CommitIndex: proto.Uint64(0),
+				Success:     proto.Bool(success),
+			}
+			rb, _ := proto.Marshal(pb)
+			writeStreamFrame(rw, rb)
+			rw.Flush()
+		}
+	}))
+}
+
+// Ensures that a large append entries request is split into requests that
+// each follow the previous one.
+func TestSplitAppendEntriesRequest(t *testing.T) {
+	req := &raft.AppendEntriesRequest{Term: 2, PrevLogIndex: 10, PrevLogTerm: 1, Entries: newEntries(11, 150, 2)}
+	reqs := splitAppendEntriesRequest(req, 64)
+	if assert.Equal(t, len(reqs), 3) {
+		assert.Equal(t, len(reqs[0].Entries), 64)
+		assert.Equal(t, reqs[0].PrevLogIndex, uint64(10))
+		assert.Equal(t, reqs[0].PrevLogTerm, uint64(1))
+		assert.Equal(t, reqs[1].PrevLogIndex, uint64(74))
+		assert.Equal(t, reqs[1].PrevLogTerm, uint64(2))
+		assert.Equal(t, len(reqs[2].Entries), 22)
+		assert.Equal(t, reqs[2].PrevLogIndex, uint64(138))
+	}
+
+	reqs = splitAppendEntriesRequest(&raft.AppendEntriesRequest{}, 64)
+	assert.Equal(t, len(reqs), 1)
+}
+
+// Ensures that requests are pipelined over a stream, within the window.
+func TestPeerStreamAppendEntries(t *testing.T) {
+	var maxWaiting waitCounter
+	ts := streamFollower(t, &maxWaiting)
+	defer ts.Close()
+
+	s, err := dialPeerStream(nil, ts.URL, nil, time.Second)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer s.close()
+
+	req := &raft.AppendEntriesRequest{Term: 1, Entries: newEntries(1, 20*StreamEntriesPerRequest, 1)}
+	resps, err := s.appendEntries(splitAppendEntriesRequest(req, StreamEntriesPerRequest), time.Second)
+	assert.NoError(t, err)
+	if assert.Equal(t, len(resps), 20) {
+		for i, resp := range resps {
+			assert.True(t, resp.Success())
+			assert.Equal(t, resp.Index(), uint64((i+1)*StreamEntriesPerRequest))
+		}
+	}
+	assert.True(t, maxWaiting.get() > 1, "requests were not pipelined")
+	assert.True(t, maxWaiting.get() <= StreamMaxInflight, "too many requests in flight")
+
+	// The stream stops at the first request that fails.
+	req = &raft.AppendEntriesRequest{Term: 1, PrevLogIndex: 5000, Entries: newEntries(5001, 3*StreamEntriesPerRequest, 1)}
+	resps, err = s.appendEntries(splitAppendEntriesRequest(req, StreamEntriesPerRequest), time.Second)
+	assert.NoError(t, err)
+	if assert.True(t, len(resps) > 0) {
+		assert.False(t, resps[len(resps)-1].Success())
+	}
+}
+
+// Ensures that the response reported to raft for split requests counts the
+// entries appended, and is a failure when the peer has a later term.
+func TestStreamResponse(t *testing.T) {
+	req := &raft.AppendEntriesRequest{Term: 2, Entries: newEntries(1, 3*StreamEntriesPerRequest, 2)}
+	reqs := splitAppendEntriesRequest(req, StreamEntriesPerRequest)
+	response := func(term, index uint64, success bool) *raft.AppendEntriesResponse {
+		b, _ := proto.Marshal(&protobuf.AppendEntriesResponse{
+			Term:        proto.Uint64(term),
+			Index:       proto.Uint64(index),
+			CommitIndex: proto.Uint64(0),
+			Success:     proto.Bool(success),
+		})
+		resp := &raft.AppendEntriesResponse{}
+		resp.Decode(bytes.NewReader(b))
+		return resp
+	}
+	first := response(2, StreamEntriesPerRequest, true)
+
+	resp, acked := streamResponse(reqs, []*raft.AppendEntriesResponse{first, response(2, 2*StreamEntriesPerRequest, true), response(2, 3*StreamEntriesPerRequest, true)})
+	assert.True(t, resp.Success())
+	assert.Equal(t, acked, 3*StreamEntriesPerRequest)
+
+	resp, acked = streamResponse(reqs, []*raft.AppendEntriesResponse{first, response(2, 0, false)})
+	assert.Equal(t, resp, first)
+	assert.Equal(t, acked, StreamEntriesPerRequest)
+
+	resp, acked = streamResponse(reqs, []*raft.AppendEntriesResponse{first, response(3, 0, false)})
+	assert.False(t, resp.Success())
+	assert.Equal(t, resp.Term(), uint64(3))
+	assert.Equal(t, acked, StreamEntriesPerRequest)
+
+	resp, acked = streamResponse(reqs, nil)
+	assert.Nil(t, resp)
+	assert.Equal(t, acked, 0)
+
+	// The entries of the caller's request are left as they are.
+	assert.Equal(t, len(req.Entries), 3*StreamEntriesPerRequest)
+}
+
+// Ensures that peers without streams are detected.
+func TestPeerStreamUnsupported(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	_, err := dialPeerStream(nil, ts.URL, nil, time.Second)
+	assert.Equal(t, err, errStreamUnsupported)
+}
+
+// Ensures that messages keep their boundaries on a stream.
+func TestStreamFrame(t *testing.T) {
+	var b bytes.Buffer
+	w := bufio.NewWriter(&b)
+	writeStreamFrame(w, []byte("foo"))
+	writeStreamFrame(w, []byte{})
+	writeStreamFrame(w, []byte("bar"))
+	w.Flush()
+
+	for _, want := range []string{"foo", "", "bar"} {
+		got, err := readStreamFrame(&b)
+		assert.NoError(t, err)
+		assert.Equal(t, string(got), want)
+	}
+	_, err := readStreamFrame(&b)
+	assert.Error(t, err)
+}
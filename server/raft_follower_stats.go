@@ -2,12 +2,16 @@ package server
 
 import (
 	"math"
+	"sync"
 	"time"
 )
 
 type raftFollowersStats struct {
 	Leader    string                        `json:"leader"`
 	Followers map[string]*raftFollowerStats `json:"followers"`
+
+	// Mutex guards the followers and their stats.
+	sync.Mutex
 }
 
 func NewRaftFollowersStats(name string) *raftFollowersStats {
@@ -31,6 +35,9 @@ type raftFollowerStats struct {
 		Fail    uint64 `json:"fail"`
 		Success uint64 `json:"success"`
 	} `json:"counts"`
+
+	// Snapshot is the snapshot being sent to the follower, if any.
+	Snapshot *snapshotProgress `json:"snapshot,omitempty"`
 }
 
 // Succ function update the raftFollowerStats with a successful send
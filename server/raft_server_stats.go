@@ -26,12 +26,29 @@ type raftServerStats struct {
 	SendingPkgRate       float64 `json:"sendPkgRate,omitempty"`
 	SendingBandwidthRate float64 `json:"sendBandwidthRate,omitempty"`
 
+	PeerCertExpiry   *time.Time `json:"peerCertExpiry,omitempty"`
+	ClientCertExpiry *time.Time `json:"clientCertExpiry,omitempty"`
+
+	// Snapshot describes the last snapshot taken, if any.
+	Snapshot *snapshotStats `json:"snapshot,omitempty"`
+
+	// Log describes the space taken up by the raft log.
+	Log *raft.LogStats `json:"log,omitempty"`
+
 	sendRateQueue *statsQueue
 	recvRateQueue *statsQueue
 
 	sync.Mutex
 }
 
+type snapshotStats struct {
+	Index uint64    `json:"index"`
+	Time  time.Time `json:"time"`
+	// Duration is in milliseconds, like latencies.
+	Duration float64 `json:"duration"`
+	Size     int64   `json:"size"`
+}
+
 func NewRaftServerStats(name string) *raftServerStats {
 	stats := &raftServerStats{
 		Name:      name,
@@ -77,3 +94,23 @@ func (ss *raftServerStats) SendAppendReq(pkgSize int) {
 
 	ss.SendAppendRequestCnt++
 }
+
+// SnapshotTaken records a snapshot taken at the given index.
+func (ss *raftServerStats) SnapshotTaken(index uint64, d time.Duration, size int64) {
+	ss.Lock()
+	defer ss.Unlock()
+
+	ss.Snapshot = &snapshotStats{
+		Index:    index,
+		Time:     time.Now(),
+		Duration: float64(d) / float64(time.Millisecond),
+		Size:     size,
+	}
+}
+
+// LastSnapshot returns the last snapshot taken, or nil.
+func (ss *raftServerStats) LastSnapshot() *snapshotStats {
+	ss.Lock()
+	defer ss.Unlock()
+	return ss.Snapshot
+}
@@ -137,12 +137,19 @@ func (r *Registry) peerURL(key, name string) (string, bool) {
 
 // UpdatePeerURL updates peer URL in registry
 func (r *Registry) UpdatePeerURL(name string, peerURL string) error {
+	r.Lock()
 	machURL, _ := r.clientURL(RegistryKey, name)
+	r.Unlock()
+	return r.UpdateURLs(name, peerURL, machURL)
+}
+
+// UpdateURLs updates both the peer URL and the client URL in registry.
+func (r *Registry) UpdateURLs(name string, peerURL string, machURL string) error {
 	// Write data to store.
 	v := url.Values{}
 	v.Set("raft", peerURL)
 	v.Set("etcd", machURL)
-	log.Debugf("Update PeerURL: %s", name)
+	log.Debugf("Update URLs: %s", name)
 	if _, err := r.store.Update(path.Join(RegistryKey, name), v.Encode(), store.Permanent); err != nil {
 		return err
 	}
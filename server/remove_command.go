@@ -42,7 +42,9 @@ func applyRemove(c *RemoveCommand, context raft.Context) (uint64, error) {
 	err := ps.registry.Unregister(c.Name)
 
 	// Delete from stats
+	ps.followersStats.Lock()
 	delete(ps.followersStats.Followers, c.Name)
+	ps.followersStats.Unlock()
 
 	if err != nil {
 		log.Debugf("Error while unregistering: %s (%v)", c.Name, err)
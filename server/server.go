@@ -11,6 +11,7 @@ import (
 	"github.com/coreos/etcd/third_party/github.com/goraft/raft"
 	"github.com/coreos/etcd/third_party/github.com/gorilla/mux"
 
+	"github.com/coreos/etcd/discovery"
 	etcdErr "github.com/coreos/etcd/error"
 	ehttp "github.com/coreos/etcd/http"
 	"github.com/coreos/etcd/log"
@@ -32,8 +33,10 @@ type Server struct {
 	registry   *Registry
 	store      store.Store
 	metrics    *metrics.Bucket
+	clientAuth *ClientAuth
 
-	trace bool
+	trace            bool
+	discoveryService bool
 }
 
 // Creates a new Server.
@@ -54,6 +57,18 @@ func (s *Server) EnableTracing() {
 	s.trace = true
 }
 
+// EnableDiscoveryService serves the discovery service under /discovery, so
+// that other clusters can bootstrap from this one.
+func (s *Server) EnableDiscoveryService() {
+	s.discoveryService = true
+}
+
+// SetClientAuth restricts access to the key space based on the identity
+// of the client certificate.
+func (s *Server) SetClientAuth(a *ClientAuth) {
+	s.clientAuth = a
+}
+
 // The current state of the server in the cluster.
 func (s *Server) State() string {
 	return s.peerServer.RaftServer().State()
@@ -138,7 +153,18 @@ func (s *Server) installV2(r *mux.Router) {
 }
 
 func (s *Server) installMod(r *mux.Router) {
-	r.PathPrefix("/mod").Handler(http.StripPrefix("/mod", mod.HttpHandler(s.URL())))
+	h := s.authorizeHandler(mod.HttpHandler(s), func(req *http.Request) (string, bool) {
+		key, _ := mod.Key(req.URL.Path)
+		return key, req.Method != "GET" && req.Method != "HEAD"
+	})
+	r.PathPrefix("/mod").Handler(http.StripPrefix("/mod", h))
+}
+
+func (s *Server) installDiscovery(r *mux.Router) {
+	h := s.authorizeHandler(discovery.NewServiceHandler(s), func(req *http.Request) (string, bool) {
+		return discovery.ServiceKey(req.Method, req.URL.Path)
+	})
+	r.PathPrefix("/discovery").Handler(http.StripPrefix("/discovery", h))
 }
 
 func (s *Server) installDebug(r *mux.Router) {
@@ -153,6 +179,9 @@ func (s *Server) installDebug(r *mux.Router) {
 // Adds a v1 server handler to the router.
 func (s *Server) handleFuncV1(r *mux.Router, path string, f func(http.ResponseWriter, *http.Request, v1.Server) error) *mux.Route {
 	return s.handleFunc(r, path, func(w http.ResponseWriter, req *http.Request) error {
+		if err := s.authorize(req); err != nil {
+			return err
+		}
 		return f(w, req, s)
 	})
 }
@@ -160,10 +189,61 @@ func (s *Server) handleFuncV1(r *mux.Router, path string, f func(http.ResponseWr
 // Adds a v2 server handler to the router.
 func (s *Server) handleFuncV2(r *mux.Router, path string, f func(http.ResponseWriter, *http.Request, v2.Server) error) *mux.Route {
 	return s.handleFunc(r, path, func(w http.ResponseWriter, req *http.Request) error {
+		if err := s.authorize(req); err != nil {
+			return err
+		}
 		return f(w, req, s)
 	})
 }
 
+// authorize checks that the role mapped to the client certificate of the
+// request may access the requested key.
+// Watches are reads, and any other method than GET or HEAD is a write.
+func (s *Server) authorize(req *http.Request) error {
+	key := "/" + mux.Vars(req)["key"]
+	write := req.Method != "GET" && req.Method != "HEAD" && !strings.HasPrefix(req.URL.Path, "/v1/watch")
+	return s.authorizeKey(req, key, write)
+}
+
+// authorizeHandler checks that the role mapped to the client certificate of
+// a request may access the key that keyOf gives for it, before passing it to
+// h. The mod and discovery handlers write the key space through it.
+// Requests without a key, like those of the dashboard, are passed through.
+func (s *Server) authorizeHandler(h http.Handler, keyOf func(*http.Request) (string, bool)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if key, write := keyOf(req); key != "" {
+			if err := s.authorizeKey(req, key, write); err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				err.(*etcdErr.Error).Write(w)
+				return
+			}
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// authorizeKey checks that the role mapped to the client certificate of the
+// request may read, or write, the given key.
+func (s *Server) authorizeKey(req *http.Request, key string, write bool) error {
+	if s.clientAuth == nil {
+		return nil
+	}
+
+	identities := ClientIdentities(req)
+	name, role := s.clientAuth.Role(identities)
+	switch {
+	case role == nil:
+		log.Infof("access denied: %s %s: no role for client identities %v", req.Method, req.URL.Path, identities)
+	case write && !role.CanWrite(key):
+		log.Infof("access denied: %s %s: role %s cannot write %s", req.Method, req.URL.Path, name, key)
+	case !write && !role.CanRead(key):
+		log.Infof("access denied: %s %s: role %s cannot read %s", req.Method, req.URL.Path, name, key)
+	default:
+		return nil
+	}
+	return etcdErr.NewError(etcdErr.EcodeAccessDenied, key, s.Store().Index())
+}
+
 type HEADResponseWriter struct {
 	http.ResponseWriter
 }
@@ -181,8 +261,12 @@ func (s *Server) handleFunc(r *mux.Router, path string, f func(http.ResponseWrit
 			w = &HEADResponseWriter{w}
 		}
 
-		// Log request.
-		log.Debugf("[recv] %s %s %s [%s]", req.Method, s.URL(), req.URL.Path, req.RemoteAddr)
+		// Log request. Requests from identified clients go to the access log.
+		if identities := ClientIdentities(req); len(identities) > 0 {
+			log.Infof("[access] %s %s %s [%s] [%s]", req.Method, s.URL(), req.URL.Path, req.RemoteAddr, identities[0])
+		} else {
+			log.Debugf("[recv] %s %s %s [%s]", req.Method, s.URL(), req.URL.Path, req.RemoteAddr)
+		}
 
 		// Execute handler function and return error if necessary.
 		if err := f(w, req); err != nil {
@@ -204,9 +288,11 @@ func (s *Server) HTTPHandler() http.Handler {
 	s.handleFunc(router, "/version", s.GetVersionHandler).Methods("GET")
 	s.installV1(router)
 	s.installV2(router)
-	// Mod is deprecated temporariy due to its unstable state.
-	// It would be added back later.
-	// s.installMod(router)
+	s.installMod(router)
+
+	if s.discoveryService {
+		s.installDiscovery(router)
+	}
 
 	if s.trace {
 		s.installDebug(router)
@@ -271,6 +357,7 @@ func (s *Server) Dispatch(c raft.Command, w http.ResponseWriter, req *http.Reque
 	var url string
 	switch c.(type) {
 	case *JoinCommand, *RemoveCommand,
+		*AddMachineCommand, *UpdateMachineCommand,
 		*SetClusterConfigCommand:
 		url, _ = ps.registry.PeerURL(leader)
 	default:
@@ -282,6 +369,17 @@ func (s *Server) Dispatch(c raft.Command, w http.ResponseWriter, req *http.Reque
 	return nil
 }
 
+// Do executes a command on the raft server of this node and returns its
+// result. Unlike Dispatch, it does not redirect to the leader, so it fails
+// if this node is not the leader.
+func (s *Server) Do(c raft.Command) (interface{}, error) {
+	ps := s.peerServer
+	if ps.raftServer.State() != raft.Leader {
+		return nil, etcdErr.NewError(etcdErr.EcodeRaftInternal, "not the leader", s.Store().Index())
+	}
+	return ps.raftServer.Do(c)
+}
+
 // Handler to return the current version of etcd.
 func (s *Server) GetVersionHandler(w http.ResponseWriter, req *http.Request) error {
 	w.WriteHeader(http.StatusOK)
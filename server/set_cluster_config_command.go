@@ -21,6 +21,5 @@ func (c *SetClusterConfigCommand) CommandName() string {
 // Apply updates the cluster configuration.
 func (c *SetClusterConfigCommand) Apply(context raft.Context) (interface{}, error) {
 	ps, _ := context.Server().Context().(*PeerServer)
-	ps.SetClusterConfig(c.Config)
-	return nil, nil
+	return nil, ps.SetClusterConfig(c.Config)
 }
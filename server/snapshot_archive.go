@@ -0,0 +1,129 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	// DefaultSnapshotRetention is the default number of snapshot files kept.
+	DefaultSnapshotRetention = 1
+
+	// snapshotArchiveDir is where the snapshots kept besides the one raft
+	// loads on restart are stored, relative to the raft data directory.
+	snapshotArchiveDir = "snapshot.archive"
+)
+
+// Raft keeps a single snapshot in its snapshot directory, named after the
+// term and index of the snapshot, and removes the previous one when it takes
+// a new one. To keep more of them, every new snapshot is also linked into
+// the archive directory, which holds the latest ones.
+
+// snapshotFile is a snapshot file along with the index it was taken at.
+type snapshotFile struct {
+	name  string
+	index uint64
+}
+
+type snapshotFiles []snapshotFile
+
+func (a snapshotFiles) Len() int           { return len(a) }
+func (a snapshotFiles) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a snapshotFiles) Less(i, j int) bool { return a[i].index < a[j].index }
+
+// readSnapshotFiles lists the snapshot files of a directory, oldest first.
+func readSnapshotFiles(dir string) (snapshotFiles, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(snapshotFiles, 0, len(fis))
+	for _, fi := range fis {
+		var term, index uint64
+		if _, err := fmt.Sscanf(fi.Name(), "%d_%d.ss", &term, &index); err != nil {
+			continue
+		}
+		if fi.Name() != fmt.Sprintf("%d_%d.ss", term, index) {
+			continue
+		}
+		files = append(files, snapshotFile{fi.Name(), index})
+	}
+	sort.Sort(files)
+	return files, nil
+}
+
+// latestSnapshotFile returns the name and index of the latest snapshot file
+// of a directory, or an empty name if there is none.
+func latestSnapshotFile(dir string) (string, uint64, error) {
+	files, err := readSnapshotFiles(dir)
+	if err != nil || len(files) == 0 {
+		return "", 0, err
+	}
+	latest := files[len(files)-1]
+	return latest.name, latest.index, nil
+}
+
+// retainSnapshot archives the snapshot file of the given name, and removes
+// the archived snapshots beyond the number to keep. Raft's own snapshot
+// counts as one, so nothing is archived when a single one is kept.
+func retainSnapshot(dataDir string, name string, retention int) error {
+	archive := filepath.Join(dataDir, snapshotArchiveDir)
+	if retention <= 1 {
+		return os.RemoveAll(archive)
+	}
+
+	if err := os.MkdirAll(archive, 0700); err != nil {
+		return err
+	}
+	dst := filepath.Join(archive, name)
+	if _, err := os.Stat(dst); os.IsNotExist(err) {
+		if err := linkOrCopy(filepath.Join(dataDir, "snapshot", name), dst); err != nil {
+			return err
+		}
+	}
+
+	files, err := readSnapshotFiles(archive)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(files)-retention; i++ {
+		if err := os.Remove(filepath.Join(archive, files[i].name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkOrCopy hard links a file, or copies it if it can't be linked.
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreos/etcd/third_party/github.com/stretchr/testify/assert"
+)
+
+// Ensures that snapshot files are ordered by index rather than by name.
+func TestLatestSnapshotFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "etcd-snapshot")
+	defer os.RemoveAll(dir)
+
+	name, _, err := latestSnapshotFile(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, name, "")
+
+	for _, name := range []string{"1_999.ss", "1_1000.ss", "2_10.ss.tmp", "foo"} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0600)
+	}
+	name, index, err := latestSnapshotFile(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, name, "1_1000.ss")
+	assert.Equal(t, index, uint64(1000))
+}
+
+// Ensures that only the latest snapshots are kept in the archive.
+func TestRetainSnapshot(t *testing.T) {
+	dataDir, _ := ioutil.TempDir("", "etcd-snapshot")
+	defer os.RemoveAll(dataDir)
+	os.Mkdir(filepath.Join(dataDir, "snapshot"), 0700)
+	archive := filepath.Join(dataDir, snapshotArchiveDir)
+
+	for _, name := range []string{"1_500.ss", "1_1000.ss", "2_1500.ss", "2_2000.ss"} {
+		ioutil.WriteFile(filepath.Join(dataDir, "snapshot", name), []byte(name), 0600)
+		assert.NoError(t, retainSnapshot(dataDir, name, 3))
+	}
+
+	files, err := readSnapshotFiles(archive)
+	assert.NoError(t, err)
+	if assert.Equal(t, len(files), 3) {
+		assert.Equal(t, files[0].name, "1_1000.ss")
+		assert.Equal(t, files[2].name, "2_2000.ss")
+	}
+	b, _ := ioutil.ReadFile(filepath.Join(archive, "2_1500.ss"))
+	assert.Equal(t, string(b), "2_1500.ss")
+
+	// Keeping a single snapshot leaves it to raft.
+	assert.NoError(t, retainSnapshot(dataDir, "2_2000.ss", 1))
+	_, err = os.Stat(archive)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,367 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/log"
+	"github.com/coreos/etcd/third_party/github.com/goraft/raft"
+)
+
+const (
+	// SnapshotChunkSize is the size of the chunks a snapshot is sent to a
+	// follower in.
+	SnapshotChunkSize = 1024 * 1024
+
+	// DefaultSnapshotRate is the default rate limit, in KB per second, of
+	// the snapshots sent to followers.
+	DefaultSnapshotRate = 10 * 1024
+
+	// SnapshotOffsetHeader holds the number of bytes of a snapshot a
+	// follower has received.
+	SnapshotOffsetHeader = "X-Etcd-Snapshot-Offset"
+
+	// SnapshotChecksumHeader holds the CRC-32 checksum of a snapshot chunk.
+	SnapshotChecksumHeader = "X-Etcd-Snapshot-Checksum"
+
+	// maxSnapshotChunk is the maximum size of a snapshot chunk a follower
+	// accepts.
+	maxSnapshotChunk = 64 * 1024 * 1024
+
+	// snapshotRecvDir is where a follower keeps the snapshot it receives,
+	// relative to the raft data directory.
+	snapshotRecvDir = "snapshot.recv"
+)
+
+var (
+	errSnapshotUnsupported = errors.New("peer doesn't support chunked snapshots")
+	errSnapshotOffset      = errors.New("snapshot chunk doesn't follow the received data")
+	errSnapshotChecksum    = errors.New("snapshot checksum mismatch")
+)
+
+// A snapshot is sent to a follower in chunks. The leader encodes the
+// snapshot recovery request and names the transfer after the snapshot and
+// the size and checksum of the encoded request. It asks the follower how
+// much of the transfer it already has, PUTs the remaining chunks to
+// /snapshotRecovery/<id>, and POSTs to the same URL once all of them are
+// acknowledged, which makes the follower recover from the snapshot. A
+// transfer that is interrupted resumes from the last acknowledged chunk the
+// next time raft sends the snapshot.
+
+// snapshotProgress reports the snapshot being sent to a follower.
+type snapshotProgress struct {
+	Index uint64 `json:"index"`
+	Size  int64  `json:"size"`
+	Sent  int64  `json:"sent"`
+}
+
+// snapshotTransferID names the transfer of an encoded snapshot recovery
+// request.
+func snapshotTransferID(req *raft.SnapshotRecoveryRequest, b []byte) string {
+	return fmt.Sprintf("%d-%d-%d-%08x", req.LastTerm, req.LastIndex, len(b), crc32.ChecksumIEEE(b))
+}
+
+// parseSnapshotTransferID retrieves the size and checksum of a snapshot
+// from the name of its transfer.
+func parseSnapshotTransferID(id string) (int64, uint32, bool) {
+	var term, index uint64
+	var size int64
+	var checksum uint32
+	if _, err := fmt.Sscanf(id, "%d-%d-%d-%08x", &term, &index, &size, &checksum); err != nil {
+		return 0, 0, false
+	}
+	// Only accept the exact form, as the ID names a file.
+	if id != fmt.Sprintf("%d-%d-%d-%08x", term, index, size, checksum) {
+		return 0, 0, false
+	}
+	return size, checksum, true
+}
+
+// sendSnapshotChunks sends an encoded snapshot recovery request to a peer in
+// chunks, starting after the ones the peer already has. The second return
+// is false if the peer doesn't support chunked snapshots.
+func (t *transporter) sendSnapshotChunks(server raft.Server, name string, u string, req *raft.SnapshotRecoveryRequest, b []byte) (*raft.SnapshotRecoveryResponse, bool) {
+	id := snapshotTransferID(req, b)
+	urlStr := fmt.Sprintf("%s/snapshotRecovery/%s", u, id)
+
+	offset, err := t.snapshotOffset(server, urlStr)
+	if err == errSnapshotUnsupported {
+		return nil, false
+	} else if err != nil {
+		log.Debugf("Cannot send snapshot to %s: %v", u, err)
+		return nil, true
+	}
+	if offset > int64(len(b)) {
+		log.Warnf("%s has %d bytes of a %d bytes snapshot", name, offset, len(b))
+		return nil, true
+	}
+
+	size := int64(len(b))
+	if offset > 0 {
+		log.Infof("Resuming snapshot %d to %s at %d of %d bytes", req.LastIndex, name, offset, size)
+	} else {
+		log.Infof("Sending snapshot %d to %s (%d bytes)", req.LastIndex, name, size)
+	}
+
+	// The progress is reported in the leader stats, so it is only changed
+	// under their lock.
+	progress := &snapshotProgress{Index: req.LastIndex, Size: size, Sent: offset}
+	t.followersStats.Lock()
+	stats, ok := t.followersStats.Followers[name]
+	if ok {
+		stats.Snapshot = progress
+	}
+	t.followersStats.Unlock()
+	if ok {
+		defer func() {
+			t.followersStats.Lock()
+			stats.Snapshot = nil
+			t.followersStats.Unlock()
+		}()
+	}
+
+	var reported int64
+	if size > 0 {
+		reported = offset * 10 / size
+	}
+	for offset < size {
+		end := offset + SnapshotChunkSize
+		if end > size {
+			end = size
+		}
+		t.snapshotLimiter.wait(int(end - offset))
+
+		offset, err = t.putSnapshotChunk(server, urlStr, offset, b[offset:end])
+		if err != nil {
+			log.Infof("Cannot send snapshot to %s at %d of %d bytes: %v", name, progress.Sent, size, err)
+			return nil, true
+		}
+		t.followersStats.Lock()
+		progress.Sent = offset
+		t.followersStats.Unlock()
+
+		// Report every tenth of the snapshot.
+		if done := offset * 10 / size; done > reported && offset < size {
+			reported = done
+			log.Infof("Sent %d of %d bytes of snapshot %d to %s", offset, size, req.LastIndex, name)
+		}
+	}
+
+	resp, err := t.PostSnapshot(server, urlStr, nil)
+	if err != nil {
+		log.Infof("%s cannot recover from snapshot %d: %v", name, req.LastIndex, err)
+		return nil, true
+	}
+	defer resp.Body.Close()
+
+	ssrrsp := &raft.SnapshotRecoveryResponse{}
+	if _, err = ssrrsp.Decode(resp.Body); err != nil && err != io.EOF {
+		log.Warn("transporter.ssr.decoding.error:", err)
+		return nil, true
+	}
+	log.Infof("Sent snapshot %d to %s", req.LastIndex, name)
+	return ssrrsp, true
+}
+
+// snapshotOffset asks a peer how much of a snapshot transfer it has.
+func (t *transporter) snapshotOffset(server raft.Server, urlStr string) (int64, error) {
+	req, err := newPeerRequest(server, "GET", urlStr, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := t.snapshotHTTPClient().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	// Older versions don't know the endpoint.
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		resp.Body.Close()
+		return 0, errSnapshotUnsupported
+	}
+	if resp, err = checkPeerResponse(resp, nil); err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return snapshotOffsetOf(resp)
+}
+
+// putSnapshotChunk sends the chunk of a snapshot that starts at the given
+// offset, and returns the offset acknowledged by the peer.
+func (t *transporter) putSnapshotChunk(server raft.Server, urlStr string, offset int64, chunk []byte) (int64, error) {
+	req, err := newPeerRequest(server, "PUT", fmt.Sprintf("%s?offset=%d", urlStr, offset), bytes.NewReader(chunk))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set(SnapshotChecksumHeader, fmt.Sprintf("%08x", crc32.ChecksumIEEE(chunk)))
+	resp, err := checkPeerResponse(t.snapshotHTTPClient().Do(req))
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	acked, err := snapshotOffsetOf(resp)
+	if err != nil {
+		return 0, err
+	}
+	if acked != offset+int64(len(chunk)) {
+		return 0, fmt.Errorf("chunk at %d acknowledged up to %d", offset, acked)
+	}
+	return acked, nil
+}
+
+func snapshotOffsetOf(resp *http.Response) (int64, error) {
+	offset, err := strconv.ParseInt(resp.Header.Get(SnapshotOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("bad snapshot offset %q", resp.Header.Get(SnapshotOffsetHeader))
+	}
+	return offset, nil
+}
+
+// A snapshotReceiver keeps the chunks of the snapshot a follower receives
+// in a file named after the transfer, so that the transfer can resume after
+// a failure, even across restarts. Only the latest transfer is kept.
+type snapshotReceiver struct {
+	mutex sync.Mutex
+}
+
+// offset returns the number of bytes received of a transfer.
+func (r *snapshotReceiver) offset(dir string, id string) (int64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	fi, err := os.Stat(filepath.Join(dir, id))
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// write appends a chunk to the data received of a transfer, and returns
+// the number of bytes received. Starting a transfer discards the others.
+func (r *snapshotReceiver) write(dir string, id string, offset int64, chunk []byte, checksum uint32) (int64, error) {
+	size, _, ok := parseSnapshotTransferID(id)
+	if !ok || offset < 0 || offset+int64(len(chunk)) > size {
+		return 0, errSnapshotOffset
+	}
+	if crc32.ChecksumIEEE(chunk) != checksum {
+		return 0, errSnapshotChecksum
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if offset == 0 {
+		if err := os.RemoveAll(dir); err != nil {
+			return 0, err
+		}
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return 0, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, id), os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if fi.Size() != offset {
+		return fi.Size(), errSnapshotOffset
+	}
+	if _, err := f.WriteAt(chunk, offset); err != nil {
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		return 0, err
+	}
+	return offset + int64(len(chunk)), nil
+}
+
+// open opens the data of a complete transfer for reading, once its
+// checksum is verified. The caller closes the file.
+func (r *snapshotReceiver) open(dir string, id string) (*os.File, error) {
+	size, checksum, ok := parseSnapshotTransferID(id)
+	if !ok {
+		return nil, errSnapshotOffset
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	f, err := os.Open(filepath.Join(dir, id))
+	if err != nil {
+		return nil, err
+	}
+	h := crc32.NewIEEE()
+	n, err := io.Copy(h, f)
+	if err == nil && n != size {
+		err = errSnapshotOffset
+	} else if err == nil && h.Sum32() != checksum {
+		os.RemoveAll(dir)
+		err = errSnapshotChecksum
+	}
+	if err == nil {
+		_, err = f.Seek(0, 0)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// remove discards the data received.
+func (r *snapshotReceiver) remove(dir string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return os.RemoveAll(dir)
+}
+
+// A rateLimiter spaces out the sending of data so that it doesn't exceed
+// a rate, whatever the number of senders.
+type rateLimiter struct {
+	// rate is in bytes per second. No limit is applied if it is not
+	// positive.
+	rate  int64
+	next  time.Time
+	mutex sync.Mutex
+}
+
+func (l *rateLimiter) setRate(rate int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.rate = rate
+}
+
+// wait blocks until n bytes can be sent.
+func (l *rateLimiter) wait(n int) {
+	l.mutex.Lock()
+	if l.rate <= 0 {
+		l.mutex.Unlock()
+		return
+	}
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	d := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	l.mutex.Unlock()
+
+	time.Sleep(d)
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/third_party/github.com/goraft/raft"
+	"github.com/coreos/etcd/third_party/github.com/gorilla/mux"
+	"github.com/coreos/etcd/third_party/github.com/stretchr/testify/assert"
+)
+
+// snapshotFollower receives chunked snapshots like a follower, into dir.
+// It fails the chunks at failAt and after, as long as failAt is not
+// negative, and records the offset of the first chunk of each transfer.
+type snapshotFollower struct {
+	dir       string
+	receiver  snapshotReceiver
+	failAt    int64
+	offsets   []int64
+	recovered *raft.SnapshotRecoveryRequest
+	resumed   bool
+}
+
+func (f *snapshotFollower) handler() http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc("/snapshotRecovery/{id}", func(w http.ResponseWriter, req *http.Request) {
+		offset, _ := f.receiver.offset(f.dir, mux.Vars(req)["id"])
+		f.resumed = true
+		w.Header().Set(SnapshotOffsetHeader, strconv.FormatInt(offset, 10))
+	}).Methods("GET")
+	router.HandleFunc("/snapshotRecovery/{id}", func(w http.ResponseWriter, req *http.Request) {
+		offset, _ := strconv.ParseInt(req.FormValue("offset"), 10, 64)
+		if f.resumed {
+			f.offsets = append(f.offsets, offset)
+			f.resumed = false
+		}
+		if f.failAt >= 0 && offset >= f.failAt {
+			http.Error(w, "", http.StatusServiceUnavailable)
+			return
+		}
+		checksum, _ := strconv.ParseUint(req.Header.Get(SnapshotChecksumHeader), 16, 32)
+		chunk, _ := ioutil.ReadAll(req.Body)
+		received, err := f.receiver.write(f.dir, mux.Vars(req)["id"], offset, chunk, uint32(checksum))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set(SnapshotOffsetHeader, strconv.FormatInt(received, 10))
+	}).Methods("PUT")
+	router.HandleFunc("/snapshotRecovery/{id}", func(w http.ResponseWriter, req *http.Request) {
+		file, err := f.receiver.open(f.dir, mux.Vars(req)["id"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		f.recovered = &raft.SnapshotRecoveryRequest{}
+		f.recovered.Decode(file)
+		resp := &raft.SnapshotRecoveryResponse{Term: f.recovered.LastTerm, Success: true, CommitIndex: f.recovered.LastIndex}
+		resp.Encode(w)
+	}).Methods("POST")
+	return router
+}
+
+func newSnapshotTransporter() *transporter {
+	t := NewTransporter(NewRaftFollowersStats("leader"), NewRaftServerStats("leader"), nil, time.Second, time.Second, time.Second)
+	t.SetSnapshotRate(0)
+	return t
+}
+
+// Ensures that a snapshot is sent in chunks, and that a failed transfer
+// resumes from the last acknowledged chunk.
+func TestSendSnapshotChunks(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "etcd-snapshot")
+	defer os.RemoveAll(dir)
+
+	f := &snapshotFollower{dir: dir, failAt: 2 * SnapshotChunkSize}
+	ts := httptest.NewServer(f.handler())
+	defer ts.Close()
+
+	state := bytes.Repeat([]byte("0123456789"), SnapshotChunkSize/2)
+	req := &raft.SnapshotRecoveryRequest{LeaderName: "leader", LastIndex: 20, LastTerm: 2, State: state}
+	var b bytes.Buffer
+	req.Encode(&b)
+
+	tr := newSnapshotTransporter()
+	tr.followersStats.Followers["follower"] = &raftFollowerStats{}
+
+	// The leader stats are read while the snapshot is sent.
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			tr.followersStats.Lock()
+			json.Marshal(tr.followersStats)
+			tr.followersStats.Unlock()
+		}
+	}()
+
+	resp, ok := tr.sendSnapshotChunks(nil, "follower", ts.URL, req, b.Bytes())
+	assert.True(t, ok)
+	assert.Nil(t, resp)
+	assert.Nil(t, f.recovered)
+
+	f.failAt = -1
+	resp, ok = tr.sendSnapshotChunks(nil, "follower", ts.URL, req, b.Bytes())
+	assert.True(t, ok)
+	if assert.NotNil(t, resp) {
+		assert.True(t, resp.Success)
+		assert.Equal(t, resp.CommitIndex, uint64(20))
+	}
+	assert.Equal(t, f.offsets, []int64{0, 2 * SnapshotChunkSize})
+	tr.followersStats.Lock()
+	assert.Nil(t, tr.followersStats.Followers["follower"].Snapshot)
+	tr.followersStats.Unlock()
+	if assert.NotNil(t, f.recovered) {
+		assert.Equal(t, f.recovered.LastIndex, uint64(20))
+		assert.Equal(t, f.recovered.State, state)
+	}
+}
+
+// Ensures that peers without chunked snapshots are detected.
+func TestSendSnapshotChunksUnsupported(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	req := &raft.SnapshotRecoveryRequest{LeaderName: "leader", LastIndex: 20, LastTerm: 2}
+	_, ok := newSnapshotTransporter().sendSnapshotChunks(nil, "follower", ts.URL, req, []byte("foo"))
+	assert.False(t, ok)
+}
+
+// Ensures that a follower only accepts the chunks that follow the data it
+// received, with a valid checksum.
+func TestSnapshotReceiver(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "etcd-snapshot")
+	defer os.RemoveAll(dir)
+
+	var r snapshotReceiver
+	data := []byte("foobar")
+	id := fmt.Sprintf("2-20-%d-%08x", len(data), crc32.ChecksumIEEE(data))
+
+	_, err := r.write(dir, id, 0, data[:3], 0)
+	assert.Equal(t, err, errSnapshotChecksum)
+
+	received, err := r.write(dir, id, 0, data[:3], crc32.ChecksumIEEE(data[:3]))
+	assert.NoError(t, err)
+	assert.Equal(t, received, int64(3))
+
+	received, err = r.write(dir, id, 4, data[4:], crc32.ChecksumIEEE(data[4:]))
+	assert.Equal(t, err, errSnapshotOffset)
+	assert.Equal(t, received, int64(3))
+
+	_, err = r.open(dir, id)
+	assert.Equal(t, err, errSnapshotOffset)
+
+	received, err = r.write(dir, id, 3, data[3:], crc32.ChecksumIEEE(data[3:]))
+	assert.NoError(t, err)
+	assert.Equal(t, received, int64(6))
+
+	file, err := r.open(dir, id)
+	if assert.NoError(t, err) {
+		b, _ := ioutil.ReadAll(file)
+		file.Close()
+		assert.Equal(t, b, data)
+	}
+
+	// A new transfer discards the previous one.
+	other := fmt.Sprintf("3-30-%d-%08x", len(data), crc32.ChecksumIEEE(data))
+	_, err = r.write(dir, other, 0, data, crc32.ChecksumIEEE(data))
+	assert.NoError(t, err)
+	offset, err := r.offset(dir, id)
+	assert.NoError(t, err)
+	assert.Equal(t, offset, int64(0))
+
+	// IDs that are not exactly in the expected form are rejected.
+	_, err = r.write(dir, "../"+id, 0, data, crc32.ChecksumIEEE(data))
+	assert.Equal(t, err, errSnapshotOffset)
+}
+
+// Ensures that the rate limiter spaces out the sending of data.
+func TestRateLimiter(t *testing.T) {
+	var l rateLimiter
+	l.setRate(1000)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		l.wait(50)
+	}
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 200*time.Millisecond, fmt.Sprintf("elapsed %v", elapsed))
+	assert.True(t, elapsed < time.Second, fmt.Sprintf("elapsed %v", elapsed))
+
+	l.setRate(0)
+	start = time.Now()
+	l.wait(1000000)
+	assert.True(t, time.Since(start) < 10*time.Millisecond)
+}
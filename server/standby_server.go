@@ -288,9 +288,9 @@ func (s *StandbyServer) join(peer string) error {
 
 func (s *StandbyServer) fullPeerURL(urlStr string) string {
 	u, err := url.Parse(urlStr)
-	if err != nil {
-		log.Warnf("fail parsing url %v", u)
-		return urlStr
+	if err != nil || u.Host == "" {
+		// Peers are usually given as host:port without a scheme.
+		u = &url.URL{Host: urlStr}
 	}
 	u.Scheme = s.Config.PeerScheme
 	return u.String()
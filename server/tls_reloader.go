@@ -0,0 +1,285 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/coreos/etcd/log"
+	httpclient "github.com/coreos/etcd/third_party/github.com/mreiferson/go-httpclient"
+)
+
+const (
+	// TLSReloadCheckInterval is the time between checks of the TLS files
+	// for changes on disk.
+	TLSReloadCheckInterval = 10 * time.Second
+
+	// CertExpiryWarning is how long before the expiry of a certificate
+	// etcd starts to warn about it.
+	CertExpiryWarning = 7 * 24 * time.Hour
+
+	// CertExpiryWarningInterval is the time between warnings about a
+	// certificate close to its expiry.
+	CertExpiryWarningInterval = time.Hour
+)
+
+// TLSReloader holds the TLS configuration of a listener, and of the
+// connections made with the same certificate.
+// It reloads the certificate, key and CA files when they change on disk
+// or when the process receives SIGHUP, so that new handshakes use the new
+// material while established connections are left untouched.
+// The configuration is swapped as a whole rather than picked during the
+// handshake, which needs no more than the TLS package of Go 1.2.
+type TLSReloader struct {
+	name string
+	info TLSInfo
+
+	mutex    sync.RWMutex
+	config   *tls.Config
+	expiry   time.Time
+	modTimes map[string]time.Time
+	handlers []func()
+
+	nextWarning time.Time
+	closeChan   chan bool
+}
+
+// NewTLSReloader loads the files of the given TLSInfo.
+// The name is used to tell listeners apart in logs.
+func NewTLSReloader(name string, info *TLSInfo) (*TLSReloader, error) {
+	r := &TLSReloader{
+		name:      name,
+		info:      *info,
+		closeChan: make(chan bool),
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSServerReloader creates a TLSReloader based on TLSInfo and starts
+// watching its files. It returns nil if TLS is not configured.
+// If any error happens, this function will call log.Fatal
+func TLSServerReloader(name string, info *TLSInfo) *TLSReloader {
+	if info.KeyFile == "" || info.CertFile == "" {
+		return nil
+	}
+
+	r, err := NewTLSReloader(name, info)
+	if err != nil {
+		log.Fatal("TLS info error: ", err)
+	}
+	go r.run()
+	return r
+}
+
+// ServerConfig returns the latest loaded configuration for listeners.
+func (r *TLSReloader) ServerConfig() *tls.Config {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.config
+}
+
+// ClientConfig returns the latest loaded configuration for connections to
+// peers, which present the certificate and verify the peers with the CA.
+func (r *TLSReloader) ClientConfig() *tls.Config {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return &tls.Config{
+		Certificates: r.config.Certificates,
+		RootCAs:      r.config.RootCAs,
+	}
+}
+
+// OnReload registers a function to call after each reload, to make the
+// connections created afterwards use the new configuration.
+func (r *TLSReloader) OnReload(f func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.handlers = append(r.handlers, f)
+}
+
+// NewListener wraps a listener so that the connections it accepts do
+// their handshake with the latest loaded configuration.
+func (r *TLSReloader) NewListener(l net.Listener) net.Listener {
+	return &tlsReloadListener{Listener: l, reloader: r}
+}
+
+type tlsReloadListener struct {
+	net.Listener
+	reloader *TLSReloader
+}
+
+func (l *tlsReloadListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return tls.Server(c, l.reloader.ServerConfig()), nil
+}
+
+// NewTransport returns a RoundTripper that sends requests through a
+// transport created by newTransport for the latest client configuration,
+// and replaced by a new one after each reload.
+func (r *TLSReloader) NewTransport(newTransport func(*tls.Config) *httpclient.Transport) http.RoundTripper {
+	t := &tlsReloadTransport{transport: newTransport(r.ClientConfig())}
+	r.OnReload(func() {
+		t.set(newTransport(r.ClientConfig()))
+	})
+	return t
+}
+
+type tlsReloadTransport struct {
+	mutex     sync.RWMutex
+	transport *httpclient.Transport
+}
+
+func (t *tlsReloadTransport) get() *httpclient.Transport {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.transport
+}
+
+func (t *tlsReloadTransport) set(tr *httpclient.Transport) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.transport.CloseIdleConnections()
+	t.transport = tr
+}
+
+func (t *tlsReloadTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.get().RoundTrip(req)
+}
+
+// CancelRequest cancels a request sent through the current transport.
+func (t *tlsReloadTransport) CancelRequest(req *http.Request) {
+	t.get().CancelRequest(req)
+}
+
+// Expiry returns the expiry time of the current certificate.
+func (r *TLSReloader) Expiry() time.Time {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.expiry
+}
+
+// Reload loads the certificate, key and CA files again.
+// The current configuration is kept if any of them fails to load.
+func (r *TLSReloader) Reload() error {
+	modTimes := r.fileModTimes()
+
+	cfg, err := r.info.ServerConfig()
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cfg.Certificates[0].Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed parsing certificate %v: %v", r.info.CertFile, err)
+	}
+
+	r.mutex.Lock()
+	r.config = cfg
+	r.expiry = leaf.NotAfter
+	r.modTimes = modTimes
+	r.nextWarning = time.Time{}
+	handlers := r.handlers
+	r.mutex.Unlock()
+
+	for _, f := range handlers {
+		f()
+	}
+	return nil
+}
+
+// Stop stops watching the files.
+func (r *TLSReloader) Stop() {
+	close(r.closeChan)
+}
+
+// run reloads the files when they change or on SIGHUP, and warns about
+// the coming expiry of the certificate.
+func (r *TLSReloader) run() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(TLSReloadCheckInterval)
+	defer ticker.Stop()
+
+	r.checkExpiry()
+	for {
+		select {
+		case <-r.closeChan:
+			return
+		case <-hup:
+			r.reload("SIGHUP")
+		case <-ticker.C:
+			if r.changed() {
+				r.reload("file change")
+			}
+		}
+		r.checkExpiry()
+	}
+}
+
+func (r *TLSReloader) reload(reason string) {
+	if err := r.Reload(); err != nil {
+		log.Warnf("%s TLS: failed reloading certificates on %s: %v", r.name, reason, err)
+		return
+	}
+	log.Infof("%s TLS: reloaded certificates on %s [cert %s, expires %v]", r.name, reason, r.info.CertFile, r.Expiry())
+}
+
+// changed checks whether any of the files has been modified since the last load.
+func (r *TLSReloader) changed() bool {
+	modTimes := r.fileModTimes()
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for file, t := range modTimes {
+		if !t.Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *TLSReloader) fileModTimes() map[string]time.Time {
+	modTimes := make(map[string]time.Time)
+	for _, file := range []string{r.info.CertFile, r.info.KeyFile, r.info.CAFile} {
+		if file == "" {
+			continue
+		}
+		if fi, err := os.Stat(file); err == nil {
+			modTimes[file] = fi.ModTime()
+		}
+	}
+	return modTimes
+}
+
+// checkExpiry logs a warning if the certificate expires soon.
+func (r *TLSReloader) checkExpiry() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	left := r.expiry.Sub(now)
+	if left > CertExpiryWarning || now.Before(r.nextWarning) {
+		return
+	}
+	r.nextWarning = now.Add(CertExpiryWarningInterval)
+
+	if left <= 0 {
+		log.Warnf("%s TLS: certificate %s expired at %v", r.name, r.info.CertFile, r.expiry)
+	} else {
+		log.Warnf("%s TLS: certificate %s expires in %v at %v", r.name, r.info.CertFile, left, r.expiry)
+	}
+}
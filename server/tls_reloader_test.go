@@ -0,0 +1,126 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreos/etcd/third_party/github.com/stretchr/testify/assert"
+)
+
+// Ensures that the client configuration of a reloader presents the
+// certificate loaded last.
+func TestTLSReloaderClientConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcd-tls-reloader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	info := &TLSInfo{
+		CertFile: filepath.Join(dir, "server.crt"),
+		KeyFile:  filepath.Join(dir, "server.key"),
+		CAFile:   "../fixtures/ca/ca.crt",
+	}
+	copyTestFile(t, "../fixtures/ca/server.crt", info.CertFile)
+	copyTestFile(t, "../fixtures/ca/server.key.insecure", info.KeyFile)
+
+	r, err := NewTLSReloader("peer", info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := r.ClientConfig()
+	assert.NotNil(t, cfg.RootCAs)
+	assert.Equal(t, certOU(t, cfg.Certificates[0]), "server")
+
+	reloaded := false
+	r.OnReload(func() { reloaded = true })
+
+	copyTestFile(t, "../fixtures/ca/server2.crt", info.CertFile)
+	copyTestFile(t, "../fixtures/ca/server2.key.insecure", info.KeyFile)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, reloaded)
+	assert.Equal(t, certOU(t, r.ClientConfig().Certificates[0]), "server2")
+	// Configurations handed out before are left untouched.
+	assert.Equal(t, certOU(t, cfg.Certificates[0]), "server")
+}
+
+// Ensures that the listener of a reloader does the handshakes of new
+// connections with the certificate loaded last.
+func TestTLSReloaderListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcd-tls-reloader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	info := &TLSInfo{
+		CertFile: filepath.Join(dir, "server.crt"),
+		KeyFile:  filepath.Join(dir, "server.key"),
+	}
+	copyTestFile(t, "../fixtures/ca/server.crt", info.CertFile)
+	copyTestFile(t, "../fixtures/ca/server.key.insecure", info.KeyFile)
+
+	r, err := NewTLSReloader("client", info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l = r.NewListener(l)
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.(*tls.Conn).Handshake()
+			c.Close()
+		}
+	}()
+
+	assert.Equal(t, serverCertOU(t, l.Addr().String()), "server")
+
+	copyTestFile(t, "../fixtures/ca/server2.crt", info.CertFile)
+	copyTestFile(t, "../fixtures/ca/server2.key.insecure", info.KeyFile)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, serverCertOU(t, l.Addr().String()), "server2")
+}
+
+func certOU(t *testing.T, cert tls.Certificate) string {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.OrganizationalUnit[0]
+}
+
+func serverCertOU(t *testing.T, addr string) string {
+	c, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	return c.ConnectionState().PeerCertificates[0].Subject.OrganizationalUnit[0]
+}
+
+func copyTestFile(t *testing.T, src, dst string) {
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dst, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -5,8 +5,10 @@ import (
 	"crypto/tls"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/coreos/etcd/log"
@@ -28,6 +30,20 @@ type transporter struct {
 	transport         *httpclient.Transport
 	snapshotClient    *http.Client
 	snapshotTransport *httpclient.Transport
+
+	// mutex guards the clients and transports, which are replaced when
+	// the raft timeouts or the TLS configuration change.
+	mutex sync.RWMutex
+
+	// streams are the open streams to the peers, by name. noStreams holds
+	// when to try again to open a stream to the peers that don't support
+	// them. Both are guarded by streamMutex.
+	streams     map[string]*peerStream
+	noStreams   map[string]time.Time
+	streamMutex sync.Mutex
+
+	// snapshotLimiter limits the rate of the snapshots sent to peers.
+	snapshotLimiter rateLimiter
 }
 
 type dialer func(network, addr string) (net.Conn, error)
@@ -36,15 +52,7 @@ type dialer func(network, addr string) (net.Conn, error)
 // Create http or https transporter based on
 // whether the user give the server cert and key
 func NewTransporter(followersStats *raftFollowersStats, serverStats *raftServerStats, registry *Registry, dialTimeout, requestTimeout, responseHeaderTimeout time.Duration) *transporter {
-	tr := &httpclient.Transport{
-		ResponseHeaderTimeout: responseHeaderTimeout,
-		// This is a workaround for Transport.CancelRequest doesn't work on
-		// HTTPS connections blocked. The patch for it is in progress,
-		// and would be available in Go1.3
-		// More: https://codereview.appspot.com/69280043/
-		ConnectTimeout: dialTimeout,
-		RequestTimeout: requestTimeout,
-	}
+	tr := newRaftTransport(dialTimeout, requestTimeout, responseHeaderTimeout)
 
 	// Sending snapshot might take a long time so we use a different HTTP transporter
 	// Timeout is set to 120s (Around 100MB if the bandwidth is 10Mbits/s)
@@ -65,17 +73,67 @@ func NewTransporter(followersStats *raftFollowersStats, serverStats *raftServerS
 		followersStats:    followersStats,
 		serverStats:       serverStats,
 		registry:          registry,
+		streams:           make(map[string]*peerStream),
+		noStreams:         make(map[string]time.Time),
 	}
+	t.snapshotLimiter.setRate(DefaultSnapshotRate * 1024)
 
 	return &t
 }
 
+func newRaftTransport(dialTimeout, requestTimeout, responseHeaderTimeout time.Duration) *httpclient.Transport {
+	return &httpclient.Transport{
+		ResponseHeaderTimeout: responseHeaderTimeout,
+		// This is a workaround for Transport.CancelRequest doesn't work on
+		// HTTPS connections blocked. The patch for it is in progress,
+		// and would be available in Go1.3
+		// More: https://codereview.appspot.com/69280043/
+		ConnectTimeout: dialTimeout,
+		RequestTimeout: requestTimeout,
+	}
+}
+
+// SetTimeouts replaces the transport used for raft messages with one
+// using the given timeouts. Requests in flight keep their old timeouts.
+func (t *transporter) SetTimeouts(dialTimeout, requestTimeout, responseHeaderTimeout time.Duration) {
+	tr := newRaftTransport(dialTimeout, requestTimeout, responseHeaderTimeout)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	tr.TLSClientConfig = t.transport.TLSClientConfig
+	tr.DisableCompression = t.transport.DisableCompression
+	t.transport.CloseIdleConnections()
+	t.transport = tr
+	t.client = &http.Client{Transport: tr}
+}
+
+// SetSnapshotRate limits the rate of the snapshots sent to peers to the
+// given number of bytes per second. A rate of zero removes the limit.
+func (t *transporter) SetSnapshotRate(rate int64) {
+	t.snapshotLimiter.setRate(rate)
+}
+
+// SetTLSConfig replaces the transports to peers with ones using the given
+// TLS configuration. Requests in flight keep their old configuration.
 func (t *transporter) SetTLSConfig(tlsConf tls.Config) {
-	t.transport.TLSClientConfig = &tlsConf
-	t.transport.DisableCompression = true
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	tr := newRaftTransport(t.transport.ConnectTimeout, t.transport.RequestTimeout, t.transport.ResponseHeaderTimeout)
+	tr.TLSClientConfig = &tlsConf
+	tr.DisableCompression = true
+	t.transport.CloseIdleConnections()
+	t.transport = tr
+	t.client = &http.Client{Transport: tr}
 
-	t.snapshotTransport.TLSClientConfig = &tlsConf
-	t.snapshotTransport.DisableCompression = true
+	sTr := &httpclient.Transport{
+		ConnectTimeout:     t.snapshotTransport.ConnectTimeout,
+		RequestTimeout:     snapshotTimeout,
+		TLSClientConfig:    &tlsConf,
+		DisableCompression: true,
+	}
+	t.snapshotTransport.CloseIdleConnections()
+	t.snapshotTransport = sTr
+	t.snapshotClient = &http.Client{Transport: sTr}
 }
 
 // Sends AppendEntries RPCs to a peer when the server is the leader.
@@ -95,6 +153,7 @@ func (t *transporter) SendAppendEntriesRequest(server raft.Server, peer *raft.Pe
 
 	log.Debugf("Send LogEntries to %s ", u)
 
+	t.followersStats.Lock()
 	thisFollowerStats, ok := t.followersStats.Followers[peer.Name]
 
 	if !ok { //this is the first time this follower has been seen
@@ -102,24 +161,45 @@ func (t *transporter) SendAppendEntriesRequest(server raft.Server, peer *raft.Pe
 		thisFollowerStats.Latency.Minimum = 1 << 63
 		t.followersStats.Followers[peer.Name] = thisFollowerStats
 	}
+	t.followersStats.Unlock()
 
 	start := time.Now()
 
-	resp, _, err := t.Post(fmt.Sprintf("%s/log/append", u), &b)
+	// Prefer the stream to the peer, which pipelines large requests.
+	if aeresp, acked, streamed := t.sendAppendEntriesStream(server, peer, u, req); streamed {
+		if acked < len(req.Entries) && aeresp != nil && aeresp.Success() {
+			log.Debugf("%s appended %d of %d entries", peer.Name, acked, len(req.Entries))
+		}
+		t.followersStats.Lock()
+		if ok && aeresp != nil {
+			thisFollowerStats.Succ(time.Now().Sub(start))
+		} else if ok {
+			thisFollowerStats.Fail()
+		}
+		t.followersStats.Unlock()
+		return aeresp
+	}
+
+	resp, err := t.post(server, fmt.Sprintf("%s/log/append", u), &b)
 
 	end := time.Now()
 
+	t.followersStats.Lock()
 	if err != nil {
-		log.Debugf("Cannot send AppendEntriesRequest to %s: %s", u, err)
 		if ok {
 			thisFollowerStats.Fail()
 		}
-		return nil
 	} else {
 		if ok {
 			thisFollowerStats.Succ(end.Sub(start))
 		}
 	}
+	t.followersStats.Unlock()
+
+	if err != nil {
+		log.Debugf("Cannot send AppendEntriesRequest to %s: %s", u, err)
+		return nil
+	}
 
 	if resp != nil {
 		defer resp.Body.Close()
@@ -147,7 +227,7 @@ func (t *transporter) SendVoteRequest(server raft.Server, peer *raft.Peer, req *
 	u, _ := t.registry.PeerURL(peer.Name)
 	log.Debugf("Send Vote from %s to %s", server.Name(), u)
 
-	resp, _, err := t.Post(fmt.Sprintf("%s/vote", u), &b)
+	resp, err := t.post(server, fmt.Sprintf("%s/vote", u), &b)
 
 	if err != nil {
 		log.Debugf("Cannot send VoteRequest to %s : %s", u, err)
@@ -178,7 +258,7 @@ func (t *transporter) SendSnapshotRequest(server raft.Server, peer *raft.Peer, r
 	u, _ := t.registry.PeerURL(peer.Name)
 	log.Debugf("Send Snapshot Request from %s to %s", server.Name(), u)
 
-	resp, _, err := t.Post(fmt.Sprintf("%s/snapshot", u), &b)
+	resp, err := t.post(server, fmt.Sprintf("%s/snapshot", u), &b)
 
 	if err != nil {
 		log.Debugf("Cannot send Snapshot Request to %s : %s", u, err)
@@ -209,7 +289,12 @@ func (t *transporter) SendSnapshotRecoveryRequest(server raft.Server, peer *raft
 	u, _ := t.registry.PeerURL(peer.Name)
 	log.Debugf("Send Snapshot Recovery from %s to %s", server.Name(), u)
 
-	resp, err := t.PostSnapshot(fmt.Sprintf("%s/snapshotRecovery", u), &b)
+	// Prefer sending the snapshot in chunks, which resumes after failures.
+	if ssrrsp, chunked := t.sendSnapshotChunks(server, peer.Name, u, req, b.Bytes()); chunked {
+		return ssrrsp
+	}
+
+	resp, err := t.PostSnapshot(server, fmt.Sprintf("%s/snapshotRecovery", u), &b)
 
 	if err != nil {
 		log.Debugf("Cannot send Snapshot Recovery to %s : %s", u, err)
@@ -229,29 +314,86 @@ func (t *transporter) SendSnapshotRecoveryRequest(server raft.Server, peer *raft
 
 }
 
+// httpClient returns the client used for raft messages.
+func (t *transporter) httpClient() *http.Client {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.client
+}
+
+// snapshotHTTPClient returns the client used for snapshots.
+func (t *transporter) snapshotHTTPClient() *http.Client {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.snapshotClient
+}
+
 // Send server side POST request
 func (t *transporter) Post(urlStr string, body io.Reader) (*http.Response, *http.Request, error) {
 	req, _ := http.NewRequest("POST", urlStr, body)
-	resp, err := t.client.Do(req)
+	resp, err := t.httpClient().Do(req)
 	return resp, req, err
 }
 
 // Send server side GET request
 func (t *transporter) Get(urlStr string) (*http.Response, *http.Request, error) {
 	req, _ := http.NewRequest("GET", urlStr, nil)
-	resp, err := t.client.Do(req)
+	resp, err := t.httpClient().Do(req)
 	return resp, req, err
 }
 
 // Send server side PUT request
 func (t *transporter) Put(urlStr string, body io.Reader) (*http.Response, *http.Request, error) {
 	req, _ := http.NewRequest("PUT", urlStr, body)
-	resp, err := t.client.Do(req)
+	resp, err := t.httpClient().Do(req)
 	return resp, req, err
 }
 
 // PostSnapshot posts a json format snapshot to the given url
 // The underlying HTTP transport has a minute level timeout
-func (t *transporter) PostSnapshot(url string, body io.Reader) (*http.Response, error) {
-	return t.snapshotClient.Post(url, "application/json", body)
+func (t *transporter) PostSnapshot(server raft.Server, url string, body io.Reader) (*http.Response, error) {
+	req, err := newPeerRequest(server, "POST", url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return checkPeerResponse(t.snapshotHTTPClient().Do(req))
+}
+
+// post sends a raft message to a peer.
+func (t *transporter) post(server raft.Server, urlStr string, body io.Reader) (*http.Response, error) {
+	req, err := newPeerRequest(server, "POST", urlStr, body)
+	if err != nil {
+		return nil, err
+	}
+	return checkPeerResponse(t.httpClient().Do(req))
+}
+
+// newPeerRequest creates a request to a peer that carries the ID of the
+// cluster of the raft server, so that the peers of other clusters reject it.
+func newPeerRequest(server raft.Server, method, urlStr string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, urlStr, body)
+	if err != nil {
+		return nil, err
+	}
+	if server == nil {
+		return req, nil
+	}
+	if ps, ok := server.Context().(*PeerServer); ok {
+		if id := ps.ClusterID(); id != "" {
+			req.Header.Set(ClusterIDHeader, id)
+		}
+	}
+	return req, nil
+}
+
+// checkPeerResponse turns the error responses of a peer, such as the
+// rejection of a request from another cluster, into errors.
+func checkPeerResponse(resp *http.Response, err error) (*http.Response, error) {
+	if err != nil || resp.StatusCode == http.StatusOK {
+		return resp, err
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	return nil, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(b))
 }
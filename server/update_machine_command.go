@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+
+	etcdErr "github.com/coreos/etcd/error"
+	"github.com/coreos/etcd/log"
+	"github.com/coreos/etcd/third_party/github.com/goraft/raft"
+)
+
+func init() {
+	raft.RegisterCommand(&UpdateMachineCommand{})
+}
+
+// UpdateMachineCommand changes the peer and client URLs of an existing machine.
+// An empty URL leaves the current value untouched.
+// The command returns the JSON-encoded machine message.
+type UpdateMachineCommand struct {
+	Name      string `json:"name"`
+	PeerURL   string `json:"peerURL"`
+	ClientURL string `json:"clientURL"`
+}
+
+// CommandName returns the name of the command in the log.
+func (c *UpdateMachineCommand) CommandName() string {
+	return "etcd:updateMachine"
+}
+
+// Apply updates the URLs of the machine in the registry.
+func (c *UpdateMachineCommand) Apply(context raft.Context) (interface{}, error) {
+	ps, _ := context.Server().Context().(*PeerServer)
+
+	// Make sure we're not getting a cached value from the registry.
+	ps.registry.Invalidate(c.Name)
+
+	peerURL, ok := ps.registry.PeerURL(c.Name)
+	if !ok {
+		return nil, etcdErr.NewError(etcdErr.EcodeMachineNotFound, c.Name, context.CommitIndex())
+	}
+	clientURL, _ := ps.registry.ClientURL(c.Name)
+
+	if c.PeerURL != "" && c.PeerURL != peerURL {
+		// The new peer URL must not collide with another machine.
+		for _, name := range ps.registry.Names() {
+			if u, _ := ps.registry.PeerURL(name); name != c.Name && u == c.PeerURL {
+				log.Warnf("%v cannot move to existing peer URL %v of %v", c.Name, c.PeerURL, name)
+				return nil, etcdErr.NewError(etcdErr.EcodeExistingPeerAddr, c.PeerURL, context.CommitIndex())
+			}
+		}
+		peerURL = c.PeerURL
+	}
+	if c.ClientURL != "" {
+		clientURL = c.ClientURL
+	}
+
+	log.Infof("Update URLs of %v to peer %v, client %v", c.Name, peerURL, clientURL)
+	if err := ps.registry.UpdateURLs(c.Name, peerURL, clientURL); err != nil {
+		log.Debugf("Error while updating in registry: %s (%v)", c.Name, err)
+		return nil, err
+	}
+	// Flush commit index, so raft will replay to here when restart
+	ps.raftServer.FlushCommitIndex()
+
+	return json.Marshal(ps.getMachineMessage(c.Name, ps.raftServer.Leader()))
+}
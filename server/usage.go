@@ -11,6 +11,8 @@ etcd
 Usage:
   etcd -name <name>
   etcd -name <name> [-data-dir=<path>]
+  etcd repair -data-dir=<path>
+  etcd inspect -data-dir=<path> [-snapshots] [-log] [-keys=<prefix> [-snapshot=<path>]]
   etcd -h | -help
   etcd -version
 
@@ -25,8 +27,27 @@ Options:
   -v                Enabled verbose logging.
   -vv               Enabled very verbose logging.
 
+Repair:
+  etcd repair drops the torn tail of the raft log in a data directory, as
+  left by a power loss, and reports the indexes it dropped. It refuses to
+  change a log that is damaged before its tail. Stop etcd first.
+
+Inspect:
+  etcd inspect prints what is in a data directory without starting etcd.
+  -snapshots         List the snapshots with their index, term and size.
+                     This is the default.
+  -log               Dump the raft log entries as JSON lines, with their
+                     commands decoded.
+  -keys=<prefix>     Print the keys under the prefix in the latest snapshot,
+                     as JSON lines.
+  -snapshot=<path>   Snapshot file to read the keys from instead.
+
 Cluster Configuration Options:
   -discovery=<url>                Discovery service used to find a peer list.
+  -discovery-service              Serve a discovery service for other clusters.
+  -discovery-srv=<domain>         Domain whose SRV records list the peers.
+  -discovery-timeout=<seconds>    Time to wait for the expected members of a
+                                  new cluster during discovery.
   -peers-file=<path>              Path to a file containing the peer list.
   -peers=<host:port>,<host:port>  Comma-separated list of peers. The members
                                   should match the peer's '-peer-addr' flag.
@@ -34,9 +55,13 @@ Cluster Configuration Options:
 Client Communication Options:
   -addr=<host:port>         The public host:port used for client communication.
   -bind-addr=<host[:port]>  The listening host:port used for client communication.
+  -listen-urls=<url>,<url>  Comma-separated list of additional client listen
+                            URLs, e.g. unix:///var/run/etcd.sock.
   -ca-file=<path>           Path to the client CA file.
   -cert-file=<path>         Path to the client cert file.
   -key-file=<path>          Path to the client key file.
+  -client-auth-file=<path>  Path to the file mapping client certificate
+                            identities to key space permissions.
 
 Peer Communication Options:
   -peer-addr=<host:port>  The public host:port used for peer communication.
@@ -48,6 +73,9 @@ Peer Communication Options:
                           Time (in milliseconds) of a heartbeat interval.
   -peer-election-timeout=<time>
                           Time (in milliseconds) for an election to timeout.
+  -peer-snapshot-rate=<rate>
+                          Rate limit (in KB per second) of the snapshots sent
+                          to followers. 0 means no limit.
 
 Other Options:
   -max-result-buffer   Max size of the result buffer.
@@ -55,6 +83,9 @@ Other Options:
   -retry-interval      Seconds to wait between cluster join retry attempts.
   -snapshot=false      Disable log snapshots
   -snapshot-count      Number of transactions before issuing a snapshot.
+  -snapshot-log-size   Size (in MB) of the log since the last snapshot before issuing a snapshot.
+  -snapshot-interval   Seconds before issuing a snapshot of new transactions.
+  -snapshot-retention  Number of snapshot files to keep.
   -cluster-active-size Number of active nodes in the cluster.
   -cluster-remove-delay Seconds before one node is removed.
   -cluster-sync-interval Seconds between synchronizations for standby mode.
@@ -73,6 +73,9 @@ func handleWatch(key string, recursive, stream bool, waitIndex string, w http.Re
 	cn, _ := w.(http.CloseNotifier)
 	closeChan := cn.CloseNotify()
 
+	w, done := gzipWriter(w, req)
+	defer done()
+
 	writeHeaders(w, s)
 	w.(http.Flusher).Flush()
 
@@ -118,8 +121,10 @@ func handleWatch(key string, recursive, stream bool, waitIndex string, w http.Re
 	return nil
 }
 
+// handleGet writes the event of the get as the nodes are walked, so that
+// big directories are neither built in memory nor marshalled first.
 func handleGet(key string, recursive, sort bool, w http.ResponseWriter, req *http.Request, s Server) error {
-	event, err := s.Store().Get(key, recursive, sort)
+	encoder, err := s.Store().GetEncoder(key, recursive, sort)
 	if err != nil {
 		return err
 	}
@@ -128,9 +133,11 @@ func handleGet(key string, recursive, sort bool, w http.ResponseWriter, req *htt
 		return nil
 	}
 
+	w, done := gzipWriter(w, req)
+	defer done()
+
 	writeHeaders(w, s)
-	b, _ := json.Marshal(event)
-	w.Write(b)
+	encoder.Encode(w)
 	return nil
 }
 
@@ -1,7 +1,10 @@
 package v2
 
 import (
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"testing"
@@ -263,3 +266,88 @@ func TestV2HeadKey(t *testing.T) {
 		assert.Equal(t, resp.ContentLength, -1)
 	})
 }
+
+// Ensures that a value is compressed for a client that accepts gzip.
+//
+//   $ curl -X PUT localhost:4001/v2/keys/foo/bar -d value=XXX
+//   $ curl -H "Accept-Encoding: gzip" localhost:4001/v2/keys/foo?recursive=true
+//
+func TestV2GetKeyGzip(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		v := url.Values{}
+		v.Set("value", "XXX")
+		resp, _ := tests.PutForm(fmt.Sprintf("%s%s", s.URL(), "/v2/keys/foo/bar"), v)
+		tests.ReadBody(resp)
+
+		resp, _ = getGzip(fmt.Sprintf("%s%s", s.URL(), "/v2/keys/foo?recursive=true"))
+		assert.Equal(t, resp.StatusCode, http.StatusOK)
+		assert.Equal(t, resp.Header.Get("Content-Encoding"), "gzip")
+		assert.Equal(t, resp.Header.Get("Content-Type"), "application/json")
+		body := readGzipJSON(t, resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, body["action"], "get", "")
+		node := body["node"].(map[string]interface{})
+		assert.Equal(t, node["key"], "/foo", "")
+		node0 := node["nodes"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, node0["key"], "/foo/bar", "")
+		assert.Equal(t, node0["value"], "XXX", "")
+	})
+}
+
+// Ensures that the events of a streaming watch are compressed, and sent
+// as they happen, for a client that accepts gzip.
+//
+//   $ curl -H "Accept-Encoding: gzip" localhost:4001/v2/keys/foo/bar?wait=true&stream=true
+//   $ curl -X PUT localhost:4001/v2/keys/foo/bar -d value=XXX
+//
+func TestV2WatchKeyStreamGzip(t *testing.T) {
+	tests.RunServer(func(s *server.Server) {
+		resp, err := getGzip(fmt.Sprintf("%s%s", s.URL(), "/v2/keys/foo/bar?wait=true&stream=true"))
+		assert.Nil(t, err, "")
+		defer resp.Body.Close()
+		assert.Equal(t, resp.Header.Get("Content-Encoding"), "gzip")
+
+		c := make(chan map[string]interface{})
+		go func() {
+			c <- readGzipJSON(t, resp.Body)
+		}()
+
+		v := url.Values{}
+		v.Set("value", "XXX")
+		r, _ := tests.PutForm(fmt.Sprintf("%s%s", s.URL(), "/v2/keys/foo/bar"), v)
+		tests.ReadBody(r)
+
+		select {
+		case body := <-c:
+			assert.Equal(t, body["action"], "set", "")
+			node := body["node"].(map[string]interface{})
+			assert.Equal(t, node["value"], "XXX", "")
+		case <-time.After(time.Second):
+			t.Fatal("cannot get watch result")
+		}
+	})
+}
+
+func getGzip(url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Setting the header keeps the client from decompressing the body.
+	req.Header.Set("Accept-Encoding", "gzip")
+	return tests.NewHTTPClient().Do(req)
+}
+
+// readGzipJSON reads the first JSON value of a compressed body.
+func readGzipJSON(t *testing.T, r io.Reader) map[string]interface{} {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	var m map[string]interface{}
+	if err := json.NewDecoder(gz).Decode(&m); err != nil {
+		t.Error(err)
+	}
+	return m
+}
@@ -0,0 +1,336 @@
+/*
+Copyright 2014 CoreOS Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"sort"
+)
+
+const (
+	// btreeMaxEntries is the maximum number of entries of a B-tree node.
+	btreeMaxEntries = 32
+
+	// btreeMinEntries is the number of entries under which a B-tree node
+	// is merged with a neighbour.
+	btreeMinEntries = btreeMaxEntries / 4
+)
+
+// children holds the nodes under a directory, by name. It is a B-tree
+// whose parts are shared between the versions of a directory, so that
+// copying a directory doesn't copy its children. The parts of the B-tree
+// created in the current generation of the store are changed in place,
+// the others are copied.
+type children struct {
+	root *btree
+	size int
+}
+
+// A btree is a node of the B-tree of children. A leaf holds the nodes in
+// the order of their names. An inner node holds its subtrees in order,
+// along with names that are lower than or equal to the names in them.
+type btree struct {
+	gen   uint64
+	nodes []*node // for a leaf
+
+	// for an inner node
+	names []string
+	subs  []*btree
+}
+
+func newChildren() *children {
+	return &children{}
+}
+
+// copy returns a version of the children that shares the B-tree.
+func (c *children) copy() *children {
+	cc := *c
+	return &cc
+}
+
+func (c *children) len() int {
+	return c.size
+}
+
+// get returns the node with the given name, or nil.
+func (c *children) get(name string) *node {
+	t := c.root
+	if t == nil {
+		return nil
+	}
+	for t.subs != nil {
+		t = t.subs[t.sub(name)]
+	}
+	i := t.search(name)
+	if i < len(t.nodes) && t.nodes[i].name == name {
+		return t.nodes[i]
+	}
+	return nil
+}
+
+// set puts a node under its name, replacing the node that has it.
+func (c *children) set(gen uint64, n *node) {
+	if c.root == nil {
+		c.root = &btree{gen: gen}
+	} else {
+		c.root = c.root.mutable(gen)
+	}
+
+	added, split := c.root.set(gen, n)
+	if added {
+		c.size++
+	}
+	if split != nil {
+		c.root = &btree{
+			gen:   gen,
+			names: []string{c.root.first(), split.first()},
+			subs:  []*btree{c.root, split},
+		}
+	}
+}
+
+// remove removes the node with the given name, if any.
+func (c *children) remove(gen uint64, name string) {
+	if c.get(name) == nil {
+		return
+	}
+
+	c.size--
+	if c.size == 0 {
+		c.root = nil
+		return
+	}
+
+	c.root = c.root.mutable(gen)
+	c.root.remove(gen, name)
+	for c.root.subs != nil && len(c.root.subs) == 1 {
+		c.root = c.root.subs[0]
+	}
+}
+
+// each calls f on every node, in the order of their names.
+func (c *children) each(f func(n *node)) {
+	if c.root != nil {
+		c.root.each(f)
+	}
+}
+
+// search returns the position of the given name in the nodes of a leaf,
+// or where it would be inserted.
+func (t *btree) search(name string) int {
+	i, j := 0, len(t.nodes)
+	for i < j {
+		h := int(uint(i+j) >> 1)
+		if t.nodes[h].name < name {
+			i = h + 1
+		} else {
+			j = h
+		}
+	}
+	return i
+}
+
+// first returns the lowest name in a B-tree node.
+func (t *btree) first() string {
+	if t.subs != nil {
+		return t.names[0]
+	}
+	return t.nodes[0].name
+}
+
+// sub returns the position of the subtree of an inner node that holds the
+// given name.
+func (t *btree) sub(name string) int {
+	i := sort.SearchStrings(t.names, name)
+	if i < len(t.names) && t.names[i] == name {
+		return i
+	}
+	if i > 0 {
+		i--
+	}
+	return i
+}
+
+// mutable returns a version of the B-tree node that can be changed in the
+// given generation.
+func (t *btree) mutable(gen uint64) *btree {
+	if t.gen == gen {
+		return t
+	}
+
+	c := &btree{gen: gen}
+	if t.subs != nil {
+		c.names = make([]string, len(t.names), len(t.names)+1)
+		copy(c.names, t.names)
+		c.subs = make([]*btree, len(t.subs), len(t.subs)+1)
+		copy(c.subs, t.subs)
+	} else {
+		c.nodes = make([]*node, len(t.nodes), len(t.nodes)+1)
+		copy(c.nodes, t.nodes)
+	}
+	return c
+}
+
+// set puts a node in a B-tree node that can be changed. It returns whether
+// the node was added rather than replaced, and the new B-tree node that
+// holds the upper half of the entries if it had to be split.
+func (t *btree) set(gen uint64, n *node) (bool, *btree) {
+	var added bool
+
+	// last tells whether the entry went last, as when names are added in
+	// order.
+	var last bool
+
+	if t.subs == nil {
+		i := t.search(n.name)
+		if i < len(t.nodes) && t.nodes[i].name == n.name {
+			t.nodes[i] = n
+			return false, nil
+		}
+		if len(t.nodes) == cap(t.nodes) {
+			// Grow slower than append does, as leaves hold most of
+			// the entries.
+			c := len(t.nodes) + len(t.nodes)/2 + 1
+			if c > btreeMaxEntries+1 {
+				c = btreeMaxEntries + 1
+			}
+			nodes := make([]*node, len(t.nodes), c)
+			copy(nodes, t.nodes)
+			t.nodes = nodes
+		}
+		t.nodes = append(t.nodes, nil)
+		copy(t.nodes[i+1:], t.nodes[i:])
+		t.nodes[i] = n
+		added = true
+		last = i == len(t.nodes)-1
+
+	} else {
+		i := t.sub(n.name)
+		sub := t.subs[i].mutable(gen)
+		t.subs[i] = sub
+		if n.name < t.names[i] {
+			t.names[i] = n.name
+		}
+
+		var split *btree
+		added, split = sub.set(gen, n)
+		if split != nil {
+			t.names = append(t.names, "")
+			copy(t.names[i+2:], t.names[i+1:])
+			t.names[i+1] = split.first()
+			t.subs = append(t.subs, nil)
+			copy(t.subs[i+2:], t.subs[i+1:])
+			t.subs[i+1] = split
+			last = i+1 == len(t.subs)-1
+		}
+	}
+
+	if t.len() <= btreeMaxEntries {
+		return added, nil
+	}
+
+	// Split in halves, or leave the node full if the entries come in
+	// order, as it would only be half full forever.
+	h := t.len() / 2
+	if last {
+		h = t.len() - 1
+	}
+	split := &btree{gen: gen}
+	if t.subs != nil {
+		split.names = append([]string(nil), t.names[h:]...)
+		t.names = t.names[:h:h]
+		split.subs = append([]*btree(nil), t.subs[h:]...)
+		t.subs = t.subs[:h:h]
+	} else {
+		split.nodes = append([]*node(nil), t.nodes[h:]...)
+		t.nodes = append([]*node(nil), t.nodes[:h]...)
+	}
+	return added, split
+}
+
+// remove removes a node from a B-tree node that can be changed and holds
+// the node.
+func (t *btree) remove(gen uint64, name string) {
+	if t.subs == nil {
+		i := t.search(name)
+		last := len(t.nodes) - 1
+		copy(t.nodes[i:], t.nodes[i+1:])
+		t.nodes[last] = nil
+		t.nodes = t.nodes[:last]
+		return
+	}
+
+	i := t.sub(name)
+	sub := t.subs[i].mutable(gen)
+	t.subs[i] = sub
+	sub.remove(gen, name)
+
+	switch {
+	case sub.len() == 0:
+		t.removeSub(i)
+	case sub.len() < btreeMinEntries:
+		// Merge the subtree with a neighbour that has room for it.
+		if i > 0 && t.subs[i-1].len()+sub.len() <= btreeMaxEntries {
+			t.merge(gen, i-1)
+		} else if i+1 < len(t.subs) && t.subs[i+1].len()+sub.len() <= btreeMaxEntries {
+			t.merge(gen, i)
+		}
+	}
+}
+
+// merge moves the entries of the subtree after the one at i into it.
+func (t *btree) merge(gen uint64, i int) {
+	left := t.subs[i].mutable(gen)
+	right := t.subs[i+1]
+	if left.subs != nil {
+		left.names = append(left.names, right.names...)
+		left.subs = append(left.subs, right.subs...)
+	} else {
+		left.nodes = append(left.nodes, right.nodes...)
+	}
+	t.subs[i] = left
+	t.removeSub(i + 1)
+}
+
+// removeSub removes the subtree at i from an inner node.
+func (t *btree) removeSub(i int) {
+	last := len(t.subs) - 1
+	copy(t.names[i:], t.names[i+1:])
+	t.names = t.names[:last]
+	copy(t.subs[i:], t.subs[i+1:])
+	t.subs[last] = nil
+	t.subs = t.subs[:last]
+}
+
+// len returns the number of entries of a B-tree node.
+func (t *btree) len() int {
+	if t.subs != nil {
+		return len(t.subs)
+	}
+	return len(t.nodes)
+}
+
+func (t *btree) each(f func(n *node)) {
+	if t.subs != nil {
+		for _, sub := range t.subs {
+			sub.each(f)
+		}
+		return
+	}
+	for _, n := range t.nodes {
+		f(n)
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2014 CoreOS Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/coreos/etcd/third_party/github.com/stretchr/testify/assert"
+)
+
+// Ensure that the children hold the same nodes as a map, in name order.
+func TestChildren(t *testing.T) {
+	c := newChildren()
+	m := make(map[string]*node)
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 20000; i++ {
+		name := strconv.Itoa(r.Intn(2000))
+		if r.Intn(3) == 0 {
+			c.remove(0, name)
+			delete(m, name)
+		} else {
+			n := &node{name: name}
+			c.set(0, n)
+			m[name] = n
+		}
+	}
+	checkChildren(t, c, m)
+
+	for name := range m {
+		c.remove(0, name)
+	}
+	checkChildren(t, c, map[string]*node{})
+}
+
+// Ensure that the children of a previous generation don't change.
+func TestChildrenCopy(t *testing.T) {
+	c := newChildren()
+	m := make(map[string]*node)
+	for i := 0; i < 1000; i++ {
+		name := strconv.Itoa(i)
+		n := &node{name: name}
+		c.set(0, n)
+		m[name] = n
+	}
+
+	c2 := c.copy()
+	for i := 0; i < 1000; i += 2 {
+		c2.remove(1, strconv.Itoa(i))
+	}
+	c2.set(1, &node{name: "new"})
+
+	checkChildren(t, c, m)
+	assert.Equal(t, c2.len(), 501, "")
+	assert.Nil(t, c2.get("0"), "")
+	assert.NotNil(t, c2.get("new"), "")
+}
+
+func checkChildren(t *testing.T, c *children, m map[string]*node) {
+	assert.Equal(t, c.len(), len(m), "")
+
+	names := make([]string, 0, len(m))
+	for name, n := range m {
+		names = append(names, name)
+		assert.Equal(t, c.get(name), n, "")
+	}
+	sort.Strings(names)
+
+	var listed []string
+	c.each(func(n *node) {
+		listed = append(listed, n.name)
+		assert.Equal(t, m[n.name], n, "")
+	})
+	assert.Equal(t, len(listed), len(names), "")
+	for i := range listed {
+		assert.Equal(t, listed[i], names[i], "")
+	}
+}
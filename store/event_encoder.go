@@ -0,0 +1,124 @@
+/*
+Copyright 2014 CoreOS Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"path"
+	"strconv"
+)
+
+// EventEncoder writes the JSON encoding of a get event. It walks the nodes
+// of a view of the store as it writes them, rather than building the whole
+// tree of NodeExterns first, so big directories take no more memory than
+// their encoding buffer and the first bytes go out right away.
+type EventEncoder struct {
+	n         *node
+	nodePath  string
+	recursive bool
+}
+
+// Encode writes the event to w, as json.Marshal writes the event of the
+// same get. Children are always in the order of their names, which is the
+// order of their keys, so the encoding is sorted either way.
+func (e *EventEncoder) Encode(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	b.WriteString(`{"action":"get","node":`)
+	if err := encodeExtern(b, e.n, e.nodePath, true, e.recursive); err != nil {
+		return err
+	}
+	b.WriteByte('}')
+	return b.Flush()
+}
+
+// encodeExtern writes the NodeExtern of the node at the given path. The
+// children of a directory are listed if list is true, and theirs if
+// recursive is true. Hidden children are left out.
+func encodeExtern(b *bufio.Writer, n *node, nodePath string, list, recursive bool) error {
+	b.WriteString(`{"key":`)
+	if err := encodeString(b, nodePath); err != nil {
+		return err
+	}
+
+	if n.IsDir() {
+		b.WriteString(`,"dir":true`)
+	} else {
+		b.WriteString(`,"value":`)
+		if err := encodeString(b, n.Value); err != nil {
+			return err
+		}
+	}
+
+	expiration, ttl := n.ExpirationAndTTL()
+	if expiration != nil {
+		t, err := expiration.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		b.WriteString(`,"expiration":`)
+		b.Write(t)
+	}
+	if ttl != 0 {
+		b.WriteString(`,"ttl":`)
+		b.WriteString(strconv.FormatInt(ttl, 10))
+	}
+
+	if n.IsDir() && list {
+		var err error
+		listed := false
+		n.Children.each(func(child *node) {
+			if err != nil || child.IsHidden() {
+				return
+			}
+			if listed {
+				b.WriteByte(',')
+			} else {
+				b.WriteString(`,"nodes":[`)
+				listed = true
+			}
+			err = encodeExtern(b, child, path.Join(nodePath, child.name), recursive, recursive)
+		})
+		if err != nil {
+			return err
+		}
+		if listed {
+			b.WriteByte(']')
+		}
+	}
+
+	if n.ModifiedIndex != 0 {
+		b.WriteString(`,"modifiedIndex":`)
+		b.WriteString(strconv.FormatUint(n.ModifiedIndex, 10))
+	}
+	if n.CreatedIndex != 0 {
+		b.WriteString(`,"createdIndex":`)
+		b.WriteString(strconv.FormatUint(n.CreatedIndex, 10))
+	}
+	b.WriteByte('}')
+	return nil
+}
+
+func encodeString(b *bufio.Writer, s string) error {
+	q, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	b.Write(q)
+	return nil
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2014 CoreOS Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/third_party/github.com/stretchr/testify/assert"
+)
+
+// The TTLs may tick between a Get and an encoding.
+var ttlPattern = regexp.MustCompile(`"ttl":[0-9-]+`)
+
+// Ensure that the encoder writes the same JSON as the event of a Get.
+func TestEventEncoder(t *testing.T) {
+	s := newStore()
+	s.Create("/foo/x", false, "bar", false, Permanent)
+	s.Create("/foo/_hidden", false, "baz", false, Permanent)
+	s.Create("/foo/y", false, "", false, time.Now().Add(time.Hour))
+	s.Create("/foo/dir/z", false, "<\"quoted\"\n>", false, Permanent)
+	s.Create("/foo/dir/w", false, "héllo", false, Permanent)
+	s.Create("/foo/empty", true, "", false, time.Now().Add(time.Hour))
+	s.Create("/foo/dir/sub/v", false, "v", false, Permanent)
+	s.Create("/bar", false, "bar", false, Permanent)
+
+	for _, key := range []string{"/", "/foo", "/foo/x", "/foo/dir", "/foo/empty", "/foo/_hidden", "/bar"} {
+		for _, recursive := range []bool{false, true} {
+			for _, sorted := range []bool{false, true} {
+				e, err := s.Get(key, recursive, sorted)
+				assert.Nil(t, err, "")
+				expected, _ := json.Marshal(e)
+
+				enc, err := s.GetEncoder(key, recursive, sorted)
+				assert.Nil(t, err, "")
+				var b bytes.Buffer
+				assert.Nil(t, enc.Encode(&b), "")
+
+				assert.Equal(t, ttlPattern.ReplaceAllString(b.String(), `"ttl":0`),
+					ttlPattern.ReplaceAllString(string(expected), `"ttl":0`), key)
+			}
+		}
+	}
+
+	_, err := s.GetEncoder("/foo/none", false, false)
+	assert.NotNil(t, err, "")
+}
+
+// Ensure that the encoder writes the nodes as they were when it was made.
+func TestEventEncoderIsolation(t *testing.T) {
+	s := newStore()
+	s.Create("/foo/x", false, "bar", false, Permanent)
+	enc, _ := s.GetEncoder("/foo", true, false)
+
+	s.Set("/foo/x", false, "changed", Permanent)
+	s.Create("/foo/y", false, "new", false, Permanent)
+
+	var b bytes.Buffer
+	enc.Encode(&b)
+	assert.Equal(t, b.String(), `{"action":"get","node":{"key":"/foo","dir":true,"nodes":[{"key":"/foo/x","value":"bar","modifiedIndex":1,"createdIndex":1}],"modifiedIndex":1,"createdIndex":1}}`, "")
+}
@@ -14,18 +14,18 @@ func TestHeapPushPop(t *testing.T) {
 	for i := 0; i < 10; i++ {
 		path := fmt.Sprintf("%v", 10-i)
 		m := time.Duration(10 - i)
-		n := newKV(nil, path, path, 0, nil, "", time.Now().Add(time.Second*m))
-		h.push(n)
+		n := newKV(0, path, path, 0, time.Now().Add(time.Second*m))
+		h.push(n, path)
 	}
 
 	min := time.Now()
 
 	for i := 0; i < 10; i++ {
-		node := h.pop()
-		if node.ExpireTime.Before(min) {
+		node, _ := h.pop()
+		if node.ExpireTime().Before(min) {
 			t.Fatal("heap sort wrong!")
 		}
-		min = node.ExpireTime
+		min = node.ExpireTime()
 	}
 
 }
@@ -40,16 +40,16 @@ func TestHeapUpdate(t *testing.T) {
 	for i, n := range kvs {
 		path := fmt.Sprintf("%v", 10-i)
 		m := time.Duration(10 - i)
-		n = newKV(nil, path, path, 0, nil, "", time.Now().Add(time.Second*m))
+		n = newKV(0, path, path, 0, time.Now().Add(time.Second*m))
 		kvs[i] = n
-		h.push(n)
+		h.push(n, path)
 	}
 
 	// Path 7
-	kvs[3].ExpireTime = time.Now().Add(time.Second * 11)
+	kvs[3].setExpireTime(time.Now().Add(time.Second * 11))
 
 	// Path 5
-	kvs[5].ExpireTime = time.Now().Add(time.Second * 12)
+	kvs[5].setExpireTime(time.Now().Add(time.Second * 12))
 
 	h.update(kvs[3])
 	h.update(kvs[5])
@@ -57,20 +57,20 @@ func TestHeapUpdate(t *testing.T) {
 	min := time.Now()
 
 	for i := 0; i < 10; i++ {
-		node := h.pop()
-		if node.ExpireTime.Before(min) {
+		node, p := h.pop()
+		if node.ExpireTime().Before(min) {
 			t.Fatal("heap sort wrong!")
 		}
-		min = node.ExpireTime
+		min = node.ExpireTime()
 
 		if i == 8 {
-			if node.Path != "7" {
-				t.Fatal("heap sort wrong!", node.Path)
+			if p != "7" {
+				t.Fatal("heap sort wrong!", p)
 			}
 		}
 
 		if i == 9 {
-			if node.Path != "5" {
+			if p != "5" {
 				t.Fatal("heap sort wrong!")
 			}
 		}
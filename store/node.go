@@ -1,11 +1,14 @@
 package store
 
 import (
+	"bytes"
+	"encoding/json"
+	"math"
 	"path"
 	"sort"
+	"strconv"
 	"time"
 
-	etcdErr "github.com/coreos/etcd/error"
 	ustrings "github.com/coreos/etcd/pkg/strings"
 )
 
@@ -21,54 +24,53 @@ var Permanent time.Time
 
 // node is the basic element in the store system.
 // A key-value pair will have a string value
-// A directory will have a children map
+// A directory will have children
+//
+// A node only holds the last element of its path. The path is known when
+// the node is reached from the root, so it isn't repeated in every node.
 type node struct {
-	Path string
+	name string
 
 	CreatedIndex  uint64
 	ModifiedIndex uint64
 
-	Parent *node `json:"-"` // should not encode this field! avoid circular dependency.
+	// expireTime is the expiration time in nanoseconds since the epoch,
+	// or 0 for a permanent node.
+	expireTime int64
 
-	ExpireTime time.Time
-	ACL        string
-	Value      string           // for key-value pair
-	Children   map[string]*node // for directory
+	Value    string    // for key-value pair
+	Children *children // for directory
 
-	// A reference to the store this node is attached to.
-	store *store
+	// gen is the generation of the store the node was created in. Nodes
+	// of previous generations may be shared with a snapshot being saved,
+	// so they are copied rather than changed. See store.mutable.
+	gen uint64
 }
 
 // newKV creates a Key-Value pair
-func newKV(store *store, nodePath string, value string, createdIndex uint64,
-	parent *node, ACL string, expireTime time.Time) *node {
-
-	return &node{
-		Path:          nodePath,
+func newKV(gen uint64, name string, value string, createdIndex uint64, expireTime time.Time) *node {
+	n := &node{
+		name:          name,
 		CreatedIndex:  createdIndex,
 		ModifiedIndex: createdIndex,
-		Parent:        parent,
-		ACL:           ACL,
-		store:         store,
-		ExpireTime:    expireTime,
 		Value:         value,
+		gen:           gen,
 	}
+	n.setExpireTime(expireTime)
+	return n
 }
 
 // newDir creates a directory
-func newDir(store *store, nodePath string, createdIndex uint64, parent *node,
-	ACL string, expireTime time.Time) *node {
-
-	return &node{
-		Path:          nodePath,
+func newDir(gen uint64, name string, createdIndex uint64, expireTime time.Time) *node {
+	n := &node{
+		name:          name,
 		CreatedIndex:  createdIndex,
 		ModifiedIndex: createdIndex,
-		Parent:        parent,
-		ACL:           ACL,
-		ExpireTime:    expireTime,
-		Children:      make(map[string]*node),
-		store:         store,
+		Children:      newChildren(),
+		gen:           gen,
 	}
+	n.setExpireTime(expireTime)
+	return n
 }
 
 // IsHidden function checks if the node is a hidden node. A hidden node
@@ -77,17 +79,12 @@ func newDir(store *store, nodePath string, createdIndex uint64, parent *node,
 // For example if we have /foo/_hidden and /foo/notHidden, get "/foo"
 // will only return /foo/notHidden
 func (n *node) IsHidden() bool {
-	_, name := path.Split(n.Path)
-
-	return name[0] == '_'
+	return len(n.name) > 0 && n.name[0] == '_'
 }
 
 // IsPermanent function checks if the node is a permanent one.
 func (n *node) IsPermanent() bool {
-	// we use a uninitialized time.Time to indicate the node is a
-	// permanent one.
-	// the uninitialized time.Time should equal zero.
-	return n.ExpireTime.IsZero()
+	return n.expireTime == 0
 }
 
 // IsDir function checks whether the node is a directory.
@@ -97,27 +94,36 @@ func (n *node) IsDir() bool {
 	return !(n.Children == nil)
 }
 
-// Read function gets the value of the node.
-// If the receiver node is not a key-value pair, a "Not A File" error will be returned.
-func (n *node) Read() (string, *etcdErr.Error) {
-	if n.IsDir() {
-		return "", etcdErr.NewError(etcdErr.EcodeNotFile, "", n.store.Index())
+// ExpireTime returns the expiration time of the node. It is the zero time
+// for a permanent node.
+func (n *node) ExpireTime() time.Time {
+	if n.IsPermanent() {
+		return Permanent
 	}
+	return time.Unix(0, n.expireTime)
+}
 
-	return n.Value, nil
+func (n *node) setExpireTime(expireTime time.Time) {
+	switch {
+	case expireTime.IsZero():
+		n.expireTime = 0
+	case expireTime.Year() >= 2262:
+		// Past the range of nanoseconds since the epoch.
+		n.expireTime = math.MaxInt64
+	default:
+		n.expireTime = expireTime.UnixNano()
+	}
 }
 
 // Write function set the value of the node to the given value.
-// If the receiver node is a directory, a "Not A File" error will be returned.
-func (n *node) Write(value string, index uint64) *etcdErr.Error {
+// A directory has no value, so it is left unchanged.
+func (n *node) Write(value string, index uint64) {
 	if n.IsDir() {
-		return etcdErr.NewError(etcdErr.EcodeNotFile, "", n.store.Index())
+		return
 	}
 
 	n.Value = value
 	n.ModifiedIndex = index
-
-	return nil
 }
 
 func (n *node) ExpirationAndTTL() (*time.Time, int64) {
@@ -129,133 +135,53 @@ func (n *node) ExpirationAndTTL() (*time.Time, int64) {
 		   ( (expireTime - timeNow) / nanosecondsPerSecond ) + 1
 		   which ranges 1..n+1
 		*/
-		ttlN := n.ExpireTime.Sub(time.Now())
+		expireTime := n.ExpireTime()
+		ttlN := expireTime.Sub(time.Now())
 		ttl := ttlN / time.Second
 		if (ttlN % time.Second) > 0 {
 			ttl++
 		}
-		return &n.ExpireTime, int64(ttl)
+		return &expireTime, int64(ttl)
 	}
 	return nil, 0
 }
 
-// List function return a slice of nodes under the receiver node.
-// If the receiver node is not a directory, a "Not A Directory" error will be returned.
-func (n *node) List() ([]*node, *etcdErr.Error) {
+// List function return a slice of nodes under the receiver node, in the
+// order of their names.
+func (n *node) List() []*node {
 	if !n.IsDir() {
-		return nil, etcdErr.NewError(etcdErr.EcodeNotDir, "", n.store.Index())
-	}
-
-	nodes := make([]*node, len(n.Children))
-
-	i := 0
-	for _, node := range n.Children {
-		nodes[i] = node
-		i++
-	}
-
-	return nodes, nil
-}
-
-// GetChild function returns the child node under the directory node.
-// On success, it returns the file node
-func (n *node) GetChild(name string) (*node, *etcdErr.Error) {
-	if !n.IsDir() {
-		return nil, etcdErr.NewError(etcdErr.EcodeNotDir, n.Path, n.store.Index())
+		return nil
 	}
 
-	child, ok := n.Children[name]
+	nodes := make([]*node, 0, n.Children.len())
 
-	if ok {
-		return child, nil
-	}
+	n.Children.each(func(node *node) {
+		nodes = append(nodes, node)
+	})
 
-	return nil, nil
+	return nodes
 }
 
-// Add function adds a node to the receiver node.
-// If the receiver is not a directory, a "Not A Directory" error will be returned.
-// If there is a existing node with the same name under the directory, a "Already Exist"
-// error will be returned
-func (n *node) Add(child *node) *etcdErr.Error {
+// GetChild function returns the child node under the directory node,
+// or nil if there is none.
+func (n *node) GetChild(name string) *node {
 	if !n.IsDir() {
-		return etcdErr.NewError(etcdErr.EcodeNotDir, "", n.store.Index())
-	}
-
-	_, name := path.Split(child.Path)
-
-	_, ok := n.Children[name]
-
-	if ok {
-		return etcdErr.NewError(etcdErr.EcodeNodeExist, "", n.store.Index())
-	}
-
-	n.Children[name] = child
-
-	return nil
-}
-
-// Remove function remove the node.
-func (n *node) Remove(dir, recursive bool, callback func(path string)) *etcdErr.Error {
-
-	if n.IsDir() {
-		if !dir {
-			// cannot delete a directory without recursive set to true
-			return etcdErr.NewError(etcdErr.EcodeNotFile, n.Path, n.store.Index())
-		}
-
-		if len(n.Children) != 0 && !recursive {
-			// cannot delete a directory if it is not empty and the operation
-			// is not recursive
-			return etcdErr.NewError(etcdErr.EcodeDirNotEmpty, n.Path, n.store.Index())
-		}
-	}
-
-	if !n.IsDir() { // key-value pair
-		_, name := path.Split(n.Path)
-
-		// find its parent and remove the node from the map
-		if n.Parent != nil && n.Parent.Children[name] == n {
-			delete(n.Parent.Children, name)
-		}
-
-		if callback != nil {
-			callback(n.Path)
-		}
-
-		if !n.IsPermanent() {
-			n.store.ttlKeyHeap.remove(n)
-		}
-
 		return nil
 	}
 
-	for _, child := range n.Children { // delete all children
-		child.Remove(true, true, callback)
-	}
-
-	// delete self
-	_, name := path.Split(n.Path)
-	if n.Parent != nil && n.Parent.Children[name] == n {
-		delete(n.Parent.Children, name)
-
-		if callback != nil {
-			callback(n.Path)
-		}
-
-		if !n.IsPermanent() {
-			n.store.ttlKeyHeap.remove(n)
-		}
-
-	}
+	return n.Children.get(name)
+}
 
-	return nil
+// Add function adds a node to the receiver directory, which must belong to
+// the given generation. It replaces the node of the same name, if any.
+func (n *node) Add(gen uint64, child *node) {
+	n.Children.set(gen, child)
 }
 
-func (n *node) Repr(recurisive, sorted bool) *NodeExtern {
+func (n *node) Repr(nodePath string, recurisive, sorted bool) *NodeExtern {
 	if n.IsDir() {
 		node := &NodeExtern{
-			Key:           n.Path,
+			Key:           nodePath,
 			Dir:           true,
 			ModifiedIndex: n.ModifiedIndex,
 			CreatedIndex:  n.CreatedIndex,
@@ -266,7 +192,7 @@ func (n *node) Repr(recurisive, sorted bool) *NodeExtern {
 			return node
 		}
 
-		children, _ := n.List()
+		children := n.List()
 		node.Nodes = make(NodeExterns, len(children))
 
 		// we do not use the index in the children slice directly
@@ -279,7 +205,7 @@ func (n *node) Repr(recurisive, sorted bool) *NodeExtern {
 				continue
 			}
 
-			node.Nodes[i] = child.Repr(recurisive, sorted)
+			node.Nodes[i] = child.Repr(path.Join(nodePath, child.name), recurisive, sorted)
 
 			i++
 		}
@@ -296,7 +222,7 @@ func (n *node) Repr(recurisive, sorted bool) *NodeExtern {
 	// since n.Value could be changed later, so we need to copy the value out
 	value := ustrings.Clone(n.Value)
 	node := &NodeExtern{
-		Key:           n.Path,
+		Key:           nodePath,
 		Value:         &value,
 		ModifiedIndex: n.ModifiedIndex,
 		CreatedIndex:  n.CreatedIndex,
@@ -305,31 +231,6 @@ func (n *node) Repr(recurisive, sorted bool) *NodeExtern {
 	return node
 }
 
-func (n *node) UpdateTTL(expireTime time.Time) {
-
-	if !n.IsPermanent() {
-		if expireTime.IsZero() {
-			// from ttl to permanent
-			n.ExpireTime = expireTime
-			// remove from ttl heap
-			n.store.ttlKeyHeap.remove(n)
-		} else {
-			// update ttl
-			n.ExpireTime = expireTime
-			// update ttl heap
-			n.store.ttlKeyHeap.update(n)
-		}
-
-	} else {
-		if !expireTime.IsZero() {
-			// from permanent to ttl
-			n.ExpireTime = expireTime
-			// push into ttl heap
-			n.store.ttlKeyHeap.push(n)
-		}
-	}
-}
-
 // Compare function compares node index and value with provided ones.
 // second result value explains result and equals to one of Compare.. constants
 func (n *node) Compare(prevValue string, prevIndex uint64) (ok bool, which int) {
@@ -349,41 +250,93 @@ func (n *node) Compare(prevValue string, prevIndex uint64) (ok bool, which int)
 	return
 }
 
-// Clone function clone the node recursively and return the new node.
-// If the node is a directory, it will clone all the content under this directory.
-// If the node is a key-value pair, it will clone the pair.
-func (n *node) Clone() *node {
-	if !n.IsDir() {
-		return newKV(n.store, n.Path, n.Value, n.CreatedIndex, n.Parent, n.ACL, n.ExpireTime)
+// copy returns a copy of the node that belongs to the given generation.
+// The children of a directory are shared with the node.
+func (n *node) copy(gen uint64) *node {
+	c := *n
+	c.gen = gen
+	if n.IsDir() {
+		c.Children = n.Children.copy()
 	}
+	return &c
+}
 
-	clone := newDir(n.store, n.Path, n.CreatedIndex, n.Parent, n.ACL, n.ExpireTime)
+// savedNode is the layout of a node in a saved state of the store, which
+// holds the full path of the node.
+type savedNode struct {
+	Path          string
+	CreatedIndex  uint64
+	ModifiedIndex uint64
+	ExpireTime    time.Time
+	ACL           string
+	Value         string
+	Children      map[string]*savedNode
+}
 
-	for key, child := range n.Children {
-		clone.Children[key] = child.Clone()
+// encode writes the node at the given path and the nodes under it in the
+// layout of a savedNode, without building the savedNodes.
+func (n *node) encode(b *bytes.Buffer, nodePath string) error {
+	p, err := json.Marshal(nodePath)
+	if err != nil {
+		return err
+	}
+	expireTime, err := n.ExpireTime().MarshalJSON()
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(n.Value)
+	if err != nil {
+		return err
 	}
 
-	return clone
-}
+	b.WriteString(`{"Path":`)
+	b.Write(p)
+	b.WriteString(`,"CreatedIndex":`)
+	b.WriteString(strconv.FormatUint(n.CreatedIndex, 10))
+	b.WriteString(`,"ModifiedIndex":`)
+	b.WriteString(strconv.FormatUint(n.ModifiedIndex, 10))
+	b.WriteString(`,"ExpireTime":`)
+	b.Write(expireTime)
+	b.WriteString(`,"ACL":"","Value":`)
+	b.Write(value)
+	b.WriteString(`,"Children":`)
 
-// recoverAndclean function help to do recovery.
-// Two things need to be done: 1. recovery structure; 2. delete expired nodes
+	if !n.IsDir() {
+		b.WriteString("null}")
+		return nil
+	}
 
-// If the node is a directory, it will help recover children's parent pointer and recursively
-// call this function on its children.
-// We check the expire last since we need to recover the whole structure first and add all the
-// notifications into the event history.
-func (n *node) recoverAndclean() {
-	if n.IsDir() {
-		for _, child := range n.Children {
-			child.Parent = n
-			child.store = n.store
-			child.recoverAndclean()
+	b.WriteByte('{')
+	for i, child := range n.List() {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, err := json.Marshal(child.name)
+		if err != nil {
+			return err
+		}
+		b.Write(name)
+		b.WriteByte(':')
+		if err := child.encode(b, path.Join(nodePath, child.name)); err != nil {
+			return err
 		}
 	}
+	b.WriteString("}}")
+	return nil
+}
 
-	if !n.ExpireTime.IsZero() {
-		n.store.ttlKeyHeap.push(n)
+// decodeNode builds the node of the given name and the nodes under it from
+// their saved state.
+func decodeNode(gen uint64, name string, sn *savedNode) *node {
+	var n *node
+	if sn.Children == nil {
+		n = newKV(gen, name, sn.Value, sn.CreatedIndex, sn.ExpireTime)
+	} else {
+		n = newDir(gen, name, sn.CreatedIndex, sn.ExpireTime)
+		for childName, child := range sn.Children {
+			n.Add(gen, decodeNode(gen, childName, child))
+		}
 	}
-
+	n.ModifiedIndex = sn.ModifiedIndex
+	return n
 }
@@ -1,6 +1,7 @@
 package store
 
 import (
+	"path"
 	"sort"
 	"time"
 )
@@ -24,7 +25,7 @@ func (eNode *NodeExtern) loadInternalNode(n *node, recursive, sorted bool) {
 	if n.IsDir() { // node is a directory
 		eNode.Dir = true
 
-		children, _ := n.List()
+		children := n.List()
 		eNode.Nodes = make(NodeExterns, len(children))
 
 		// we do not use the index in the children slice directly
@@ -36,7 +37,7 @@ func (eNode *NodeExtern) loadInternalNode(n *node, recursive, sorted bool) {
 				continue
 			}
 
-			eNode.Nodes[i] = child.Repr(recursive, sorted)
+			eNode.Nodes[i] = child.Repr(path.Join(eNode.Key, child.name), recursive, sorted)
 			i++
 		}
 
@@ -48,7 +49,7 @@ func (eNode *NodeExtern) loadInternalNode(n *node, recursive, sorted bool) {
 		}
 
 	} else { // node is a file
-		value, _ := n.Read()
+		value := n.Value
 		eNode.Value = &value
 	}
 
@@ -79,29 +79,47 @@ func newStats() *Stats {
 	return s
 }
 
+// clone returns a copy of the counters. Reads increment them without the
+// world lock, so they are loaded atomically.
 func (s *Stats) clone() *Stats {
-	return &Stats{s.GetSuccess, s.GetFail, s.SetSuccess, s.SetFail,
-		s.DeleteSuccess, s.DeleteFail, s.UpdateSuccess, s.UpdateFail, s.CreateSuccess,
-		s.CreateFail, s.CompareAndSwapSuccess, s.CompareAndSwapFail,
-		s.CompareAndDeleteSuccess, s.CompareAndDeleteFail, s.Watchers, s.ExpireCount}
+	return &Stats{
+		GetSuccess:              atomic.LoadUint64(&s.GetSuccess),
+		GetFail:                 atomic.LoadUint64(&s.GetFail),
+		SetSuccess:              atomic.LoadUint64(&s.SetSuccess),
+		SetFail:                 atomic.LoadUint64(&s.SetFail),
+		DeleteSuccess:           atomic.LoadUint64(&s.DeleteSuccess),
+		DeleteFail:              atomic.LoadUint64(&s.DeleteFail),
+		UpdateSuccess:           atomic.LoadUint64(&s.UpdateSuccess),
+		UpdateFail:              atomic.LoadUint64(&s.UpdateFail),
+		CreateSuccess:           atomic.LoadUint64(&s.CreateSuccess),
+		CreateFail:              atomic.LoadUint64(&s.CreateFail),
+		CompareAndSwapSuccess:   atomic.LoadUint64(&s.CompareAndSwapSuccess),
+		CompareAndSwapFail:      atomic.LoadUint64(&s.CompareAndSwapFail),
+		CompareAndDeleteSuccess: atomic.LoadUint64(&s.CompareAndDeleteSuccess),
+		CompareAndDeleteFail:    atomic.LoadUint64(&s.CompareAndDeleteFail),
+		ExpireCount:             atomic.LoadUint64(&s.ExpireCount),
+		Watchers:                atomic.LoadUint64(&s.Watchers),
+	}
 }
 
 // Status() return the statistics info of etcd storage its recent start
 func (s *Stats) toJson() []byte {
-	b, _ := json.Marshal(s)
+	b, _ := json.Marshal(s.clone())
 	return b
 }
 
 func (s *Stats) TotalReads() uint64 {
-	return s.GetSuccess + s.GetFail
+	c := s.clone()
+	return c.GetSuccess + c.GetFail
 }
 
 func (s *Stats) TotalTranscations() uint64 {
-	return s.SetSuccess + s.SetFail +
-		s.DeleteSuccess + s.DeleteFail +
-		s.CompareAndSwapSuccess + s.CompareAndSwapFail +
-		s.CompareAndDeleteSuccess + s.CompareAndDeleteFail +
-		s.UpdateSuccess + s.UpdateFail
+	c := s.clone()
+	return c.SetSuccess + c.SetFail +
+		c.DeleteSuccess + c.DeleteFail +
+		c.CompareAndSwapSuccess + c.CompareAndSwapFail +
+		c.CompareAndDeleteSuccess + c.CompareAndDeleteFail +
+		c.UpdateSuccess + c.UpdateFail
 }
 
 func (s *Stats) Inc(field int) {
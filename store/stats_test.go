@@ -1,6 +1,7 @@
 package store
 
 import (
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -95,7 +96,7 @@ func TestStoreStatsExpireCount(t *testing.T) {
 
 	go mockSyncService(s.DeleteExpiredKeys, c)
 	s.Create("/foo", false, "bar", false, time.Now().Add(500*time.Millisecond))
-	assert.Equal(t, uint64(0), s.Stats.ExpireCount, "")
+	assert.Equal(t, uint64(0), atomic.LoadUint64(&s.Stats.ExpireCount), "")
 	time.Sleep(600 * time.Millisecond)
-	assert.Equal(t, uint64(1), s.Stats.ExpireCount, "")
+	assert.Equal(t, uint64(1), atomic.LoadUint64(&s.Stats.ExpireCount), "")
 }
@@ -17,12 +17,14 @@ limitations under the License.
 package store
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"path"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	etcdErr "github.com/coreos/etcd/error"
@@ -44,6 +46,7 @@ type Store interface {
 	Index() uint64
 
 	Get(nodePath string, recursive, sorted bool) (*Event, error)
+	GetEncoder(nodePath string, recursive, sorted bool) (*EventEncoder, error)
 	Set(nodePath string, dir bool, value string, expireTime time.Time) (*Event, error)
 	Update(nodePath string, newValue string, expireTime time.Time) (*Event, error)
 	Create(nodePath string, dir bool, value string, unique bool,
@@ -71,6 +74,10 @@ type store struct {
 	CurrentVersion int
 	ttlKeyHeap     *ttlKeyHeap  // need to recovery manually
 	worldLock      sync.RWMutex // stop the world lock
+
+	// gen is the current generation of the nodes. Save starts a new
+	// generation, so that the nodes it serializes are never changed.
+	gen uint64
 }
 
 func New() Store {
@@ -80,7 +87,7 @@ func New() Store {
 func newStore() *store {
 	s := new(store)
 	s.CurrentVersion = defaultVersion
-	s.Root = newDir(s, "/", s.CurrentIndex, nil, "", Permanent)
+	s.Root = newDir(s.gen, "", s.CurrentIndex, Permanent)
 	s.Stats = newStats()
 	s.WatcherHub = newWatchHub(1000)
 	s.ttlKeyHeap = newTtlKeyHeap()
@@ -105,13 +112,14 @@ func (s *store) CommandFactory() CommandFactory {
 // Get returns a get event.
 // If recursive is true, it will return all the content under the node path.
 // If sorted is true, it will sort the content by keys.
+// Get reads a view of the store that doesn't change, so it doesn't hold
+// the world lock and never blocks writes.
 func (s *store) Get(nodePath string, recursive, sorted bool) (*Event, error) {
-	s.worldLock.RLock()
-	defer s.worldLock.RUnlock()
+	root, index := s.view()
 
 	nodePath = path.Clean(path.Join("/", nodePath))
 
-	n, err := s.internalGet(nodePath)
+	n, err := s.internalGetFrom(root, index, nodePath)
 
 	if err != nil {
 		s.Stats.Inc(GetFail)
@@ -126,6 +134,26 @@ func (s *store) Get(nodePath string, recursive, sorted bool) (*Event, error) {
 	return e, nil
 }
 
+// GetEncoder returns an encoder of the event that Get would return, which
+// writes the nodes as it walks them. Like Get, it reads a view of the store
+// that doesn't change, even while the event is being written.
+func (s *store) GetEncoder(nodePath string, recursive, sorted bool) (*EventEncoder, error) {
+	root, index := s.view()
+
+	nodePath = path.Clean(path.Join("/", nodePath))
+
+	n, err := s.internalGetFrom(root, index, nodePath)
+
+	if err != nil {
+		s.Stats.Inc(GetFail)
+		return nil, err
+	}
+
+	s.Stats.Inc(GetSuccess)
+
+	return &EventEncoder{n: n, nodePath: nodePath, recursive: recursive}, nil
+}
+
 // Create creates the node at nodePath. Create will help to create intermediate directories with no ttl.
 // If the node has already existed, create will fail.
 // If any node on the path is a file, create will fail.
@@ -232,12 +260,13 @@ func (s *store) CompareAndSwap(nodePath string, prevValue string, prevIndex uint
 	s.CurrentIndex++
 
 	e := newEvent(CompareAndSwap, nodePath, s.CurrentIndex, n.CreatedIndex)
-	e.PrevNode = n.Repr(false, false)
+	e.PrevNode = n.Repr(nodePath, false, false)
 	eNode := e.Node
 
 	// if test succeed, write the value
+	n = s.mutable(n, nodePath)
 	n.Write(value, s.CurrentIndex)
-	n.UpdateTTL(expireTime)
+	s.updateTTL(n, nodePath, expireTime)
 
 	// copy the value for safety
 	valueCopy := ustrings.Clone(value)
@@ -275,7 +304,7 @@ func (s *store) Delete(nodePath string, dir, recursive bool) (*Event, error) {
 
 	nextIndex := s.CurrentIndex + 1
 	e := newEvent(Delete, nodePath, nextIndex, n.CreatedIndex)
-	e.PrevNode = n.Repr(false, false)
+	e.PrevNode = n.Repr(nodePath, false, false)
 	eNode := e.Node
 
 	if n.IsDir() {
@@ -287,7 +316,7 @@ func (s *store) Delete(nodePath string, dir, recursive bool) (*Event, error) {
 		s.WatcherHub.notifyWatchers(e, path, true)
 	}
 
-	err = n.Remove(dir, recursive, callback)
+	err = s.remove(n, nodePath, dir, recursive, callback)
 
 	if err != nil {
 		s.Stats.Inc(DeleteFail)
@@ -334,7 +363,7 @@ func (s *store) CompareAndDelete(nodePath string, prevValue string, prevIndex ui
 	s.CurrentIndex++
 
 	e := newEvent(CompareAndDelete, nodePath, s.CurrentIndex, n.CreatedIndex)
-	e.PrevNode = n.Repr(false, false)
+	e.PrevNode = n.Repr(nodePath, false, false)
 
 	callback := func(path string) { // notify function
 		// notify the watchers with deleted set true
@@ -342,7 +371,7 @@ func (s *store) CompareAndDelete(nodePath string, prevValue string, prevIndex ui
 	}
 
 	// delete a key-value pair, no error should happen
-	n.Remove(false, false, callback)
+	s.remove(n, nodePath, false, false, callback)
 
 	s.WatcherHub.notify(e)
 	s.Stats.Inc(CompareAndDeleteSuccess)
@@ -376,11 +405,14 @@ func (s *store) Watch(key string, recursive, stream bool, sinceIndex uint64) (*W
 	return w, nil
 }
 
-// walk walks all the nodePath and apply the walkFunc on each directory
-func (s *store) walk(nodePath string, walkFunc func(prev *node, component string) (*node, *etcdErr.Error)) (*node, *etcdErr.Error) {
+// walk walks all the nodePath from the given root and apply the walkFunc on each directory,
+// along with its path.
+func (s *store) walk(root *node, nodePath string, walkFunc func(prev *node, prevPath, component string) (*node, *etcdErr.Error)) (*node, *etcdErr.Error) {
 	components := strings.Split(nodePath, "/")
 
-	curr := s.Root
+	curr := root
+	currPath := "/"
+	end := 0
 	var err *etcdErr.Error
 
 	for i := 1; i < len(components); i++ {
@@ -388,11 +420,13 @@ func (s *store) walk(nodePath string, walkFunc func(prev *node, component string
 			return curr, nil
 		}
 
-		curr, err = walkFunc(curr, components[i])
+		curr, err = walkFunc(curr, currPath, components[i])
 		if err != nil {
 			return nil, err
 		}
 
+		end += len(components[i]) + 1
+		currPath = nodePath[:end]
 	}
 
 	return curr, nil
@@ -421,7 +455,7 @@ func (s *store) Update(nodePath string, newValue string, expireTime time.Time) (
 	}
 
 	e := newEvent(Update, nodePath, nextIndex, n.CreatedIndex)
-	e.PrevNode = n.Repr(false, false)
+	e.PrevNode = n.Repr(nodePath, false, false)
 	eNode := e.Node
 
 	if n.IsDir() && len(newValue) != 0 {
@@ -430,6 +464,7 @@ func (s *store) Update(nodePath string, newValue string, expireTime time.Time) (
 		return nil, etcdErr.NewError(etcdErr.EcodeNotFile, nodePath, currIndex)
 	}
 
+	n = s.mutable(n, nodePath)
 	n.Write(newValue, nextIndex)
 
 	if n.IsDir() {
@@ -441,7 +476,7 @@ func (s *store) Update(nodePath string, newValue string, expireTime time.Time) (
 	}
 
 	// update ttl
-	n.UpdateTTL(expireTime)
+	s.updateTTL(n, nodePath, expireTime)
 
 	eNode.Expiration, eNode.TTL = n.ExpirationAndTTL()
 
@@ -477,9 +512,10 @@ func (s *store) internalCreate(nodePath string, dir bool, value string, unique,
 	}
 
 	dirName, nodeName := path.Split(nodePath)
+	dirPath := path.Clean(dirName)
 
 	// walk through the nodePath, create dirs and get the last directory node
-	d, err := s.walk(dirName, s.checkDir)
+	d, err := s.walk(s.Root, dirPath, s.checkDir)
 
 	if err != nil {
 		s.Stats.Inc(SetFail)
@@ -490,7 +526,10 @@ func (s *store) internalCreate(nodePath string, dir bool, value string, unique,
 	e := newEvent(action, nodePath, nextIndex, nextIndex)
 	eNode := e.Node
 
-	n, _ := d.GetChild(nodeName)
+	// Get a version of the directory that can be changed before replacing
+	// a node in it, so that it doesn't get copied twice.
+	d = s.mutable(d, dirPath)
+	n := d.GetChild(nodeName)
 
 	// force will try to replace a existing file
 	if n != nil {
@@ -498,9 +537,9 @@ func (s *store) internalCreate(nodePath string, dir bool, value string, unique,
 			if n.IsDir() {
 				return nil, etcdErr.NewError(etcdErr.EcodeNotFile, nodePath, currIndex)
 			}
-			e.PrevNode = n.Repr(false, false)
+			e.PrevNode = n.Repr(nodePath, false, false)
 
-			n.Remove(false, false, nil)
+			s.remove(n, nodePath, false, false, nil)
 		} else {
 			return nil, etcdErr.NewError(etcdErr.EcodeNodeExist, nodePath, currIndex)
 		}
@@ -511,20 +550,20 @@ func (s *store) internalCreate(nodePath string, dir bool, value string, unique,
 		valueCopy := ustrings.Clone(value)
 		eNode.Value = &valueCopy
 
-		n = newKV(s, nodePath, value, nextIndex, d, "", expireTime)
+		n = newKV(s.gen, ustrings.Clone(nodeName), value, nextIndex, expireTime)
 
 	} else { // create directory
 		eNode.Dir = true
 
-		n = newDir(s, nodePath, nextIndex, d, "", expireTime)
+		n = newDir(s.gen, ustrings.Clone(nodeName), nextIndex, expireTime)
 	}
 
 	// we are sure d is a directory and does not have the children with name n.Name
-	d.Add(n)
+	d.Add(s.gen, n)
 
 	// node with TTL
 	if !n.IsPermanent() {
-		s.ttlKeyHeap.push(n)
+		s.ttlKeyHeap.push(n, nodePath)
 
 		eNode.Expiration, eNode.TTL = n.ExpirationAndTTL()
 	}
@@ -536,24 +575,29 @@ func (s *store) internalCreate(nodePath string, dir bool, value string, unique,
 
 // InternalGet gets the node of the given nodePath.
 func (s *store) internalGet(nodePath string) (*node, *etcdErr.Error) {
+	return s.internalGetFrom(s.Root, s.CurrentIndex, nodePath)
+}
+
+// internalGetFrom gets the node of the given nodePath in the tree of the
+// given root, which holds the state of the store at the given index.
+func (s *store) internalGetFrom(root *node, index uint64, nodePath string) (*node, *etcdErr.Error) {
 	nodePath = path.Clean(path.Join("/", nodePath))
 
-	walkFunc := func(parent *node, name string) (*node, *etcdErr.Error) {
+	walkFunc := func(parent *node, parentPath, name string) (*node, *etcdErr.Error) {
 
 		if !parent.IsDir() {
-			err := etcdErr.NewError(etcdErr.EcodeNotDir, parent.Path, s.CurrentIndex)
+			err := etcdErr.NewError(etcdErr.EcodeNotDir, parentPath, index)
 			return nil, err
 		}
 
-		child, ok := parent.Children[name]
-		if ok {
+		if child := parent.Children.get(name); child != nil {
 			return child, nil
 		}
 
-		return nil, etcdErr.NewError(etcdErr.EcodeKeyNotFound, path.Join(parent.Path, name), s.CurrentIndex)
+		return nil, etcdErr.NewError(etcdErr.EcodeKeyNotFound, path.Join(parentPath, name), index)
 	}
 
-	f, err := s.walk(nodePath, walkFunc)
+	f, err := s.walk(root, nodePath, walkFunc)
 
 	if err != nil {
 		return nil, err
@@ -567,14 +611,14 @@ func (s *store) DeleteExpiredKeys(cutoff time.Time) {
 	defer s.worldLock.Unlock()
 
 	for {
-		node := s.ttlKeyHeap.top()
-		if node == nil || node.ExpireTime.After(cutoff) {
+		node, nodePath := s.ttlKeyHeap.top()
+		if node == nil || node.ExpireTime().After(cutoff) {
 			break
 		}
 
 		s.CurrentIndex++
-		e := newEvent(Expire, node.Path, s.CurrentIndex, node.CreatedIndex)
-		e.PrevNode = node.Repr(false, false)
+		e := newEvent(Expire, nodePath, s.CurrentIndex, node.CreatedIndex)
+		e.PrevNode = node.Repr(nodePath, false, false)
 
 		callback := func(path string) { // notify function
 			// notify the watchers with deleted set true
@@ -582,7 +626,7 @@ func (s *store) DeleteExpiredKeys(cutoff time.Time) {
 		}
 
 		s.ttlKeyHeap.pop()
-		node.Remove(true, true, callback)
+		s.remove(node, nodePath, true, true, callback)
 
 		s.Stats.Inc(ExpireCount)
 
@@ -595,37 +639,41 @@ func (s *store) DeleteExpiredKeys(cutoff time.Time) {
 // If it is a directory, this function will return the pointer to that node.
 // If it does not exist, this function will create a new directory and return the pointer to that node.
 // If it is a file, this function will return error.
-func (s *store) checkDir(parent *node, dirName string) (*node, *etcdErr.Error) {
-	node, ok := parent.Children[dirName]
+func (s *store) checkDir(parent *node, parentPath, dirName string) (*node, *etcdErr.Error) {
+	node := parent.Children.get(dirName)
 
-	if ok {
+	if node != nil {
 		if node.IsDir() {
 			return node, nil
 		}
 
-		return nil, etcdErr.NewError(etcdErr.EcodeNotDir, node.Path, s.CurrentIndex)
+		return nil, etcdErr.NewError(etcdErr.EcodeNotDir, path.Join(parentPath, dirName), s.CurrentIndex)
 	}
 
-	n := newDir(s, path.Join(parent.Path, dirName), s.CurrentIndex+1, parent, parent.ACL, Permanent)
+	parent = s.mutable(parent, parentPath)
+	n := newDir(s.gen, ustrings.Clone(dirName), s.CurrentIndex+1, Permanent)
 
-	parent.Children[dirName] = n
+	parent.Add(s.gen, n)
 
 	return n, nil
 }
 
 // Save saves the static state of the store system.
 // It will not be able to save the state of watchers.
-// It will not save the parent field of the node. Or there will
-// be cyclic dependencies issue for the json package.
+//
+// The nodes are not copied: Save starts a new generation, after which
+// the store copies a node before changing it, and serializes the nodes of
+// the previous generations without holding the world lock.
 func (s *store) Save() ([]byte, error) {
 	s.worldLock.Lock()
 
 	clonedStore := newStore()
 	clonedStore.CurrentIndex = s.CurrentIndex
-	clonedStore.Root = s.Root.Clone()
+	clonedStore.Root = s.Root
 	clonedStore.WatcherHub = s.WatcherHub.clone()
 	clonedStore.Stats = s.Stats.clone()
 	clonedStore.CurrentVersion = s.CurrentVersion
+	s.freeze()
 
 	s.worldLock.Unlock()
 
@@ -639,12 +687,12 @@ func (s *store) Save() ([]byte, error) {
 }
 
 // Recovery recovers the store system from a static state
-// It needs to recover the parent field of the nodes.
 // It needs to delete the expired nodes since the saved time and also
 // needs to create monitoring go routines.
 func (s *store) Recovery(state []byte) error {
 	s.worldLock.Lock()
 	defer s.worldLock.Unlock()
+
 	err := json.Unmarshal(state, s)
 
 	if err != nil {
@@ -653,12 +701,204 @@ func (s *store) Recovery(state []byte) error {
 
 	s.ttlKeyHeap = newTtlKeyHeap()
 
-	s.Root.recoverAndclean()
+	s.recoverAndclean(s.Root, "/")
+	return nil
+}
+
+// recoverAndclean function help to do recovery.
+// It puts the nodes with a TTL in the TTL heap, so that the expired ones get
+// deleted. We check the expire last since we need to recover the whole
+// structure first and add all the notifications into the event history.
+func (s *store) recoverAndclean(n *node, nodePath string) {
+	if n.IsDir() {
+		n.Children.each(func(child *node) {
+			s.recoverAndclean(child, path.Join(nodePath, child.name))
+		})
+	}
+
+	if !n.IsPermanent() {
+		s.ttlKeyHeap.push(n, nodePath)
+	}
+}
+
+// MarshalJSON encodes the state of the store. The nodes are written as
+// they are walked, along with their paths, so that the layout is the same
+// as the one of a tree of savedNodes.
+func (s *store) MarshalJSON() ([]byte, error) {
+	var root bytes.Buffer
+	if err := s.Root.encode(&root, "/"); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(&struct {
+		Root           json.RawMessage
+		WatcherHub     *watcherHub
+		CurrentIndex   uint64
+		Stats          *Stats
+		CurrentVersion int
+	}{root.Bytes(), s.WatcherHub, s.CurrentIndex, s.Stats, s.CurrentVersion})
+}
+
+// UnmarshalJSON decodes a state of the store written by MarshalJSON into
+// new nodes, as the current ones may be being saved.
+func (s *store) UnmarshalJSON(b []byte) error {
+	state := struct {
+		Root           *savedNode
+		WatcherHub     *watcherHub
+		CurrentIndex   *uint64
+		Stats          *Stats
+		CurrentVersion *int
+	}{nil, s.WatcherHub, &s.CurrentIndex, s.Stats, &s.CurrentVersion}
+
+	if err := json.Unmarshal(b, &state); err != nil {
+		return err
+	}
+
+	if state.Root != nil {
+		s.Root = decodeNode(s.gen, "", state.Root)
+	}
+	s.WatcherHub = state.WatcherHub
+	s.Stats = state.Stats
+	return nil
+}
+
+// remove removes the node at the given path.
+// The node is detached from its parent, which is copied first if it is
+// shared with a snapshot. The nodes under a directory are left in place,
+// as they go away along with it.
+func (s *store) remove(n *node, nodePath string, dir, recursive bool, callback func(path string)) *etcdErr.Error {
+
+	if n.IsDir() {
+		if !dir {
+			// cannot delete a directory without recursive set to true
+			return etcdErr.NewError(etcdErr.EcodeNotFile, nodePath, s.CurrentIndex)
+		}
+
+		if n.Children.len() != 0 && !recursive {
+			// cannot delete a directory if it is not empty and the operation
+			// is not recursive
+			return etcdErr.NewError(etcdErr.EcodeDirNotEmpty, nodePath, s.CurrentIndex)
+		}
+	}
+
+	// find its parent and remove the node from it
+	dirName, name := path.Split(nodePath)
+	dirPath := path.Clean(dirName)
+	parent, err := s.internalGet(dirPath)
+	if err == nil && parent.GetChild(name) == n {
+		parent = s.mutable(parent, dirPath)
+		parent.Children.remove(s.gen, name)
+	}
+
+	s.removed(n, nodePath, callback)
 	return nil
 }
 
+// removed notifies the removal of the node and of the nodes under it,
+// children first, and drops them from the TTL heap.
+func (s *store) removed(n *node, nodePath string, callback func(path string)) {
+	if n.IsDir() {
+		n.Children.each(func(child *node) {
+			s.removed(child, path.Join(nodePath, child.name), callback)
+		})
+	}
+
+	if callback != nil {
+		callback(nodePath)
+	}
+
+	if !n.IsPermanent() {
+		s.ttlKeyHeap.remove(n)
+	}
+}
+
+// updateTTL sets the expiration time of the node at the given path, and
+// keeps the TTL heap in line.
+func (s *store) updateTTL(n *node, nodePath string, expireTime time.Time) {
+
+	if !n.IsPermanent() {
+		if expireTime.IsZero() {
+			// from ttl to permanent
+			n.setExpireTime(expireTime)
+			// remove from ttl heap
+			s.ttlKeyHeap.remove(n)
+		} else {
+			// update ttl
+			n.setExpireTime(expireTime)
+			// update ttl heap
+			s.ttlKeyHeap.update(n)
+		}
+
+	} else {
+		if !expireTime.IsZero() {
+			// from permanent to ttl
+			n.setExpireTime(expireTime)
+			// push into ttl heap
+			s.ttlKeyHeap.push(n, nodePath)
+		}
+	}
+}
+
+// mutable returns a version of the node that can be changed. A node of a
+// previous generation may be part of a snapshot being saved or of a view
+// being read, so it is replaced in the tree with a copy, along with its
+// parents. The node must be in the tree, at the given path.
+func (s *store) mutable(n *node, nodePath string) *node {
+	if n.gen == s.gen {
+		return n
+	}
+
+	c := n.copy(s.gen)
+	if n == s.Root {
+		s.Root = c
+	} else {
+		dirPath := path.Dir(nodePath)
+		parent, _ := s.internalGet(dirPath)
+		parent = s.mutable(parent, dirPath)
+		parent.Add(s.gen, c)
+	}
+	if !n.IsPermanent() {
+		s.ttlKeyHeap.replace(n, c)
+	}
+	return c
+}
+
+// freeze starts a new generation if the current one has nodes, so that
+// the tree doesn't change anymore. It must be called with the world lock
+// held.
+func (s *store) freeze() {
+	// Any change copies the root into the current generation.
+	if s.Root.gen == s.gen {
+		s.gen++
+	}
+}
+
+// view returns the root and the index of the current state of the store.
+// The tree under the root doesn't change, so it can be read without the
+// world lock.
+//
+// This isn't free for writes: the first read after a write takes the world
+// lock to freeze the tree, and the next write then copies the nodes on its
+// path. With reads between all writes, a set costs up to twice as much as
+// without them. Freezing under the read lock instead lets readers freeze
+// after every write, which costs more copies than it saves in locking.
+func (s *store) view() (*node, uint64) {
+	s.worldLock.RLock()
+	if s.Root.gen != s.gen {
+		root, index := s.Root, s.CurrentIndex
+		s.worldLock.RUnlock()
+		return root, index
+	}
+	s.worldLock.RUnlock()
+
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+	s.freeze()
+	return s.Root, s.CurrentIndex
+}
+
 func (s *store) JsonStats() []byte {
-	s.Stats.Watchers = uint64(s.WatcherHub.count)
+	atomic.StoreUint64(&s.Stats.Watchers, uint64(atomic.LoadInt64(&s.WatcherHub.count)))
 	return s.Stats.toJson()
 }
 
@@ -20,7 +20,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func BenchmarkStoreSet128Bytes(b *testing.B) {
@@ -176,6 +179,96 @@ func BenchmarkWatchOneKey(b *testing.B) {
 	}
 }
 
+func BenchmarkStoreSetWithGets(b *testing.B) {
+	benchStoreSetWithReads(b, "/foo/0", false)
+}
+
+func BenchmarkStoreSetWithRecursiveGets(b *testing.B) {
+	benchStoreSetWithReads(b, "/foo", true)
+}
+
+// benchStoreSetWithReads sets keys while other goroutines keep getting the
+// given path, and reports the throughput of the reads along with the
+// time of a set.
+func benchStoreSetWithReads(b *testing.B, readPath string, recursive bool) {
+	s := newStore()
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("/foo/%d", i)
+		s.Set(keys[i], false, "bar", Permanent)
+	}
+
+	var reads uint64
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				s.Get(readPath, recursive, false)
+				atomic.AddUint64(&reads, 1)
+			}
+		}()
+	}
+
+	b.ResetTimer()
+	start := time.Now()
+	atomic.StoreUint64(&reads, 0)
+	for i := 0; i < b.N; i++ {
+		s.Set(keys[i%len(keys)], false, "bar", Permanent)
+	}
+	elapsed := time.Since(start)
+	n := atomic.LoadUint64(&reads)
+	b.StopTimer()
+
+	close(stop)
+	wg.Wait()
+	b.Logf("%.0f reads/s", float64(n)/elapsed.Seconds())
+}
+
+func BenchmarkStoreMemoryFlat(b *testing.B) {
+	benchStoreMemory(b, func(i int) string {
+		return fmt.Sprintf("/foo/%d", i)
+	})
+}
+
+func BenchmarkStoreMemoryDeep(b *testing.B) {
+	benchStoreMemory(b, func(i int) string {
+		return fmt.Sprintf("/registry/services/endpoints/default/service-%d/%d", i/100, i%100)
+	})
+}
+
+// storeSink keeps the store of a memory benchmark alive while its memory
+// is measured.
+var storeSink *store
+
+// benchStoreMemory sets keys with small values, and reports the memory
+// the store holds per key.
+func benchStoreMemory(b *testing.B, key func(i int) string) {
+	memStats := new(runtime.MemStats)
+	runtime.GC()
+	runtime.ReadMemStats(memStats)
+
+	storeSink = newStore()
+	for i := 0; i < b.N; i++ {
+		storeSink.Set(key(i), false, "bar", Permanent)
+	}
+	b.StopTimer()
+
+	setMemStats := new(runtime.MemStats)
+	runtime.GC()
+	runtime.ReadMemStats(setMemStats)
+
+	b.Logf("%.0f B/key", float64(int64(setMemStats.Alloc)-int64(memStats.Alloc))/float64(b.N))
+	storeSink = nil
+}
+
 func benchStoreSet(b *testing.B, valueSize int, process func(interface{}) ([]byte, error)) {
 	s := newStore()
 	b.StopTimer()
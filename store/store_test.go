@@ -17,6 +17,9 @@ limitations under the License.
 package store
 
 import (
+	"bytes"
+	"encoding/json"
+	"strconv"
 	"testing"
 	"time"
 
@@ -699,6 +702,150 @@ func TestStoreRecoverWithExpiration(t *testing.T) {
 	assert.Nil(t, e, "")
 }
 
+// Ensure that the nodes are saved with their full paths, as they used to be.
+func TestStoreSaveFormat(t *testing.T) {
+	s := newStore()
+	s.Create("/foo/x", false, "bar", false, Permanent)
+	s.Create("/foo/_y", false, "baz", false, Permanent)
+	b, err := s.Save()
+	assert.Nil(t, err, "")
+
+	var state struct {
+		Root         *savedNode
+		CurrentIndex uint64
+	}
+	assert.Nil(t, json.Unmarshal(b, &state), "")
+	assert.Equal(t, state.CurrentIndex, uint64(2), "")
+	assert.Equal(t, state.Root.Path, "/", "")
+	foo := state.Root.Children["foo"]
+	assert.Equal(t, foo.Path, "/foo", "")
+	assert.Equal(t, foo.CreatedIndex, uint64(1), "")
+	assert.Equal(t, foo.Children["x"].Path, "/foo/x", "")
+	assert.Equal(t, foo.Children["x"].Value, "bar", "")
+	assert.Nil(t, foo.Children["x"].Children, "")
+	assert.Equal(t, foo.Children["_y"].Path, "/foo/_y", "")
+
+	var raw struct {
+		Root map[string]interface{}
+	}
+	assert.Nil(t, json.Unmarshal(b, &raw), "")
+	_, ok := raw.Root["ACL"]
+	assert.True(t, ok, "")
+}
+
+// Ensure that the changes made after a snapshot is started don't show in it.
+func TestStoreSaveIsolation(t *testing.T) {
+	s := newStore()
+	s.Create("/foo/x", false, "bar", false, Permanent)
+	s.Create("/foo/y", false, "baz", false, time.Now().Add(time.Hour))
+	s.Create("/dir/z", false, "qux", false, Permanent)
+	var before bytes.Buffer
+	s.Root.encode(&before, "/")
+
+	// Start a new generation like Save does, and keep the saved tree.
+	s.worldLock.Lock()
+	root := s.Root
+	s.gen++
+	s.worldLock.Unlock()
+
+	s.Set("/foo/x", false, "changed", Permanent)
+	s.Update("/foo/y", "changed", time.Now().Add(2*time.Hour))
+	s.CompareAndSwap("/dir/z", "qux", 0, "changed", Permanent)
+	s.Create("/foo/new", false, "new", false, Permanent)
+	s.Delete("/dir", false, true)
+
+	var after bytes.Buffer
+	root.encode(&after, "/")
+	assert.Equal(t, after.String(), before.String(), "")
+
+	e, err := s.Get("/foo/x", false, false)
+	assert.Nil(t, err, "")
+	assert.Equal(t, *e.Node.Value, "changed", "")
+	e, err = s.Get("/foo/y", false, false)
+	assert.Nil(t, err, "")
+	assert.Equal(t, *e.Node.Value, "changed", "")
+	_, err = s.Get("/dir/z", false, false)
+	assert.NotNil(t, err, "")
+
+	// The TTL heap holds the nodes of the live tree.
+	s.DeleteExpiredKeys(time.Now().Add(3 * time.Hour))
+	_, err = s.Get("/foo/y", false, false)
+	assert.NotNil(t, err, "")
+	assert.Equal(t, s.ttlKeyHeap.Len(), 0, "")
+}
+
+// Ensure that a get during writes sees the state at one index.
+func TestStoreGetConcurrentWrites(t *testing.T) {
+	s := newStore()
+	s.Create("/foo", true, "", false, Permanent)
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			s.Create("/foo/"+strconv.Itoa(i), false, "bar", false, Permanent)
+			s.Delete("/foo/"+strconv.Itoa(i-1), false, false)
+		}
+		close(done)
+	}()
+
+	for i := 0; i < 100; i++ {
+		e, err := s.Get("/foo", true, false)
+		assert.Nil(t, err, "")
+		assert.True(t, len(e.Node.Nodes) <= 1, "")
+	}
+	<-done
+}
+
+// Ensure that a snapshot taken during writes holds the state at one index.
+func TestStoreSaveConcurrentWrites(t *testing.T) {
+	s := newStore()
+	s.Create("/foo", true, "", false, Permanent)
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			s.Create("/foo/"+strconv.Itoa(i), false, "bar", false, Permanent)
+		}
+		close(done)
+	}()
+
+	for i := 0; i < 10; i++ {
+		b, err := s.Save()
+		assert.Nil(t, err, "")
+
+		s2 := newStore()
+		s2.Recovery(b)
+		e, err := s2.Get("/foo", true, false)
+		assert.Nil(t, err, "")
+		assert.Equal(t, uint64(len(e.Node.Nodes)+1), s2.CurrentIndex, "")
+	}
+	<-done
+}
+
+// Ensure that a snapshot can be taken while gets update the stats and
+// freeze the tree.
+func TestStoreSaveConcurrentGets(t *testing.T) {
+	s := newStore()
+	s.Create("/foo", false, "bar", false, Permanent)
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			s.Get("/foo", false, false)
+			s.Get("/missing", false, false)
+		}
+		close(done)
+	}()
+
+	for i := 0; i < 10; i++ {
+		s.Set("/foo", false, "baz", Permanent)
+		_, err := s.Save()
+		assert.Nil(t, err, "")
+	}
+	<-done
+	assert.Equal(t, s.Stats.TotalReads(), uint64(2000), "")
+}
+
 // Ensure that the store can watch for hidden keys as long as it's an exact path match.
 func TestStoreWatchCreateWithHiddenKey(t *testing.T) {
 	s := newStore()
@@ -4,9 +4,16 @@ import (
 	"container/heap"
 )
 
+// A ttlKey is a node with a TTL, along with its path, which the node
+// doesn't hold.
+type ttlKey struct {
+	node *node
+	path string
+}
+
 // An TTLKeyHeap is a min-heap of TTLKeys order by expiration time
 type ttlKeyHeap struct {
-	array  []*node
+	array  []ttlKey
 	keyMap map[*node]int
 }
 
@@ -21,7 +28,7 @@ func (h ttlKeyHeap) Len() int {
 }
 
 func (h ttlKeyHeap) Less(i, j int) bool {
-	return h.array[i].ExpireTime.Before(h.array[j].ExpireTime)
+	return h.array[i].node.expireTime < h.array[j].node.expireTime
 }
 
 func (h ttlKeyHeap) Swap(i, j int) {
@@ -29,14 +36,14 @@ func (h ttlKeyHeap) Swap(i, j int) {
 	h.array[i], h.array[j] = h.array[j], h.array[i]
 
 	// update map
-	h.keyMap[h.array[i]] = i
-	h.keyMap[h.array[j]] = j
+	h.keyMap[h.array[i].node] = i
+	h.keyMap[h.array[j].node] = j
 }
 
 func (h *ttlKeyHeap) Push(x interface{}) {
-	n, _ := x.(*node)
-	h.keyMap[n] = len(h.array)
-	h.array = append(h.array, n)
+	k, _ := x.(ttlKey)
+	h.keyMap[k.node] = len(h.array)
+	h.array = append(h.array, k)
 }
 
 func (h *ttlKeyHeap) Pop() interface{} {
@@ -44,32 +51,33 @@ func (h *ttlKeyHeap) Pop() interface{} {
 	n := len(old)
 	x := old[n-1]
 	h.array = old[0 : n-1]
-	delete(h.keyMap, x)
+	delete(h.keyMap, x.node)
 	return x
 }
 
-func (h *ttlKeyHeap) top() *node {
+func (h *ttlKeyHeap) top() (*node, string) {
 	if h.Len() != 0 {
-		return h.array[0]
+		return h.array[0].node, h.array[0].path
 	}
-	return nil
+	return nil, ""
 }
 
-func (h *ttlKeyHeap) pop() *node {
+func (h *ttlKeyHeap) pop() (*node, string) {
 	x := heap.Pop(h)
-	n, _ := x.(*node)
-	return n
+	k, _ := x.(ttlKey)
+	return k.node, k.path
 }
 
-func (h *ttlKeyHeap) push(x interface{}) {
-	heap.Push(h, x)
+func (h *ttlKeyHeap) push(n *node, path string) {
+	heap.Push(h, ttlKey{node: n, path: path})
 }
 
 func (h *ttlKeyHeap) update(n *node) {
 	index, ok := h.keyMap[n]
 	if ok {
+		k := h.array[index]
 		heap.Remove(h, index)
-		heap.Push(h, n)
+		heap.Push(h, k)
 	}
 }
 
@@ -79,3 +87,13 @@ func (h *ttlKeyHeap) remove(n *node) {
 		heap.Remove(h, index)
 	}
 }
+
+// replace puts a copy of a node in its place.
+func (h *ttlKeyHeap) replace(n *node, c *node) {
+	index, ok := h.keyMap[n]
+	if ok {
+		delete(h.keyMap, n)
+		h.array[index].node = c
+		h.keyMap[c] = index
+	}
+}
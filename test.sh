@@ -23,12 +23,14 @@ go test -v ./server/v1/tests -race
 go test -i ./server/v2/tests
 go test -v ./server/v2/tests -race
 
-# Mod is deprecated temporarily.
-# go test -i ./mod/lock/v2/tests
-# go test -v ./mod/lock/v2/tests
+go test -i ./mod/lock/v2/tests
+go test -v ./mod/lock/v2/tests
 
-go test -i ./pkg/btrfs
-go test -v ./pkg/btrfs
+go test -i ./discovery
+go test -v ./discovery -race
+
+go test -i ./pkg/systemd
+go test -v ./pkg/systemd
 
 go test -i ./tests/functional
 ETCD_BIN_PATH=$(pwd)/bin/etcd go test -v ./tests/functional -race
@@ -0,0 +1,90 @@
+package test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/tests"
+	"github.com/coreos/etcd/third_party/github.com/stretchr/testify/assert"
+)
+
+// Ensure that a machine can be pre-registered, updated and removed through the admin API.
+func TestAdminMachinesAddUpdateRemove(t *testing.T) {
+	_, etcds, err := CreateCluster(3, &os.ProcAttr{Files: []*os.File{nil, os.Stdout, os.Stderr}}, false)
+	assert.NoError(t, err)
+	defer DestroyCluster(etcds)
+
+	time.Sleep(1 * time.Second)
+
+	// Pre-register a machine that has not started yet.
+	resp, _ := tests.Post("http://localhost:7001/v2/admin/machines/node4", "application/json", bytes.NewBufferString(`{"peerURL":"http://127.0.0.1:7004", "clientURL":"http://127.0.0.1:4004"}`))
+	body := tests.ReadBodyJSON(resp)
+	assert.Equal(t, resp.StatusCode, 200)
+	assert.Equal(t, body["name"], "node4")
+	assert.Equal(t, body["peerURL"], "http://127.0.0.1:7004")
+	assert.Equal(t, body["clientURL"], "http://127.0.0.1:4004")
+
+	// Adding it again is rejected.
+	resp, _ = tests.Post("http://localhost:7001/v2/admin/machines/node4", "application/json", bytes.NewBufferString(`{"peerURL":"http://127.0.0.1:7004", "clientURL":"http://127.0.0.1:4004"}`))
+	body = tests.ReadBodyJSON(resp)
+	assert.Equal(t, resp.StatusCode, 412)
+	assert.Equal(t, body["errorCode"], 111)
+
+	// Move it to new URLs.
+	resp, _ = tests.Put("http://localhost:7001/v2/admin/machines/node4", "application/json", bytes.NewBufferString(`{"peerURL":"http://127.0.0.1:7005"}`))
+	body = tests.ReadBodyJSON(resp)
+	assert.Equal(t, resp.StatusCode, 200)
+	assert.Equal(t, body["peerURL"], "http://127.0.0.1:7005")
+	assert.Equal(t, body["clientURL"], "http://127.0.0.1:4004")
+
+	time.Sleep(1 * time.Second)
+
+	resp, _ = tests.Get("http://localhost:7002/v2/admin/machines/node4")
+	body = tests.ReadBodyJSON(resp)
+	assert.Equal(t, resp.StatusCode, 200)
+	assert.Equal(t, body["peerURL"], "http://127.0.0.1:7005")
+
+	// The peer URL of another machine cannot be taken.
+	resp, _ = tests.Put("http://localhost:7001/v2/admin/machines/node4", "application/json", bytes.NewBufferString(`{"peerURL":"http://127.0.0.1:7002"}`))
+	body = tests.ReadBodyJSON(resp)
+	assert.Equal(t, resp.StatusCode, 400)
+	assert.Equal(t, body["errorCode"], 109)
+
+	// Remove it.
+	resp, _ = tests.Delete("http://localhost:7001/v2/admin/machines/node4", "application/json", nil)
+	assert.Equal(t, resp.StatusCode, 200)
+
+	time.Sleep(1 * time.Second)
+
+	resp, _ = tests.Get("http://localhost:7002/v2/admin/machines/node4")
+	body = tests.ReadBodyJSON(resp)
+	assert.Equal(t, resp.StatusCode, 404)
+	assert.Equal(t, body["errorCode"], 110)
+}
+
+// Ensure that invalid admin machine requests return JSON errors.
+func TestAdminMachinesInvalidRequest(t *testing.T) {
+	_, etcds, err := CreateCluster(3, &os.ProcAttr{Files: []*os.File{nil, os.Stdout, os.Stderr}}, false)
+	assert.NoError(t, err)
+	defer DestroyCluster(etcds)
+
+	time.Sleep(1 * time.Second)
+
+	resp, _ := tests.Post("http://localhost:7001/v2/admin/machines/node4", "application/json", bytes.NewBufferString(`{"peerURL":"127.0.0.1:7004"}`))
+	body := tests.ReadBodyJSON(resp)
+	assert.Equal(t, resp.StatusCode, 400)
+	assert.Equal(t, resp.Header.Get("Content-Type"), "application/json")
+	assert.Equal(t, body["errorCode"], 209)
+
+	resp, _ = tests.Put("http://localhost:7001/v2/admin/machines/node9", "application/json", bytes.NewBufferString(`{"clientURL":"http://127.0.0.1:4009"}`))
+	body = tests.ReadBodyJSON(resp)
+	assert.Equal(t, resp.StatusCode, 404)
+	assert.Equal(t, body["errorCode"], 110)
+
+	resp, _ = tests.Delete("http://localhost:7001/v2/admin/machines/node9", "application/json", nil)
+	body = tests.ReadBodyJSON(resp)
+	assert.Equal(t, resp.StatusCode, 404)
+	assert.Equal(t, body["errorCode"], 110)
+}
@@ -3,6 +3,7 @@ package test
 import (
 	"bytes"
 	"encoding/json"
+	"net/url"
 	"os"
 	"testing"
 	"time"
@@ -88,3 +89,60 @@ func TestGetMachines(t *testing.T) {
 		t.Errorf("no leader in the cluster")
 	}
 }
+
+// Ensure that the raft timing can be updated through the cluster configuration.
+func TestClusterConfigRaftTiming(t *testing.T) {
+	_, etcds, err := CreateCluster(3, &os.ProcAttr{Files: []*os.File{nil, os.Stdout, os.Stderr}}, false)
+	assert.NoError(t, err)
+	defer DestroyCluster(etcds)
+
+	resp, _ := tests.Put("http://localhost:7001/v2/admin/config", "application/json", bytes.NewBufferString(`{"heartbeatInterval":500, "electionTimeout":2500, "snapshotCount":500}`))
+	assert.Equal(t, resp.StatusCode, 200)
+
+	time.Sleep(1 * time.Second)
+
+	resp, _ = tests.Get("http://localhost:7002/v2/admin/config")
+	body := tests.ReadBodyJSON(resp)
+	assert.Equal(t, resp.StatusCode, 200)
+	assert.Equal(t, body["heartbeatInterval"], 500)
+	assert.Equal(t, body["electionTimeout"], 2500)
+	assert.Equal(t, body["snapshotCount"], 500)
+
+	// node1 is still the leader, and it heartbeats every 500ms instead of
+	// every 50ms: about 4 heartbeats reach a follower in 2 seconds,
+	// instead of about 40.
+	before := heartbeatCount(t, "node2")
+	time.Sleep(2 * time.Second)
+	count := heartbeatCount(t, "node2") - before
+	if count == 0 || count > 10 {
+		t.Fatalf("Unexpected heartbeats in 2 seconds: %d", count)
+	}
+
+	// Timing where elections would start between heartbeats is rejected.
+	resp, _ = tests.Put("http://localhost:7001/v2/admin/config", "application/json", bytes.NewBufferString(`{"heartbeatInterval":1, "electionTimeout":1}`))
+	assert.Equal(t, resp.StatusCode, 400)
+
+	// The cluster keeps accepting writes with the new timing.
+	resp, _ = tests.PutForm("http://localhost:4002/v2/keys/foo", url.Values{"value": {"bar"}})
+	assert.Equal(t, resp.StatusCode, 201)
+}
+
+// heartbeatCount returns the number of successful appends of the leader,
+// node1, to the given follower.
+func heartbeatCount(t *testing.T, name string) uint64 {
+	resp, err := tests.Get("http://localhost:4001/v2/stats/leader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats struct {
+		Followers map[string]struct {
+			Counts struct {
+				Success uint64 `json:"success"`
+			} `json:"counts"`
+		} `json:"followers"`
+	}
+	if err := json.Unmarshal(tests.ReadBody(resp), &stats); err != nil {
+		t.Fatal(err)
+	}
+	return stats.Followers[name].Counts.Success
+}
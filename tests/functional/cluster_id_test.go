@@ -0,0 +1,98 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/server"
+	"github.com/coreos/etcd/tests"
+	"github.com/coreos/etcd/third_party/github.com/stretchr/testify/assert"
+)
+
+// Ensure that all the machines of a cluster report the same cluster ID.
+func TestClusterID(t *testing.T) {
+	_, etcds, err := CreateCluster(3, &os.ProcAttr{Files: []*os.File{nil, os.Stdout, os.Stderr}}, false)
+	assert.NoError(t, err)
+	defer DestroyCluster(etcds)
+
+	time.Sleep(1 * time.Second)
+
+	id := clusterID(t, "http://localhost:7001")
+	assert.NotEqual(t, id, "")
+	assert.Equal(t, clusterID(t, "http://localhost:7002"), id)
+
+	resp, err := tests.Get("http://localhost:7002/v2/admin/machines")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	machines := make([]map[string]interface{}, 0)
+	json.Unmarshal(tests.ReadBody(resp), &machines)
+	assert.Equal(t, len(machines), 3)
+	for _, m := range machines {
+		assert.Equal(t, m["clusterID"], id)
+	}
+}
+
+// Ensure that the peer requests of another cluster are rejected.
+func TestClusterIDMismatch(t *testing.T) {
+	procAttr := &os.ProcAttr{Files: []*os.File{nil, os.Stdout, os.Stderr}}
+	_, etcds, err := CreateCluster(1, procAttr, false)
+	assert.NoError(t, err)
+	defer DestroyCluster(etcds)
+
+	// A second cluster that doesn't know of the first one.
+	other, err := os.StartProcess(EtcdBinPath, []string{"etcd", "-f", "-data-dir=/tmp/other", "-name=other", "-addr=127.0.0.1:4002", "-peer-addr=127.0.0.1:7002"}, procAttr)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer stopServer(other)
+	if err := WaitForServer("127.0.0.1:4002", buildClient(), "http"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	id := clusterID(t, "http://localhost:7001")
+	otherID := clusterID(t, "http://localhost:7002")
+	assert.NotEqual(t, id, otherID)
+
+	// The leader of the other cluster can't append entries.
+	req, _ := http.NewRequest("POST", "http://localhost:7001/log/append", bytes.NewBufferString(""))
+	req.Header.Set(server.ClusterIDHeader, otherID)
+	resp, err := http.DefaultClient.Do(req)
+	if assert.NoError(t, err) {
+		body := tests.ReadBodyJSON(resp)
+		assert.Equal(t, resp.StatusCode, http.StatusForbidden)
+		assert.Equal(t, body["errorCode"], 113)
+	}
+
+	// A machine with the data of the other cluster can't join.
+	join := fmt.Sprintf(`{"name":"other","raftURL":"http://127.0.0.1:7002","etcdURL":"http://127.0.0.1:4002","clusterID":%q}`, otherID)
+	resp, err = tests.Put("http://localhost:7001/join", "application/json", bytes.NewBufferString(join))
+	if assert.NoError(t, err) {
+		body := tests.ReadBodyJSON(resp)
+		assert.Equal(t, resp.StatusCode, http.StatusForbidden)
+		assert.Equal(t, body["errorCode"], 113)
+	}
+
+	// The cluster keeps working on its own.
+	resp, err = tests.Get("http://localhost:7001/v2/admin/machines")
+	if assert.NoError(t, err) {
+		machines := make([]map[string]interface{}, 0)
+		json.Unmarshal(tests.ReadBody(resp), &machines)
+		assert.Equal(t, len(machines), 1)
+	}
+}
+
+// clusterID retrieves the cluster ID from the cluster config of a machine.
+func clusterID(t *testing.T, peerURL string) string {
+	resp, err := tests.Get(peerURL + "/v2/admin/config")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	id, _ := tests.ReadBodyJSON(resp)["clusterID"].(string)
+	return id
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"strings"
 	"sync"
 	"testing"
@@ -347,6 +348,140 @@ func TestDiscoveryRestart(t *testing.T) {
 	})
 }
 
+// TestDiscoveryService ensures that a cluster can bootstrap from the
+// discovery service of another etcd, and that the service rejects members
+// beyond the size of the cluster.
+func TestDiscoveryService(t *testing.T) {
+	service, err := startServer2([]string{"-discovery-service", "-addr", "127.0.0.1:4002", "-peer-addr", "127.0.0.1:7002"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer stopServer(service)
+
+	client := http.Client{}
+	if err := WaitForServer("127.0.0.1:4002", client, "http"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	resp, err := client.Get("http://127.0.0.1:4002/discovery/new?size=1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	token := string(etcdtest.ReadBody(resp))
+	assert.True(t, strings.HasPrefix(token, "http://127.0.0.1:4002/discovery/"))
+
+	proc, err := startServer([]string{"-discovery", token})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer stopServer(proc)
+
+	err = assertServerFunctional(client, "http")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// The cluster is complete, so another member is rejected.
+	v := url.Values{}
+	v.Set("value", "http://127.0.0.1:7003")
+	resp, err = etcdtest.PutForm(token+"/node3", v)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	body := string(etcdtest.ReadBody(resp))
+	assert.Equal(t, resp.StatusCode, http.StatusBadRequest)
+	assert.Contains(t, body, `"errorCode":103`)
+
+	// The registered member can still renew its registration.
+	v.Set("value", "http://127.0.0.1:7001")
+	resp, err = etcdtest.PutForm(token+"/node1", v)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	etcdtest.ReadBody(resp)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+
+	resp, err = client.Get(token + "?recursive=true")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	body = string(etcdtest.ReadBody(resp))
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	assert.Contains(t, body, "node1")
+	assert.NotContains(t, body, "node3")
+}
+
+// TestDiscoveryServiceWaitForSize ensures that the members of a new cluster
+// wait for each other, and that a member beyond the size of the cluster
+// becomes a standby.
+func TestDiscoveryServiceWaitForSize(t *testing.T) {
+	service, err := startServer2([]string{"-discovery-service", "-addr", "127.0.0.1:4002", "-peer-addr", "127.0.0.1:7002"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer stopServer(service)
+
+	client := http.Client{}
+	if err := WaitForServer("127.0.0.1:4002", client, "http"); err != nil {
+		t.Fatal(err.Error())
+	}
+	resp, err := client.Get("http://127.0.0.1:4002/discovery/new?size=2")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	token := string(etcdtest.ReadBody(resp))
+
+	proc, err := startServer([]string{"-discovery", token})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer stopServer(proc)
+
+	// The first member waits for the second one before it serves clients.
+	time.Sleep(2 * time.Second)
+	_, err = client.Get("http://127.0.0.1:4001/version")
+	assert.Error(t, err)
+
+	proc3, err := startDiscoveryMember("node3", 3, token)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer stopServer(proc3)
+
+	err = assertServerFunctional(client, "http")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	proc4, err := startDiscoveryMember("node4", 4, token)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer stopServer(proc4)
+	if err := WaitForServer("127.0.0.1:4004", client, "http"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// The third member follows the cluster as a standby.
+	c := goetcd.NewClient([]string{"http://127.0.0.1:4001"})
+	result, err := c.Get("_etcd/machines", false, true)
+	if assert.NoError(t, err) {
+		assert.Equal(t, len(result.Node.Nodes), 2)
+	}
+}
+
+func startDiscoveryMember(name string, i int, discovery string) (*os.Process, error) {
+	procAttr := new(os.ProcAttr)
+	procAttr.Files = []*os.File{nil, os.Stdout, os.Stderr}
+
+	cmd := []string{"etcd", "-f", "-data-dir=/tmp/" + name, "-name=" + name,
+		fmt.Sprintf("-addr=127.0.0.1:%d", 4000+i), fmt.Sprintf("-peer-addr=127.0.0.1:%d", 7000+i),
+		"-discovery", discovery}
+
+	return os.StartProcess(EtcdBinPath, cmd, procAttr)
+}
+
 func assertServerNotUp(client http.Client, scheme string) error {
 	path := fmt.Sprintf("%s://127.0.0.1:4001/v2/keys/foo", scheme)
 	fields := url.Values(map[string][]string{"value": {"bar"}})
@@ -2,11 +2,13 @@ package test
 
 import (
 	"io/ioutil"
+	"net/http"
 	"os"
 	"strconv"
 	"testing"
 	"time"
 
+	"github.com/coreos/etcd/tests"
 	"github.com/coreos/etcd/third_party/github.com/coreos/go-etcd/etcd"
 )
 
@@ -147,3 +149,119 @@ func TestSnapshotRestart(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// TestSnapshotToFollower tests a new machine catching up from the snapshot
+// of the leader, which is sent in chunks.
+func TestSnapshotToFollower(t *testing.T) {
+	procAttr := new(os.ProcAttr)
+	procAttr.Files = []*os.File{nil, os.Stdout, os.Stderr}
+	args := []string{"etcd", "-name=node1", "-data-dir=/tmp/node1", "-snapshot=true", "-snapshot-count=500", "-f"}
+
+	process, err := os.StartProcess(EtcdBinPath, args, procAttr)
+	if err != nil {
+		t.Fatal("start process failed:" + err.Error())
+	}
+	defer process.Kill()
+
+	time.Sleep(time.Second)
+
+	c := etcd.NewClient(nil)
+
+	c.SyncCluster()
+	// issue first 501 commands
+	for i := 0; i < 501; i++ {
+		if _, err := c.Set("foo"+strconv.Itoa(i%10), "bar"+strconv.Itoa(i), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// wait for a snapshot interval
+	time.Sleep(3 * time.Second)
+
+	args = []string{"etcd", "-name=node2", "-data-dir=/tmp/node2", "-addr=127.0.0.1:4002", "-peer-addr=127.0.0.1:7002", "-peers=127.0.0.1:7001", "-f"}
+	follower, err := os.StartProcess(EtcdBinPath, args, procAttr)
+	if err != nil {
+		t.Fatal("start process failed:" + err.Error())
+	}
+	defer follower.Kill()
+
+	time.Sleep(2 * time.Second)
+
+	c2 := etcd.NewClient([]string{"http://127.0.0.1:4002"})
+	result, err := c2.Get("foo0", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Node.Value != "bar500" {
+		t.Fatalf("follower has foo0=%s, want bar500", result.Node.Value)
+	}
+
+	// The chunks are discarded once the follower recovered.
+	if _, err := os.Stat("/tmp/node2/snapshot.recv"); !os.IsNotExist(err) {
+		t.Fatal("received snapshot chunks were kept")
+	}
+}
+
+// TestSnapshotPolicy tests snapshots forced through the admin endpoint, the
+// snapshot interval and the retention of snapshot files.
+func TestSnapshotPolicy(t *testing.T) {
+	procAttr := new(os.ProcAttr)
+	procAttr.Files = []*os.File{nil, os.Stdout, os.Stderr}
+	args := []string{"etcd", "-name=node1", "-data-dir=/tmp/node1", "-snapshot-interval=1", "-snapshot-retention=2", "-f"}
+
+	process, err := os.StartProcess(EtcdBinPath, args, procAttr)
+	if err != nil {
+		t.Fatal("start process failed:" + err.Error())
+	}
+	defer process.Kill()
+
+	time.Sleep(time.Second)
+
+	c := etcd.NewClient(nil)
+	c.SyncCluster()
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Set("foo", "bar"+strconv.Itoa(i), 0); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tests.Post("http://127.0.0.1:7001/v2/admin/snapshot", "application/json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatal("snapshot failed:", resp.Status)
+		}
+		if index, _ := tests.ReadBodyJSON(resp)["index"].(float64); index == 0 {
+			t.Fatal("no snapshot index")
+		}
+	}
+
+	archive, err := ioutil.ReadDir("/tmp/node1/snapshot.archive")
+	if err != nil {
+		t.Fatal("list archived snapshots failed:" + err.Error())
+	}
+	if len(archive) != 2 {
+		t.Fatal("wrong number of archived snapshots :[2/", len(archive), "]")
+	}
+
+	// A snapshot is taken after the snapshot interval.
+	resp, err := tests.Get("http://127.0.0.1:4001/v2/stats/self")
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, _ := tests.ReadBodyJSON(resp)["snapshot"].(map[string]interface{})
+	if _, err := c.Set("foo", "baz", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(4 * time.Second)
+
+	resp, err = tests.Get("http://127.0.0.1:4001/v2/stats/self")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, _ := tests.ReadBodyJSON(resp)["snapshot"].(map[string]interface{})
+	if snapshot == nil || last == nil || snapshot["index"].(float64) <= last["index"].(float64) {
+		t.Fatalf("no snapshot after the interval: %v, last %v", snapshot, last)
+	}
+}
@@ -0,0 +1,87 @@
+package test
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestTLSReloadOnSIGHUP asserts that the client listener serves a
+// replaced certificate after SIGHUP without a restart.
+func TestTLSReloadOnSIGHUP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcd-tls-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	copyFile(t, "../../fixtures/ca/server.crt", certFile)
+	copyFile(t, "../../fixtures/ca/server.key.insecure", keyFile)
+
+	proc, err := startServer([]string{
+		"-cert-file=" + certFile,
+		"-key-file=" + keyFile,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer stopServer(proc)
+
+	time.Sleep(time.Second)
+
+	if ou := servedCertOU(t); ou != "server" {
+		t.Fatalf("served certificate OU = %q, want %q", ou, "server")
+	}
+
+	copyFile(t, "../../fixtures/ca/server2.crt", certFile)
+	copyFile(t, "../../fixtures/ca/server2.key.insecure", keyFile)
+	if err := proc.Signal(syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Second)
+
+	if ou := servedCertOU(t); ou != "server2" {
+		t.Fatalf("served certificate OU = %q, want %q", ou, "server2")
+	}
+
+	client := buildTLSClient(&tls.Config{InsecureSkipVerify: true})
+	resp, err := client.Get("https://127.0.0.1:4001/v2/stats/self")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	stats := make(map[string]interface{})
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stats["clientCertExpiry"]; !ok {
+		t.Fatalf("clientCertExpiry missing from stats: %v", stats)
+	}
+}
+
+func servedCertOU(t *testing.T) string {
+	conn, err := tls.Dial("tcp", "127.0.0.1:4001", &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Subject.OrganizationalUnit[0]
+}
+
+func copyFile(t *testing.T, src, dst string) {
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dst, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -51,6 +51,10 @@ func TestV1SoloMigration(t *testing.T) {
 	tests.ReadBody(resp)
 	assert.Nil(t, err, "")
 	assert.Equal(t, resp.StatusCode, 200, "")
+
+	// The cluster was started without an ID, so the leader assigns one.
+	time.Sleep(2 * time.Second)
+	assert.NotEqual(t, clusterID(t, "http://localhost:7001"), "")
 }
 
 // Ensure that we can start a v2 cluster from the logs of a v1 cluster.
@@ -65,6 +65,12 @@ func newCommand(name string, data []byte) (Command, error) {
 	return copy, nil
 }
 
+// DecodeCommand creates an instance of the registered command of the given
+// name from its data, as found in a log entry.
+func DecodeCommand(name string, data []byte) (Command, error) {
+	return newCommand(name, data)
+}
+
 // Registers a command by storing a reference to an instance of it.
 func RegisterCommand(command Command) {
 	if command == nil {
@@ -2,10 +2,11 @@ package raft
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
-	"io"
 	"os"
+	"path/filepath"
 	"sync"
 
 	"github.com/coreos/etcd/third_party/github.com/goraft/raft/protobuf"
@@ -18,10 +19,14 @@ import (
 //------------------------------------------------------------------------------
 
 // A log is a collection of log entries that are persisted to durable storage.
+// They are kept in a directory of segment files, and appended to the last
+// one.
 type Log struct {
 	ApplyFunc	func(*LogEntry, Command) (interface{}, error)
-	file		*os.File
+	file		*os.File	// the last segment
 	path		string
+	segments	segments
+	segmentSize	int64
 	entries		[]*LogEntry
 	commitIndex	uint64
 	mutex		sync.RWMutex
@@ -30,6 +35,13 @@ type Log struct {
 	initialized bool
 }
 
+// LogStats describes the space a log takes up.
+type LogStats struct {
+	Segments	int	`json:"segments"`
+	Size		int64	`json:"size"`		// bytes of the entries after the last snapshot
+	DiskUsage	int64	`json:"diskUsage"`	// bytes of the segments on disk, reserved space included
+}
+
 // The results of the applying a log entry.
 type logResult struct {
 	returnValue	interface{}
@@ -45,7 +57,8 @@ type logResult struct {
 // Creates a new log.
 func newLog() *Log {
 	return &Log{
-		entries: make([]*LogEntry, 0),
+		entries:	make([]*LogEntry, 0),
+		segmentSize:	DefaultLogSegmentSize,
 	}
 }
 
@@ -130,66 +143,87 @@ func (l *Log) currentTerm() uint64 {
 // State
 //--------------------------------------
 
-// Opens the log file and reads existing entries. The log can remain open and
-// continue to append entries to the end of the log.
+// Opens the log directory and reads existing entries. The log can remain
+// open and continue to append entries to its last segment. A torn tail is
+// dropped, but a log that is damaged before its tail is not opened. A log
+// kept in a single file, as by earlier versions, is moved into a directory
+// first.
 func (l *Log) open(path string) error {
-	// Read all the entries from the log if one exists.
-	var readBytes int64
-
-	var err error
 	debugln("log.open.open ", path)
-	// open log file
-	l.file, err = os.OpenFile(path, os.O_RDWR, 0600)
 	l.path = path
 
+	if err := migrateLog(path); err != nil {
+		return fmt.Errorf("raft.Log: Unable to migrate %s: %v", path, err)
+	}
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return err
+	}
+	segments, err := listSegments(path)
 	if err != nil {
-		// if the log file does not exist before
-		// we create the log file and set commitIndex to 0
-		if os.IsNotExist(err) {
-			l.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0600)
-			debugln("log.open.create ", path)
-			if err == nil {
-				l.initialized = true
-			}
+		return err
+	}
+
+	// if the log does not exist before
+	// we create its first segment
+	if len(segments) == 0 {
+		debugln("log.open.create ", path)
+		if err := l.createSegment(l.startIndex + 1); err != nil {
 			return err
 		}
-		return err
+		l.initialized = true
+		return nil
 	}
 	debugln("log.open.exist ", path)
 
-	// Read the file and decode entries.
-	for {
-		// Instantiate log entry and decode into it.
-		entry, _ := newLogEntry(l, nil, 0, 0, nil)
-		entry.Position, _ = l.file.Seek(0, os.SEEK_CUR)
-
-		n, err := entry.Decode(l.file)
-		if err != nil {
-			if err == io.EOF {
-				debugln("open.log.append: finish ")
-			} else {
-				if err = l.file.Truncate(readBytes); err != nil {
-					return fmt.Errorf("raft.Log: Unable to recover: %v", err)
-				}
-				l.file.Seek(readBytes, os.SEEK_SET)
-			}
-			break
-		}
+	// Read the segments and decode entries.
+	size, tail, err := readSegments(segments, func(entry *LogEntry) error {
+		entry.log = l
 		if entry.Index() > l.startIndex {
 			// Append entry.
 			l.entries = append(l.entries, entry)
 			if entry.Index() <= l.commitIndex {
 				command, err := newCommand(entry.CommandName(), entry.Command())
 				if err != nil {
-					continue
+					return nil
 				}
 				l.ApplyFunc(entry, command)
 			}
 			debugln("open.log.append log index ", entry.Index())
 		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Keep appending to the last segment.
+	last := segments[len(segments)-1]
+	if l.file, err = os.OpenFile(last.path, os.O_RDWR, 0600); err != nil {
+		return err
+	}
+	l.segments = segments
 
-		readBytes += int64(n)
+	// Drop the entries that were not written in full. They were never
+	// synced, so no one was told they are on disk.
+	if tail != nil {
+		warnf("raft.Log: dropping a torn tail: %v", tail)
+		if err = l.file.Truncate(size); err != nil {
+			l.file.Close()
+			return fmt.Errorf("raft.Log: Unable to recover: %v", err)
+		}
+	}
+	last.size = size
+	if _, err = l.file.Seek(size, os.SEEK_SET); err != nil {
+		l.file.Close()
+		return err
+	}
+	if err := preallocate(l.file, l.segmentSize); err != nil {
+		debugln("log.open.preallocate ", err)
 	}
+
+	// Remove the segments left behind by a compaction that was cut short.
+	l.removeSegments(0, l.segments.find(l.startIndex+1))
+
 	debugln("open.log.recovery number of log ", len(l.entries))
 	l.initialized = true
 	return nil
@@ -204,6 +238,7 @@ func (l *Log) close() {
 		l.file.Close()
 		l.file = nil
 	}
+	l.segments = nil
 	l.entries = make([]*LogEntry, 0)
 }
 
@@ -212,6 +247,140 @@ func (l *Log) sync() error {
 	return l.file.Sync()
 }
 
+//--------------------------------------
+// Segments
+//--------------------------------------
+
+// Creates a segment for the entries from the given index on, and appends to
+// it from now on. Its space on disk is reserved up front.
+func (l *Log) createSegment(firstIndex uint64) error {
+	path := filepath.Join(l.path, segmentName(firstIndex))
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := preallocate(file, l.segmentSize); err != nil {
+		debugln("log.segment.preallocate ", err)
+	}
+	if err := syncDir(l.path); err != nil {
+		file.Close()
+		return err
+	}
+
+	l.file = file
+	l.segments = append(l.segments, &segment{path: path, firstIndex: firstIndex})
+	return nil
+}
+
+// Closes the last segment once the entry of the given size doesn't fit in
+// it, and starts the next one at the index of the entry. An entry larger
+// than a segment gets a segment of its own.
+func (l *Log) rollSegment(index uint64, size int64, w *bufio.Writer) error {
+	last := l.segments[len(l.segments)-1]
+	if last.size == 0 || last.size+size <= l.segmentSize {
+		return nil
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.file.Close()
+	l.file = nil
+
+	if err := l.createSegment(index); err != nil {
+		return err
+	}
+	w.Reset(l.file)
+	return nil
+}
+
+// Removes the segments from i to j, not included.
+func (l *Log) removeSegments(i, j int) {
+	if i >= j {
+		return
+	}
+	for _, s := range l.segments[i:j] {
+		if err := os.Remove(s.path); err != nil {
+			warnf("raft.Log: Unable to remove segment %s: %v", s.path, err)
+		}
+	}
+	l.segments = append(l.segments[:i], l.segments[j:]...)
+	syncDir(l.path)
+}
+
+// Drops the given entry and the ones after it from the segments, and
+// appends after the entry before it from now on.
+func (l *Log) truncateSegments(entry *LogEntry) error {
+	i := l.segments.find(entry.Index())
+	s := l.segments[i]
+
+	if i < len(l.segments)-1 {
+		l.file.Close()
+		l.file = nil
+		l.removeSegments(i+1, len(l.segments))
+
+		file, err := os.OpenFile(s.path, os.O_RDWR, 0600)
+		if err != nil {
+			return err
+		}
+		l.file = file
+		if err := preallocate(l.file, l.segmentSize); err != nil {
+			debugln("log.truncate.preallocate ", err)
+		}
+	}
+
+	if err := l.file.Truncate(entry.Position); err != nil {
+		return err
+	}
+	s.size = entry.Position
+	_, err := l.file.Seek(entry.Position, os.SEEK_SET)
+	return err
+}
+
+// Removes all the segments and starts a new one at the given index. The
+// entries before it must be held by a snapshot.
+func (l *Log) resetSegments(firstIndex uint64) error {
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	for _, s := range l.segments {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	l.segments = nil
+	return l.createSegment(firstIndex)
+}
+
+// The space taken by the log.
+func (l *Log) stats() LogStats {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	stats := LogStats{Segments: len(l.segments)}
+	for _, s := range l.segments {
+		if fi, err := os.Stat(s.path); err == nil {
+			stats.DiskUsage += diskUsage(fi)
+		}
+	}
+
+	// Count the entries from the first one kept, leaving out the compacted
+	// entries the first segment may still hold.
+	if len(l.entries) > 0 {
+		first := l.entries[0]
+		i := l.segments.find(first.Index())
+		stats.Size = l.segments[i].size - first.Position
+		for _, s := range l.segments[i+1:] {
+			stats.Size += s.size
+		}
+	}
+	return stats
+}
+
 //--------------------------------------
 // Entries
 //--------------------------------------
@@ -384,14 +553,6 @@ func (l *Log) setCommitIndex(index uint64) error {
 	return nil
 }
 
-// Set the commitIndex at the head of the log file to the current
-// commit Index. This should be called after obtained a log lock
-func (l *Log) flushCommitIndex() {
-	l.file.Seek(0, os.SEEK_SET)
-	fmt.Fprintf(l.file, "%8x\n", l.commitIndex)
-	l.file.Seek(0, os.SEEK_END)
-}
-
 //--------------------------------------
 // Truncation
 //--------------------------------------
@@ -418,8 +579,11 @@ func (l *Log) truncate(index uint64, term uint64) error {
 	// If we're truncating everything then just clear the entries.
 	if index == l.startIndex {
 		debugln("log.truncate.clear")
-		l.file.Truncate(0)
-		l.file.Seek(0, os.SEEK_SET)
+		if len(l.entries) > 0 {
+			if err := l.truncateSegments(l.entries[0]); err != nil {
+				return err
+			}
+		}
 
 		// notify clients if this node is the previous leader
 		for _, entry := range l.entries {
@@ -440,9 +604,9 @@ func (l *Log) truncate(index uint64, term uint64) error {
 		// Otherwise truncate up to the desired entry.
 		if index < l.startIndex+uint64(len(l.entries)) {
 			debugln("log.truncate.finish")
-			position := l.entries[index-l.startIndex].Position
-			l.file.Truncate(position)
-			l.file.Seek(position, os.SEEK_SET)
+			if err := l.truncateSegments(l.entries[index-l.startIndex]); err != nil {
+				return err
+			}
 
 			// notify clients if this node is the previous leader
 			for i := index - l.startIndex; i < uint64(len(l.entries)); i++ {
@@ -468,28 +632,25 @@ func (l *Log) appendEntries(entries []*protobuf.LogEntry) error {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
-	startPosition, _ := l.file.Seek(0, os.SEEK_CUR)
+	if l.file == nil {
+		return errors.New("raft.Log: Log is not open")
+	}
 
 	w := bufio.NewWriter(l.file)
 
-	var size int64
-	var err error
 	// Append each entry but exit if we hit an error.
 	for i := range entries {
 		logEntry := &LogEntry{
-			log:		l,
-			Position:	startPosition,
-			pb:		entries[i],
+			log:	l,
+			pb:	entries[i],
 		}
 
-		if size, err = l.writeEntry(logEntry, w); err != nil {
+		if _, err := l.writeEntry(logEntry, w); err != nil {
 			return err
 		}
-
-		startPosition += size
 	}
 	w.Flush()
-	err = l.sync()
+	err := l.sync()
 
 	if err != nil {
 		panic(err)
@@ -507,33 +668,15 @@ func (l *Log) appendEntry(entry *LogEntry) error {
 		return errors.New("raft.Log: Log is not open")
 	}
 
-	// Make sure the term and index are greater than the previous.
-	if len(l.entries) > 0 {
-		lastEntry := l.entries[len(l.entries)-1]
-		if entry.Term() < lastEntry.Term() {
-			return fmt.Errorf("raft.Log: Cannot append entry with earlier term (%x:%x <= %x:%x)", entry.Term(), entry.Index(), lastEntry.Term(), lastEntry.Index())
-		} else if entry.Term() == lastEntry.Term() && entry.Index() <= lastEntry.Index() {
-			return fmt.Errorf("raft.Log: Cannot append entry with earlier index in the same term (%x:%x <= %x:%x)", entry.Term(), entry.Index(), lastEntry.Term(), lastEntry.Index())
-		}
-	}
-
-	position, _ := l.file.Seek(0, os.SEEK_CUR)
-
-	entry.Position = position
-
-	// Write to storage.
-	if _, err := entry.Encode(l.file); err != nil {
+	w := bufio.NewWriter(l.file)
+	if _, err := l.writeEntry(entry, w); err != nil {
 		return err
 	}
-
-	// Append to entries list if stored on disk.
-	l.entries = append(l.entries, entry)
-
-	return nil
+	return w.Flush()
 }
 
 // appendEntry with Buffered io
-func (l *Log) writeEntry(entry *LogEntry, w io.Writer) (int64, error) {
+func (l *Log) writeEntry(entry *LogEntry, w *bufio.Writer) (int64, error) {
 	if l.file == nil {
 		return -1, errors.New("raft.Log: Log is not open")
 	}
@@ -548,11 +691,23 @@ func (l *Log) writeEntry(entry *LogEntry, w io.Writer) (int64, error) {
 		}
 	}
 
-	// Write to storage.
-	size, err := entry.Encode(w)
+	// Encode the entry first, to know whether it fits in the last segment.
+	var buf bytes.Buffer
+	size, err := entry.Encode(&buf)
 	if err != nil {
 		return -1, err
 	}
+	if err := l.rollSegment(entry.Index(), int64(size), w); err != nil {
+		return -1, err
+	}
+
+	// Write to storage.
+	last := l.segments[len(l.segments)-1]
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return -1, err
+	}
+	entry.Position = last.size
+	last.size += int64(size)
 
 	// Append to entries list if stored on disk.
 	l.entries = append(l.entries, entry)
@@ -564,7 +719,8 @@ func (l *Log) writeEntry(entry *LogEntry, w io.Writer) (int64, error) {
 // Log compaction
 //--------------------------------------
 
-// compact the log before index (including index)
+// compact the log before index (including index). The segments holding
+// only compacted entries are removed.
 func (l *Log) compact(index uint64, term uint64) error {
 	var entries []*LogEntry
 
@@ -579,42 +735,18 @@ func (l *Log) compact(index uint64, term uint64) error {
 	// we just recovery from on snapshot
 	if index >= l.internalCurrentIndex() {
 		entries = make([]*LogEntry, 0)
-	} else {
-		// get all log entries after index
-		entries = l.entries[index-l.startIndex:]
-	}
 
-	// create a new log file and add all the entries
-	new_file_path := l.path + ".new"
-	file, err := os.OpenFile(new_file_path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
-	if err != nil {
-		return err
-	}
-	for _, entry := range entries {
-		position, _ := l.file.Seek(0, os.SEEK_CUR)
-		entry.Position = position
-
-		if _, err = entry.Encode(file); err != nil {
-			file.Close()
-			os.Remove(new_file_path)
+		// start over with an empty segment
+		if err := l.resetSegments(index + 1); err != nil {
 			return err
 		}
-	}
-	file.Sync()
-
-	old_file := l.file
+	} else {
+		// get all log entries after index
+		entries = l.entries[index-l.startIndex:]
 
-	// rename the new log file
-	err = os.Rename(new_file_path, l.path)
-	if err != nil {
-		file.Close()
-		os.Remove(new_file_path)
-		return err
+		// remove the segments before the one holding the first entry kept
+		l.removeSegments(0, l.segments.find(index+1))
 	}
-	l.file = file
-
-	// close the old log file
-	old_file.Close()
 
 	// compaction the in memory log
 	l.entries = entries
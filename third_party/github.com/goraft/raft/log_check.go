@@ -0,0 +1,247 @@
+package raft
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/coreos/etcd/third_party/github.com/goraft/raft/protobuf"
+)
+
+// A TornTail is the damaged end of a log file, as left by a write that a
+// power loss cut short. No intact entry follows it, so it can be dropped:
+// the entries in it were never acknowledged as being on disk. Only the last
+// segment of a log can have one.
+type TornTail struct {
+	Path      string   // segment file holding the tail
+	Offset    int64    // position of the first damaged entry
+	Size      int64    // number of bytes from Offset to the end of the file
+	LastIndex uint64   // index of the last intact entry, or 0 if there is none
+	Indexes   []uint64 // indexes of the damaged entries that could still be read
+	Err       error    // what is wrong with the first damaged entry
+}
+
+func (t *TornTail) String() string {
+	s := fmt.Sprintf("%d bytes at offset %d of %s after index %d (%v)", t.Size, t.Offset, t.Path, t.LastIndex, t.Err)
+	if len(t.Indexes) > 0 {
+		s += fmt.Sprintf(", holding indexes %v", t.Indexes)
+	}
+	return s
+}
+
+// A CorruptLogError is returned for a damaged entry that intact entries
+// follow, in its segment or in the next ones. Dropping it would drop them as
+// well, so it can't be repaired by truncating the log.
+type CorruptLogError struct {
+	Path      string // segment file holding the damaged entry
+	Offset    int64  // position of the damaged entry
+	LastIndex uint64 // index of the last intact entry before it
+	NextIndex uint64 // index of the first intact entry after it
+	Err       error
+}
+
+func (e *CorruptLogError) Error() string {
+	return fmt.Sprintf("raft.Log: corrupt entry at offset %d of %s after index %d, followed by intact entry %d: %v", e.Offset, e.Path, e.LastIndex, e.NextIndex, e.Err)
+}
+
+// readLog decodes the entries of a log file from its start, calling f on
+// each of them in order. lastIndex is the index of the entry before the
+// file, if any. It returns the position of the end of the intact entries
+// and, if the file doesn't end there, its torn tail. The file is left
+// unchanged.
+func readLog(file *os.File, lastIndex uint64, f func(*LogEntry) error) (int64, *TornTail, error) {
+	if _, err := file.Seek(0, os.SEEK_SET); err != nil {
+		return 0, nil, err
+	}
+	r := bufio.NewReader(file)
+
+	var position int64
+	for {
+		entry := &LogEntry{pb: &protobuf.LogEntry{}, Position: position}
+		n, err := entry.Decode(r)
+		if err == io.EOF {
+			return position, nil, nil
+		}
+		if err != nil {
+			tail, err := checkTail(file, position, lastIndex, err)
+			return position, tail, err
+		}
+
+		if err := f(entry); err != nil {
+			return position, nil, err
+		}
+		lastIndex = entry.Index()
+		position += int64(n)
+	}
+}
+
+// checkTail looks at the bytes of a log file from the damaged entry at the
+// given position. They are a torn tail unless an intact entry, with a later
+// index than the last one, can be found in them.
+func checkTail(file *os.File, position int64, lastIndex uint64, cause error) (*TornTail, error) {
+	if _, err := file.Seek(position, os.SEEK_SET); err != nil {
+		return nil, err
+	}
+	rest, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 1; i < len(rest); i++ {
+		if index, ok := intactEntryAt(rest[i:], lastIndex); ok {
+			return nil, &CorruptLogError{Path: file.Name(), Offset: position, LastIndex: lastIndex, NextIndex: index, Err: cause}
+		}
+	}
+
+	tail := &TornTail{Path: file.Name(), Offset: position, Size: int64(len(rest)), LastIndex: lastIndex, Err: cause}
+
+	// Read what can be read of the damaged entries, following the lengths
+	// in their headers, to tell which indexes were lost.
+	for i := 0; i < len(rest); {
+		length, ok := headerAt(rest[i:])
+		if !ok {
+			break
+		}
+		entry := &LogEntry{pb: &protobuf.LogEntry{}}
+		n, err := entry.Decode(bytes.NewReader(rest[i:]))
+		if err != nil && err != ErrChecksum {
+			break
+		}
+		if err == ErrChecksum {
+			// The checksum is wrong, but the data may still decode.
+			n = length
+		}
+		if entry.Index() > lastIndex {
+			tail.Indexes = append(tail.Indexes, entry.Index())
+		}
+		i += n
+	}
+
+	return tail, nil
+}
+
+// intactEntryAt checks whether b starts with an entry whose checksum
+// matches and whose index comes after lastIndex. Entries without a
+// checksum are intact if they decode.
+func intactEntryAt(b []byte, lastIndex uint64) (uint64, bool) {
+	if _, ok := headerAt(b); !ok {
+		return 0, false
+	}
+	entry := &LogEntry{pb: &protobuf.LogEntry{}}
+	if _, err := entry.Decode(bytes.NewReader(b)); err != nil {
+		return 0, false
+	}
+	return entry.Index(), entry.Index() > lastIndex
+}
+
+// headerAt returns the size of the entry whose header starts b, if there is
+// a valid header, and the entry fits in b.
+func headerAt(b []byte) (int, bool) {
+	if len(b) < legacyHeaderSize {
+		return 0, false
+	}
+	length, ok := parseHex(b[:8])
+	if !ok {
+		return 0, false
+	}
+	size := legacyHeaderSize
+	if b[8] == ' ' {
+		size = headerSize
+		if len(b) < size || b[17] != '\n' {
+			return 0, false
+		}
+		if _, ok := parseHex(b[9:17]); !ok {
+			return 0, false
+		}
+	} else if b[8] != '\n' {
+		return 0, false
+	}
+	if uint64(len(b)-size) < length {
+		return 0, false
+	}
+	return size + int(length), true
+}
+
+// readSegments decodes the entries of the segments of a log in order,
+// calling f on each of them. It returns the size of the intact entries of
+// the last segment and its torn tail, if it has one. A segment before the
+// last one that ends in damaged entries is corrupt, as the next segments
+// follow the damage.
+func readSegments(list segments, f func(*LogEntry) error) (int64, *TornTail, error) {
+	var lastIndex uint64
+	for i, s := range list {
+		file, err := os.Open(s.path)
+		if err != nil {
+			return 0, nil, err
+		}
+		size, tail, err := readLog(file, lastIndex, func(entry *LogEntry) error {
+			lastIndex = entry.Index()
+			return f(entry)
+		})
+		file.Close()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if i == len(list)-1 {
+			return size, tail, nil
+		}
+		if tail != nil {
+			return 0, nil, &CorruptLogError{Path: tail.Path, Offset: tail.Offset, LastIndex: tail.LastIndex, NextIndex: list[i+1].firstIndex, Err: tail.Err}
+		}
+	}
+	return 0, nil, nil
+}
+
+// logSegments returns the segments of the log at the given path. A log
+// kept in a single file, as by earlier versions, has that one segment.
+func logSegments(path string) (segments, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return segments{{path: path, size: fi.Size()}}, nil
+	}
+	return listSegments(path)
+}
+
+// ReadLog calls f on each intact entry of the log at the given path, in
+// order, and returns the torn tail of the log, if it has one. The log is
+// not changed. A CorruptLogError is returned if the log is damaged before
+// its tail.
+func ReadLog(path string, f func(*LogEntry) error) (*TornTail, error) {
+	list, err := logSegments(path)
+	if err != nil {
+		return nil, err
+	}
+	_, tail, err := readSegments(list, f)
+	return tail, err
+}
+
+// RepairLog truncates the torn tail of the log at the given path, if it has
+// one, and returns it. The log is left as it is if it is damaged before its
+// tail.
+func RepairLog(path string) (*TornTail, error) {
+	list, err := logSegments(path)
+	if err != nil {
+		return nil, err
+	}
+	_, tail, err := readSegments(list, func(*LogEntry) error { return nil })
+	if err != nil || tail == nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(tail.Path, os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if err := file.Truncate(tail.Offset); err != nil {
+		return nil, err
+	}
+	return tail, file.Sync()
+}
@@ -3,7 +3,9 @@ package raft
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 
 	"github.com/coreos/etcd/third_party/code.google.com/p/gogoprotobuf/proto"
@@ -13,7 +15,7 @@ import (
 // A log entry stores a single item in the log.
 type LogEntry struct {
 	pb       *protobuf.LogEntry
-	Position int64 // position in the log file
+	Position int64 // position in the segment file holding the entry
 	log      *Log
 	event    *ev
 }
@@ -67,6 +69,23 @@ func (e *LogEntry) Command() []byte {
 	return e.pb.GetCommand()
 }
 
+// The header of a log entry holds the length of its data and a checksum of
+// it: "%8x %08x\n". Entries written before checksums were added only have
+// the length, "%8x\n", and are read without being checked.
+const (
+	legacyHeaderSize = 9
+	headerSize       = 18
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrChecksum is returned when the data of a log entry doesn't match the
+// checksum in its header.
+var ErrChecksum = errors.New("raft.LogEntry: checksum mismatch")
+
+// errHeader is returned when the header of a log entry can't be parsed.
+var errHeader = errors.New("raft.LogEntry: invalid header")
+
 // Encodes the log entry to a buffer. Returns the number of bytes
 // written and any error that may have occurred.
 func (e *LogEntry) Encode(w io.Writer) (int, error) {
@@ -75,33 +94,84 @@ func (e *LogEntry) Encode(w io.Writer) (int, error) {
 		return -1, err
 	}
 
-	if _, err = fmt.Fprintf(w, "%8x\n", len(b)); err != nil {
+	if _, err = fmt.Fprintf(w, "%8x %08x\n", len(b), crc32.Checksum(b, crcTable)); err != nil {
 		return -1, err
 	}
 
-	return w.Write(b)
+	n, err := w.Write(b)
+	return n + headerSize, err
 }
 
 // Decodes the log entry from a buffer. Returns the number of bytes read and
-// any error that occurs.
+// any error that occurs. io.EOF is returned if there is no entry left, and
+// io.ErrUnexpectedEOF if the entry is cut short.
 func (e *LogEntry) Decode(r io.Reader) (int, error) {
-
-	var length int
-	_, err := fmt.Fscanf(r, "%8x\n", &length)
-	if err != nil {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header[:legacyHeaderSize]); err != nil {
 		return -1, err
 	}
 
-	data := make([]byte, length)
-	_, err = io.ReadFull(r, data)
+	length, ok := parseHex(header[:8])
+	if !ok {
+		return -1, errHeader
+	}
 
-	if err != nil {
+	var crc uint64
+	checked := false
+	n := legacyHeaderSize
+	switch header[8] {
+	case '\n':
+	case ' ':
+		if _, err := io.ReadFull(r, header[legacyHeaderSize:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return -1, err
+		}
+		if crc, ok = parseHex(header[9:17]); !ok || header[17] != '\n' {
+			return -1, errHeader
+		}
+		checked = true
+		n = headerSize
+	default:
+		return -1, errHeader
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 		return -1, err
 	}
 
-	if err = proto.Unmarshal(data, e.pb); err != nil {
+	if checked && uint64(crc32.Checksum(data, crcTable)) != crc {
+		return -1, ErrChecksum
+	}
+
+	if err := proto.Unmarshal(data, e.pb); err != nil {
 		return -1, err
 	}
 
-	return length + 8 + 1, nil
+	return n + int(length), nil
+}
+
+// parseHex parses a right-aligned, space-padded hexadecimal number.
+func parseHex(b []byte) (uint64, bool) {
+	var v uint64
+	digits := 0
+	for _, c := range b {
+		switch {
+		case c == ' ' && digits == 0:
+			continue
+		case '0' <= c && c <= '9':
+			v = v<<4 | uint64(c-'0')
+		case 'a' <= c && c <= 'f':
+			v = v<<4 | uint64(c-'a'+10)
+		default:
+			return 0, false
+		}
+		digits++
+	}
+	return v, digits > 0
 }
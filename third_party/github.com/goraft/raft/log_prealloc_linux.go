@@ -0,0 +1,25 @@
+package raft
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize is FALLOC_FL_KEEP_SIZE, from Linux/include/uapi/linux/falloc.h.
+const fallocKeepSize = 0x1
+
+// preallocate reserves the blocks of a segment file up to the given size,
+// so that appending to it doesn't allocate them one at a time. The size of
+// the file is left as it is: it ends at the last entry.
+func preallocate(file *os.File, size int64) error {
+	return syscall.Fallocate(int(file.Fd()), fallocKeepSize, 0, size)
+}
+
+// diskUsage returns the bytes a file takes up on disk, the blocks reserved
+// past its end included.
+func diskUsage(fi os.FileInfo) int64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return st.Blocks * 512
+	}
+	return fi.Size()
+}
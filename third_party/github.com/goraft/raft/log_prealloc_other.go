@@ -0,0 +1,18 @@
+// +build !linux
+
+package raft
+
+import (
+	"os"
+)
+
+// preallocate is not supported on the platform. Segment files grow as
+// entries are appended.
+func preallocate(file *os.File, size int64) error {
+	return nil
+}
+
+// diskUsage returns the bytes a file takes up on disk.
+func diskUsage(fi os.FileInfo) int64 {
+	return fi.Size()
+}
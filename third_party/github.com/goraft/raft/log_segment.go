@@ -0,0 +1,136 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// DefaultLogSegmentSize is the size of the segment files of a log. An entry
+// that doesn't fit in what is left of a segment starts the next one.
+const DefaultLogSegmentSize = 64 * 1024 * 1024
+
+// errStop stops readLog before the end of the file.
+var errStop = errors.New("stop")
+
+// A segment is a file of a log. It holds the entries from its first index
+// on, up to the first index of the next segment.
+type segment struct {
+	path       string
+	firstIndex uint64
+	size       int64 // bytes of entries in the file
+}
+
+type segments []*segment
+
+func (a segments) Len() int           { return len(a) }
+func (a segments) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a segments) Less(i, j int) bool { return a[i].firstIndex < a[j].firstIndex }
+
+// segmentName returns the name of the segment file starting at the given
+// index. Names sort in the order of the segments.
+func segmentName(firstIndex uint64) string {
+	return fmt.Sprintf("%016x.log", firstIndex)
+}
+
+// listSegments returns the segments in a log directory, in order.
+func listSegments(dir string) (segments, error) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := d.Readdirnames(-1)
+	d.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var list segments
+	for _, name := range names {
+		if len(name) != len(segmentName(0)) || filepath.Ext(name) != ".log" {
+			continue
+		}
+		firstIndex, err := strconv.ParseUint(name[:16], 16, 64)
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, &segment{path: path, firstIndex: firstIndex, size: fi.Size()})
+	}
+	sort.Sort(list)
+	return list, nil
+}
+
+// find returns the position of the segment holding the given index.
+func (a segments) find(index uint64) int {
+	i := sort.Search(len(a), func(i int) bool { return a[i].firstIndex > index })
+	if i == 0 {
+		return 0
+	}
+	return i - 1
+}
+
+// migrateLog turns a log kept in a single file, as by earlier versions, into
+// a log directory at the same path, holding the file as its first segment.
+// The file is only renamed, so a migration cut short is finished on the
+// next call.
+func migrateLog(path string) error {
+	tmp := path + ".migrate"
+
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		if _, err := os.Stat(tmp); err == nil {
+			return renameSynced(tmp, path)
+		}
+		return nil
+	}
+	if err != nil || fi.IsDir() {
+		return err
+	}
+
+	// Name the segment after the first entry. The file of a compacted log
+	// doesn't start at index 1.
+	firstIndex := uint64(1)
+	if file, err := os.Open(path); err == nil {
+		readLog(file, 0, func(entry *LogEntry) error {
+			firstIndex = entry.Index()
+			return errStop
+		})
+		file.Close()
+	}
+
+	if err := os.MkdirAll(tmp, 0700); err != nil {
+		return err
+	}
+	if err := os.Rename(path, filepath.Join(tmp, segmentName(firstIndex))); err != nil {
+		return err
+	}
+	return renameSynced(tmp, path)
+}
+
+// renameSynced renames a file and syncs the directory holding it, so that
+// the rename survives a crash.
+func renameSynced(oldpath, newpath string) error {
+	if err := os.Rename(oldpath, newpath); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(newpath))
+}
+
+// syncDir syncs a directory, to make the files created, renamed or removed
+// in it durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -1,10 +1,14 @@
 package raft
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+
+	"github.com/coreos/etcd/third_party/code.google.com/p/gogoprotobuf/proto"
 )
 
 //------------------------------------------------------------------------------
@@ -28,7 +32,7 @@ func TestLogNewLog(t *testing.T) {
 		t.Fatalf("Unable to open log: %v", err)
 	}
 	defer log.close()
-	defer os.Remove(path)
+	defer os.RemoveAll(path)
 
 	e, _ := newLogEntry(log, nil, 1, 1, &testCommand1{Val: "foo", I: 20})
 	if err := log.appendEntry(e); err != nil {
@@ -68,7 +72,7 @@ func TestLogExistingLog(t *testing.T) {
 	e2, _ := newLogEntry(tmpLog, nil, 3, 2, &testCommand1{Val: "bar", I: 0})
 	log, path := setupLog([]*LogEntry{e0, e1, e2})
 	defer log.close()
-	defer os.Remove(path)
+	defer os.RemoveAll(path)
 
 	// Validate existing log entries.
 	if len(log.entries) != 3 {
@@ -93,7 +97,7 @@ func TestLogContainsEntries(t *testing.T) {
 	e2, _ := newLogEntry(tmpLog, nil, 3, 2, &testCommand1{Val: "bar", I: 0})
 	log, path := setupLog([]*LogEntry{e0, e1, e2})
 	defer log.close()
-	defer os.Remove(path)
+	defer os.RemoveAll(path)
 
 	if log.containsEntry(0, 0) {
 		t.Fatalf("Zero-index entry should not exist in log.")
@@ -117,10 +121,10 @@ func TestLogRecovery(t *testing.T) {
 	tmpLog := newLog()
 	e0, _ := newLogEntry(tmpLog, nil, 1, 1, &testCommand1{Val: "foo", I: 20})
 	e1, _ := newLogEntry(tmpLog, nil, 2, 1, &testCommand2{X: 100})
-	f, _ := ioutil.TempFile("", "raft-log-")
-
-	e0.Encode(f)
-	e1.Encode(f)
+	path, _ := ioutil.TempDir("", "raft-log-")
+	defer os.RemoveAll(path)
+	writeSegment(path, []*LogEntry{e0, e1})
+	f, _ := os.OpenFile(filepath.Join(path, segmentName(1)), os.O_WRONLY|os.O_APPEND, 0600)
 	f.WriteString("CORRUPT!")
 	f.Close()
 
@@ -128,11 +132,10 @@ func TestLogRecovery(t *testing.T) {
 	log.ApplyFunc = func(e *LogEntry, c Command) (interface{}, error) {
 		return nil, nil
 	}
-	if err := log.open(f.Name()); err != nil {
+	if err := log.open(path); err != nil {
 		t.Fatalf("Unable to open log: %v", err)
 	}
 	defer log.close()
-	defer os.Remove(f.Name())
 
 	e, _ := newLogEntry(log, nil, 3, 2, &testCommand1{Val: "bat", I: -5})
 	if err := log.appendEntry(e); err != nil {
@@ -154,6 +157,160 @@ func TestLogRecovery(t *testing.T) {
 	}
 }
 
+// Ensure that a last entry with a bad checksum is dropped when the log is opened.
+func TestLogRecoveryChecksum(t *testing.T) {
+	path, offsets := writeTestLog(t)
+	defer os.RemoveAll(path)
+	segment := filepath.Join(path, segmentName(1))
+	corruptByte(t, segment, offsets[3]-1)
+
+	log := newLog()
+	log.ApplyFunc = func(e *LogEntry, c Command) (interface{}, error) {
+		return nil, nil
+	}
+	if err := log.open(path); err != nil {
+		t.Fatalf("Unable to open log: %v", err)
+	}
+	defer log.close()
+
+	if len(log.entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(log.entries))
+	}
+	if fi, _ := os.Stat(segment); fi.Size() != offsets[2] {
+		t.Fatalf("Expected the log to be truncated to %d bytes, got %d", offsets[2], fi.Size())
+	}
+}
+
+// Ensure that a log with a damaged entry before intact ones is not opened.
+func TestLogCorruptMiddle(t *testing.T) {
+	path, offsets := writeTestLog(t)
+	defer os.RemoveAll(path)
+	segment := filepath.Join(path, segmentName(1))
+	corruptByte(t, segment, offsets[2]-1)
+
+	log := newLog()
+	log.ApplyFunc = func(e *LogEntry, c Command) (interface{}, error) {
+		return nil, nil
+	}
+	err := log.open(path)
+	cerr, ok := err.(*CorruptLogError)
+	if !ok {
+		t.Fatalf("Expected a corrupt log error, got %v", err)
+	}
+	if cerr.Path != segment || cerr.Offset != offsets[1] || cerr.LastIndex != 1 || cerr.NextIndex != 3 || cerr.Err != ErrChecksum {
+		t.Fatalf("Unexpected error: %v", cerr)
+	}
+
+	if _, err := RepairLog(path); err == nil {
+		t.Fatal("Repairing a log damaged in the middle should fail")
+	}
+	if fi, _ := os.Stat(segment); fi.Size() != offsets[3] {
+		t.Fatalf("Expected the log to be left unchanged, got %d bytes", fi.Size())
+	}
+}
+
+// Ensure that entries written without checksums can still be read.
+func TestLogLegacyEntries(t *testing.T) {
+	f, _ := ioutil.TempFile("", "raft-log-")
+	defer os.RemoveAll(f.Name())
+	for i := uint64(1); i <= 2; i++ {
+		e, _ := newLogEntry(newLog(), nil, i, 1, &testCommand2{X: int(i)})
+		b, _ := proto.Marshal(e.pb)
+		fmt.Fprintf(f, "%8x\n", len(b))
+		f.Write(b)
+	}
+	f.Close()
+
+	log := newLog()
+	log.ApplyFunc = func(e *LogEntry, c Command) (interface{}, error) {
+		return nil, nil
+	}
+	if err := log.open(f.Name()); err != nil {
+		t.Fatalf("Unable to open log: %v", err)
+	}
+	e, _ := newLogEntry(log, nil, 3, 1, &testCommand2{X: 3})
+	if err := log.appendEntry(e); err != nil {
+		t.Fatalf("Unable to append: %v", err)
+	}
+	log.close()
+
+	count := 0
+	tail, err := ReadLog(f.Name(), func(*LogEntry) error {
+		count++
+		return nil
+	})
+	if count != 3 || tail != nil || err != nil {
+		t.Fatalf("Unexpected check result: %v %v %v", count, tail, err)
+	}
+}
+
+// Ensure that the torn tail of a log is reported and dropped by RepairLog.
+func TestRepairLog(t *testing.T) {
+	path, offsets := writeTestLog(t)
+	defer os.RemoveAll(path)
+	segment := filepath.Join(path, segmentName(1))
+
+	// Cut the last entry short, and leave zeros after it as a file system
+	// may do.
+	os.Truncate(segment, offsets[2]+20)
+	os.Truncate(segment, offsets[2]+100)
+
+	count := 0
+	tail, err := ReadLog(path, func(*LogEntry) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unable to check log: %v", err)
+	}
+	if count != 2 || tail == nil || tail.Path != segment || tail.Offset != offsets[2] || tail.Size != 100 || tail.LastIndex != 2 {
+		t.Fatalf("Unexpected check result: %v %v", count, tail)
+	}
+
+	tail, err = RepairLog(path)
+	if err != nil || tail == nil || tail.LastIndex != 2 {
+		t.Fatalf("Unexpected repair result: %v %v", tail, err)
+	}
+	if fi, _ := os.Stat(segment); fi.Size() != offsets[2] {
+		t.Fatalf("Expected the log to be truncated to %d bytes, got %d", offsets[2], fi.Size())
+	}
+
+	if tail, err = RepairLog(path); tail != nil || err != nil {
+		t.Fatalf("Unexpected repair of a sound log: %v %v", tail, err)
+	}
+}
+
+// writeTestLog writes a log of three entries in one segment, and returns
+// the offsets of the entries and of the end of the segment.
+func writeTestLog(t *testing.T) (string, []int64) {
+	path, _ := ioutil.TempDir("", "raft-log-")
+	f, _ := os.Create(filepath.Join(path, segmentName(1)))
+	defer f.Close()
+
+	offsets := []int64{0}
+	for i := uint64(1); i <= 3; i++ {
+		e, _ := newLogEntry(newLog(), nil, i, 1, &testCommand1{Val: "foo", I: int(i)})
+		n, err := e.Encode(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, offsets[len(offsets)-1]+int64(n))
+	}
+	return path, offsets
+}
+
+func corruptByte(t *testing.T, path string, offset int64) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b := make([]byte, 1)
+	f.ReadAt(b, offset)
+	b[0] ^= 0xff
+	f.WriteAt(b, offset)
+}
+
 //--------------------------------------
 // Append
 //--------------------------------------
@@ -165,7 +322,7 @@ func TestLogTruncate(t *testing.T) {
 		t.Fatalf("Unable to open log: %v", err)
 	}
 
-	defer os.Remove(path)
+	defer os.RemoveAll(path)
 
 	entry1, _ := newLogEntry(log, nil, 1, 1, &testCommand1{Val: "foo", I: 20})
 	if err := log.appendEntry(entry1); err != nil {
@@ -230,3 +387,188 @@ func TestLogTruncate(t *testing.T) {
 		t.Fatalf("Unexpected entry[2]: %v", log.entries[2])
 	}
 }
+
+//--------------------------------------
+// Segments
+//--------------------------------------
+
+// Ensure that entries go to a new segment once one is full, and that the
+// segments holding only compacted entries are removed.
+func TestLogSegments(t *testing.T) {
+	path := getLogPath()
+	defer os.RemoveAll(path)
+	log := openSegmentedLog(t, path, 0)
+	appendTestEntries(t, log, 1, 20, 1)
+
+	list, _ := listSegments(path)
+	if len(list) < 3 {
+		t.Fatalf("Expected the entries to take several segments, got %d", len(list))
+	}
+	var size int64
+	for i, s := range list {
+		if i < len(list)-1 && s.size > log.segmentSize {
+			t.Fatalf("Segment %s is larger than %d bytes: %d", s.path, log.segmentSize, s.size)
+		}
+		if e := log.getEntry(s.firstIndex); e == nil || e.Position != 0 {
+			t.Fatalf("Segment %s doesn't start with entry %d", s.path, s.firstIndex)
+		}
+		size += s.size
+	}
+	if stats := log.stats(); stats.Segments != len(list) || stats.Size != size || stats.DiskUsage < size {
+		t.Fatalf("Unexpected stats: %+v, with %d bytes in %d segments", stats, size, len(list))
+	}
+
+	// Compact the log, and check that the segments before the one holding
+	// the first entry kept are gone.
+	if err := log.compact(10, 1); err != nil {
+		t.Fatalf("Unable to compact: %v", err)
+	}
+	kept := list[list.find(11):]
+	compacted := log.getEntry(11).Position
+	for _, s := range list[:list.find(11)] {
+		compacted += s.size
+	}
+	list, _ = listSegments(path)
+	if len(list) != len(kept) || list[0].path != kept[0].path {
+		t.Fatalf("Expected %d segments from %s, got %d", len(kept), kept[0].path, len(list))
+	}
+	if stats := log.stats(); stats.Segments != len(kept) || stats.Size != size-compacted {
+		t.Fatalf("Unexpected stats after compaction: %+v", stats)
+	}
+	log.close()
+
+	// Reopen the log, and check that the entries after the snapshot are
+	// read back.
+	log = openSegmentedLog(t, path, 10)
+	defer log.close()
+	if len(log.entries) != 10 || log.entries[0].Index() != 11 || log.entries[9].Index() != 20 {
+		t.Fatalf("Unexpected entries after reopening: %v", log.entries)
+	}
+	appendTestEntries(t, log, 21, 21, 1)
+
+	// Compact past the end of the log, as when recovering from a snapshot
+	// sent by the leader, and check that one empty segment is left.
+	if err := log.compact(30, 2); err != nil {
+		t.Fatalf("Unable to compact: %v", err)
+	}
+	list, _ = listSegments(path)
+	if len(list) != 1 || list[0].firstIndex != 31 || list[0].size != 0 {
+		t.Fatalf("Unexpected segments: %v", list)
+	}
+}
+
+// Ensure that truncating the log removes the segments after the entry it
+// is truncated to.
+func TestLogTruncateSegments(t *testing.T) {
+	path := getLogPath()
+	defer os.RemoveAll(path)
+	log := openSegmentedLog(t, path, 0)
+	appendTestEntries(t, log, 1, 20, 1)
+	if err := log.setCommitIndex(5); err != nil {
+		t.Fatalf("Unable to commit: %v", err)
+	}
+
+	position := log.getEntry(7).Position
+	if err := log.truncate(6, 1); err != nil {
+		t.Fatalf("Unable to truncate: %v", err)
+	}
+	list, _ := listSegments(path)
+	if last := list[len(list)-1]; last.firstIndex > 7 || list.find(7) != len(list)-1 || last.size != position {
+		t.Fatalf("Unexpected segments after truncating: %v", list)
+	}
+
+	appendTestEntries(t, log, 7, 8, 2)
+	log.close()
+
+	log = openSegmentedLog(t, path, 0)
+	defer log.close()
+	if len(log.entries) != 8 || log.entries[6].Term() != 2 || log.entries[7].Index() != 8 {
+		t.Fatalf("Unexpected entries after reopening: %v", log.entries)
+	}
+}
+
+// Ensure that a segment damaged at its end makes the log corrupt when
+// segments follow it.
+func TestLogCorruptSegment(t *testing.T) {
+	path := getLogPath()
+	defer os.RemoveAll(path)
+	log := openSegmentedLog(t, path, 0)
+	appendTestEntries(t, log, 1, 20, 1)
+	log.close()
+
+	list, _ := listSegments(path)
+	corruptByte(t, list[0].path, list[0].size-1)
+
+	log = newLog()
+	log.ApplyFunc = func(e *LogEntry, c Command) (interface{}, error) {
+		return nil, nil
+	}
+	err := log.open(path)
+	cerr, ok := err.(*CorruptLogError)
+	if !ok {
+		t.Fatalf("Expected a corrupt log error, got %v", err)
+	}
+	if cerr.Path != list[0].path || cerr.NextIndex != list[1].firstIndex {
+		t.Fatalf("Unexpected error: %v", cerr)
+	}
+	if _, err := RepairLog(path); err == nil {
+		t.Fatal("Repairing a log damaged before its last segment should fail")
+	}
+}
+
+// Ensure that a log kept in a single file is moved into a directory.
+func TestLogMigrate(t *testing.T) {
+	path := getLogPath()
+	defer os.RemoveAll(path)
+	f, _ := os.Create(path)
+	for i := uint64(5); i <= 7; i++ {
+		e, _ := newLogEntry(newLog(), nil, i, 1, &testCommand2{X: int(i)})
+		e.Encode(f)
+	}
+	f.Close()
+
+	log := openSegmentedLog(t, path, 4)
+	if len(log.entries) != 3 || log.entries[0].Index() != 5 {
+		t.Fatalf("Unexpected entries: %v", log.entries)
+	}
+	appendTestEntries(t, log, 8, 8, 1)
+	log.close()
+
+	list, _ := listSegments(path)
+	if len(list) != 1 || list[0].path != filepath.Join(path, segmentName(5)) {
+		t.Fatalf("Unexpected segments: %v", list)
+	}
+
+	// Finish a migration cut short after the file was moved.
+	os.Rename(path, path+".migrate")
+	if err := migrateLog(path); err != nil {
+		t.Fatalf("Unable to finish the migration: %v", err)
+	}
+	if list, _ := listSegments(path); len(list) != 1 || list[0].size == 0 {
+		t.Fatalf("Unexpected segments: %v", list)
+	}
+}
+
+// openSegmentedLog opens a log with small segments, after a snapshot at the
+// given index.
+func openSegmentedLog(t *testing.T, path string, startIndex uint64) *Log {
+	log := newLog()
+	log.segmentSize = 256
+	log.startIndex = startIndex
+	log.ApplyFunc = func(e *LogEntry, c Command) (interface{}, error) {
+		return nil, nil
+	}
+	if err := log.open(path); err != nil {
+		t.Fatalf("Unable to open log: %v", err)
+	}
+	return log
+}
+
+func appendTestEntries(t *testing.T, log *Log, from, to, term uint64) {
+	for i := from; i <= to; i++ {
+		e, _ := newLogEntry(log, nil, i, term, &testCommand1{Val: "foo", I: int(i)})
+		if err := log.appendEntry(e); err != nil {
+			t.Fatalf("Unable to append: %v", err)
+		}
+	}
+}
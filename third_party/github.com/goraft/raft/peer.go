@@ -18,6 +18,7 @@ type Peer struct {
 	ConnectionString  string `json:"connectionString"`
 	prevLogIndex      uint64
 	stopChan          chan bool
+	intervalChan      chan time.Duration
 	heartbeatInterval time.Duration
 	lastActivity      time.Time
 	sync.RWMutex
@@ -35,6 +36,7 @@ func newPeer(server *server, name string, connectionString string, heartbeatInte
 		server:            server,
 		Name:              name,
 		ConnectionString:  connectionString,
+		intervalChan:      make(chan time.Duration, 1),
 		heartbeatInterval: heartbeatInterval,
 	}
 }
@@ -45,9 +47,30 @@ func newPeer(server *server, name string, connectionString string, heartbeatInte
 //
 //------------------------------------------------------------------------------
 
-// Sets the heartbeat timeout.
+// Sets the heartbeat timeout. A running heartbeat is restarted with the
+// new interval.
 func (p *Peer) setHeartbeatInterval(duration time.Duration) {
+	p.Lock()
 	p.heartbeatInterval = duration
+	p.Unlock()
+
+	// Drop an interval not yet picked up by the heartbeat; the new one
+	// replaces it.
+	select {
+	case <-p.intervalChan:
+	default:
+	}
+	select {
+	case p.intervalChan <- duration:
+	default:
+	}
+}
+
+// Retrieves the heartbeat timeout.
+func (p *Peer) getHeartbeatInterval() time.Duration {
+	p.RLock()
+	defer p.RUnlock()
+	return p.heartbeatInterval
 }
 
 //--------------------------------------
@@ -140,9 +163,11 @@ func (p *Peer) heartbeat(c chan bool) {
 
 	c <- true
 
-	ticker := time.Tick(p.heartbeatInterval)
+	interval := p.getHeartbeatInterval()
+	ticker := time.NewTicker(interval)
+	defer func() { ticker.Stop() }()
 
-	debugln("peer.heartbeat: ", p.Name, p.heartbeatInterval)
+	debugln("peer.heartbeat: ", p.Name, interval)
 
 	for {
 		select {
@@ -158,7 +183,15 @@ func (p *Peer) heartbeat(c chan bool) {
 				return
 			}
 
-		case <-ticker:
+		case i := <-p.intervalChan:
+			if i != interval {
+				interval = i
+				ticker.Stop()
+				ticker = time.NewTicker(interval)
+				debugln("peer.heartbeat.interval: ", p.Name, interval)
+			}
+
+		case <-ticker.C:
 			start := time.Now()
 			p.flush()
 			duration := time.Now().Sub(start)
@@ -203,11 +236,17 @@ func (p *Peer) sendAppendEntriesRequest(req *AppendEntriesRequest) {
 	p.Lock()
 	if resp.Success() {
 		if len(req.Entries) > 0 {
-			p.prevLogIndex = req.Entries[len(req.Entries)-1].GetIndex()
+			// A transporter splitting the request may have had only its
+			// first entries appended, up to the index of the response.
+			last := req.Entries[len(req.Entries)-1]
+			if resp.Index() > req.PrevLogIndex && resp.Index() < last.GetIndex() {
+				last = req.Entries[resp.Index()-req.PrevLogIndex-1]
+			}
+			p.prevLogIndex = last.GetIndex()
 
 			// if peer append a log entry from the current term
 			// we set append to true
-			if req.Entries[len(req.Entries)-1].GetTerm() == p.server.currentTerm {
+			if last.GetTerm() == p.server.currentTerm {
 				resp.append = true
 			}
 		}
@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"hash/crc32"
 	"io/ioutil"
 	"math"
 	"os"
@@ -74,6 +73,7 @@ type Server interface {
 	State() string
 	Path() string
 	LogPath() string
+	LogStats() LogStats
 	SnapshotPath(lastIndex uint64, lastTerm uint64) string
 	Term() uint64
 	CommitIndex() uint64
@@ -278,6 +278,11 @@ func (s *server) LogPath() string {
 	return path.Join(s.path, "log")
 }
 
+// Retrieves the space taken up by the log.
+func (s *server) LogStats() LogStats {
+	return s.log.stats()
+}
+
 // Retrieves the current state of the server.
 func (s *server) State() string {
 	s.mutex.RLock()
@@ -1350,38 +1355,9 @@ func (s *server) LoadSnapshot() error {
 	snapshotPath := path.Join(s.path, "snapshot", filenames[len(filenames)-1])
 
 	// Read snapshot data.
-	file, err := os.OpenFile(snapshotPath, os.O_RDONLY, 0)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	// Check checksum.
-	var checksum uint32
-	n, err := fmt.Fscanf(file, "%08x\n", &checksum)
+	s.snapshot, err = ReadSnapshot(snapshotPath)
 	if err != nil {
 		return err
-	} else if n != 1 {
-		return errors.New("checksum.err: bad.snapshot.file")
-	}
-
-	// Load remaining snapshot contents.
-	b, err := ioutil.ReadAll(file)
-	if err != nil {
-		return err
-	}
-
-	// Generate checksum.
-	byteChecksum := crc32.ChecksumIEEE(b)
-	if uint32(checksum) != byteChecksum {
-		s.debugln(checksum, " ", byteChecksum)
-		return errors.New("bad snapshot file")
-	}
-
-	// Decode snapshot.
-	if err = json.Unmarshal(b, &s.snapshot); err != nil {
-		s.debugln("unmarshal.snapshot.error: ", err)
-		return err
 	}
 
 	// Recover snapshot into state machine.
@@ -2,6 +2,7 @@ package raft
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
@@ -85,6 +86,45 @@ func (ss *Snapshot) save() error {
 	return nil
 }
 
+// ReadSnapshot reads the snapshot file at the given path, and checks it.
+func ReadSnapshot(path string) (*Snapshot, error) {
+	file, err := os.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Check checksum.
+	var checksum uint32
+	n, err := fmt.Fscanf(file, "%08x\n", &checksum)
+	if err != nil {
+		return nil, err
+	} else if n != 1 {
+		return nil, errors.New("checksum.err: bad.snapshot.file")
+	}
+
+	// Load remaining snapshot contents.
+	b, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate checksum.
+	byteChecksum := crc32.ChecksumIEEE(b)
+	if uint32(checksum) != byteChecksum {
+		debugln(checksum, " ", byteChecksum)
+		return nil, errors.New("bad snapshot file")
+	}
+
+	// Decode snapshot.
+	ss := &Snapshot{}
+	if err = json.Unmarshal(b, ss); err != nil {
+		debugln("unmarshal.snapshot.error: ", err)
+		return nil, err
+	}
+	return ss, nil
+}
+
 // remove deletes the snapshot file.
 func (ss *Snapshot) remove() error {
 	if err := os.Remove(ss.Path); err != nil {
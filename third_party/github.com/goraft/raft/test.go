@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -39,25 +40,35 @@ func getLogPath() string {
 }
 
 func setupLog(entries []*LogEntry) (*Log, string) {
-	f, _ := ioutil.TempFile("", "raft-log-")
+	path, _ := ioutil.TempDir("", "raft-log-")
+	writeSegment(path, entries)
 
-	for _, entry := range entries {
-		entry.Encode(f)
+	log := newLog()
+	log.ApplyFunc = func(e *LogEntry, c Command) (interface{}, error) {
+		return nil, nil
 	}
-	err := f.Close()
+	if err := log.open(path); err != nil {
+		panic(err)
+	}
+	return log, path
+}
 
+// writeSegment writes the entries as the first segment of a log directory.
+func writeSegment(path string, entries []*LogEntry) {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		panic(err)
+	}
+	f, err := os.Create(filepath.Join(path, segmentName(1)))
 	if err != nil {
 		panic(err)
 	}
 
-	log := newLog()
-	log.ApplyFunc = func(e *LogEntry, c Command) (interface{}, error) {
-		return nil, nil
+	for _, entry := range entries {
+		entry.Encode(f)
 	}
-	if err := log.open(f.Name()); err != nil {
+	if err := f.Close(); err != nil {
 		panic(err)
 	}
-	return log, f.Name()
 }
 
 //--------------------------------------
@@ -89,15 +100,7 @@ func newTestServerWithPath(name string, transporter Transporter, p string) Serve
 
 func newTestServerWithLog(name string, transporter Transporter, entries []*LogEntry) Server {
 	server := newTestServer(name, transporter)
-	f, err := os.Create(server.LogPath())
-	if err != nil {
-		panic(err)
-	}
-
-	for _, entry := range entries {
-		entry.Encode(f)
-	}
-	f.Close()
+	writeSegment(server.LogPath(), entries)
 	return server
 }
 